@@ -0,0 +1,23 @@
+# (c) 2021, Flare Networks Limited. All rights reserved.
+# Please see the file LICENSE for licensing terms.
+
+#!/bin/bash
+if [[ $(pwd) =~ " " ]]; then echo "Working directory path contains a folder with a space in its name, please remove all spaces" && exit; fi
+if [ -z ${GOPATH+x} ]; then echo "GOPATH is not set, visit https://github.com/golang/go/wiki/SettingGOPATH" && exit; fi
+printf "\x1b[34mFlare Network Local Attestor\x1b[0m\n\n"
+
+LAUNCH_DIR=$(pwd)
+AVALANCHE_DIR=$GOPATH/src/github.com/ava-labs/avalanchego
+ATTESTOR_DIR=$LAUNCH_DIR/db/local/attestor
+
+# Key of the local network's genesis attestor, never use it on a public network
+mkdir -p $ATTESTOR_DIR
+chmod 700 $ATTESTOR_DIR
+if [ ! -f $ATTESTOR_DIR/key.txt ]; then
+	(umask 077 && echo "44b8de040dec19cf810efe64919b481e05e2ba643efe003223662f1626b114f0" > $ATTESTOR_DIR/key.txt)
+fi
+
+$AVALANCHE_DIR/build/attestor \
+--rpc=http://127.0.0.1:9650/ext/bc/C/rpc \
+--key-file=$ATTESTOR_DIR/key.txt \
+--state-dir=$ATTESTOR_DIR
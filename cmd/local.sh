@@ -33,17 +33,16 @@ then
 	mkdir -p $LAUNCH_DIR/db/local/node5
 fi
 
-export TESTING_ATTESTATION_PROVIDERS="0xff57CaF5B871db64F2a7F4C5bc2d17A5E666F7E8"
-
 # NODE 1
 printf "Launching Node 1 at 127.0.0.1:9650\n"
-export WEB3_API=debug
+export FBA_REGISTRY_VALs=$LAUNCH_DIR/db/local/node1/fba_registry.json
 nohup ./build/avalanchego \
 --public-ip=127.0.0.1 \
 --http-port=9650 \
 --staking-port=9651 \
 --log-dir=$LAUNCH_DIR/logs/local/node1 \
 --db-dir=$LAUNCH_DIR/db/local/node1 \
+--chain-config-dir=$LAUNCH_DIR/conf/local/node1/chains \
 --bootstrap-ips= \
 --bootstrap-ids= \
 --staking-tls-cert-file=$LAUNCH_DIR/conf/local/node1/node.crt \
@@ -55,7 +54,7 @@ sleep 3
 
 # NODE 2
 printf "Launching Node 2 at 127.0.0.2:9652\n"
-export WEB3_API=disabled
+export FBA_REGISTRY_VALs=$LAUNCH_DIR/db/local/node2/fba_registry.json
 nohup ./build/avalanchego \
 --public-ip=127.0.0.1 \
 --http-port=9652 \
@@ -73,6 +72,7 @@ sleep 3
 
 # NODE 3
 printf "Launching Node 3 at 127.0.0.1:9654\n"
+export FBA_REGISTRY_VALs=$LAUNCH_DIR/db/local/node3/fba_registry.json
 nohup ./build/avalanchego \
 --public-ip=127.0.0.1 \
 --http-port=9654 \
@@ -90,6 +90,7 @@ sleep 3
 
 # NODE 4
 printf "Launching Node 4 at 127.0.0.1:9656\n"
+export FBA_REGISTRY_VALs=$LAUNCH_DIR/db/local/node4/fba_registry.json
 nohup ./build/avalanchego \
 --public-ip=127.0.0.1 \
 --http-port=9656 \
@@ -107,6 +108,7 @@ sleep 3
 
 # NODE 5
 printf "Launching Node 5 at 127.0.0.1:9658\n"
+export FBA_REGISTRY_VALs=$LAUNCH_DIR/db/local/node5/fba_registry.json
 nohup ./build/avalanchego \
 --public-ip=127.0.0.1 \
 --http-port=9658 \
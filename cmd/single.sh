@@ -35,7 +35,7 @@ fi
 
 # NODE 1
 printf "Launching Node 1 at 127.0.0.1:9650\n"
-export WEB3_API=debug
+export FBA_REGISTRY_VALs=$LAUNCH_DIR/db/local/node1/fba_registry.json
 ./build/avalanchego \
 --public-ip=127.0.0.1 \
 --snow-sample-size=1 \
@@ -44,6 +44,7 @@ export WEB3_API=debug
 --staking-port=9651 \
 --log-dir=$LAUNCH_DIR/logs/local/node1 \
 --db-dir=$LAUNCH_DIR/db/local/node1 \
+--chain-config-dir=$LAUNCH_DIR/conf/local/node1/chains \
 --bootstrap-ips= \
 --bootstrap-ids= \
 --staking-enabled=false \
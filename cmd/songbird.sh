@@ -27,14 +27,15 @@ fi
 
 # NODE 1
 printf "Launching Songbird Node at 127.0.0.1:9650\n"
-export WEB3_API=debug
+export FBA_REGISTRY_VALs=$LAUNCH_DIR/db/songbird/node1/fba_registry.json
 nohup ./build/avalanchego \
---http-host= \
+--http-host=127.0.0.1 \
 --public-ip=127.0.0.1 \
 --http-port=9650 \
 --staking-port=9651 \
 --log-dir=$LAUNCH_DIR/logs/songbird/node1 \
 --db-dir=$LAUNCH_DIR/db/songbird/node1 \
+--chain-config-dir=$LAUNCH_DIR/conf/songbird/chains \
 --bootstrap-ips="$(curl -m 10 -sX POST --data '{ "jsonrpc":"2.0", "id":1, "method":"info.getNodeIP" }' -H 'content-type:application/json;' https://songbird.flare.network/ext/info | jq -r ".result.ip")" \
 --bootstrap-ids="$(curl -m 10 -sX POST --data '{ "jsonrpc":"2.0", "id":1, "method":"info.getNodeID" }' -H 'content-type:application/json;' https://songbird.flare.network/ext/info | jq -r ".result.nodeID")" \
 --db-type=$DB_TYPE \
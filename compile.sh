@@ -43,18 +43,81 @@ cp $WORKING_DIR/src/avalanchego/unparsed_config.go ./genesis/unparsed_config.go
 cp $WORKING_DIR/src/avalanchego/node.go ./node/node.go
 cp $WORKING_DIR/src/avalanchego/vm.go ./vms/platformvm/vm.go
 cp $WORKING_DIR/src/avalanchego/set.go ./snow/validators/set.go
+cp $WORKING_DIR/src/avalanchego/fba_validators.go ./snow/validators/fba_validators.go
+cp $WORKING_DIR/src/avalanchego/fba_quorum.go ./snow/validators/fba_quorum.go
+cp $WORKING_DIR/src/avalanchego/fba_validators_test.go ./snow/validators/fba_validators_test.go
+cp $WORKING_DIR/src/avalanchego/fba_quorum_test.go ./snow/validators/fba_quorum_test.go
+mkdir -p ./api/fba
+cp $WORKING_DIR/src/avalanchego/fba_service.go ./api/fba/service.go
+cp $WORKING_DIR/src/avalanchego/fba_service_test.go ./api/fba/service_test.go
+mkdir -p ./cmd/fbacheck
+cp $WORKING_DIR/src/fbacheck/main.go ./cmd/fbacheck/main.go
 cp $WORKING_DIR/src/avalanchego/build_coreth.sh ./scripts/build_coreth.sh
 mkdir ./scripts/coreth_changes
 cp $WORKING_DIR/src/coreth/vm.go ./scripts/coreth_changes/vm.go
+cp $WORKING_DIR/src/coreth/state_connector_service.go ./scripts/coreth_changes/state_connector_service.go
+cp $WORKING_DIR/src/coreth/fork_checkpoint_service.go ./scripts/coreth_changes/fork_checkpoint_service.go
+cp $WORKING_DIR/src/coreth/fork_checkpoint_service_test.go ./scripts/coreth_changes/fork_checkpoint_service_test.go
+cp $WORKING_DIR/src/coreth/flare_fork_service.go ./scripts/coreth_changes/flare_fork_service.go
+cp $WORKING_DIR/src/coreth/flare_config.go ./scripts/coreth_changes/flare_config.go
+cp $WORKING_DIR/src/coreth/flare_config_test.go ./scripts/coreth_changes/flare_config_test.go
+cp $WORKING_DIR/src/coreth/attestor_admin_service.go ./scripts/coreth_changes/attestor_admin_service.go
+cp $WORKING_DIR/src/coreth/fba_registry_updates.go ./scripts/coreth_changes/fba_registry_updates.go
+cp $WORKING_DIR/src/coreth/fba_registry_updates_test.go ./scripts/coreth_changes/fba_registry_updates_test.go
+cp $WORKING_DIR/src/coreth/flare_daemon_service.go ./scripts/coreth_changes/flare_daemon_service.go
+cp $WORKING_DIR/src/coreth/accepted_block_records.go ./scripts/coreth_changes/accepted_block_records.go
 cp $WORKING_DIR/src/coreth/import_tx.go ./scripts/coreth_changes/import_tx.go
 cp $WORKING_DIR/src/coreth/export_tx.go ./scripts/coreth_changes/export_tx.go
 cp $WORKING_DIR/src/coreth/state_transition.go ./scripts/coreth_changes/state_transition.go
 cp $WORKING_DIR/src/stateco/state_connector.go ./scripts/coreth_changes/state_connector.go
+cp $WORKING_DIR/src/stateco/state_connector_test.go ./scripts/coreth_changes/state_connector_test.go
+cp $WORKING_DIR/src/stateco/state_connector_storage.go ./scripts/coreth_changes/state_connector_storage.go
+cp $WORKING_DIR/src/stateco/state_connector_storage_test.go ./scripts/coreth_changes/state_connector_storage_test.go
+cp $WORKING_DIR/src/stateco/state_connector_rounds.go ./scripts/coreth_changes/state_connector_rounds.go
+cp $WORKING_DIR/src/stateco/state_connector_rounds_test.go ./scripts/coreth_changes/state_connector_rounds_test.go
+cp $WORKING_DIR/src/stateco/block_records.go ./scripts/coreth_changes/block_records.go
+cp $WORKING_DIR/src/stateco/state_connector_fork.go ./scripts/coreth_changes/state_connector_fork.go
+cp $WORKING_DIR/src/stateco/state_connector_fork_test.go ./scripts/coreth_changes/state_connector_fork_test.go
+cp $WORKING_DIR/src/stateco/state_connector_rewards.go ./scripts/coreth_changes/state_connector_rewards.go
+cp $WORKING_DIR/src/stateco/state_connector_rewards_test.go ./scripts/coreth_changes/state_connector_rewards_test.go
+cp $WORKING_DIR/src/stateco/state_connector_penalties.go ./scripts/coreth_changes/state_connector_penalties.go
+cp $WORKING_DIR/src/stateco/state_connector_penalties_test.go ./scripts/coreth_changes/state_connector_penalties_test.go
+cp $WORKING_DIR/src/stateco/state_connector_weights.go ./scripts/coreth_changes/state_connector_weights.go
+cp $WORKING_DIR/src/stateco/state_connector_weights_test.go ./scripts/coreth_changes/state_connector_weights_test.go
+cp $WORKING_DIR/src/stateco/state_connector_attestors.go ./scripts/coreth_changes/state_connector_attestors.go
+cp $WORKING_DIR/src/stateco/state_connector_attestors_test.go ./scripts/coreth_changes/state_connector_attestors_test.go
+cp $WORKING_DIR/src/stateco/stateconnector/stateconnector.go ./scripts/coreth_changes/stateconnector.go
+cp $WORKING_DIR/src/stateco/stateconnector/stateconnector_test.go ./scripts/coreth_changes/stateconnector_test.go
+cp $WORKING_DIR/src/stateco/stateconnector/merkle.go ./scripts/coreth_changes/merkle.go
+cp $WORKING_DIR/src/stateco/stateconnector/merkle_test.go ./scripts/coreth_changes/merkle_test.go
+cp $WORKING_DIR/src/stateco/vm/state_connector_precompile.go ./scripts/coreth_changes/state_connector_precompile.go
+cp $WORKING_DIR/src/stateco/vm/state_connector_precompile_test.go ./scripts/coreth_changes/state_connector_precompile_test.go
 cp $WORKING_DIR/src/keeper/keeper.go ./scripts/coreth_changes/keeper.go
 cp $WORKING_DIR/src/keeper/keeper_test.go ./scripts/coreth_changes/keeper_test.go
+cp $WORKING_DIR/src/keeper/block_end_receipt.go ./scripts/coreth_changes/block_end_receipt.go
+cp $WORKING_DIR/src/keeper/block_end_receipt_test.go ./scripts/coreth_changes/block_end_receipt_test.go
+cp $WORKING_DIR/src/keeper/flare_daemon_records.go ./scripts/coreth_changes/flare_daemon_records.go
+cp $WORKING_DIR/src/keeper/flare_daemon_records_test.go ./scripts/coreth_changes/flare_daemon_records_test.go
+cp $WORKING_DIR/src/keeper/mint_ledger.go ./scripts/coreth_changes/mint_ledger.go
+cp $WORKING_DIR/src/keeper/mint_ledger_test.go ./scripts/coreth_changes/mint_ledger_test.go
+cp $WORKING_DIR/src/keeper/system_calls.go ./scripts/coreth_changes/system_calls.go
+cp $WORKING_DIR/src/keeper/system_calls_test.go ./scripts/coreth_changes/system_calls_test.go
+cp $WORKING_DIR/src/keeper/system_hooks.go ./scripts/coreth_changes/system_hooks.go
+cp $WORKING_DIR/src/keeper/system_hooks_test.go ./scripts/coreth_changes/system_hooks_test.go
+cp $WORKING_DIR/src/forks/flare_fork_schedule.go ./scripts/coreth_changes/flare_fork_schedule.go
+cp $WORKING_DIR/src/forks/flare_fork_schedule_test.go ./scripts/coreth_changes/flare_fork_schedule_test.go
+cp $WORKING_DIR/src/forks/flare_chain_config.go ./scripts/coreth_changes/flare_chain_config.go
+cp $WORKING_DIR/src/forks/flare_chain_config_test.go ./scripts/coreth_changes/flare_chain_config_test.go
+cp $WORKING_DIR/src/fba/fba_registry.go ./scripts/coreth_changes/fba_registry.go
+cp $WORKING_DIR/src/fba/fba_registry_test.go ./scripts/coreth_changes/fba_registry_test.go
+cp $WORKING_DIR/src/fba/fba_weights.go ./scripts/coreth_changes/fba_weights.go
+cp $WORKING_DIR/src/fba/fba_weights_test.go ./scripts/coreth_changes/fba_weights_test.go
+mkdir ./scripts/coreth_changes/attestor
+cp $WORKING_DIR/src/attestor/*.go ./scripts/coreth_changes/attestor/
 
 export ROCKSDBALLOWED=1
 ./scripts/build.sh
+go build -o ./build/fbacheck ./cmd/fbacheck
 rm -rf ./scripts/coreth_changes
 chmod -R 775 $GOPATH/src/github.com/ava-labs
 chmod -R 775 $GOPATH/pkg/mod/github.com/ava-labs
\ No newline at end of file
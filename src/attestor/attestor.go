@@ -0,0 +1,284 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ava-labs/coreth/core/stateconnector"
+	"github.com/ava-labs/coreth/core/types"
+	"github.com/ava-labs/coreth/interfaces"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// Selectors of StateConnector.sol
+var (
+	submitAttestationSelector = []byte{0xcf, 0xd1, 0xfd, 0xad} // submitAttestation(uint256,bytes32,bytes32,bytes32)
+	getAttestationSelector    = []byte{0x29, 0xbe, 0x4d, 0xb2} // getAttestation(uint256)
+	totalBuffersSelector      = []byte{0xec, 0x74, 0x24, 0xa0} // totalBuffers()
+	merkleRootsSelector       = []byte{0x71, 0xc5, 0xec, 0xb1} // merkleRoots(uint256)
+)
+
+var errBufferElapsed = errors.New("buffer elapsed before the attestation was accepted")
+
+// Client is the subset of the C-chain RPC client used by the attestor
+type Client interface {
+	PendingNonceAt(ctx context.Context, account common.Address) (uint64, error)
+	SuggestGasPrice(ctx context.Context) (*big.Int, error)
+	SendTransaction(ctx context.Context, tx *types.Transaction) error
+	TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error)
+	CallContract(ctx context.Context, call interfaces.CallMsg, blockNumber *big.Int) ([]byte, error)
+}
+
+// VoteSource provides the merkle hash that the attestor commits to in a buffer
+type VoteSource interface {
+	MerkleHash(ctx context.Context, bufferNumber uint64) (common.Hash, error)
+}
+
+// mockVoteSource votes for keccak256(bufferNumber), like the JS mock client, so that every
+// mock attestor agrees on every round
+type mockVoteSource struct{}
+
+func (mockVoteSource) MerkleHash(_ context.Context, bufferNumber uint64) (common.Hash, error) {
+	return crypto.Keccak256Hash(common.BigToHash(new(big.Int).SetUint64(bufferNumber)).Bytes()), nil
+}
+
+// Config holds the settings of an Attestor
+type Config struct {
+	StateConnector common.Address
+	ChainID        *big.Int
+	Gas            uint64
+	GasPrice       *big.Int      // nil to use the node's suggestion
+	SubmitMargin   time.Duration // do not submit in the last SubmitMargin of a buffer
+	RetryInterval  time.Duration
+}
+
+// Attestor submits a commit/reveal vote to the StateConnector in every buffer and checks that
+// its votes were counted once the round is finalised
+type Attestor struct {
+	config  Config
+	client  Client
+	key     *ecdsa.PrivateKey
+	address common.Address
+	signer  types.Signer
+	store   *commitmentStore
+	source  VoteSource
+	now     func() time.Time
+}
+
+func NewAttestor(config Config, client Client, key *ecdsa.PrivateKey, store *commitmentStore, source VoteSource) *Attestor {
+	return &Attestor{
+		config:  config,
+		client:  client,
+		key:     key,
+		address: crypto.PubkeyToAddress(key.PublicKey),
+		signer:  types.LatestSignerForChainID(config.ChainID),
+		store:   store,
+		source:  source,
+		now:     time.Now,
+	}
+}
+
+// bufferAt returns the buffer that [t] falls in and the time at which the next buffer starts
+func bufferAt(t time.Time) (uint64, time.Time) {
+	bufferNumber := stateconnector.BufferNumber(big.NewInt(t.Unix())).Uint64()
+	return bufferNumber, time.Unix(int64(stateconnector.BufferStartTime(bufferNumber+1)), 0)
+}
+
+// Run votes in every buffer until [ctx] is cancelled
+func (a *Attestor) Run(ctx context.Context) error {
+	log.Info("Starting attestor", "address", a.address, "stateConnector", a.config.StateConnector)
+	for {
+		bufferNumber, bufferEnd := bufferAt(a.now())
+		if bufferEnd.Sub(a.now()) < a.config.SubmitMargin {
+			log.Debug("Too late in buffer to submit, waiting for the next one", "bufferNumber", bufferNumber)
+		} else if c := a.store.Get(bufferNumber); c != nil && c.Submitted {
+			log.Debug("Already voted in buffer", "bufferNumber", bufferNumber)
+		} else {
+			bufferCtx, cancel := context.WithDeadline(ctx, bufferEnd)
+			err := a.attest(bufferCtx, bufferNumber)
+			if err == nil {
+				a.verify(bufferCtx, bufferNumber)
+			}
+			cancel()
+			if err != nil {
+				log.Error("Failed to submit attestation", "bufferNumber", bufferNumber, "error", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Until(bufferEnd)):
+		}
+	}
+}
+
+// attest commits to this buffer's merkle hash and reveals the random of the previous buffer
+func (a *Attestor) attest(ctx context.Context, bufferNumber uint64) error {
+	c := a.store.Get(bufferNumber)
+	if c == nil {
+		merkleHash, err := a.source.MerkleHash(ctx, bufferNumber)
+		if err != nil {
+			return fmt.Errorf("failed to get merkle hash: %w", err)
+		}
+		c = &Commitment{BufferNumber: bufferNumber, MerkleHash: merkleHash}
+		if _, err := rand.Read(c.Random[:]); err != nil {
+			return fmt.Errorf("failed to generate random: %w", err)
+		}
+		// Persist the random before it can end up on chain
+		if err := a.store.Put(c); err != nil {
+			return fmt.Errorf("failed to store commitment: %w", err)
+		}
+	}
+	// The previous random is revealed even if its transaction was never confirmed, it may
+	// still have been included
+	var prevRandom common.Hash
+	if prev := a.store.Get(bufferNumber - 1); prev != nil {
+		prevRandom = prev.Random
+	} else {
+		log.Info("No commitment from the previous buffer to reveal", "bufferNumber", bufferNumber)
+	}
+	vote := stateconnector.NewVote(c.MerkleHash, c.Random, prevRandom)
+
+	receipt, err := a.submit(ctx, encodeSubmitAttestation(bufferNumber, vote))
+	if err != nil {
+		return err
+	}
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		return fmt.Errorf("submitAttestation reverted in transaction %s", receipt.TxHash.Hex())
+	}
+	c.TxHash = receipt.TxHash
+	c.Submitted = true
+	if err := a.store.Put(c); err != nil {
+		return fmt.Errorf("failed to store commitment: %w", err)
+	}
+	log.Info("Submitted attestation", "bufferNumber", bufferNumber, "merkleHash", c.MerkleHash, "tx", receipt.TxHash)
+	return nil
+}
+
+// submit signs and sends a transaction to the StateConnector and waits for its receipt. The
+// nonce is fetched again after every failed attempt, so a transaction sent from the same
+// account by someone else, or a dropped transaction, does not wedge the attestor.
+func (a *Attestor) submit(ctx context.Context, data []byte) (*types.Receipt, error) {
+	for attempt := 1; ; attempt++ {
+		receipt, err := a.trySubmit(ctx, data)
+		if err == nil {
+			return receipt, nil
+		}
+		log.Warn("Attestation attempt failed", "attempt", attempt, "error", err)
+		select {
+		case <-ctx.Done():
+			return nil, errBufferElapsed
+		case <-time.After(a.config.RetryInterval):
+		}
+	}
+}
+
+func (a *Attestor) trySubmit(ctx context.Context, data []byte) (*types.Receipt, error) {
+	nonce, err := a.client.PendingNonceAt(ctx, a.address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get nonce: %w", err)
+	}
+	gasPrice := a.config.GasPrice
+	if gasPrice == nil {
+		if gasPrice, err = a.client.SuggestGasPrice(ctx); err != nil {
+			return nil, fmt.Errorf("failed to get gas price: %w", err)
+		}
+	}
+	tx, err := types.SignTx(types.NewTransaction(nonce, a.config.StateConnector, big.NewInt(0), a.config.Gas, gasPrice, data), a.signer, a.key)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.client.SendTransaction(ctx, tx); err != nil {
+		return nil, fmt.Errorf("failed to send transaction: %w", err)
+	}
+	for {
+		receipt, err := a.client.TransactionReceipt(ctx, tx.Hash())
+		if err == nil && receipt != nil {
+			return receipt, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, errBufferElapsed
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// verify checks the commitment made two buffers before [bufferNumber], whose round was
+// finalised by the first attestation submitted in [bufferNumber]
+func (a *Attestor) verify(ctx context.Context, bufferNumber uint64) {
+	for _, c := range a.store.Pending() {
+		round := c.BufferNumber + 2
+		if round > bufferNumber {
+			continue
+		}
+		if round == bufferNumber {
+			a.verifyAttestation(ctx, c)
+		}
+		a.verifyMerkleRoot(ctx, c, round)
+		c.Verified = true
+		if err := a.store.Put(c); err != nil {
+			log.Error("Failed to store commitment", "bufferNumber", c.BufferNumber, "error", err)
+		}
+	}
+}
+
+// verifyAttestation checks that the contract unmasks our vote to the hash we committed to
+func (a *Attestor) verifyAttestation(ctx context.Context, c *Commitment) {
+	round := new(big.Int).SetUint64(c.BufferNumber + 2)
+	ret, err := a.call(ctx, append(append([]byte{}, getAttestationSelector...), common.BigToHash(round).Bytes()...))
+	if err != nil {
+		log.Warn("Vote was not counted, getAttestation failed", "bufferNumber", c.BufferNumber, "round", round, "error", err)
+		return
+	}
+	if got := common.BytesToHash(ret); got != c.MerkleHash {
+		log.Warn("Vote was not counted as committed", "bufferNumber", c.BufferNumber, "round", round, "want", c.MerkleHash, "got", got)
+	}
+}
+
+// verifyMerkleRoot checks that [round] was finalised with the hash we committed to
+func (a *Attestor) verifyMerkleRoot(ctx context.Context, c *Commitment, round uint64) {
+	ret, err := a.call(ctx, totalBuffersSelector)
+	if err != nil {
+		log.Warn("Failed to read totalBuffers", "error", err)
+		return
+	}
+	if totalBuffers := new(big.Int).SetBytes(ret); totalBuffers.Cmp(new(big.Int).SetUint64(round)) < 0 {
+		log.Warn("Round was not finalised", "round", round, "totalBuffers", totalBuffers)
+		return
+	}
+	index := big.NewInt(int64(stateconnector.ProofIndex(new(big.Int).SetUint64(round))))
+	ret, err = a.call(ctx, append(append([]byte{}, merkleRootsSelector...), common.BigToHash(index).Bytes()...))
+	if err != nil {
+		log.Warn("Failed to read merkle root", "round", round, "error", err)
+		return
+	}
+	if root := common.BytesToHash(ret); root != c.MerkleHash {
+		log.Warn("Round was finalised with a different merkle root", "round", round, "want", c.MerkleHash, "got", root)
+		return
+	}
+	log.Info("Vote counted in finalised round", "bufferNumber", c.BufferNumber, "round", round, "merkleRoot", c.MerkleHash)
+}
+
+func (a *Attestor) call(ctx context.Context, data []byte) ([]byte, error) {
+	to := a.config.StateConnector
+	return a.client.CallContract(ctx, interfaces.CallMsg{From: a.address, To: &to, Data: data}, nil)
+}
+
+func encodeSubmitAttestation(bufferNumber uint64, vote stateconnector.Vote) []byte {
+	data := append([]byte{}, submitAttestationSelector...)
+	data = append(data, common.BigToHash(new(big.Int).SetUint64(bufferNumber)).Bytes()...)
+	data = append(data, vote.MaskedMerkleHash.Bytes()...)
+	data = append(data, vote.CommittedRandom.Bytes()...)
+	return append(data, vote.RevealedRandom.Bytes()...)
+}
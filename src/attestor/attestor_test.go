@@ -0,0 +1,173 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package main
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ava-labs/coreth/core/stateconnector"
+	"github.com/ava-labs/coreth/core/types"
+	"github.com/ava-labs/coreth/interfaces"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// fakeClient accepts every transaction and mines it immediately
+type fakeClient struct {
+	nonce uint64
+	sent  []*types.Transaction
+	calls map[string][]byte
+}
+
+func (c *fakeClient) PendingNonceAt(context.Context, common.Address) (uint64, error) {
+	return c.nonce, nil
+}
+
+func (c *fakeClient) SuggestGasPrice(context.Context) (*big.Int, error) {
+	return big.NewInt(225000000000), nil
+}
+
+func (c *fakeClient) SendTransaction(_ context.Context, tx *types.Transaction) error {
+	c.sent = append(c.sent, tx)
+	c.nonce++
+	return nil
+}
+
+func (c *fakeClient) TransactionReceipt(_ context.Context, txHash common.Hash) (*types.Receipt, error) {
+	return &types.Receipt{Status: types.ReceiptStatusSuccessful, TxHash: txHash}, nil
+}
+
+func (c *fakeClient) CallContract(_ context.Context, call interfaces.CallMsg, _ *big.Int) ([]byte, error) {
+	return c.calls[string(call.Data)], nil
+}
+
+func newTestAttestor(t *testing.T, client Client, dir string) *Attestor {
+	key, err := crypto.HexToECDSA("44b8de040dec19cf810efe64919b481e05e2ba643efe003223662f1626b114f0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := openCommitmentStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := Config{
+		StateConnector: common.HexToAddress("0x1000000000000000000000000000000000000001"),
+		ChainID:        big.NewInt(16),
+		Gas:            1000000,
+		RetryInterval:  time.Millisecond,
+	}
+	return NewAttestor(config, client, key, store, mockVoteSource{})
+}
+
+func decodeSubmitAttestation(t *testing.T, tx *types.Transaction) (uint64, stateconnector.Vote) {
+	data := tx.Data()
+	if len(data) != 4+4*32 || string(data[:4]) != string(submitAttestationSelector) {
+		t.Fatalf("unexpected transaction data %x", data)
+	}
+	return new(big.Int).SetBytes(data[4:36]).Uint64(), stateconnector.Vote{
+		MaskedMerkleHash: common.BytesToHash(data[36:68]),
+		CommittedRandom:  common.BytesToHash(data[68:100]),
+		RevealedRandom:   common.BytesToHash(data[100:132]),
+	}
+}
+
+func TestAttestorRevealsAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	client := &fakeClient{}
+	ctx := context.Background()
+
+	if err := newTestAttestor(t, client, dir).attest(ctx, 100); err != nil {
+		t.Fatal(err)
+	}
+	// A new attestor on the same state directory reveals the random committed to before the restart
+	if err := newTestAttestor(t, client, dir).attest(ctx, 101); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.sent) != 2 || client.sent[0].Nonce() != 0 || client.sent[1].Nonce() != 1 {
+		t.Fatalf("unexpected transactions %v", client.sent)
+	}
+	bufferNumber, commit := decodeSubmitAttestation(t, client.sent[0])
+	if bufferNumber != 100 || commit.RevealedRandom != (common.Hash{}) {
+		t.Errorf("unexpected first vote %d %+v", bufferNumber, commit)
+	}
+	bufferNumber, reveal := decodeSubmitAttestation(t, client.sent[1])
+	if bufferNumber != 101 {
+		t.Errorf("unexpected buffer number %d", bufferNumber)
+	}
+	if stateconnector.Commit(reveal.RevealedRandom) != commit.CommittedRandom {
+		t.Fatalf("revealed random does not match the commitment")
+	}
+	want, _ := mockVoteSource{}.MerkleHash(ctx, 100)
+	if stateconnector.Mask(commit.MaskedMerkleHash, reveal.RevealedRandom) != want {
+		t.Errorf("vote does not unmask to the merkle hash")
+	}
+	if reveal.CommittedRandom == commit.CommittedRandom {
+		t.Errorf("random reused across buffers")
+	}
+}
+
+func TestAttestorVerifiesFinalisedRound(t *testing.T) {
+	client := &fakeClient{calls: make(map[string][]byte)}
+	a := newTestAttestor(t, client, t.TempDir())
+	ctx := context.Background()
+	for bufferNumber := uint64(100); bufferNumber <= 102; bufferNumber++ {
+		if bufferNumber == 102 {
+			merkleHash, _ := mockVoteSource{}.MerkleHash(ctx, 100)
+			client.calls[string(totalBuffersSelector)] = common.BigToHash(big.NewInt(102)).Bytes()
+			index := common.BigToHash(big.NewInt(int64(stateconnector.ProofIndex(big.NewInt(102))))).Bytes()
+			client.calls[string(append(append([]byte{}, merkleRootsSelector...), index...))] = merkleHash.Bytes()
+			round := common.BigToHash(big.NewInt(102)).Bytes()
+			client.calls[string(append(append([]byte{}, getAttestationSelector...), round...))] = merkleHash.Bytes()
+		}
+		if err := a.attest(ctx, bufferNumber); err != nil {
+			t.Fatal(err)
+		}
+		a.verify(ctx, bufferNumber)
+	}
+	if c := a.store.Get(100); c == nil || !c.Verified {
+		t.Errorf("commitment of buffer 100 not verified: %+v", c)
+	}
+	if c := a.store.Get(101); c == nil || c.Verified {
+		t.Errorf("commitment of buffer 101 verified before its round: %+v", c)
+	}
+}
+
+func TestCommitmentStorePrunes(t *testing.T) {
+	dir := t.TempDir()
+	store, err := openCommitmentStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for bufferNumber := uint64(1); bufferNumber <= 10; bufferNumber++ {
+		if err := store.Put(&Commitment{BufferNumber: bufferNumber, Submitted: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reopened, err := openCommitmentStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reopened.commitments) != commitmentRetention+1 || reopened.Get(10-commitmentRetention) == nil || reopened.Get(9-commitmentRetention) != nil {
+		t.Errorf("unexpected commitments after pruning %v", reopened.commitments)
+	}
+	pending := reopened.Pending()
+	for i := 1; i < len(pending); i++ {
+		if pending[i-1].BufferNumber >= pending[i].BufferNumber {
+			t.Errorf("pending commitments out of order")
+		}
+	}
+}
+
+func TestBufferAt(t *testing.T) {
+	start := time.Unix(int64(stateconnector.BufferStartTime(42)), 0)
+	for _, offset := range []time.Duration{0, 45 * time.Second, 89 * time.Second} {
+		bufferNumber, end := bufferAt(start.Add(offset))
+		if bufferNumber != 42 || !end.Equal(start.Add(stateconnector.BufferWindow*time.Second)) {
+			t.Errorf("bufferAt(start+%s): got %d ending %s", offset, bufferNumber, end)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+// The attestor submits StateConnector votes for one attestation provider account. It replaces
+// the JS StateConnectorMockClient: randoms are generated with crypto/rand and stored before they
+// are committed to, so a restarted attestor can still reveal them.
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ava-labs/coreth/accounts/keystore"
+	"github.com/ava-labs/coreth/ethclient"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+var (
+	rpcURL         = flag.String("rpc", "http://127.0.0.1:9650/ext/bc/C/rpc", "C-chain RPC endpoint")
+	stateConnector = flag.String("state-connector", "0x1000000000000000000000000000000000000001", "StateConnector contract address")
+	keystoreFile   = flag.String("keystore", "", "encrypted keystore file of the attestation provider account")
+	passwordFile   = flag.String("password-file", "", "file holding the keystore password")
+	keyFile        = flag.String("key-file", "", "file holding the hex private key of the attestation provider account, for local networks")
+	stateDir       = flag.String("state-dir", "attestor", "directory in which commitments are persisted")
+	gas            = flag.Uint64("gas", 1000000, "gas limit of submitAttestation transactions")
+	gasPriceGwei   = flag.Uint64("gas-price", 0, "gas price in gwei, 0 to use the node's suggestion")
+	submitMargin   = flag.Duration("submit-margin", 10*time.Second, "do not submit in the last part of a buffer")
+	retryInterval  = flag.Duration("retry-interval", 3*time.Second, "delay between failed submission attempts")
+	verbosity      = flag.Int("verbosity", int(log.LvlInfo), "log level, 0=crit to 5=trace")
+)
+
+func main() {
+	flag.Parse()
+	log.Root().SetHandler(log.LvlFilterHandler(log.Lvl(*verbosity), log.StreamHandler(os.Stderr, log.TerminalFormat(false))))
+	if err := run(); err != nil && err != context.Canceled {
+		log.Crit("Attestor stopped", "error", err)
+	}
+}
+
+func run() error {
+	key, err := loadKey()
+	if err != nil {
+		return err
+	}
+	if !common.IsHexAddress(*stateConnector) {
+		return fmt.Errorf("invalid state connector address %q", *stateConnector)
+	}
+	store, err := openCommitmentStore(*stateDir)
+	if err != nil {
+		return fmt.Errorf("failed to open commitment store: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		log.Info("Shutting down attestor")
+		cancel()
+	}()
+
+	client, err := ethclient.DialContext(ctx, *rpcURL)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", *rpcURL, err)
+	}
+	defer client.Close()
+	chainID, err := client.ChainID(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get chain ID: %w", err)
+	}
+
+	config := Config{
+		StateConnector: common.HexToAddress(*stateConnector),
+		ChainID:        chainID,
+		Gas:            *gas,
+		SubmitMargin:   *submitMargin,
+		RetryInterval:  *retryInterval,
+	}
+	if *gasPriceGwei > 0 {
+		config.GasPrice = new(big.Int).Mul(new(big.Int).SetUint64(*gasPriceGwei), big.NewInt(1000000000))
+	}
+	return NewAttestor(config, client, key, store, mockVoteSource{}).Run(ctx)
+}
+
+// loadKey reads the attestation provider key from an encrypted keystore, or from a plain key
+// file that only its owner can read
+func loadKey() (*ecdsa.PrivateKey, error) {
+	switch {
+	case *keystoreFile != "" && *keyFile != "":
+		return nil, fmt.Errorf("-keystore and -key-file are mutually exclusive")
+	case *keystoreFile != "":
+		keyJSON, err := ioutil.ReadFile(*keystoreFile)
+		if err != nil {
+			return nil, err
+		}
+		password, err := readSecretFile(*passwordFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read password: %w", err)
+		}
+		k, err := keystore.DecryptKey(keyJSON, password)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt keystore: %w", err)
+		}
+		return k.PrivateKey, nil
+	case *keyFile != "":
+		hexKey, err := readSecretFile(*keyFile)
+		if err != nil {
+			return nil, err
+		}
+		return crypto.HexToECDSA(strings.TrimPrefix(hexKey, "0x"))
+	default:
+		return nil, fmt.Errorf("one of -keystore or -key-file is required")
+	}
+}
+
+// readSecretFile returns the trimmed contents of [path], refusing files that other users can read
+func readSecretFile(path string) (string, error) {
+	if path == "" {
+		return "", fmt.Errorf("no file given")
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return "", fmt.Errorf("%s is accessible by other users, restrict it with chmod 600", path)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
@@ -0,0 +1,135 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Number of buffers a commitment is kept for: it is revealed in the next buffer and verified
+// two buffers after it was made
+const commitmentRetention = 4
+
+// Commitment is a vote made in one buffer. It is written to disk before the vote is sent so
+// that the random can still be revealed after a crash.
+type Commitment struct {
+	BufferNumber uint64      `json:"bufferNumber"`
+	MerkleHash   common.Hash `json:"merkleHash"`
+	Random       common.Hash `json:"random"`
+	TxHash       common.Hash `json:"txHash"`
+	Submitted    bool        `json:"submitted"`
+	Verified     bool        `json:"verified"`
+}
+
+// commitmentStore keeps the commitments of recent buffers in a JSON file
+type commitmentStore struct {
+	lock        sync.Mutex
+	path        string
+	commitments map[uint64]*Commitment
+}
+
+func openCommitmentStore(dir string) (*commitmentStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	s := &commitmentStore{
+		path:        filepath.Join(dir, "commitments.json"),
+		commitments: make(map[uint64]*Commitment),
+	}
+	data, err := ioutil.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var commitments []*Commitment
+	if err := json.Unmarshal(data, &commitments); err != nil {
+		return nil, err
+	}
+	for _, c := range commitments {
+		s.commitments[c.BufferNumber] = c
+	}
+	return s, nil
+}
+
+// Get returns a copy of the commitment made in [bufferNumber], or nil
+func (s *commitmentStore) Get(bufferNumber uint64) *Commitment {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	c, ok := s.commitments[bufferNumber]
+	if !ok {
+		return nil
+	}
+	cpy := *c
+	return &cpy
+}
+
+// Put stores [c], drops commitments that are too old to be revealed or verified and syncs the
+// file to disk
+func (s *commitmentStore) Put(c *Commitment) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	cpy := *c
+	s.commitments[c.BufferNumber] = &cpy
+	for bufferNumber := range s.commitments {
+		if bufferNumber+commitmentRetention < c.BufferNumber {
+			delete(s.commitments, bufferNumber)
+		}
+	}
+	return s.flush()
+}
+
+// Pending returns the submitted commitments that have not been verified yet, oldest first
+func (s *commitmentStore) Pending() []*Commitment {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var pending []*Commitment
+	for _, c := range s.commitments {
+		if c.Submitted && !c.Verified {
+			cpy := *c
+			pending = append(pending, &cpy)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].BufferNumber < pending[j].BufferNumber })
+	return pending
+}
+
+// flush writes the commitments to a temporary file and renames it over the store, so that a
+// crash never leaves a partially written file behind
+func (s *commitmentStore) flush() error {
+	commitments := make([]*Commitment, 0, len(s.commitments))
+	for _, c := range s.commitments {
+		commitments = append(commitments, c)
+	}
+	sort.Slice(commitments, func(i, j int) bool { return commitments[i].BufferNumber < commitments[j].BufferNumber })
+	data, err := json.MarshalIndent(commitments, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
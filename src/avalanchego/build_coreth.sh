@@ -32,19 +32,74 @@ fi
 echo "Applying Flare-specific changes to Coreth..."
 chmod -R 775 $coreth_path
 cp $AVALANCHE_PATH/scripts/coreth_changes/vm.go $coreth_path/plugin/evm/vm.go
+cp $AVALANCHE_PATH/scripts/coreth_changes/state_connector_service.go $coreth_path/plugin/evm/state_connector_service.go
+cp $AVALANCHE_PATH/scripts/coreth_changes/fork_checkpoint_service.go $coreth_path/plugin/evm/fork_checkpoint_service.go
+cp $AVALANCHE_PATH/scripts/coreth_changes/fork_checkpoint_service_test.go $coreth_path/plugin/evm/fork_checkpoint_service_test.go
+cp $AVALANCHE_PATH/scripts/coreth_changes/flare_fork_service.go $coreth_path/plugin/evm/flare_fork_service.go
+cp $AVALANCHE_PATH/scripts/coreth_changes/flare_config.go $coreth_path/plugin/evm/flare_config.go
+cp $AVALANCHE_PATH/scripts/coreth_changes/flare_config_test.go $coreth_path/plugin/evm/flare_config_test.go
+cp $AVALANCHE_PATH/scripts/coreth_changes/attestor_admin_service.go $coreth_path/plugin/evm/attestor_admin_service.go
+cp $AVALANCHE_PATH/scripts/coreth_changes/fba_registry_updates.go $coreth_path/plugin/evm/fba_registry_updates.go
+cp $AVALANCHE_PATH/scripts/coreth_changes/fba_registry_updates_test.go $coreth_path/plugin/evm/fba_registry_updates_test.go
+cp $AVALANCHE_PATH/scripts/coreth_changes/flare_daemon_service.go $coreth_path/plugin/evm/flare_daemon_service.go
+cp $AVALANCHE_PATH/scripts/coreth_changes/accepted_block_records.go $coreth_path/plugin/evm/accepted_block_records.go
 cp $AVALANCHE_PATH/scripts/coreth_changes/import_tx.go $coreth_path/plugin/evm/import_tx.go
 rm $coreth_path/plugin/evm/import_tx_test.go
 cp $AVALANCHE_PATH/scripts/coreth_changes/export_tx.go $coreth_path/plugin/evm/export_tx.go
 rm $coreth_path/plugin/evm/export_tx_test.go
 cp $AVALANCHE_PATH/scripts/coreth_changes/state_transition.go $coreth_path/core/state_transition.go
 cp $AVALANCHE_PATH/scripts/coreth_changes/state_connector.go $coreth_path/core/state_connector.go
+cp $AVALANCHE_PATH/scripts/coreth_changes/state_connector_test.go $coreth_path/core/state_connector_test.go
+cp $AVALANCHE_PATH/scripts/coreth_changes/state_connector_storage.go $coreth_path/core/state_connector_storage.go
+cp $AVALANCHE_PATH/scripts/coreth_changes/state_connector_storage_test.go $coreth_path/core/state_connector_storage_test.go
+cp $AVALANCHE_PATH/scripts/coreth_changes/state_connector_rounds.go $coreth_path/core/state_connector_rounds.go
+cp $AVALANCHE_PATH/scripts/coreth_changes/state_connector_rounds_test.go $coreth_path/core/state_connector_rounds_test.go
+cp $AVALANCHE_PATH/scripts/coreth_changes/block_records.go $coreth_path/core/block_records.go
+cp $AVALANCHE_PATH/scripts/coreth_changes/state_connector_fork.go $coreth_path/core/state_connector_fork.go
+cp $AVALANCHE_PATH/scripts/coreth_changes/state_connector_fork_test.go $coreth_path/core/state_connector_fork_test.go
+cp $AVALANCHE_PATH/scripts/coreth_changes/state_connector_rewards.go $coreth_path/core/state_connector_rewards.go
+cp $AVALANCHE_PATH/scripts/coreth_changes/state_connector_rewards_test.go $coreth_path/core/state_connector_rewards_test.go
+cp $AVALANCHE_PATH/scripts/coreth_changes/state_connector_penalties.go $coreth_path/core/state_connector_penalties.go
+cp $AVALANCHE_PATH/scripts/coreth_changes/state_connector_penalties_test.go $coreth_path/core/state_connector_penalties_test.go
+cp $AVALANCHE_PATH/scripts/coreth_changes/state_connector_weights.go $coreth_path/core/state_connector_weights.go
+cp $AVALANCHE_PATH/scripts/coreth_changes/state_connector_weights_test.go $coreth_path/core/state_connector_weights_test.go
+cp $AVALANCHE_PATH/scripts/coreth_changes/state_connector_attestors.go $coreth_path/core/state_connector_attestors.go
+cp $AVALANCHE_PATH/scripts/coreth_changes/state_connector_attestors_test.go $coreth_path/core/state_connector_attestors_test.go
+mkdir -p $coreth_path/core/stateconnector
+cp $AVALANCHE_PATH/scripts/coreth_changes/stateconnector.go $coreth_path/core/stateconnector/stateconnector.go
+cp $AVALANCHE_PATH/scripts/coreth_changes/stateconnector_test.go $coreth_path/core/stateconnector/stateconnector_test.go
+cp $AVALANCHE_PATH/scripts/coreth_changes/merkle.go $coreth_path/core/stateconnector/merkle.go
+cp $AVALANCHE_PATH/scripts/coreth_changes/merkle_test.go $coreth_path/core/stateconnector/merkle_test.go
+cp $AVALANCHE_PATH/scripts/coreth_changes/state_connector_precompile.go $coreth_path/core/vm/state_connector_precompile.go
+cp $AVALANCHE_PATH/scripts/coreth_changes/state_connector_precompile_test.go $coreth_path/core/vm/state_connector_precompile_test.go
 cp $AVALANCHE_PATH/scripts/coreth_changes/keeper.go $coreth_path/core/keeper.go
 cp $AVALANCHE_PATH/scripts/coreth_changes/keeper_test.go $coreth_path/core/keeper_test.go
+cp $AVALANCHE_PATH/scripts/coreth_changes/block_end_receipt.go $coreth_path/core/block_end_receipt.go
+cp $AVALANCHE_PATH/scripts/coreth_changes/block_end_receipt_test.go $coreth_path/core/block_end_receipt_test.go
+cp $AVALANCHE_PATH/scripts/coreth_changes/flare_daemon_records.go $coreth_path/core/flare_daemon_records.go
+cp $AVALANCHE_PATH/scripts/coreth_changes/flare_daemon_records_test.go $coreth_path/core/flare_daemon_records_test.go
+cp $AVALANCHE_PATH/scripts/coreth_changes/mint_ledger.go $coreth_path/core/mint_ledger.go
+cp $AVALANCHE_PATH/scripts/coreth_changes/mint_ledger_test.go $coreth_path/core/mint_ledger_test.go
+cp $AVALANCHE_PATH/scripts/coreth_changes/system_calls.go $coreth_path/core/system_calls.go
+cp $AVALANCHE_PATH/scripts/coreth_changes/system_calls_test.go $coreth_path/core/system_calls_test.go
+cp $AVALANCHE_PATH/scripts/coreth_changes/system_hooks.go $coreth_path/core/system_hooks.go
+cp $AVALANCHE_PATH/scripts/coreth_changes/system_hooks_test.go $coreth_path/core/system_hooks_test.go
+cp $AVALANCHE_PATH/scripts/coreth_changes/flare_fork_schedule.go $coreth_path/core/flare_fork_schedule.go
+cp $AVALANCHE_PATH/scripts/coreth_changes/flare_fork_schedule_test.go $coreth_path/core/flare_fork_schedule_test.go
+cp $AVALANCHE_PATH/scripts/coreth_changes/flare_chain_config.go $coreth_path/core/flare_chain_config.go
+cp $AVALANCHE_PATH/scripts/coreth_changes/flare_chain_config_test.go $coreth_path/core/flare_chain_config_test.go
+cp $AVALANCHE_PATH/scripts/coreth_changes/fba_registry.go $coreth_path/core/fba_registry.go
+cp $AVALANCHE_PATH/scripts/coreth_changes/fba_registry_test.go $coreth_path/core/fba_registry_test.go
+cp $AVALANCHE_PATH/scripts/coreth_changes/fba_weights.go $coreth_path/core/fba_weights.go
+cp $AVALANCHE_PATH/scripts/coreth_changes/fba_weights_test.go $coreth_path/core/fba_weights_test.go
+mkdir -p $coreth_path/cmd/attestor
+cp $AVALANCHE_PATH/scripts/coreth_changes/attestor/*.go $coreth_path/cmd/attestor/
 
 # Build Coreth
 echo "Building Coreth @ ${coreth_version} ..."
 cd "$coreth_path"
 go build -ldflags "-X github.com/ava-labs/coreth/plugin/evm.Version=$coreth_version $static_ld_flags" -o "$evm_path" "plugin/"*.go
+go build -o "$AVALANCHE_PATH/build/attestor" ./cmd/attestor
 cd "$AVALANCHE_PATH"
 
 # Building coreth + using go get can mess with the go.mod file.
@@ -0,0 +1,238 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package validators
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/bits"
+	"sort"
+	"strings"
+
+	"github.com/ava-labs/avalanchego/ids"
+	"github.com/ava-labs/avalanchego/utils/constants"
+)
+
+// Largest network FindDisjointFBAQuorums checks, the nodes of a set are kept in 64 bits
+const maxFBAQuorumCheckNodes = 64
+
+var (
+	errTooManyFBANodes = fmt.Errorf("quorum intersection can be checked for at most %d nodes", maxFBAQuorumCheckNodes)
+	errNoFBAQuorum     = errors.New("the nodes do not form any quorum")
+)
+
+// FBAQuorumSlice is an entry of the quorumSlices of an FBA validator file. A node accepts a
+// set of nodes as a quorum if, for at least one of its slices, Threshold of the slice's
+// Validators are in the set. Consensus does not enforce the thresholds: a node only samples
+// from the members of its slices, see sliceValidators. The thresholds describe the trust that
+// FindDisjointFBAQuorums checks across nodes.
+type FBAQuorumSlice struct {
+	Threshold  int      `json:"threshold"`
+	Validators []string `json:"validators"`
+}
+
+// QuorumSlice is a parsed FBAQuorumSlice
+type QuorumSlice struct {
+	Threshold  int
+	Validators []ids.ShortID
+}
+
+func parseQuorumSlices(slices []FBAQuorumSlice) ([]QuorumSlice, error) {
+	parsed := make([]QuorumSlice, len(slices))
+	for i, slice := range slices {
+		if len(slice.Validators) == 0 {
+			return nil, fmt.Errorf("quorum slice %d has no validators", i)
+		}
+		if slice.Threshold < 1 || slice.Threshold > len(slice.Validators) {
+			return nil, fmt.Errorf("quorum slice %d: threshold %d is not between 1 and its %d validators", i, slice.Threshold, len(slice.Validators))
+		}
+		seen := ids.ShortSet{}
+		for _, nodeIDStr := range slice.Validators {
+			if !strings.HasPrefix(nodeIDStr, constants.NodeIDPrefix) {
+				return nil, fmt.Errorf("quorum slice %d: node ID %q does not start with %s", i, nodeIDStr, constants.NodeIDPrefix)
+			}
+			nodeID, err := ids.ShortFromPrefixedString(nodeIDStr, constants.NodeIDPrefix)
+			if err != nil {
+				return nil, fmt.Errorf("quorum slice %d: invalid node ID %q: %w", i, nodeIDStr, err)
+			}
+			if seen.Contains(nodeID) {
+				return nil, fmt.Errorf("quorum slice %d: %s is listed twice", i, nodeIDStr)
+			}
+			seen.Add(nodeID)
+			parsed[i].Validators = append(parsed[i].Validators, nodeID)
+		}
+		parsed[i].Threshold = slice.Threshold
+	}
+	return parsed, nil
+}
+
+// ParseFBAQuorumSlices returns the quorum slices declared in an FBA validator file
+func ParseFBAQuorumSlices(b []byte) ([]QuorumSlice, error) {
+	list := FBAValidatorList{}
+	if err := json.Unmarshal(b, &list); err != nil {
+		return nil, err
+	}
+	return parseQuorumSlices(list.QuorumSlices)
+}
+
+// sliceValidators returns the validators of [vdrs] that are a member of at least one of
+// [slices], and the members that are not in [vdrs]
+func sliceValidators(vdrs []fbaValidator, slices []QuorumSlice) ([]fbaValidator, []ids.ShortID) {
+	members := ids.ShortSet{}
+	for _, slice := range slices {
+		members.Add(slice.Validators...)
+	}
+	var sliced []fbaValidator
+	for _, v := range vdrs {
+		if members.Contains(v.nodeID) {
+			sliced = append(sliced, v)
+			members.Remove(v.nodeID)
+		}
+	}
+	missing := members.List()
+	sort.Slice(missing, func(i, j int) bool { return missing[i].String() < missing[j].String() })
+	return sliced, missing
+}
+
+// FindDisjointFBAQuorums looks for two quorums without a node in common among [nodes], the
+// quorum slices declared by each node. It returns nil quorums if every two quorums intersect.
+// A quorum is a nonempty set of nodes that contains, for each of its nodes, one of that node's
+// slices. Every node that appears in a slice must be one of [nodes], and the nodes must form
+// at least one quorum.
+func FindDisjointFBAQuorums(nodes map[ids.ShortID][]QuorumSlice) ([]ids.ShortID, []ids.ShortID, error) {
+	if len(nodes) > maxFBAQuorumCheckNodes {
+		return nil, nil, errTooManyFBANodes
+	}
+	nodeIDs := make([]ids.ShortID, 0, len(nodes))
+	for nodeID := range nodes {
+		nodeIDs = append(nodeIDs, nodeID)
+	}
+	sort.Slice(nodeIDs, func(i, j int) bool { return nodeIDs[i].String() < nodeIDs[j].String() })
+	index := make(map[ids.ShortID]uint, len(nodeIDs))
+	for i, nodeID := range nodeIDs {
+		index[nodeID] = uint(i)
+	}
+
+	type maskedSlice struct {
+		threshold int
+		members   uint64
+	}
+	slices := make([][]maskedSlice, len(nodeIDs))
+	// A quorum that contains node i has at least minSize[i] nodes
+	minSize := make([]int, len(nodeIDs))
+	for i, nodeID := range nodeIDs {
+		if len(nodes[nodeID]) == 0 {
+			return nil, nil, fmt.Errorf("%s has no quorum slices", nodeID.PrefixedString(constants.NodeIDPrefix))
+		}
+		for k, slice := range nodes[nodeID] {
+			if k == 0 || slice.Threshold < minSize[i] {
+				minSize[i] = slice.Threshold
+			}
+			masked := maskedSlice{threshold: slice.Threshold}
+			for _, member := range slice.Validators {
+				j, ok := index[member]
+				if !ok {
+					return nil, nil, fmt.Errorf("%s trusts %s, whose quorum slices are unknown", nodeID.PrefixedString(constants.NodeIDPrefix), member.PrefixedString(constants.NodeIDPrefix))
+				}
+				masked.members |= 1 << j
+			}
+			slices[i] = append(slices[i], masked)
+		}
+	}
+
+	// largestQuorum removes the nodes that have no slice in [set] until every node left has one
+	largestQuorum := func(set uint64) uint64 {
+		for changed := true; changed; {
+			changed = false
+			for i := range nodeIDs {
+				if set&(1<<uint(i)) == 0 {
+					continue
+				}
+				satisfied := false
+				for _, slice := range slices[i] {
+					if bits.OnesCount64(slice.members&set) >= slice.threshold {
+						satisfied = true
+						break
+					}
+				}
+				if !satisfied {
+					set &^= 1 << uint(i)
+					changed = true
+				}
+			}
+		}
+		return set
+	}
+	// sizes returns the most that the nodes of [set] require of the size of a quorum, and the
+	// least
+	sizes := func(set uint64) (int, int) {
+		most, least := 0, 0
+		for i := range nodeIDs {
+			if set&(1<<uint(i)) == 0 {
+				continue
+			}
+			if minSize[i] > most {
+				most = minSize[i]
+			}
+			if least == 0 || minSize[i] < least {
+				least = minSize[i]
+			}
+		}
+		return most, least
+	}
+	toNodeIDs := func(set uint64) []ids.ShortID {
+		var quorum []ids.ShortID
+		for i, nodeID := range nodeIDs {
+			if set&(1<<uint(i)) != 0 {
+				quorum = append(quorum, nodeID)
+			}
+		}
+		return quorum
+	}
+
+	all := uint64(1)<<uint(len(nodeIDs)) - 1
+	if largestQuorum(all) == 0 {
+		return nil, nil, errNoFBAQuorum
+	}
+	// find looks for a quorum that contains [in] and is contained in [within], and whose
+	// complement contains a quorum, deciding on one node at a time. Every quorum within a set is
+	// part of the largest quorum of that set, and adding nodes to [in] only shrinks the quorums
+	// left in its complement, so a branch is dropped as soon as either rules it out, or once the
+	// two quorums cannot both fit in the nodes left to them.
+	var find func(in, within uint64) (uint64, uint64)
+	find = func(in, within uint64) (uint64, uint64) {
+		within = largestQuorum(within)
+		if in&^within != 0 {
+			return 0, 0
+		}
+		other := largestQuorum(all &^ in)
+		if other == 0 {
+			return 0, 0
+		}
+		if in != 0 {
+			if largestQuorum(in) == in {
+				return in, other
+			}
+			size, _ := sizes(in)
+			_, otherSize := sizes(other)
+			if size+otherSize > bits.OnesCount64(within|other) {
+				return 0, 0
+			}
+		}
+		undecided := within &^ in
+		if undecided == 0 {
+			return 0, 0
+		}
+		next := undecided & -undecided
+		if quorum, other := find(in|next, within); quorum != 0 {
+			return quorum, other
+		}
+		return find(in, within&^next)
+	}
+	if quorum, other := find(0, all); quorum != 0 {
+		return toNodeIDs(quorum), toNodeIDs(other), nil
+	}
+	return nil, nil, nil
+}
@@ -0,0 +1,184 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package validators
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ava-labs/avalanchego/ids"
+)
+
+// Give each of [nodes] the slice of [threshold] of [members]
+func fbaTestNetwork(nodes map[ids.ShortID][]QuorumSlice, members []ids.ShortID, threshold int) {
+	for _, nodeID := range members {
+		nodes[nodeID] = append(nodes[nodeID], QuorumSlice{Threshold: threshold, Validators: members})
+	}
+}
+
+func fbaTestNodes(from byte, to byte) []ids.ShortID {
+	var nodeIDs []ids.ShortID
+	for i := from; i < to; i++ {
+		nodeIDs = append(nodeIDs, ids.ShortID{i})
+	}
+	return nodeIDs
+}
+
+func TestParseQuorumSlices(t *testing.T) {
+	a, b := fbaTestNodeID(1), fbaTestNodeID(2)
+	tests := []struct {
+		name    string
+		slices  []FBAQuorumSlice
+		want    []QuorumSlice
+		wantErr string
+	}{
+		{"none", nil, []QuorumSlice{}, ""},
+		{"valid", []FBAQuorumSlice{{Threshold: 1, Validators: []string{a, b}}, {Threshold: 1, Validators: []string{b}}},
+			[]QuorumSlice{{Threshold: 1, Validators: []ids.ShortID{{1}, {2}}}, {Threshold: 1, Validators: []ids.ShortID{{2}}}}, ""},
+		{"empty", []FBAQuorumSlice{{Threshold: 1}}, nil, "no validators"},
+		{"zero threshold", []FBAQuorumSlice{{Threshold: 0, Validators: []string{a}}}, nil, "threshold 0"},
+		{"threshold above members", []FBAQuorumSlice{{Threshold: 3, Validators: []string{a, b}}}, nil, "threshold 3"},
+		{"no prefix", []FBAQuorumSlice{{Threshold: 1, Validators: []string{strings.TrimPrefix(a, "NodeID-")}}}, nil, "does not start with"},
+		{"invalid node ID", []FBAQuorumSlice{{Threshold: 1, Validators: []string{"NodeID-xyz"}}}, nil, "invalid node ID"},
+		{"listed twice", []FBAQuorumSlice{{Threshold: 1, Validators: []string{a, a}}}, nil, "listed twice"},
+	}
+	for _, test := range tests {
+		got, err := parseQuorumSlices(test.slices)
+		switch {
+		case test.wantErr == "" && (err != nil || !reflect.DeepEqual(got, test.want)):
+			t.Errorf("%s: got (%v, %v) want %v", test.name, got, err, test.want)
+		case test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)):
+			t.Errorf("%s: got %v want error containing %q", test.name, err, test.wantErr)
+		}
+	}
+}
+
+func TestSliceValidators(t *testing.T) {
+	vdrs := []fbaValidator{{nodeID: ids.ShortID{1}, weight: 1}, {nodeID: ids.ShortID{2}, weight: 2}, {nodeID: ids.ShortID{3}, weight: 3}}
+	slices := []QuorumSlice{
+		{Threshold: 1, Validators: []ids.ShortID{{3}, {5}}},
+		{Threshold: 2, Validators: []ids.ShortID{{1}, {3}, {4}}},
+	}
+	sliced, missing := sliceValidators(vdrs, slices)
+	if want := []fbaValidator{vdrs[0], vdrs[2]}; !reflect.DeepEqual(sliced, want) {
+		t.Errorf("got sliced validators %v want %v", sliced, want)
+	}
+	if want := []ids.ShortID{{4}, {5}}; !reflect.DeepEqual(missing, want) {
+		t.Errorf("got missing members %v want %v", missing, want)
+	}
+	if sliced, missing := sliceValidators(vdrs, nil); sliced != nil || len(missing) != 0 {
+		t.Errorf("without slices: got (%v, %v)", sliced, missing)
+	}
+}
+
+func TestFindDisjointFBAQuorums(t *testing.T) {
+	tests := []struct {
+		name     string
+		nodes    func() map[ids.ShortID][]QuorumSlice
+		disjoint bool
+		wantErr  error
+	}{
+		{"two thirds of four", func() map[ids.ShortID][]QuorumSlice {
+			nodes := make(map[ids.ShortID][]QuorumSlice)
+			fbaTestNetwork(nodes, fbaTestNodes(0, 4), 3)
+			return nodes
+		}, false, nil},
+		{"half of four", func() map[ids.ShortID][]QuorumSlice {
+			nodes := make(map[ids.ShortID][]QuorumSlice)
+			fbaTestNetwork(nodes, fbaTestNodes(0, 4), 2)
+			return nodes
+		}, true, nil},
+		{"two groups", func() map[ids.ShortID][]QuorumSlice {
+			nodes := make(map[ids.ShortID][]QuorumSlice)
+			fbaTestNetwork(nodes, fbaTestNodes(0, 3), 2)
+			fbaTestNetwork(nodes, fbaTestNodes(3, 6), 2)
+			return nodes
+		}, true, nil},
+		{"nodes outside the core", func() map[ids.ShortID][]QuorumSlice {
+			nodes := make(map[ids.ShortID][]QuorumSlice)
+			fbaTestNetwork(nodes, fbaTestNodes(0, 3), 2)
+			// Nodes 3 and 4 trust two of the three core nodes, without being trusted themselves
+			for _, nodeID := range fbaTestNodes(3, 5) {
+				nodes[nodeID] = []QuorumSlice{{Threshold: 2, Validators: fbaTestNodes(0, 3)}}
+			}
+			return nodes
+		}, false, nil},
+		{"more than half of sixty four", func() map[ids.ShortID][]QuorumSlice {
+			nodes := make(map[ids.ShortID][]QuorumSlice)
+			fbaTestNetwork(nodes, fbaTestNodes(0, 64), 33)
+			return nodes
+		}, false, nil},
+		{"two groups of thirty two", func() map[ids.ShortID][]QuorumSlice {
+			nodes := make(map[ids.ShortID][]QuorumSlice)
+			fbaTestNetwork(nodes, fbaTestNodes(0, 32), 17)
+			fbaTestNetwork(nodes, fbaTestNodes(32, 64), 17)
+			return nodes
+		}, true, nil},
+		{"too many nodes", func() map[ids.ShortID][]QuorumSlice {
+			nodes := make(map[ids.ShortID][]QuorumSlice)
+			fbaTestNetwork(nodes, fbaTestNodes(0, 65), 44)
+			return nodes
+		}, false, errTooManyFBANodes},
+		{"no quorum", func() map[ids.ShortID][]QuorumSlice {
+			return map[ids.ShortID][]QuorumSlice{
+				{1}: {{Threshold: 2, Validators: []ids.ShortID{{1}}}},
+			}
+		}, false, errNoFBAQuorum},
+	}
+	for _, test := range tests {
+		nodes := test.nodes()
+		a, b, err := FindDisjointFBAQuorums(nodes)
+		if err != test.wantErr || (a != nil) != test.disjoint {
+			t.Errorf("%s: got (%v, %v, %v)", test.name, a, b, err)
+			continue
+		}
+		if !test.disjoint {
+			continue
+		}
+		// Both must be quorums without a node in common
+		inA := ids.ShortSet{}
+		inA.Add(a...)
+		for _, quorum := range [][]ids.ShortID{a, b} {
+			in := ids.ShortSet{}
+			in.Add(quorum...)
+			for _, nodeID := range quorum {
+				satisfied := false
+				for _, slice := range nodes[nodeID] {
+					count := 0
+					for _, member := range slice.Validators {
+						if in.Contains(member) {
+							count++
+						}
+					}
+					satisfied = satisfied || count >= slice.Threshold
+				}
+				if !satisfied {
+					t.Errorf("%s: %v is not a quorum", test.name, quorum)
+				}
+			}
+		}
+		for _, nodeID := range b {
+			if inA.Contains(nodeID) {
+				t.Errorf("%s: quorums %v and %v intersect", test.name, a, b)
+			}
+		}
+	}
+}
+
+func TestFindDisjointFBAQuorumsErrors(t *testing.T) {
+	unknown := map[ids.ShortID][]QuorumSlice{
+		{1}: {{Threshold: 1, Validators: []ids.ShortID{{2}}}},
+	}
+	if _, _, err := FindDisjointFBAQuorums(unknown); err == nil || !strings.Contains(err.Error(), "unknown") {
+		t.Errorf("unknown member: got %v", err)
+	}
+	noSlices := map[ids.ShortID][]QuorumSlice{
+		{1}: {{Threshold: 1, Validators: []ids.ShortID{{1}}}},
+		{2}: nil,
+	}
+	if _, _, err := FindDisjointFBAQuorums(noSlices); err == nil || !strings.Contains(err.Error(), "no quorum slices") {
+		t.Errorf("node without slices: got %v", err)
+	}
+}
@@ -0,0 +1,205 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package fba
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gorilla/rpc/v2"
+
+	"github.com/ava-labs/avalanchego/ids"
+	"github.com/ava-labs/avalanchego/network"
+	"github.com/ava-labs/avalanchego/snow/engine/common"
+	"github.com/ava-labs/avalanchego/snow/validators"
+	"github.com/ava-labs/avalanchego/utils/constants"
+	"github.com/ava-labs/avalanchego/utils/json"
+	"github.com/ava-labs/avalanchego/utils/logging"
+
+	safemath "github.com/ava-labs/avalanchego/utils/math"
+)
+
+var errFBAValidatorsNotLoaded = errors.New("FBA validators have not been loaded")
+
+// Network is the part of the node's network that tells which validators are connected
+type Network interface {
+	Peers(nodeIDs []ids.ShortID) []network.PeerID
+}
+
+// FBA is the API service for inspecting the FBA validators a node is using
+type FBA struct {
+	log     logging.Logger
+	nodeID  ids.ShortID
+	network Network
+	// Returns the FBA validators in use, validators.GetFBAValidatorsInfo outside of tests
+	validators func() (validators.FBAValidatorsInfo, bool)
+}
+
+// NewService returns a new FBA API service
+func NewService(log logging.Logger, nodeID ids.ShortID, network Network) (*common.HTTPHandler, error) {
+	newServer := rpc.NewServer()
+	codec := json.NewCodec()
+	newServer.RegisterCodec(codec, "application/json")
+	newServer.RegisterCodec(codec, "application/json;charset=UTF-8")
+	if err := newServer.RegisterService(&FBA{
+		log:        log,
+		nodeID:     nodeID,
+		network:    network,
+		validators: validators.GetFBAValidatorsInfo,
+	}, "fba"); err != nil {
+		return nil, err
+	}
+	return &common.HTTPHandler{Handler: newServer}, nil
+}
+
+// connected returns the node IDs of [vdrs] that this node is connected to, itself included
+func (service *FBA) connected(vdrs []validators.FBAValidator) (map[string]bool, error) {
+	nodeIDs := make([]ids.ShortID, 0, len(vdrs))
+	for _, v := range vdrs {
+		nodeID, err := ids.ShortFromPrefixedString(v.NodeID, constants.NodeIDPrefix)
+		if err != nil {
+			return nil, err
+		}
+		nodeIDs = append(nodeIDs, nodeID)
+	}
+	connected := map[string]bool{service.nodeID.PrefixedString(constants.NodeIDPrefix): true}
+	if len(nodeIDs) == 0 {
+		return connected, nil
+	}
+	for _, peer := range service.network.Peers(nodeIDs) {
+		connected[peer.ID] = true
+	}
+	return connected, nil
+}
+
+// APIFBAValidator is a validator returned by getValidators
+type APIFBAValidator struct {
+	NodeID    string      `json:"nodeID"`
+	Weight    json.Uint64 `json:"weight"`
+	Connected bool        `json:"connected"`
+}
+
+// GetValidatorsReply are the results from calling GetValidators
+type GetValidatorsReply struct {
+	Validators  []APIFBAValidator `json:"validators"`
+	TotalWeight json.Uint64       `json:"totalWeight"`
+}
+
+// GetValidators returns the FBA validators this node samples from, with their weights and
+// whether this node is connected to them
+func (service *FBA) GetValidators(_ *http.Request, _ *struct{}, reply *GetValidatorsReply) error {
+	service.log.Info("FBA: GetValidators called")
+
+	info, ok := service.validators()
+	if !ok {
+		return errFBAValidatorsNotLoaded
+	}
+	connected, err := service.connected(info.Validators)
+	if err != nil {
+		return err
+	}
+	reply.Validators = make([]APIFBAValidator, len(info.Validators))
+	totalWeight := uint64(0)
+	for i, v := range info.Validators {
+		reply.Validators[i] = APIFBAValidator{NodeID: v.NodeID, Weight: json.Uint64(v.Weight), Connected: connected[v.NodeID]}
+		if totalWeight, err = safemath.Add64(totalWeight, v.Weight); err != nil {
+			return err
+		}
+	}
+	reply.TotalWeight = json.Uint64(totalWeight)
+	return nil
+}
+
+// GetConnectedWeightReply are the results from calling GetConnectedWeight
+type GetConnectedWeightReply struct {
+	ConnectedWeight json.Uint64 `json:"connectedWeight"`
+	TotalWeight     json.Uint64 `json:"totalWeight"`
+	// Share of the total weight that is connected, between 0 and 1
+	PercentConnected float64 `json:"percentConnected"`
+	// Validators this node is not connected to
+	Disconnected []string `json:"disconnected"`
+}
+
+// GetConnectedWeight returns how much of the FBA validators' weight this node is connected to
+func (service *FBA) GetConnectedWeight(_ *http.Request, _ *struct{}, reply *GetConnectedWeightReply) error {
+	service.log.Info("FBA: GetConnectedWeight called")
+
+	info, ok := service.validators()
+	if !ok {
+		return errFBAValidatorsNotLoaded
+	}
+	connected, err := service.connected(info.Validators)
+	if err != nil {
+		return err
+	}
+	var connectedWeight, totalWeight uint64
+	reply.Disconnected = []string{}
+	for _, v := range info.Validators {
+		if totalWeight, err = safemath.Add64(totalWeight, v.Weight); err != nil {
+			return err
+		}
+		if !connected[v.NodeID] {
+			reply.Disconnected = append(reply.Disconnected, v.NodeID)
+			continue
+		}
+		if connectedWeight, err = safemath.Add64(connectedWeight, v.Weight); err != nil {
+			return err
+		}
+	}
+	reply.ConnectedWeight = json.Uint64(connectedWeight)
+	reply.TotalWeight = json.Uint64(totalWeight)
+	if totalWeight > 0 {
+		reply.PercentConnected = float64(connectedWeight) / float64(totalWeight)
+	}
+	return nil
+}
+
+// GetInfoReply are the results from calling GetInfo
+type GetInfoReply struct {
+	// File the FBA validators were loaded from
+	Source string `json:"source"`
+	// Hex SHA-256 hash of the bootstrap file or of the registry set in use, equal on nodes that
+	// use the same registry set
+	SourceHash string `json:"sourceHash"`
+	// C-chain block from which the registry set in use applies, zero for the bootstrap file
+	ActivationHeight json.Uint64 `json:"activationHeight"`
+	// Hex SHA-256 hash of the validators in use, equal on nodes that use the same list
+	ValidatorsHash string      `json:"validatorsHash"`
+	NumValidators  json.Uint32 `json:"numValidators"`
+	AppliedAt      time.Time   `json:"appliedAt"`
+}
+
+// validatorsHash hashes the node IDs and weights of [vdrs] in node ID order
+func validatorsHash(vdrs []validators.FBAValidator) string {
+	sorted := append([]validators.FBAValidator(nil), vdrs...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].NodeID < sorted[j].NodeID })
+	h := sha256.New()
+	for _, v := range sorted {
+		fmt.Fprintf(h, "%s:%d\n", v.NodeID, v.Weight)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// GetInfo returns which file the FBA validators were loaded from and its hash, so that
+// operators can check that every node runs the same FBA list
+func (service *FBA) GetInfo(_ *http.Request, _ *struct{}, reply *GetInfoReply) error {
+	service.log.Info("FBA: GetInfo called")
+
+	info, ok := service.validators()
+	if !ok {
+		return errFBAValidatorsNotLoaded
+	}
+	reply.Source = info.Source
+	reply.SourceHash = hex.EncodeToString(info.SourceHash[:])
+	reply.ActivationHeight = json.Uint64(info.ActivationHeight)
+	reply.ValidatorsHash = validatorsHash(info.Validators)
+	reply.NumValidators = json.Uint32(len(info.Validators))
+	reply.AppliedAt = info.AppliedAt
+	return nil
+}
@@ -0,0 +1,154 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package fba
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ava-labs/avalanchego/ids"
+	"github.com/ava-labs/avalanchego/network"
+	"github.com/ava-labs/avalanchego/snow/validators"
+	"github.com/ava-labs/avalanchego/utils/constants"
+	"github.com/ava-labs/avalanchego/utils/logging"
+)
+
+// Define a network that is connected to a fixed set of peers
+type networkMock struct {
+	connected map[ids.ShortID]bool
+}
+
+func (n *networkMock) Peers(nodeIDs []ids.ShortID) []network.PeerID {
+	var peers []network.PeerID
+	for _, nodeID := range nodeIDs {
+		if n.connected[nodeID] {
+			peers = append(peers, network.PeerID{ID: nodeID.PrefixedString(constants.NodeIDPrefix)})
+		}
+	}
+	return peers
+}
+
+func testNodeID(i byte) string {
+	return ids.ShortID{i}.PrefixedString(constants.NodeIDPrefix)
+}
+
+// Return a service run by node 1 that is connected to node 2 only, and uses [info]
+func newTestService(info *validators.FBAValidatorsInfo) *FBA {
+	return &FBA{
+		log:     logging.NoLog{},
+		nodeID:  ids.ShortID{1},
+		network: &networkMock{connected: map[ids.ShortID]bool{{2}: true}},
+		validators: func() (validators.FBAValidatorsInfo, bool) {
+			if info == nil {
+				return validators.FBAValidatorsInfo{}, false
+			}
+			return *info, true
+		},
+	}
+}
+
+func testValidatorsInfo() *validators.FBAValidatorsInfo {
+	return &validators.FBAValidatorsInfo{
+		Validators: []validators.FBAValidator{
+			{NodeID: testNodeID(1), Weight: 10},
+			{NodeID: testNodeID(2), Weight: 20},
+			{NodeID: testNodeID(3), Weight: 30},
+		},
+		Source:     "fba_validators.json",
+		SourceHash: sha256.Sum256([]byte("fba_validators.json")),
+		AppliedAt:  time.Unix(1000, 0),
+	}
+}
+
+func TestFBAServiceNotLoaded(t *testing.T) {
+	service := newTestService(nil)
+	if err := service.GetValidators(nil, nil, &GetValidatorsReply{}); err != errFBAValidatorsNotLoaded {
+		t.Errorf("getValidators: got %v", err)
+	}
+	if err := service.GetConnectedWeight(nil, nil, &GetConnectedWeightReply{}); err != errFBAValidatorsNotLoaded {
+		t.Errorf("getConnectedWeight: got %v", err)
+	}
+	if err := service.GetInfo(nil, nil, &GetInfoReply{}); err != errFBAValidatorsNotLoaded {
+		t.Errorf("getInfo: got %v", err)
+	}
+}
+
+func TestFBAServiceGetValidators(t *testing.T) {
+	reply := GetValidatorsReply{}
+	if err := newTestService(testValidatorsInfo()).GetValidators(nil, nil, &reply); err != nil {
+		t.Fatal(err)
+	}
+	want := []APIFBAValidator{
+		{NodeID: testNodeID(1), Weight: 10, Connected: true},
+		{NodeID: testNodeID(2), Weight: 20, Connected: true},
+		{NodeID: testNodeID(3), Weight: 30, Connected: false},
+	}
+	if !reflect.DeepEqual(reply.Validators, want) || reply.TotalWeight != 60 {
+		t.Errorf("got %+v", reply)
+	}
+
+	info := testValidatorsInfo()
+	info.Validators[2].NodeID = "NodeID-xyz"
+	if err := newTestService(info).GetValidators(nil, nil, &GetValidatorsReply{}); err == nil {
+		t.Error("invalid node ID: expected an error")
+	}
+}
+
+func TestFBAServiceGetConnectedWeight(t *testing.T) {
+	reply := GetConnectedWeightReply{}
+	if err := newTestService(testValidatorsInfo()).GetConnectedWeight(nil, nil, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.ConnectedWeight != 30 || reply.TotalWeight != 60 || reply.PercentConnected != 0.5 || !reflect.DeepEqual(reply.Disconnected, []string{testNodeID(3)}) {
+		t.Errorf("got %+v", reply)
+	}
+
+	// Only this node
+	info := testValidatorsInfo()
+	info.Validators = info.Validators[:1]
+	reply = GetConnectedWeightReply{}
+	if err := newTestService(info).GetConnectedWeight(nil, nil, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.ConnectedWeight != 10 || reply.PercentConnected != 1 || reply.Disconnected == nil || len(reply.Disconnected) != 0 {
+		t.Errorf("got %+v", reply)
+	}
+}
+
+func TestFBAServiceGetInfo(t *testing.T) {
+	info := testValidatorsInfo()
+	reply := GetInfoReply{}
+	if err := newTestService(info).GetInfo(nil, nil, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Source != info.Source || reply.SourceHash != hex.EncodeToString(info.SourceHash[:]) || reply.NumValidators != 3 || !reply.AppliedAt.Equal(info.AppliedAt) {
+		t.Errorf("got %+v", reply)
+	}
+
+	// The validators hash does not depend on the order or the source of the validators
+	reordered := testValidatorsInfo()
+	reordered.Validators[0], reordered.Validators[2] = reordered.Validators[2], reordered.Validators[0]
+	reordered.Source, reordered.SourceHash = "fba_registry.json", sha256.Sum256([]byte("fba_registry.json"))
+	reordered.ActivationHeight = 110
+	other := GetInfoReply{}
+	if err := newTestService(reordered).GetInfo(nil, nil, &other); err != nil {
+		t.Fatal(err)
+	}
+	if reply.ActivationHeight != 0 || other.ActivationHeight != 110 {
+		t.Errorf("got activation heights %d and %d", reply.ActivationHeight, other.ActivationHeight)
+	}
+	if other.ValidatorsHash != reply.ValidatorsHash || other.SourceHash == reply.SourceHash {
+		t.Errorf("got hashes %s, %s and %s, %s", reply.ValidatorsHash, reply.SourceHash, other.ValidatorsHash, other.SourceHash)
+	}
+	reordered.Validators[0].Weight++
+	if err := newTestService(reordered).GetInfo(nil, nil, &other); err != nil {
+		t.Fatal(err)
+	}
+	if other.ValidatorsHash == reply.ValidatorsHash {
+		t.Error("validators hash does not change with the weights")
+	}
+}
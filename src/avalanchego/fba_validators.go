@@ -0,0 +1,551 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package validators
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ava-labs/avalanchego/ids"
+	"github.com/ava-labs/avalanchego/utils/constants"
+	"github.com/ava-labs/avalanchego/utils/logging"
+
+	safemath "github.com/ava-labs/avalanchego/utils/math"
+)
+
+// How often the FBA validator files are checked for changes. The registry file is also checked
+// whenever the C-chain accepts a block, see SetFBAAcceptedHeight.
+const fbaValidatorsPollInterval = 5 * time.Second
+
+var (
+	errNoFBAValidatorsFile    = errors.New("FBA_VALs is not set")
+	errNoFBAValidators        = errors.New("no validators listed")
+	errNoFBARegistrySets      = errors.New("no validator sets listed")
+	errNoSlicedValidators     = errors.New("none of the quorum slice members are listed")
+	errFBAValidatorsNotLoaded = errors.New("FBA validators are not loaded")
+
+	fbaLoaderLock sync.Mutex
+	fbaLoader     *fbaValidatorLoader
+)
+
+// FBAValidatorList is the format of the FBA_VALs file
+type FBAValidatorList struct {
+	Validators   []FBAValidator   `json:"validators"`
+	QuorumSlices []FBAQuorumSlice `json:"quorumSlices,omitempty"`
+}
+
+type FBAValidator struct {
+	NodeID string `json:"nodeID"`
+	Weight uint64 `json:"weight"`
+}
+
+// FBARegistryValidatorSets is the format of the FBA_REGISTRY_VALs file written by the C-chain.
+// It lists the set in effect at the last block the C-chain has accepted, followed by the sets
+// that take effect at later blocks, in order of activation.
+type FBARegistryValidatorSets struct {
+	ValidatorSets []FBARegistryValidatorSet `json:"validatorSets"`
+}
+
+// FBARegistryValidatorSet takes effect once the C-chain has accepted block [ActivationHeight].
+// A set without validators hands back to the FBA_VALs file.
+type FBARegistryValidatorSet struct {
+	ActivationHeight uint64         `json:"activationHeight"`
+	Validators       []FBAValidator `json:"validators"`
+}
+
+type fbaValidator struct {
+	nodeID ids.ShortID
+	weight uint64
+}
+
+// fbaRegistrySet is a parsed FBARegistryValidatorSet, [hash] is the SHA-256 hash of its JSON
+// encoding
+type fbaRegistrySet struct {
+	activationHeight uint64
+	validators       []fbaValidator
+	hash             [sha256.Size]byte
+}
+
+// parseFBAValidators parses an FBA_VALs file, see parseFBAValidatorEntries
+func parseFBAValidators(b []byte) ([]fbaValidator, []QuorumSlice, error) {
+	list := FBAValidatorList{}
+	if err := json.Unmarshal(b, &list); err != nil {
+		return nil, nil, err
+	}
+	if len(list.Validators) == 0 {
+		return nil, nil, errNoFBAValidators
+	}
+	slices, err := parseQuorumSlices(list.QuorumSlices)
+	if err != nil {
+		return nil, nil, err
+	}
+	vdrs, err := parseFBAValidatorEntries(list.Validators)
+	if err != nil {
+		return nil, nil, err
+	}
+	return vdrs, slices, nil
+}
+
+// parseFBAValidatorEntries checks that every node ID carries the NodeID- prefix and is listed
+// once, that every weight is positive and that the total weight fits in 64 bits
+func parseFBAValidatorEntries(entries []FBAValidator) ([]fbaValidator, error) {
+	vdrs := make([]fbaValidator, 0, len(entries))
+	seen := ids.ShortSet{}
+	totalWeight := uint64(0)
+	for i, v := range entries {
+		if !strings.HasPrefix(v.NodeID, constants.NodeIDPrefix) {
+			return nil, fmt.Errorf("validator %d: node ID %q does not start with %s", i, v.NodeID, constants.NodeIDPrefix)
+		}
+		nodeID, err := ids.ShortFromPrefixedString(v.NodeID, constants.NodeIDPrefix)
+		if err != nil {
+			return nil, fmt.Errorf("validator %d: invalid node ID %q: %w", i, v.NodeID, err)
+		}
+		if seen.Contains(nodeID) {
+			return nil, fmt.Errorf("validator %d: %s is listed twice", i, v.NodeID)
+		}
+		if v.Weight == 0 {
+			return nil, fmt.Errorf("validator %d: %s has zero weight", i, v.NodeID)
+		}
+		totalWeight, err = safemath.Add64(totalWeight, v.Weight)
+		if err != nil {
+			return nil, fmt.Errorf("validator %d: total weight overflows", i)
+		}
+		seen.Add(nodeID)
+		vdrs = append(vdrs, fbaValidator{nodeID: nodeID, weight: v.Weight})
+	}
+	return vdrs, nil
+}
+
+// parseFBARegistrySets parses an FBA_REGISTRY_VALs file. It has to list at least one set, the
+// activation heights have to increase down the list and the validators of every set have to be
+// valid.
+func parseFBARegistrySets(b []byte) ([]fbaRegistrySet, error) {
+	file := FBARegistryValidatorSets{}
+	if err := json.Unmarshal(b, &file); err != nil {
+		return nil, err
+	}
+	if len(file.ValidatorSets) == 0 {
+		return nil, errNoFBARegistrySets
+	}
+	sets := make([]fbaRegistrySet, len(file.ValidatorSets))
+	for i, set := range file.ValidatorSets {
+		if i > 0 && set.ActivationHeight <= file.ValidatorSets[i-1].ActivationHeight {
+			return nil, fmt.Errorf("set %d: activation height %d is not after %d", i, set.ActivationHeight, file.ValidatorSets[i-1].ActivationHeight)
+		}
+		vdrs, err := parseFBAValidatorEntries(set.Validators)
+		if err != nil {
+			return nil, fmt.Errorf("set %d: %w", i, err)
+		}
+		b, err := json.Marshal(set)
+		if err != nil {
+			return nil, err
+		}
+		sets[i] = fbaRegistrySet{activationHeight: set.ActivationHeight, validators: vdrs, hash: sha256.Sum256(b)}
+	}
+	return sets, nil
+}
+
+// fbaValidatorFile tracks a validator file so that it is only parsed again once it changes
+type fbaValidatorFile struct {
+	path    string
+	exists  bool
+	modTime time.Time
+	size    int64
+}
+
+// changed reports whether the file was created, modified or removed since the last call
+func (f *fbaValidatorFile) changed() bool {
+	if f.path == "" {
+		return false
+	}
+	info, err := os.Stat(f.path)
+	exists := err == nil
+	var modTime time.Time
+	var size int64
+	if exists {
+		modTime, size = info.ModTime(), info.Size()
+	}
+	changed := exists != f.exists || !modTime.Equal(f.modTime) || size != f.size
+	f.exists, f.modTime, f.size = exists, modTime, size
+	return changed
+}
+
+// fbaValidatorLoader holds the FBA validators that every validator set is made of. The
+// validators come from the registry set that the C-chain has activated while the registry file
+// is valid and that set lists validators, and from the bootstrap file otherwise. A set takes the
+// current validators whenever it is set, and the sets in [sets] are also reset as soon as the
+// validators change.
+type fbaValidatorLoader struct {
+	log       logging.Logger
+	bootstrap fbaValidatorFile
+	registry  fbaValidatorFile
+
+	// Sets parsed from the registry file and the index of the one in use, -1 if none. Only the
+	// goroutine that loads the files uses them.
+	registrySets   []fbaRegistrySet
+	registryActive int
+
+	lock             sync.RWMutex
+	current          []fbaValidator
+	source           string
+	sourceHash       [sha256.Size]byte
+	activationHeight uint64
+	appliedAt        time.Time
+	sets             map[*set]struct{}
+	// Height of the last block accepted by the C-chain
+	acceptedHeight uint64
+
+	reload   chan struct{}
+	accepted chan struct{}
+	stop     chan struct{}
+}
+
+// readRegistry parses the registry file again. A missing or invalid file leaves no registry
+// sets, so that the bootstrap file is used; an invalid file is logged.
+func (l *fbaValidatorLoader) readRegistry() {
+	l.registrySets = nil
+	if l.registry.path == "" {
+		return
+	}
+	b, err := ioutil.ReadFile(l.registry.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			l.log.Error("couldn't read FBA registry validators: %s", err)
+		}
+		return
+	}
+	sets, err := parseFBARegistrySets(b)
+	if err != nil {
+		l.log.Error("ignoring FBA registry validators in %s: %s", l.registry.path, err)
+		return
+	}
+	l.registrySets = sets
+}
+
+// activeRegistrySet returns the index of the last registry set whose activation height the
+// C-chain has accepted, of the first set while it has accepted none of them, and -1 if there
+// are no registry sets
+func (l *fbaValidatorLoader) activeRegistrySet() int {
+	l.lock.RLock()
+	height := l.acceptedHeight
+	l.lock.RUnlock()
+	active := -1
+	for i, set := range l.registrySets {
+		if i == 0 || set.activationHeight <= height {
+			active = i
+		}
+	}
+	return active
+}
+
+// load parses the bootstrap file, an invalid bootstrap file is an error, and returns the
+// validators of the registry set in use if it lists any, and those of the bootstrap file
+// otherwise. If the bootstrap file declares quorum slices, only the validators that are a member
+// of one of them are returned.
+func (l *fbaValidatorLoader) load() ([]fbaValidator, fbaValidatorSource, error) {
+	b, err := ioutil.ReadFile(l.bootstrap.path)
+	if err != nil {
+		return nil, fbaValidatorSource{}, err
+	}
+	vdrs, slices, err := parseFBAValidators(b)
+	if err != nil {
+		return nil, fbaValidatorSource{}, fmt.Errorf("invalid FBA validators in %s: %w", l.bootstrap.path, err)
+	}
+	source := fbaValidatorSource{path: l.bootstrap.path, hash: sha256.Sum256(b)}
+	if l.registryActive >= 0 {
+		if set := l.registrySets[l.registryActive]; len(set.validators) > 0 {
+			vdrs = set.validators
+			source = fbaValidatorSource{path: l.registry.path, hash: set.hash, activationHeight: set.activationHeight}
+		}
+	}
+	if len(slices) == 0 {
+		return vdrs, source, nil
+	}
+	sliced, missing := sliceValidators(vdrs, slices)
+	for _, nodeID := range missing {
+		l.log.Warn("quorum slice member %s is not an FBA validator in %s", nodeID.PrefixedString(constants.NodeIDPrefix), source.path)
+	}
+	if len(sliced) == 0 {
+		return nil, fbaValidatorSource{}, fmt.Errorf("FBA validators in %s: %w", source.path, errNoSlicedValidators)
+	}
+	return sliced, source, nil
+}
+
+// fbaValidatorSource describes where validators were loaded from: the file, the SHA-256 hash of
+// the bootstrap file or of the registry set, and the activation height of the registry set
+type fbaValidatorSource struct {
+	path             string
+	hash             [sha256.Size]byte
+	activationHeight uint64
+}
+
+// apply makes [vdrs] the current validators, logs how they differ from the previous ones and
+// resets every validator set that uses them
+func (l *fbaValidatorLoader) apply(vdrs []fbaValidator, source fbaValidatorSource) {
+	l.lock.Lock()
+	previous := l.current
+	l.current, l.source, l.sourceHash, l.activationHeight, l.appliedAt = vdrs, source.path, source.hash, source.activationHeight, time.Now()
+	sets := make([]*set, 0, len(l.sets))
+	for s := range l.sets {
+		sets = append(sets, s)
+	}
+	l.lock.Unlock()
+
+	previousWeights := make(map[ids.ShortID]uint64, len(previous))
+	for _, v := range previous {
+		previousWeights[v.nodeID] = v.weight
+	}
+	changes := 0
+	for _, v := range vdrs {
+		previousWeight, ok := previousWeights[v.nodeID]
+		switch {
+		case !ok:
+			l.log.Info("FBA validator %s added with weight %d", v.nodeID.PrefixedString(constants.NodeIDPrefix), v.weight)
+			changes++
+		case previousWeight != v.weight:
+			l.log.Info("FBA validator %s weight changed from %d to %d", v.nodeID.PrefixedString(constants.NodeIDPrefix), previousWeight, v.weight)
+			changes++
+		}
+		delete(previousWeights, v.nodeID)
+	}
+	for nodeID, weight := range previousWeights {
+		l.log.Info("FBA validator %s with weight %d removed", nodeID.PrefixedString(constants.NodeIDPrefix), weight)
+		changes++
+	}
+	if source.path == l.registry.path {
+		l.log.Info("applied %d FBA validators from %s activated at C-chain height %d, %d changes", len(vdrs), source.path, source.activationHeight, changes)
+	} else {
+		l.log.Info("applied %d FBA validators from %s, %d changes", len(vdrs), source.path, changes)
+	}
+
+	for _, s := range sets {
+		if err := s.Set(nil); err != nil {
+			l.log.Error("couldn't reset validator set to the FBA validators: %s", err)
+		}
+	}
+}
+
+// reloadIfChanged parses the validator files again if [force] is set or if either of them
+// changed, and applies the result, or the registry set that the C-chain has activated since,
+// unless it is invalid or the same as the current validators
+func (l *fbaValidatorLoader) reloadIfChanged(force bool) {
+	bootstrapChanged, registryChanged := l.bootstrap.changed(), l.registry.changed()
+	if force || registryChanged {
+		l.readRegistry()
+	}
+	active := l.activeRegistrySet()
+	if !force && !bootstrapChanged && !registryChanged && active == l.registryActive {
+		return
+	}
+	l.registryActive = active
+	vdrs, source, err := l.load()
+	if err != nil {
+		l.log.Error("keeping the current FBA validators: %s", err)
+		return
+	}
+	l.lock.Lock()
+	same := source.path == l.source && len(vdrs) == len(l.current)
+	for i := 0; same && i < len(vdrs); i++ {
+		same = vdrs[i] == l.current[i]
+	}
+	if same {
+		// The file was rewritten with the same validators, only its hash or activation changed
+		l.sourceHash, l.activationHeight = source.hash, source.activationHeight
+	}
+	l.lock.Unlock()
+	if !same {
+		l.apply(vdrs, source)
+	}
+}
+
+func (l *fbaValidatorLoader) watch() {
+	ticker := time.NewTicker(fbaValidatorsPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			l.reloadIfChanged(false)
+		case <-l.reload:
+			l.reloadIfChanged(true)
+		case <-l.accepted:
+			l.reloadIfChanged(false)
+		case <-l.stop:
+			return
+		}
+	}
+}
+
+func (l *fbaValidatorLoader) validators() []fbaValidator {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	return l.current
+}
+
+// InitFBAValidators loads the FBA validators from [bootstrapPath], or from [registryPath] if
+// the C-chain has written valid sets there, and starts watching both files. Until the C-chain
+// reports the blocks it accepts, the first registry set is used, which is the one in effect at
+// the last block the C-chain accepted. It fails if the bootstrap file is missing or invalid, so
+// that a misconfigured node does not start.
+func InitFBAValidators(bootstrapPath string, registryPath string, log logging.Logger) error {
+	if bootstrapPath == "" {
+		return errNoFBAValidatorsFile
+	}
+	l := &fbaValidatorLoader{
+		log:       log,
+		bootstrap: fbaValidatorFile{path: bootstrapPath},
+		registry:  fbaValidatorFile{path: registryPath},
+		sets:      make(map[*set]struct{}),
+		reload:    make(chan struct{}, 1),
+		accepted:  make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+	}
+	l.bootstrap.changed()
+	l.registry.changed()
+	l.readRegistry()
+	l.registryActive = l.activeRegistrySet()
+	// The bootstrap file has to be valid even while the registry file is in use, and has to list
+	// every member of its quorum slices
+	b, err := ioutil.ReadFile(bootstrapPath)
+	if err != nil {
+		return err
+	}
+	bootstrapVdrs, slices, err := parseFBAValidators(b)
+	if err != nil {
+		return fmt.Errorf("invalid FBA validators in %s: %w", bootstrapPath, err)
+	}
+	if _, missing := sliceValidators(bootstrapVdrs, slices); len(missing) > 0 {
+		return fmt.Errorf("quorum slice member %s is not an FBA validator in %s", missing[0].PrefixedString(constants.NodeIDPrefix), bootstrapPath)
+	}
+	vdrs, source, err := l.load()
+	if err != nil {
+		return err
+	}
+
+	fbaLoaderLock.Lock()
+	if fbaLoader != nil {
+		close(fbaLoader.stop)
+	}
+	fbaLoader = l
+	fbaLoaderLock.Unlock()
+	l.apply(vdrs, source)
+	go l.log.RecoverAndPanic(l.watch)
+	return nil
+}
+
+// ReloadFBAValidators parses the FBA validator files again, whether or not they changed
+func ReloadFBAValidators() {
+	fbaLoaderLock.Lock()
+	defer fbaLoaderLock.Unlock()
+	if fbaLoader == nil {
+		return
+	}
+	select {
+	case fbaLoader.reload <- struct{}{}:
+	default: // a reload is already pending
+	}
+}
+
+// SetFBAAcceptedHeight records that the C-chain has accepted block [height], so that the
+// registry set activated at that height is applied. The registry file is checked for changes
+// at each height, so that a set written shortly before its activation is not missed.
+func SetFBAAcceptedHeight(height uint64) {
+	fbaLoaderLock.Lock()
+	l := fbaLoader
+	fbaLoaderLock.Unlock()
+	if l == nil {
+		return
+	}
+	l.lock.Lock()
+	l.acceptedHeight = height
+	l.lock.Unlock()
+	select {
+	case l.accepted <- struct{}{}:
+	default: // a check is already pending
+	}
+}
+
+// StopFBAValidators stops watching the FBA validator files
+func StopFBAValidators() {
+	fbaLoaderLock.Lock()
+	defer fbaLoaderLock.Unlock()
+	if fbaLoader != nil {
+		close(fbaLoader.stop)
+		fbaLoader = nil
+	}
+}
+
+// ResetOnFBAValidatorsChange resets [s] to the FBA validators whenever they change, rather than
+// the next time it is set. The loader keeps [s] for as long as it runs, so only sets that live
+// as long as the node, such as the primary network's, should be passed.
+func ResetOnFBAValidatorsChange(s Set) error {
+	vdrs, ok := s.(*set)
+	if !ok {
+		return fmt.Errorf("%T is not an FBA validator set", s)
+	}
+	fbaLoaderLock.Lock()
+	l := fbaLoader
+	fbaLoaderLock.Unlock()
+	if l == nil {
+		return errFBAValidatorsNotLoaded
+	}
+	l.lock.Lock()
+	l.sets[vdrs] = struct{}{}
+	l.lock.Unlock()
+	return nil
+}
+
+// FBAValidatorsInfo describes the FBA validators a node is using
+type FBAValidatorsInfo struct {
+	Validators []FBAValidator
+	// File the validators were loaded from, and the SHA-256 hash of the bootstrap file or of the
+	// registry set in use
+	Source     string
+	SourceHash [sha256.Size]byte
+	// C-chain block from which the registry set in use applies, zero for the bootstrap file
+	ActivationHeight uint64
+	// When the validators were last applied to the validator sets
+	AppliedAt time.Time
+}
+
+// GetFBAValidatorsInfo returns the FBA validators in use, false if they have not been loaded
+func GetFBAValidatorsInfo() (FBAValidatorsInfo, bool) {
+	fbaLoaderLock.Lock()
+	l := fbaLoader
+	fbaLoaderLock.Unlock()
+	if l == nil {
+		return FBAValidatorsInfo{}, false
+	}
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	info := FBAValidatorsInfo{
+		Validators:       make([]FBAValidator, len(l.current)),
+		Source:           l.source,
+		SourceHash:       l.sourceHash,
+		ActivationHeight: l.activationHeight,
+		AppliedAt:        l.appliedAt,
+	}
+	for i, v := range l.current {
+		info.Validators[i] = FBAValidator{NodeID: v.nodeID.PrefixedString(constants.NodeIDPrefix), Weight: v.weight}
+	}
+	return info, true
+}
+
+// currentFBAValidators returns the validators that every set is made of, none if the loader has
+// not been initialized
+func currentFBAValidators() []fbaValidator {
+	fbaLoaderLock.Lock()
+	l := fbaLoader
+	fbaLoaderLock.Unlock()
+	if l == nil {
+		return nil
+	}
+	return l.validators()
+}
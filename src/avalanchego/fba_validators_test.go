@@ -0,0 +1,308 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package validators
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/ava-labs/avalanchego/ids"
+	"github.com/ava-labs/avalanchego/utils/constants"
+	"github.com/ava-labs/avalanchego/utils/logging"
+)
+
+// Define a logger that keeps the info messages
+type fbaTestLog struct {
+	logging.NoLog
+	infos []string
+}
+
+func (l *fbaTestLog) Info(format string, args ...interface{}) {
+	l.infos = append(l.infos, fmt.Sprintf(format, args...))
+}
+
+func fbaTestNodeID(i byte) string {
+	return ids.ShortID{i}.PrefixedString(constants.NodeIDPrefix)
+}
+
+// Encode an FBA validator file listing [weights] by node
+func fbaTestFile(t *testing.T, weights map[byte]uint64, slices []FBAQuorumSlice) []byte {
+	nodes := make([]int, 0, len(weights))
+	for node := range weights {
+		nodes = append(nodes, int(node))
+	}
+	sort.Ints(nodes)
+	list := FBAValidatorList{QuorumSlices: slices}
+	for _, node := range nodes {
+		list.Validators = append(list.Validators, FBAValidator{NodeID: fbaTestNodeID(byte(node)), Weight: weights[byte(node)]})
+	}
+	b, err := json.Marshal(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// Encode an FBA registry file with a set listing [weights] by node for each activation height
+func fbaTestRegistryFile(t *testing.T, sets map[uint64]map[byte]uint64) []byte {
+	heights := make([]int, 0, len(sets))
+	for height := range sets {
+		heights = append(heights, int(height))
+	}
+	sort.Ints(heights)
+	file := FBARegistryValidatorSets{}
+	for _, height := range heights {
+		set := FBARegistryValidatorSet{ActivationHeight: uint64(height), Validators: []FBAValidator{}}
+		nodes := make([]int, 0, len(sets[uint64(height)]))
+		for node := range sets[uint64(height)] {
+			nodes = append(nodes, int(node))
+		}
+		sort.Ints(nodes)
+		for _, node := range nodes {
+			set.Validators = append(set.Validators, FBAValidator{NodeID: fbaTestNodeID(byte(node)), Weight: sets[uint64(height)][byte(node)]})
+		}
+		file.ValidatorSets = append(file.ValidatorSets, set)
+	}
+	b, err := json.Marshal(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func writeFBATestFile(t *testing.T, path string, b []byte) {
+	if err := ioutil.WriteFile(path, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Install a loader of [bootstrap] and [registry] as the one every set uses, without watching
+// the files, and load them
+func newFBATestLoader(t *testing.T, bootstrap string, registry string) (*fbaValidatorLoader, *fbaTestLog) {
+	log := &fbaTestLog{}
+	l := &fbaValidatorLoader{
+		log:            log,
+		bootstrap:      fbaValidatorFile{path: bootstrap},
+		registry:       fbaValidatorFile{path: registry},
+		registryActive: -1,
+		sets:           make(map[*set]struct{}),
+		reload:         make(chan struct{}, 1),
+		accepted:       make(chan struct{}, 1),
+		stop:           make(chan struct{}),
+	}
+	fbaLoaderLock.Lock()
+	fbaLoader = l
+	fbaLoaderLock.Unlock()
+	t.Cleanup(StopFBAValidators)
+	l.reloadIfChanged(true)
+	return l, log
+}
+
+func TestParseFBAValidators(t *testing.T) {
+	a, b := fbaTestNodeID(1), fbaTestNodeID(2)
+	tests := []struct {
+		name    string
+		file    string
+		want    int
+		wantErr string
+	}{
+		{"valid", fmt.Sprintf(`{"validators": [{"nodeID": %q, "weight": 1}, {"nodeID": %q, "weight": 2}]}`, a, b), 2, ""},
+		{"invalid json", `{"validators": `, 0, "unexpected end"},
+		{"no validators", `{"validators": []}`, 0, errNoFBAValidators.Error()},
+		{"no prefix", fmt.Sprintf(`{"validators": [{"nodeID": %q, "weight": 1}]}`, strings.TrimPrefix(a, constants.NodeIDPrefix)), 0, "does not start with"},
+		{"invalid node ID", `{"validators": [{"nodeID": "NodeID-xyz", "weight": 1}]}`, 0, "invalid node ID"},
+		{"listed twice", fmt.Sprintf(`{"validators": [{"nodeID": %q, "weight": 1}, {"nodeID": %q, "weight": 2}]}`, a, a), 0, "listed twice"},
+		{"zero weight", fmt.Sprintf(`{"validators": [{"nodeID": %q, "weight": 0}]}`, a), 0, "zero weight"},
+		{"weight overflows", fmt.Sprintf(`{"validators": [{"nodeID": %q, "weight": %d}, {"nodeID": %q, "weight": 1}]}`, a, uint64(math.MaxUint64), b), 0, "overflows"},
+		{"invalid slice", fmt.Sprintf(`{"validators": [{"nodeID": %q, "weight": 1}], "quorumSlices": [{"threshold": 2, "validators": [%q]}]}`, a, a), 0, "threshold"},
+	}
+	for _, test := range tests {
+		vdrs, _, err := parseFBAValidators([]byte(test.file))
+		switch {
+		case test.wantErr == "" && (err != nil || len(vdrs) != test.want):
+			t.Errorf("%s: got (%v, %v) want %d validators", test.name, vdrs, err, test.want)
+		case test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)):
+			t.Errorf("%s: got %v want error containing %q", test.name, err, test.wantErr)
+		}
+	}
+}
+
+func TestParseFBARegistrySets(t *testing.T) {
+	a, b := fbaTestNodeID(1), fbaTestNodeID(2)
+	tests := []struct {
+		name    string
+		file    string
+		want    int
+		wantErr string
+	}{
+		{"valid", fmt.Sprintf(`{"validatorSets": [{"activationHeight": 0, "validators": []}, {"activationHeight": 10, "validators": [{"nodeID": %q, "weight": 1}, {"nodeID": %q, "weight": 2}]}]}`, a, b), 2, ""},
+		{"invalid json", `{"validatorSets": `, 0, "unexpected end"},
+		{"no sets", `{"validatorSets": []}`, 0, errNoFBARegistrySets.Error()},
+		{"old format", fmt.Sprintf(`{"validators": [{"nodeID": %q, "weight": 1}]}`, a), 0, errNoFBARegistrySets.Error()},
+		{"same height", `{"validatorSets": [{"activationHeight": 10, "validators": []}, {"activationHeight": 10, "validators": []}]}`, 0, "not after"},
+		{"invalid validators", fmt.Sprintf(`{"validatorSets": [{"activationHeight": 10, "validators": [{"nodeID": %q, "weight": 0}]}]}`, a), 0, "set 0"},
+	}
+	for _, test := range tests {
+		sets, err := parseFBARegistrySets([]byte(test.file))
+		switch {
+		case test.wantErr == "" && (err != nil || len(sets) != test.want):
+			t.Errorf("%s: got (%v, %v) want %d sets", test.name, sets, err, test.want)
+		case test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)):
+			t.Errorf("%s: got %v want error containing %q", test.name, err, test.wantErr)
+		}
+	}
+}
+
+func TestFBAValidatorsReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fba")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	bootstrap := filepath.Join(dir, "fba_validators.json")
+	registry := filepath.Join(dir, "fba_registry.json")
+	writeFBATestFile(t, bootstrap, fbaTestFile(t, map[byte]uint64{1: 10, 2: 20}, nil))
+	l, _ := newFBATestLoader(t, bootstrap, registry)
+
+	watched, other := NewSet(), NewSet()
+	if err := ResetOnFBAValidatorsChange(watched); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []Set{watched, other} {
+		if err := s.Set(nil); err != nil {
+			t.Fatal(err)
+		}
+		if s.Len() != 2 || s.Weight() != 30 {
+			t.Fatalf("got %s", s)
+		}
+	}
+
+	// A valid registry file takes over, and only the watched set is reset right away
+	writeFBATestFile(t, registry, fbaTestRegistryFile(t, map[uint64]map[byte]uint64{0: {1: 15, 3: 5}}))
+	l.reloadIfChanged(false)
+	if watched.Weight() != 20 || watched.Contains(ids.ShortID{2}) || !watched.Contains(ids.ShortID{3}) {
+		t.Errorf("watched set not reset: %s", watched)
+	}
+	if other.Weight() != 30 {
+		t.Errorf("unwatched set reset: %s", other)
+	}
+	if err := other.Set(nil); err != nil || other.Weight() != 20 {
+		t.Errorf("set does not take the current validators: %s, %v", other, err)
+	}
+	if len(l.sets) != 1 {
+		t.Errorf("loader keeps %d sets want 1", len(l.sets))
+	}
+	if info, ok := GetFBAValidatorsInfo(); !ok || info.Source != registry || len(info.Validators) != 2 {
+		t.Errorf("got info (%+v, %v)", info, ok)
+	}
+
+	// An invalid bootstrap file keeps the current validators, an invalid registry file falls
+	// back to the bootstrap file
+	writeFBATestFile(t, bootstrap, []byte(`{"validators": []}`))
+	l.reloadIfChanged(true)
+	if watched.Weight() != 20 {
+		t.Errorf("invalid bootstrap file applied: %s", watched)
+	}
+	writeFBATestFile(t, bootstrap, fbaTestFile(t, map[byte]uint64{1: 10, 2: 20}, nil))
+	writeFBATestFile(t, registry, []byte(`{"validatorSets": [{"activationHeight": 0, "validators": [{"nodeID": "NodeID-xyz", "weight": 1}]}]}`))
+	l.reloadIfChanged(true)
+	if watched.Weight() != 30 || !watched.Contains(ids.ShortID{2}) {
+		t.Errorf("bootstrap file not applied: %s", watched)
+	}
+}
+
+func TestFBAValidatorsDiffLogging(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fba")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	bootstrap := filepath.Join(dir, "fba_validators.json")
+	writeFBATestFile(t, bootstrap, fbaTestFile(t, map[byte]uint64{1: 10, 2: 20, 3: 30}, nil))
+	l, log := newFBATestLoader(t, bootstrap, "")
+
+	log.infos = nil
+	writeFBATestFile(t, bootstrap, fbaTestFile(t, map[byte]uint64{1: 10, 2: 25, 4: 40}, nil))
+	l.reloadIfChanged(true)
+	want := []string{
+		fmt.Sprintf("FBA validator %s weight changed from 20 to 25", fbaTestNodeID(2)),
+		fmt.Sprintf("FBA validator %s added with weight 40", fbaTestNodeID(4)),
+		fmt.Sprintf("FBA validator %s with weight 30 removed", fbaTestNodeID(3)),
+		fmt.Sprintf("applied 3 FBA validators from %s, 3 changes", bootstrap),
+	}
+	if strings.Join(log.infos, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got log\n%s\nwant\n%s", strings.Join(log.infos, "\n"), strings.Join(want, "\n"))
+	}
+
+	// Rewriting the same validators applies nothing
+	log.infos = nil
+	writeFBATestFile(t, bootstrap, fbaTestFile(t, map[byte]uint64{1: 10, 2: 25, 4: 40}, nil))
+	l.reloadIfChanged(true)
+	if len(log.infos) != 0 {
+		t.Errorf("unchanged validators logged %q", log.infos)
+	}
+}
+
+func TestFBARegistrySetActivation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fba")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	bootstrap := filepath.Join(dir, "fba_validators.json")
+	registry := filepath.Join(dir, "fba_registry.json")
+	writeFBATestFile(t, bootstrap, fbaTestFile(t, map[byte]uint64{1: 10, 2: 20}, nil))
+	writeFBATestFile(t, registry, fbaTestRegistryFile(t, map[uint64]map[byte]uint64{0: {}, 20: {1: 15, 3: 5}, 30: {3: 7}}))
+	l, log := newFBATestLoader(t, bootstrap, registry)
+
+	tests := []struct {
+		height         uint64
+		wantWeight     uint64
+		wantActivation uint64
+	}{
+		{0, 30, 0},
+		{19, 30, 0},
+		{20, 20, 20},
+		{29, 20, 20},
+		{35, 7, 30},
+	}
+	for _, test := range tests {
+		SetFBAAcceptedHeight(test.height)
+		l.reloadIfChanged(false)
+		info, _ := GetFBAValidatorsInfo()
+		var weight uint64
+		for _, v := range info.Validators {
+			weight += v.Weight
+		}
+		if weight != test.wantWeight || info.ActivationHeight != test.wantActivation {
+			t.Errorf("height %d: got weight %d activated at %d want %d at %d", test.height, weight, info.ActivationHeight, test.wantWeight, test.wantActivation)
+		}
+	}
+
+	// Dropping the sets that are no longer in use applies nothing
+	log.infos = nil
+	writeFBATestFile(t, registry, fbaTestRegistryFile(t, map[uint64]map[byte]uint64{30: {3: 7}, 40: {3: 9}}))
+	l.reloadIfChanged(false)
+	if len(log.infos) != 0 {
+		t.Errorf("unchanged validators logged %q", log.infos)
+	}
+
+	// Until the C-chain reports an accepted block, the first set is in use
+	l, _ = newFBATestLoader(t, bootstrap, registry)
+	if info, _ := GetFBAValidatorsInfo(); info.ActivationHeight != 30 || len(info.Validators) != 1 || info.Validators[0].Weight != 7 {
+		t.Errorf("got %+v", info)
+	}
+	SetFBAAcceptedHeight(40)
+	l.reloadIfChanged(false)
+	if info, _ := GetFBAValidatorsInfo(); info.ActivationHeight != 40 || info.Validators[0].Weight != 9 {
+		t.Errorf("got %+v", info)
+	}
+}
@@ -17,13 +17,17 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net"
+	"os"
+	"os/signal"
 	"path/filepath"
 	"sync"
+	"syscall"
 
 	"github.com/hashicorp/go-plugin"
 
 	"github.com/ava-labs/avalanchego/api/admin"
 	"github.com/ava-labs/avalanchego/api/auth"
+	"github.com/ava-labs/avalanchego/api/fba"
 	"github.com/ava-labs/avalanchego/api/health"
 	"github.com/ava-labs/avalanchego/api/info"
 	"github.com/ava-labs/avalanchego/api/keystore"
@@ -41,7 +45,9 @@ import (
 	"github.com/ava-labs/avalanchego/network"
 	"github.com/ava-labs/avalanchego/network/dialer"
 	"github.com/ava-labs/avalanchego/network/throttling"
+	"github.com/ava-labs/avalanchego/snow"
 	"github.com/ava-labs/avalanchego/snow/engine/common"
+	"github.com/ava-labs/avalanchego/snow/engine/snowman/block"
 	"github.com/ava-labs/avalanchego/snow/networking/benchlist"
 	"github.com/ava-labs/avalanchego/snow/networking/router"
 	"github.com/ava-labs/avalanchego/snow/networking/timeout"
@@ -134,6 +140,9 @@ type Node struct {
 	// current validators of the network
 	vdrs validators.Manager
 
+	// SIGHUP reloads the FBA validator files
+	fbaReloadSignal chan os.Signal
+
 	// Handles HTTP API calls
 	APIServer server.Server
 
@@ -193,6 +202,9 @@ func (n *Node) initNetworking() error {
 	if err := n.vdrs.Set(constants.PrimaryNetworkID, primaryNetworkValidators); err != nil {
 		return err
 	}
+	if err := validators.ResetOnFBAValidatorsChange(primaryNetworkValidators); err != nil {
+		return err
+	}
 
 	// Configure benchlist
 	n.Config.BenchlistConfig.Validators = n.vdrs
@@ -433,6 +445,92 @@ func (n *Node) initDatabase(dbManager manager.Manager) error {
 	return nil
 }
 
+// Load the FBA validators that make up every validator set. They are reloaded when the FBA_VALs
+// or FBA_REGISTRY_VALs file changes, when the C-chain reaches the activation height of a
+// registry set, and when the node receives SIGHUP.
+func (n *Node) initFBAValidators() error {
+	n.Log.Info("initializing FBA validators")
+	if err := validators.InitFBAValidators(os.Getenv("FBA_VALs"), os.Getenv("FBA_REGISTRY_VALs"), n.Log); err != nil {
+		return err
+	}
+	n.fbaReloadSignal = make(chan os.Signal, 1)
+	signal.Notify(n.fbaReloadSignal, syscall.SIGHUP)
+	go n.Log.RecoverAndPanic(func() {
+		for range n.fbaReloadSignal {
+			n.Log.Info("reloading FBA validators on SIGHUP")
+			validators.ReloadFBAValidators()
+		}
+	})
+	return nil
+}
+
+// fbaHeightTracker reports the height of every block the C-chain accepts to the FBA validators,
+// which apply a registry validator set once the C-chain has accepted its activation height
+type fbaHeightTracker struct {
+	log    logging.Logger
+	chains chains.Manager
+
+	lock     sync.Mutex
+	cChainID ids.ID
+	vm       block.ChainVM // nil until the C-chain is created
+}
+
+// RegisterChain keeps the VM of the C-chain once it is created, and reports the height of its
+// last accepted block
+func (t *fbaHeightTracker) RegisterChain(_ string, ctx *snow.Context, engine common.Engine) {
+	cChainID, err := t.chains.Lookup("C")
+	if err != nil || ctx.ChainID != cChainID {
+		return
+	}
+	vm, ok := engine.GetVM().(block.ChainVM)
+	if !ok {
+		t.log.Error("C-chain VM %T has no blocks, FBA registry validators will not be activated", engine.GetVM())
+		return
+	}
+	t.lock.Lock()
+	t.cChainID, t.vm = cChainID, vm
+	t.lock.Unlock()
+
+	ctx.Lock.Lock()
+	defer ctx.Lock.Unlock()
+	lastAccepted, err := vm.LastAccepted()
+	if err != nil {
+		t.log.Warn("couldn't get the last accepted C-chain block: %s", err)
+		return
+	}
+	t.report(vm, lastAccepted)
+}
+
+// Accept reports the height of [containerID] if it is a C-chain block. The decision dispatcher
+// calls it once the VM has accepted the block, with the lock of the chain held.
+func (t *fbaHeightTracker) Accept(ctx *snow.Context, containerID ids.ID, _ []byte) error {
+	t.lock.Lock()
+	vm := t.vm
+	isCChain := vm != nil && ctx.ChainID == t.cChainID
+	t.lock.Unlock()
+	if isCChain {
+		t.report(vm, containerID)
+	}
+	return nil
+}
+
+func (t *fbaHeightTracker) report(vm block.ChainVM, blkID ids.ID) {
+	blk, err := vm.GetBlock(blkID)
+	if err != nil {
+		t.log.Warn("couldn't get the height of C-chain block %s: %s", blkID, err)
+		return
+	}
+	validators.SetFBAAcceptedHeight(blk.Height())
+}
+
+// Report the height of every block the C-chain accepts to the FBA validators. Assumes
+// n.chainManager and n.DecisionDispatcher are initialized.
+func (n *Node) initFBAHeights() error {
+	tracker := &fbaHeightTracker{log: n.Log, chains: n.chainManager}
+	n.chainManager.AddRegistrant(tracker)
+	return n.DecisionDispatcher.Register("fbaHeights", tracker)
+}
+
 // Set the node IDs of the peers this node should first connect to
 func (n *Node) initBeacons() error {
 	n.beacons = validators.NewSet()
@@ -896,6 +994,22 @@ func (n *Node) initInfoAPI() error {
 	return n.APIServer.AddRoute(service, &sync.RWMutex{}, "info", "", n.HTTPLog)
 }
 
+// initFBAAPI initializes the FBA API service, which reports the FBA validators this node uses.
+// It is served alongside the info API.
+// Assumes n.Log, n.Net and n.APIServer already initialized
+func (n *Node) initFBAAPI() error {
+	if !n.Config.InfoAPIEnabled {
+		n.Log.Info("skipping FBA API initialization because the info API has been disabled")
+		return nil
+	}
+	n.Log.Info("initializing FBA API")
+	service, err := fba.NewService(n.Log, n.ID, n.Net)
+	if err != nil {
+		return err
+	}
+	return n.APIServer.AddRoute(service, &sync.RWMutex{}, "fba", "", n.HTTPLog)
+}
+
 // initHealthAPI initializes the Health API service
 // Assumes n.Log, n.Net, n.APIServer, n.HTTPLog already initialized
 func (n *Node) initHealthAPI() error {
@@ -1047,6 +1161,10 @@ func (n *Node) Initialize(
 		return fmt.Errorf("problem initializing database: %w", err)
 	}
 
+	if err := n.initFBAValidators(); err != nil { // Load the FBA validators
+		return fmt.Errorf("problem loading FBA validators: %w", err)
+	}
+
 	if err = n.initBeacons(); err != nil { // Configure the beacons
 		return fmt.Errorf("problem initializing node beacons: %w", err)
 	}
@@ -1084,12 +1202,18 @@ func (n *Node) Initialize(
 	if err := n.initChainManager(n.Config.AvaxAssetID); err != nil { // Set up the chain manager
 		return fmt.Errorf("couldn't initialize chain manager: %w", err)
 	}
+	if err := n.initFBAHeights(); err != nil { // Activate the FBA registry validators by C-chain height
+		return fmt.Errorf("couldn't track C-chain heights for the FBA validators: %w", err)
+	}
 	if err := n.initAdminAPI(); err != nil { // Start the Admin API
 		return fmt.Errorf("couldn't initialize admin API: %w", err)
 	}
 	if err := n.initInfoAPI(); err != nil { // Start the Info API
 		return fmt.Errorf("couldn't initialize info API: %w", err)
 	}
+	if err := n.initFBAAPI(); err != nil { // Start the FBA API
+		return fmt.Errorf("couldn't initialize FBA API: %w", err)
+	}
 	if err := n.initIPCs(); err != nil { // Start the IPCs
 		return fmt.Errorf("couldn't initialize IPCs: %w", err)
 	}
@@ -1136,6 +1260,11 @@ func (n *Node) shutdown() {
 	if n.profiler != nil {
 		n.profiler.Shutdown()
 	}
+	if n.fbaReloadSignal != nil {
+		signal.Stop(n.fbaReloadSignal)
+		close(n.fbaReloadSignal)
+	}
+	validators.StopFBAValidators()
 	if n.Net != nil {
 		// Close already logs its own error if one occurs, so the error is ignored here
 		_ = n.Net.Close()
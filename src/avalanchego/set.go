@@ -11,15 +11,11 @@
 package validators
 
 import (
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"os"
 	"strings"
 	"sync"
 
 	"github.com/ava-labs/avalanchego/ids"
-	"github.com/ava-labs/avalanchego/utils/constants"
 	"github.com/ava-labs/avalanchego/utils/formatting"
 	"github.com/ava-labs/avalanchego/utils/sampler"
 
@@ -121,29 +117,10 @@ func (s *set) Set(vdrs []Validator) error {
 	return s.set(vdrs)
 }
 
-type FBAValidatorList struct {
-	Validators []FBAValidator `json:"validators"`
-}
-
-type FBAValidator struct {
-	NodeID string `json:"nodeID"`
-	Weight uint64 `json:"weight"`
-}
-
+// set ignores [vdrs], every validator set is made of the FBA validators, see fba_validators.go
 func (s *set) set(vdrs []Validator) error {
-	FBAValidatorsFilePath := os.Getenv("FBA_VALs")
-	FBAValidators := FBAValidatorList{}
-	if FBAValidatorsFilePath != "" {
-		file, err := ioutil.ReadFile(FBAValidatorsFilePath)
-		if err != nil {
-			fmt.Println(err)
-		}
-		err = json.Unmarshal(file, &FBAValidators)
-		if err != nil {
-			fmt.Println(err)
-		}
-	}
-	lenVdrs := len(FBAValidators.Validators)
+	FBAValidators := currentFBAValidators()
+	lenVdrs := len(FBAValidators)
 	// If the underlying arrays are much larger than necessary, resize them to
 	// allow garbage collection of unused memory
 	if cap(s.vdrSlice) > len(s.vdrSlice)*maxExcessCapacityFactor {
@@ -163,16 +140,9 @@ func (s *set) set(vdrs []Validator) error {
 	s.totalWeight = 0
 	s.initialized = false
 
-	for _, vdr := range FBAValidators.Validators {
-		vdrID, err := ids.ShortFromPrefixedString(vdr.NodeID, constants.NodeIDPrefix)
-		if err != nil {
-			fmt.Println(err)
-			continue
-		}
-		if s.contains(vdrID) {
-			continue
-		}
-		w := vdr.Weight
+	for _, vdr := range FBAValidators {
+		vdrID := vdr.nodeID
+		w := vdr.weight
 		i := len(s.vdrSlice)
 		s.vdrMap[vdrID] = i
 		s.vdrSlice = append(s.vdrSlice, &validator{
@@ -0,0 +1,61 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package evm
+
+import (
+	"github.com/ava-labs/coreth/core"
+	"github.com/ava-labs/coreth/core/types"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// startBlockRecords stores what the execution of every block recorded, the state connector
+// rounds, the fork checkpoints, the flareDaemon executions, the system calls and the receipt of
+// the end of the block, once the block is accepted
+func (vm *VM) startBlockRecords() {
+	vm.shutdownWg.Add(1)
+	go vm.ctx.Log.RecoverAndPanic(vm.awaitAcceptedBlockRecords)
+}
+
+func (vm *VM) awaitAcceptedBlockRecords() {
+	defer vm.shutdownWg.Done()
+	acceptedChan := make(chan core.ChainEvent, 16)
+	sub := vm.chain.BlockChain().SubscribeChainAcceptedEvent(acceptedChan)
+	defer sub.Unsubscribe()
+
+	for {
+		select {
+		case event := <-acceptedChan:
+			vm.acceptBlockRecords(event.Block)
+		case <-sub.Err():
+			return
+		case <-vm.shutdownChan:
+			return
+		}
+	}
+}
+
+func (vm *VM) acceptBlockRecords(block *types.Block) {
+	if err := core.AcceptAttestationRounds(vm.chaindb, block); err != nil {
+		log.Error("Failed to store state connector rounds", "height", block.NumberU64(), "err", err)
+	}
+	if err := core.AcceptForkCheckpoints(vm.chaindb, block, vm.backupForkCheckpoint); err != nil {
+		log.Error("Failed to store fork checkpoints", "height", block.NumberU64(), "err", err)
+	}
+	if _, err := core.AcceptSystemCallTraces(vm.chaindb, block); err != nil {
+		log.Error("Failed to store system calls", "height", block.NumberU64(), "err", err)
+	}
+	if _, err := core.AcceptBlockEndReceipt(vm.chaindb, block); err != nil {
+		log.Error("Failed to store the receipt of the end of the block", "height", block.NumberU64(), "err", err)
+	}
+	executions, err := core.AcceptFlareDaemonExecutions(vm.chaindb, block)
+	if err != nil {
+		log.Error("Failed to store flareDaemon executions", "height", block.NumberU64(), "err", err)
+		return
+	}
+	for _, execution := range executions {
+		if execution.ErrorClass != core.FlareDaemonOK {
+			log.Warn("FlareDaemon execution failed in accepted block", "height", execution.BlockNumber, "class", execution.ErrorClass, "error", execution.Error)
+		}
+	}
+}
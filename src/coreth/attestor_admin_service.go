@@ -0,0 +1,30 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package evm
+
+import (
+	"github.com/ava-labs/coreth/core"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// AttestorAdminAPI offers the attestoradmin_ namespace, used to inspect and replace the
+// attestor overrides of the node config without restarting the node
+type AttestorAdminAPI struct{}
+
+// GetAttestors returns the attestor overrides in effect
+func (api *AttestorAdminAPI) GetAttestors() core.AttestorSets {
+	return core.GetAttestorSets()
+}
+
+// SetAttestors replaces the attestor overrides with those of [config], which takes the same
+// testing-attestors and local-attestors fields as the node config. The overrides in effect are
+// kept if [config] is invalid.
+func (api *AttestorAdminAPI) SetAttestors(config core.AttestorConfig) (core.AttestorSets, error) {
+	sets, err := core.SetAttestorConfig(&config)
+	if err != nil {
+		return core.AttestorSets{}, err
+	}
+	log.Info("Attestor sets reloaded", "testing", sets.Testing, "local", sets.Local)
+	return sets, nil
+}
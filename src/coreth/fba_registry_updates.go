@@ -0,0 +1,251 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package evm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+
+	"github.com/ava-labs/avalanchego/ids"
+	"github.com/ava-labs/avalanchego/utils/constants"
+	"github.com/ava-labs/coreth/core"
+	"github.com/ava-labs/coreth/core/types"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// fbaRegistryFileEnv names the file that the FBA validators read from the on-chain registry are
+// written to. avalanchego loads it in place of its FBA_VALs bootstrap file, see
+// fba_validators.go. The C-chain runs in its own process, so this file is the only way the
+// validators reach avalanchego. A change read at block N is written as a set that activates at
+// block N+fbaRegistryActivationDelay, and avalanchego applies it once the C-chain has accepted
+// that block, so every node switches at the same height.
+const fbaRegistryFileEnv = "FBA_REGISTRY_VALs"
+
+// Number of blocks between the block at which a change of the FBA validators is read and the
+// block from which it applies, which leaves avalanchego time to pick up the rewritten file
+const fbaRegistryActivationDelay = 10
+
+// fbaRegistryFile lists the validator set in effect at the last accepted block, followed by the
+// sets that take effect at later blocks. It holds nothing that depends on when a node read the
+// registry, so nodes that have accepted the same blocks write the same file.
+type fbaRegistryFile struct {
+	ValidatorSets []fbaRegistryFileSet `json:"validatorSets"`
+}
+
+// fbaRegistryFileSet takes effect once the C-chain has accepted block [ActivationHeight]. A set
+// without validators hands back to the FBA_VALs file.
+type fbaRegistryFileSet struct {
+	ActivationHeight uint64                 `json:"activationHeight"`
+	Validators       []fbaRegistryFileEntry `json:"validators"`
+}
+
+type fbaRegistryFileEntry struct {
+	NodeID string `json:"nodeID"`
+	Weight uint64 `json:"weight"`
+}
+
+// startFBARegistryUpdates keeps the file named by FBA_REGISTRY_VALs up to date with the FBA
+// registry, if the variable is set
+func (vm *VM) startFBARegistryUpdates() {
+	path := os.Getenv(fbaRegistryFileEnv)
+	if path == "" {
+		return
+	}
+	vm.shutdownWg.Add(1)
+	go vm.ctx.Log.RecoverAndPanic(func() { vm.awaitAcceptedFBARegistry(path) })
+}
+
+// readFBARegistry returns the validators in the FBA registry as of [block]
+func (vm *VM) readFBARegistry(block *types.Block) ([]core.FBAValidator, bool, error) {
+	statedb, err := vm.chain.BlockState(block)
+	if err != nil {
+		return nil, false, err
+	}
+	return core.ReadFBARegistry(vm.chainConfig, vm.chain.BlockChain(), block.Header(), statedb)
+}
+
+// fbaWeightEpoch returns the block that starts the market-cap weight epoch of [block], false if
+// the chain has no weight epochs at [block]
+func (vm *VM) fbaWeightEpoch(block *types.Block) (uint64, bool) {
+	return core.GetFBAWeightEpochStart(vm.chainConfig.ChainID, block.Number(), new(big.Int).SetUint64(block.Time()))
+}
+
+// readFBAWeights returns the market-cap weights of the epoch that starts at block [start]. They
+// are computed against the state of that block the first time, which is when that block is
+// accepted, and stored, so that a restarted node applies the same weights without that state.
+func (vm *VM) readFBAWeights(start uint64) ([]core.FBAValidator, bool, error) {
+	if weights, found, stored, err := core.ReadFBAWeights(vm.chaindb, start); err != nil || stored {
+		return weights, found, err
+	}
+	block := vm.chain.BlockChain().GetBlockByNumber(start)
+	if block == nil {
+		return nil, false, fmt.Errorf("block %d not found", start)
+	}
+	statedb, err := vm.chain.BlockState(block)
+	if err != nil {
+		return nil, false, err
+	}
+	chains, found, err := core.ReadFBAContributions(vm.chainConfig, vm.chain.BlockChain(), block.Header(), statedb)
+	if err != nil {
+		return nil, false, err
+	}
+	var weights []core.FBAValidator
+	if found {
+		weights = core.ComputeFBAWeights(chains)
+	}
+	return weights, found, core.WriteFBAWeights(vm.chaindb, start, weights, found)
+}
+
+// awaitAcceptedFBARegistry reads the FBA registry at every accepted block and adds a set to the
+// file at [path] whenever the validators change, see fbaRegistryFileEnv. Once the chain has
+// weight epochs and an FBA contributions contract, the validators are given the market-cap
+// weights of the current epoch, and validators without contributions are left out, so the
+// weights of an epoch take effect fbaRegistryActivationDelay blocks after the block that
+// starts it. Without a registry, the validators are the contributors themselves. While the
+// chain has neither, the set lists no validators, so that avalanchego falls back to its
+// bootstrap file. The sets in the file are kept across restarts, and a set is dropped once the
+// next one has taken effect.
+func (vm *VM) awaitAcceptedFBARegistry(path string) {
+	defer vm.shutdownWg.Done()
+	acceptedChan := make(chan core.ChainEvent, 16)
+	sub := vm.chain.BlockChain().SubscribeChainAcceptedEvent(acceptedChan)
+	defer sub.Unsubscribe()
+
+	var (
+		sets    = readFBARegistryFile(path)
+		written []byte // encoding of [sets] as last written or read
+
+		epochStart   uint64 // start of the weight epoch that [weights] were computed for
+		epochRead    bool
+		weights      []core.FBAValidator
+		weightsFound bool
+	)
+	written, _ = json.Marshal(sets)
+	update := func(block *types.Block) {
+		validators, ok, err := vm.readFBARegistry(block)
+		if err != nil {
+			log.Warn("Failed to read FBA registry", "height", block.NumberU64(), "err", err)
+			return
+		}
+		start, epochs := vm.fbaWeightEpoch(block)
+		if !epochs {
+			epochRead, weights, weightsFound = false, nil, false
+		} else if !epochRead || start != epochStart {
+			w, found, err := vm.readFBAWeights(start)
+			if err != nil {
+				log.Warn("Failed to read FBA contributions", "epoch", start, "err", err)
+				return
+			}
+			epochStart, epochRead, weights, weightsFound = start, true, w, found
+			if found {
+				log.Info("FBA market-cap weights computed", "epoch", start, "validators", len(w))
+			}
+		}
+		switch {
+		case ok && weightsFound:
+			validators = core.ApplyFBAWeights(validators, weights)
+		case !ok && len(weights) > 0:
+			validators, ok = weights, true
+		}
+		entries := []fbaRegistryFileEntry{}
+		if ok {
+			for _, v := range validators {
+				entries = append(entries, fbaRegistryFileEntry{NodeID: ids.ShortID(v.NodeID).PrefixedString(constants.NodeIDPrefix), Weight: v.Weight})
+			}
+		}
+		height := block.NumberU64()
+		var added bool
+		if sets, added = addFBARegistrySet(sets, height, entries); added {
+			log.Info("FBA validator set updated from registry", "height", height, "activationHeight", height+fbaRegistryActivationDelay, "validators", len(entries))
+		}
+		b, _ := json.Marshal(sets)
+		if bytes.Equal(b, written) {
+			return
+		}
+		if err := writeFBARegistryFile(path, &fbaRegistryFile{ValidatorSets: sets}); err != nil {
+			log.Warn("Failed to write FBA registry file", "path", path, "err", err)
+			return
+		}
+		written = b
+	}
+
+	update(vm.chain.LastAcceptedBlock())
+	for {
+		select {
+		case event := <-acceptedChan:
+			update(event.Block)
+		case <-sub.Err():
+			return
+		case <-vm.shutdownChan:
+			return
+		}
+	}
+}
+
+// addFBARegistrySet drops the sets of [sets] that a later set has replaced by block [height],
+// and adds [entries] as a set that activates fbaRegistryActivationDelay blocks later unless
+// they are the validators of the last set. It returns whether it added a set.
+func addFBARegistrySet(sets []fbaRegistryFileSet, height uint64, entries []fbaRegistryFileEntry) ([]fbaRegistryFileSet, bool) {
+	for len(sets) > 1 && sets[1].ActivationHeight <= height {
+		sets = sets[1:]
+	}
+	latest := []fbaRegistryFileEntry{}
+	if len(sets) > 0 {
+		latest = sets[len(sets)-1].Validators
+	}
+	key, _ := json.Marshal(entries)
+	if latestKey, _ := json.Marshal(latest); bytes.Equal(key, latestKey) {
+		return sets, false
+	}
+	if len(sets) == 0 {
+		// The bootstrap file is in use until the first set takes effect
+		sets = append(sets, fbaRegistryFileSet{Validators: []fbaRegistryFileEntry{}})
+	}
+	return append(sets, fbaRegistryFileSet{ActivationHeight: height + fbaRegistryActivationDelay, Validators: entries}), true
+}
+
+// readFBARegistryFile returns the sets in the file at [path], none if there is no file or it
+// cannot be read
+func readFBARegistryFile(path string) []fbaRegistryFileSet {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warn("Failed to read FBA registry file", "path", path, "err", err)
+		}
+		return nil
+	}
+	var file fbaRegistryFile
+	if err := json.Unmarshal(b, &file); err != nil {
+		log.Warn("Ignoring invalid FBA registry file", "path", path, "err", err)
+		return nil
+	}
+	return file.ValidatorSets
+}
+
+// writeFBARegistryFile replaces the file at [path] in one step, so that avalanchego never reads
+// a partially written file
+func writeFBARegistryFile(path string, file *fbaRegistryFile) error {
+	b, err := json.MarshalIndent(file, "", "    ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,79 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package evm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestAddFBARegistrySet(t *testing.T) {
+	a := []fbaRegistryFileEntry{{NodeID: "NodeID-a", Weight: 1}}
+	b := []fbaRegistryFileEntry{{NodeID: "NodeID-b", Weight: 2}}
+	none := []fbaRegistryFileEntry{}
+
+	// Without a registry there is nothing to write
+	sets, added := addFBARegistrySet(nil, 5, none)
+	if added || len(sets) != 0 {
+		t.Fatalf("got %+v, %v", sets, added)
+	}
+	// The first set is preceded by the bootstrap file
+	sets, added = addFBARegistrySet(sets, 10, a)
+	want := []fbaRegistryFileSet{{ActivationHeight: 0, Validators: none}, {ActivationHeight: 10 + fbaRegistryActivationDelay, Validators: a}}
+	if !added || !reflect.DeepEqual(sets, want) {
+		t.Fatalf("got %+v want %+v", sets, want)
+	}
+	// The same validators add nothing, and the bootstrap file stays until the set takes effect
+	if sets, added = addFBARegistrySet(sets, 11, a); added || !reflect.DeepEqual(sets, want) {
+		t.Fatalf("got %+v, %v", sets, added)
+	}
+	// A change before the previous one takes effect follows it
+	sets, _ = addFBARegistrySet(sets, 12, b)
+	want = append(want, fbaRegistryFileSet{ActivationHeight: 12 + fbaRegistryActivationDelay, Validators: b})
+	if !reflect.DeepEqual(sets, want) {
+		t.Fatalf("got %+v want %+v", sets, want)
+	}
+	// Sets are dropped once the next one has taken effect
+	if sets, _ = addFBARegistrySet(sets, 12+fbaRegistryActivationDelay, b); !reflect.DeepEqual(sets, want[2:]) {
+		t.Fatalf("got %+v want %+v", sets, want[2:])
+	}
+	// Removing the registry hands back to the bootstrap file
+	sets, added = addFBARegistrySet(sets, 30, none)
+	want = []fbaRegistryFileSet{want[2], {ActivationHeight: 30 + fbaRegistryActivationDelay, Validators: none}}
+	if !added || !reflect.DeepEqual(sets, want) {
+		t.Fatalf("got %+v want %+v", sets, want)
+	}
+}
+
+func TestFBARegistryFileKeepsSets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fba")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "fba_registry.json")
+
+	if sets := readFBARegistryFile(path); sets != nil {
+		t.Errorf("got sets %+v without a file", sets)
+	}
+	sets := []fbaRegistryFileSet{
+		{ActivationHeight: 20, Validators: []fbaRegistryFileEntry{{NodeID: "NodeID-a", Weight: 1}}},
+		{ActivationHeight: 30, Validators: []fbaRegistryFileEntry{}},
+	}
+	if err := writeFBARegistryFile(path, &fbaRegistryFile{ValidatorSets: sets}); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFBARegistryFile(path); !reflect.DeepEqual(got, sets) {
+		t.Errorf("got %+v want %+v", got, sets)
+	}
+	if err := ioutil.WriteFile(path, []byte(`{"validatorSets": `), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFBARegistryFile(path); got != nil {
+		t.Errorf("got sets %+v from an invalid file", got)
+	}
+}
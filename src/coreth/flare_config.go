@@ -0,0 +1,89 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package evm
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// FlareAPIConfig holds the node config fields of the Flare namespaces, which coreth's Config
+// does not know about
+type FlareAPIConfig struct {
+	StateConnectorAPIEnabled bool `json:"stateconnector-api-enabled"`
+	// Serves the flaredaemon_ namespace, whose executions, system calls and supply are read
+	// from the database of this node. Off unless set in the node config.
+	FlareDaemonAPIEnabled bool `json:"flaredaemon-api-enabled"`
+	// Serves the flare_ namespace with the fork schedule and the parameters in effect at a block.
+	// Off unless set in the node config.
+	FlareAPIEnabled bool `json:"flare-api-enabled"`
+	// Serves the flaredaemon counters, and no other metrics, in the Prometheus format at
+	// /metrics. Off unless set in the node config.
+	FlareMetricsAPIEnabled bool `json:"flare-metrics-api-enabled"`
+	// Directory that forkcheckpoint_exportCheckpoint writes to, exports are disabled if empty
+	ForkCheckpointExportDir string `json:"fork-checkpoint-export-dir"`
+	// Records the calls made by each system call with its trace. Off unless set in the node
+	// config.
+	SystemCallTracingEnabled bool `json:"system-call-tracing-enabled"`
+}
+
+// parseFlareAPIConfig reads the Flare namespace fields from the node config [configBytes]
+func parseFlareAPIConfig(configBytes []byte) (FlareAPIConfig, error) {
+	config := FlareAPIConfig{StateConnectorAPIEnabled: true}
+	if len(configBytes) == 0 {
+		return config, nil
+	}
+	if err := json.Unmarshal(configBytes, &config); err != nil {
+		return FlareAPIConfig{}, err
+	}
+	return config, nil
+}
+
+// apiConflicts explains the Flare settings of [c] that do not work the way they may be
+// expected to
+func (c *FlareAPIConfig) apiConflicts() []string {
+	var conflicts []string
+	if c.SystemCallTracingEnabled && !c.FlareDaemonAPIEnabled {
+		conflicts = append(conflicts, "system-call-tracing-enabled is set without flaredaemon-api-enabled, the recorded calls are only served by flaredaemon_getSystemCalls")
+	}
+	return conflicts
+}
+
+// setFlareDefaults turns off the namespaces that expose the chain to the public and limits
+// block range requests. It runs after SetDefaults and before the node config is applied, so
+// each of these can be set in the node config.
+func (c *Config) setFlareDefaults() {
+	c.EthAPIEnabled = false
+	c.NetAPIEnabled = false
+	c.Web3APIEnabled = false
+	c.DebugAPIEnabled = false
+	c.TxPoolAPIEnabled = false
+	c.MaxBlocksPerRequest = 1
+}
+
+// apiConflicts explains the settings of [c] that do not work the way they may be expected to
+func (c *Config) apiConflicts() []string {
+	var conflicts []string
+	if web3API := os.Getenv("WEB3_API"); web3API != "" {
+		conflicts = append(conflicts, "WEB3_API="+web3API+" is ignored, set eth-api-enabled, net-api-enabled, web3-api-enabled, debug-api-enabled and tx-pool-api-enabled in the C-chain config instead")
+	}
+	if c.DebugAPIEnabled && c.Pruning {
+		conflicts = append(conflicts, "debug-api-enabled is set on a pruned node, tracing only works for blocks whose state has not been pruned, set pruning-enabled to false to trace any block")
+	}
+	if !c.EthAPIEnabled {
+		for _, api := range []struct {
+			name    string
+			enabled bool
+		}{
+			{"net-api-enabled", c.NetAPIEnabled},
+			{"web3-api-enabled", c.Web3APIEnabled},
+			{"tx-pool-api-enabled", c.TxPoolAPIEnabled},
+		} {
+			if api.enabled {
+				conflicts = append(conflicts, api.name+" is set without eth-api-enabled, most web3 clients will not be able to use it")
+			}
+		}
+	}
+	return conflicts
+}
@@ -0,0 +1,106 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package evm
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseFlareAPIConfig(t *testing.T) {
+	defaults := FlareAPIConfig{StateConnectorAPIEnabled: true}
+	tests := []struct {
+		config  string
+		want    FlareAPIConfig
+		wantErr bool
+	}{
+		{"", defaults, false},
+		{`{}`, defaults, false},
+		{`{"eth-api-enabled": true}`, defaults, false},
+		{`{"stateconnector-api-enabled": false}`, FlareAPIConfig{}, false},
+		{`{"flare-metrics-api-enabled": true, "fork-checkpoint-export-dir": "exports"}`, FlareAPIConfig{StateConnectorAPIEnabled: true, FlareMetricsAPIEnabled: true, ForkCheckpointExportDir: "exports"}, false},
+		{`{"system-call-tracing-enabled": true}`, FlareAPIConfig{StateConnectorAPIEnabled: true, SystemCallTracingEnabled: true}, false},
+		{`{"flaredaemon-api-enabled": true, "flare-api-enabled": true}`, FlareAPIConfig{StateConnectorAPIEnabled: true, FlareDaemonAPIEnabled: true, FlareAPIEnabled: true}, false},
+		{`{"stateconnector-api-enabled": "no"}`, FlareAPIConfig{}, true},
+		{`{`, FlareAPIConfig{}, true},
+	}
+	for _, test := range tests {
+		got, err := parseFlareAPIConfig([]byte(test.config))
+		if got != test.want || (err != nil) != test.wantErr {
+			t.Errorf("parseFlareAPIConfig(%q) = (%+v, %v)", test.config, got, err)
+		}
+	}
+}
+
+func TestSetFlareDefaults(t *testing.T) {
+	var config Config
+	config.SetDefaults()
+	config.setFlareDefaults()
+	if config.EthAPIEnabled || config.NetAPIEnabled || config.Web3APIEnabled || config.DebugAPIEnabled || config.TxPoolAPIEnabled {
+		t.Errorf("namespaces enabled by default: %+v", config)
+	}
+	if config.MaxBlocksPerRequest != 1 {
+		t.Errorf("got max blocks per request %d want 1", config.MaxBlocksPerRequest)
+	}
+
+	// The node config applies on top of the defaults
+	if err := json.Unmarshal([]byte(`{"eth-api-enabled": true, "max-blocks-per-request": 0}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	if !config.EthAPIEnabled || config.NetAPIEnabled || config.MaxBlocksPerRequest != 0 {
+		t.Errorf("node config not applied: %+v", config)
+	}
+}
+
+func TestAPIConflicts(t *testing.T) {
+	if web3API, ok := os.LookupEnv("WEB3_API"); ok {
+		defer os.Setenv("WEB3_API", web3API)
+	} else {
+		defer os.Unsetenv("WEB3_API")
+	}
+	tests := []struct {
+		name    string
+		web3API string
+		config  Config
+		want    []string
+	}{
+		{"none", "", Config{EthAPIEnabled: true, NetAPIEnabled: true, DebugAPIEnabled: true}, nil},
+		{"all off", "", Config{Pruning: true}, nil},
+		{"web3 env", "debug", Config{}, []string{"WEB3_API=debug"}},
+		{"debug on pruned node", "", Config{EthAPIEnabled: true, DebugAPIEnabled: true, Pruning: true}, []string{"debug-api-enabled"}},
+		{"namespaces without eth", "", Config{NetAPIEnabled: true, TxPoolAPIEnabled: true}, []string{"net-api-enabled", "tx-pool-api-enabled"}},
+		{"web3 without eth", "", Config{Web3APIEnabled: true}, []string{"web3-api-enabled"}},
+	}
+	for _, test := range tests {
+		os.Setenv("WEB3_API", test.web3API)
+		conflicts := test.config.apiConflicts()
+		var got []string
+		for _, conflict := range conflicts {
+			got = append(got, strings.SplitN(conflict, " ", 2)[0])
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got conflicts %q", test.name, conflicts)
+		}
+	}
+}
+
+func TestFlareAPIConflicts(t *testing.T) {
+	tests := []struct {
+		name   string
+		config FlareAPIConfig
+		want   int
+	}{
+		{"defaults", FlareAPIConfig{StateConnectorAPIEnabled: true}, 0},
+		{"tracing served", FlareAPIConfig{SystemCallTracingEnabled: true, FlareDaemonAPIEnabled: true}, 0},
+		{"tracing not served", FlareAPIConfig{SystemCallTracingEnabled: true}, 1},
+	}
+	for _, test := range tests {
+		if conflicts := test.config.apiConflicts(); len(conflicts) != test.want {
+			t.Errorf("%s: got conflicts %q", test.name, conflicts)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package evm
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ava-labs/coreth/consensus"
+	"github.com/ava-labs/coreth/core"
+	"github.com/ava-labs/coreth/core/rawdb"
+	"github.com/ava-labs/coreth/core/types"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Maximum number of blocks that a single listFailedExecutions call may span
+const maxFlareDaemonExecutionsRange = 1000
+
+// FlareDaemonAPI offers the flaredaemon_ namespace, exposing the flareDaemon executions and the
+// system calls of the blocks accepted by this node
+type FlareDaemonAPI struct{ vm *VM }
+
+// GetExecutions returns the flareDaemon executions of accepted block [blockNumber], empty if
+// this node has not recorded any
+func (api *FlareDaemonAPI) GetExecutions(blockNumber uint64) ([]*core.FlareDaemonExecution, error) {
+	executions, err := core.ReadFlareDaemonExecutions(api.vm.chaindb, blockNumber)
+	if executions == nil {
+		executions = []*core.FlareDaemonExecution{}
+	}
+	return executions, err
+}
+
+// GetSystemCalls returns the calls that the chain made on its own behalf in accepted block
+// [blockNumber], in the order they were made, empty if this node has not recorded any
+func (api *FlareDaemonAPI) GetSystemCalls(blockNumber uint64) ([]*core.SystemCallTrace, error) {
+	traces, err := core.ReadSystemCallTraces(api.vm.chaindb, blockNumber)
+	if traces == nil {
+		traces = []*core.SystemCallTrace{}
+	}
+	return traces, err
+}
+
+// GetBlockEndReceipt returns the logs and the gas of the system calls made at the end of accepted
+// block [blockNumber], or null if this node has not recorded any
+func (api *FlareDaemonAPI) GetBlockEndReceipt(blockNumber uint64) (*core.BlockEndReceipt, error) {
+	return core.ReadBlockEndReceipt(api.vm.chaindb, blockNumber)
+}
+
+// Supply is the native token supply as of a block, as reconciled by GetSupply
+type Supply struct {
+	BlockNumber uint64 `json:"blockNumber"`
+	// Sum of the genesis allocations
+	GenesisSupply *big.Int `json:"genesisSupply"`
+	// Minted by the flareDaemon and paid as attestor rewards since the mint ledger was activated
+	MintedToDate *big.Int `json:"mintedToDate"`
+	// GenesisSupply + MintedToDate, the tokens created on this chain, exact when the ledger was
+	// activated before the first mint. Tokens moved to or from other chains by atomic
+	// transactions are not accounted for.
+	TotalSupply         *big.Int        `json:"totalSupply"`
+	MintLedger          core.MintLedger `json:"mintLedger"`
+	MaximumMintPerEpoch *big.Int        `json:"maximumMintPerEpoch"`
+	MaximumMintPerYear  *big.Int        `json:"maximumMintPerYear"`
+}
+
+// GetSupply returns the supply and the mint ledger as of the last accepted block. The epoch
+// and year amounts are those of the epoch of that block, nil caps are uncapped.
+func (api *FlareDaemonAPI) GetSupply() (*Supply, error) {
+	block := api.vm.chain.LastAcceptedBlock()
+	statedb, err := api.vm.chain.BlockState(block)
+	if err != nil {
+		return nil, err
+	}
+	chainID, blockTime := api.vm.chainConfig.ChainID, new(big.Int).SetUint64(block.Time())
+	ledger := core.GetMintLedgerAddr(chainID, blockTime)
+	epochSeconds := core.GetMintEpochSeconds(chainID, block.Number(), blockTime)
+	mintLedger := core.ReadMintLedger(statedb, ledger, epochSeconds, block.Time())
+	return &Supply{
+		BlockNumber:         block.NumberU64(),
+		GenesisSupply:       api.vm.genesisSupply,
+		MintedToDate:        mintLedger.TotalMinted,
+		TotalSupply:         new(big.Int).Add(api.vm.genesisSupply, mintLedger.TotalMinted),
+		MintLedger:          mintLedger,
+		MaximumMintPerEpoch: core.GetMaximumMintPerEpoch(chainID, block.Number(), blockTime),
+		MaximumMintPerYear:  core.GetMaximumMintPerYear(chainID, block.Number(), blockTime),
+	}, nil
+}
+
+// ListFailedExecutions returns the flareDaemon executions in blocks [from, to] that failed to
+// trigger the daemon or to mint
+func (api *FlareDaemonAPI) ListFailedExecutions(from uint64, to uint64) ([]*core.FlareDaemonExecution, error) {
+	if to < from {
+		return nil, fmt.Errorf("invalid range: from %d is greater than to %d", from, to)
+	}
+	if to-from >= maxFlareDaemonExecutionsRange {
+		return nil, fmt.Errorf("range of %d blocks exceeds maximum of %d", to-from+1, maxFlareDaemonExecutionsRange)
+	}
+	failed := []*core.FlareDaemonExecution{}
+	for blockNumber := from; ; blockNumber++ {
+		executions, err := core.ReadFlareDaemonExecutions(api.vm.chaindb, blockNumber)
+		if err != nil {
+			return nil, err
+		}
+		for _, execution := range executions {
+			if execution.ErrorClass != core.FlareDaemonOK {
+				failed = append(failed, execution)
+			}
+		}
+		if blockNumber == to {
+			return failed, nil
+		}
+	}
+}
+
+// flareDaemonChainContext gives core.FinalizeSystemCalls the chain of [vm] from the consensus
+// callbacks, which run before vm.chain is set when blocks are reprocessed on startup. Headers
+// are therefore read from the database, so that BLOCKHASH resolves the same way then.
+type flareDaemonChainContext struct{ vm *VM }
+
+func (c flareDaemonChainContext) Engine() consensus.Engine {
+	if c.vm.chain == nil {
+		return nil
+	}
+	return c.vm.chain.BlockChain().Engine()
+}
+
+func (c flareDaemonChainContext) GetHeader(hash common.Hash, number uint64) *types.Header {
+	return rawdb.ReadHeader(c.vm.chaindb, hash, number)
+}
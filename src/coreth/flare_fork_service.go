@@ -0,0 +1,37 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package evm
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ava-labs/coreth/core"
+	"github.com/ava-labs/coreth/rpc"
+)
+
+// FlareForkAPI offers the flare_ namespace, exposing the fork schedule of this chain and the
+// parameters it yields at a block
+type FlareForkAPI struct{ vm *VM }
+
+// GetForkSchedule returns the fork schedule of this chain
+func (api *FlareForkAPI) GetForkSchedule() core.FlareForkSchedule {
+	return core.GetFlareForkSchedule(api.vm.chainID)
+}
+
+// GetParams returns the parameters in effect at [blockNumber], or at the last accepted block
+// for the latest, pending and accepted tags
+func (api *FlareForkAPI) GetParams(blockNumber rpc.BlockNumber) (*core.FlareParams, error) {
+	block := api.vm.chain.LastAcceptedBlock()
+	if blockNumber >= 0 {
+		if uint64(blockNumber) > block.NumberU64() {
+			return nil, fmt.Errorf("block %d has not been accepted", blockNumber)
+		}
+		block = api.vm.chain.GetBlockByNumber(uint64(blockNumber))
+		if block == nil {
+			return nil, fmt.Errorf("block %d not found", blockNumber)
+		}
+	}
+	return core.GetFlareParams(api.vm.chainID, block.Number(), new(big.Int).SetUint64(block.Time())), nil
+}
@@ -0,0 +1,107 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package evm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/ava-labs/coreth/core"
+	"github.com/ava-labs/coreth/core/state"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+var (
+	errCheckpointNotFound       = errors.New("fork checkpoint not found")
+	errCheckpointStateMissing   = errors.New("state of fork checkpoint was not backed up")
+	errCheckpointExportDisabled = errors.New("fork checkpoint exports are disabled, set fork-checkpoint-export-dir in the C-chain config")
+)
+
+// backupForkCheckpoint commits the state of block [blockHash] to disk so that the trie
+// survives pruning, and returns its state root. It is called once the child block that causes
+// the fork is accepted, while the state of its parent is still held in memory with those of
+// the other recently accepted blocks.
+func (vm *VM) backupForkCheckpoint(blockHash common.Hash) (common.Hash, error) {
+	block := vm.chain.GetBlockByHash(blockHash)
+	if block == nil {
+		return common.Hash{}, fmt.Errorf("block %s not found", blockHash.Hex())
+	}
+	root := block.Root()
+	if err := vm.chain.BlockChain().StateCache().TrieDB().Commit(root, false, nil); err != nil {
+		return common.Hash{}, fmt.Errorf("failed to commit state root %s: %w", root.Hex(), err)
+	}
+	return root, nil
+}
+
+// ForkCheckpointAPI offers the forkcheckpoint_ namespace, used to inspect the checkpoints
+// created when the local attestation providers disagree with the default set, and to export
+// the state at them for inspection. Accepted blocks are final, so the node cannot be rolled
+// back to a checkpoint, and no node can be started from an exported state.
+type ForkCheckpointAPI struct{ vm *VM }
+
+// ListCheckpoints returns every fork checkpoint, oldest round first
+func (api *ForkCheckpointAPI) ListCheckpoints() ([]*core.ForkCheckpoint, error) {
+	return core.ReadForkCheckpoints(api.vm.chaindb)
+}
+
+// GetCheckpoint returns the fork checkpoint created in round [bufferNumber]
+func (api *ForkCheckpointAPI) GetCheckpoint(bufferNumber uint64) (*core.ForkCheckpoint, error) {
+	return api.checkpoint(bufferNumber)
+}
+
+func (api *ForkCheckpointAPI) checkpoint(bufferNumber uint64) (*core.ForkCheckpoint, error) {
+	checkpoint, err := core.ReadForkCheckpoint(api.vm.chaindb, bufferNumber)
+	if err != nil {
+		return nil, err
+	}
+	if checkpoint == nil {
+		return nil, errCheckpointNotFound
+	}
+	return checkpoint, nil
+}
+
+// forkCheckpointExportPath returns where an export named [name] is written in [dir]. The name
+// cannot leave the directory.
+func forkCheckpointExportPath(dir string, name string) (string, error) {
+	if dir == "" {
+		return "", errCheckpointExportDisabled
+	}
+	if name == "" || name == "." || name == ".." || name != filepath.Base(name) {
+		return "", fmt.Errorf("invalid export file name %q, it must not contain a path", name)
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// ExportCheckpoint writes a JSON dump of the state at the fork checkpoint of round
+// [bufferNumber] to the file [name] in the configured fork-checkpoint-export-dir. An existing
+// file is never overwritten.
+func (api *ForkCheckpointAPI) ExportCheckpoint(bufferNumber uint64, name string) error {
+	path, err := forkCheckpointExportPath(api.vm.flareAPIConfig.ForkCheckpointExportDir, name)
+	if err != nil {
+		return err
+	}
+	checkpoint, err := api.checkpoint(bufferNumber)
+	if err != nil {
+		return err
+	}
+	if !checkpoint.StateCommitted {
+		return errCheckpointStateMissing
+	}
+	statedb, err := api.vm.chain.BlockChain().StateAt(checkpoint.ParentRoot)
+	if err != nil {
+		return fmt.Errorf("failed to open state at %s: %w", checkpoint.ParentRoot.Hex(), err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	statedb.IterativeDump(&state.DumpConfig{}, json.NewEncoder(file))
+	log.Info("Exported fork checkpoint", "bufferNumber", bufferNumber, "root", checkpoint.ParentRoot, "path", path)
+	return nil
+}
@@ -0,0 +1,33 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package evm
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestForkCheckpointExportPath(t *testing.T) {
+	dir := filepath.Join("var", "exports")
+	tests := []struct {
+		dir     string
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{dir, "checkpoint.json", filepath.Join(dir, "checkpoint.json"), false},
+		{"", "checkpoint.json", "", true},
+		{dir, "", "", true},
+		{dir, "..", "", true},
+		{dir, "../checkpoint.json", "", true},
+		{dir, "/etc/passwd", "", true},
+		{dir, "sub/checkpoint.json", "", true},
+	}
+	for _, test := range tests {
+		got, err := forkCheckpointExportPath(test.dir, test.name)
+		if got != test.want || (err != nil) != test.wantErr {
+			t.Errorf("forkCheckpointExportPath(%q, %q) = (%q, %v)", test.dir, test.name, got, err)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package evm
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ava-labs/coreth/core"
+	"github.com/ava-labs/coreth/core/state"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Maximum number of buffers that a single listDivergentRounds call may span
+const maxDivergentRoundsRange = 10000
+
+// StateConnectorAPI offers the stateconnector_ namespace, exposing the per-round attestation
+// vote breakdowns recorded by this node
+type StateConnectorAPI struct{ vm *VM }
+
+// GetRound returns the vote breakdown of round [bufferNumber], or null if this node has not
+// processed that round
+func (api *StateConnectorAPI) GetRound(bufferNumber uint64) (*core.AttestationRound, error) {
+	return core.ReadAttestationRound(api.vm.chaindb, bufferNumber)
+}
+
+// GetAttestorHistory returns how [address] voted in every round recorded by this node
+func (api *StateConnectorAPI) GetAttestorHistory(address common.Address) []core.AttestorVote {
+	history := core.ReadAttestorHistory(api.vm.chaindb, address)
+	if history == nil {
+		return []core.AttestorVote{}
+	}
+	return history
+}
+
+// ListDivergentRounds returns the breakdowns of rounds in [from, to] in which at least one
+// attestor disagreed with the plurality
+func (api *StateConnectorAPI) ListDivergentRounds(from uint64, to uint64) ([]*core.AttestationRound, error) {
+	if to < from {
+		return nil, fmt.Errorf("invalid range: from %d is greater than to %d", from, to)
+	}
+	if to-from >= maxDivergentRoundsRange {
+		return nil, fmt.Errorf("range of %d rounds exceeds maximum of %d", to-from+1, maxDivergentRoundsRange)
+	}
+	rounds := []*core.AttestationRound{}
+	for _, bufferNumber := range core.ReadDivergentRounds(api.vm.chaindb, from, to) {
+		round, err := core.ReadAttestationRound(api.vm.chaindb, bufferNumber)
+		if err != nil {
+			return nil, err
+		}
+		if round != nil {
+			rounds = append(rounds, round)
+		}
+	}
+	return rounds, nil
+}
+
+// acceptedPenaltyLedger opens the state of the last accepted block and returns it together
+// with the address of the penalty ledger at that block and whether penalties apply there
+func (api *StateConnectorAPI) acceptedPenaltyLedger() (*state.StateDB, common.Address, bool, error) {
+	block := api.vm.chain.LastAcceptedBlock()
+	statedb, err := api.vm.chain.BlockState(block)
+	if err != nil {
+		return nil, common.Address{}, false, err
+	}
+	blockTime := new(big.Int).SetUint64(block.Time())
+	ledger := core.GetAttestorPenaltyLedgerAddr(api.vm.chainConfig.ChainID, blockTime)
+	return statedb, ledger, core.GetAttestorPenaltiesActivated(api.vm.chainConfig.ChainID, block.Number(), blockTime), nil
+}
+
+// GetAttestorPenalty returns the penalty ledger record of [address] as of the last accepted block
+func (api *StateConnectorAPI) GetAttestorPenalty(address common.Address) (*core.AttestorPenalty, error) {
+	statedb, ledger, _, err := api.acceptedPenaltyLedger()
+	if err != nil {
+		return nil, err
+	}
+	penalty := core.ReadAttestorPenalty(statedb, ledger, address)
+	return &penalty, nil
+}
+
+// ListExcludedAttestors returns the penalty ledger records of the attestors that are excluded
+// from the default attestor set as of the last accepted block, none while penalties are off
+func (api *StateConnectorAPI) ListExcludedAttestors() ([]core.AttestorPenalty, error) {
+	statedb, ledger, activated, err := api.acceptedPenaltyLedger()
+	if err != nil {
+		return nil, err
+	}
+	penalties := []core.AttestorPenalty{}
+	if !activated {
+		return penalties, nil
+	}
+	for _, a := range core.ReadExcludedAttestors(statedb, ledger) {
+		penalties = append(penalties, core.ReadAttestorPenalty(statedb, ledger, a))
+	}
+	return penalties, nil
+}
@@ -81,6 +81,8 @@ type StateTransition struct {
 	data       []byte
 	state      vm.StateDB
 	evm        *vm.EVM
+	// Calls made by the chain itself during the transaction, see systemCalls
+	sysCalls *SystemCalls
 }
 
 // Message represents a message sent to a contract.
@@ -131,10 +133,18 @@ func (st *StateTransition) Call(caller vm.ContractRef, addr common.Address, inpu
 	return st.evm.Call(caller, addr, input, gas, value)
 }
 
+func (st *StateTransition) GetChainID() *big.Int {
+	return st.evm.ChainConfig().ChainID
+}
+
 func (st *StateTransition) GetBlockNumber() *big.Int {
 	return st.evm.Context.BlockNumber
 }
 
+func (st *StateTransition) GetBlockTime() *big.Int {
+	return st.evm.Context.Time
+}
+
 func (st *StateTransition) GetGasLimit() uint64 {
 	return st.evm.Context.GasLimit
 }
@@ -143,6 +153,26 @@ func (st *StateTransition) AddBalance(addr common.Address, amount *big.Int) {
 	st.state.AddBalance(addr, amount)
 }
 
+func (st *StateTransition) SubBalance(addr common.Address, amount *big.Int) {
+	st.state.SubBalance(addr, amount)
+}
+
+func (st *StateTransition) GetState(addr common.Address, key common.Hash) common.Hash {
+	return st.state.GetState(addr, key)
+}
+
+func (st *StateTransition) SetState(addr common.Address, key common.Hash, value common.Hash) {
+	st.state.SetState(addr, key, value)
+}
+
+func (st *StateTransition) GetNonce(addr common.Address) uint64 {
+	return st.state.GetNonce(addr)
+}
+
+func (st *StateTransition) SetNonce(addr common.Address, nonce uint64) {
+	st.state.SetNonce(addr, nonce)
+}
+
 // Revert returns the concrete revert reason if the execution is aborted by `REVERT`
 // opcode. Note the reason can be nil if no data supplied with revert opcode.
 func (result *ExecutionResult) Revert() []byte {
@@ -371,12 +401,14 @@ func (st *StateTransition) TransitionDb() (*ExecutionResult, error) {
 		// Increment the nonce for the next transaction
 		st.state.SetNonce(msg.From(), st.state.GetNonce(sender.Address())+1)
 		ret, st.gas, vmerr = st.evm.Call(sender, st.to(), st.data, st.gas, st.value)
-		if vmerr == nil && *msg.To() == GetStateConnectorContract(chainID, timestamp) && len(st.data) >= 36 && len(ret) == 32 {
+		if vmerr == nil && *msg.To() == GetStateConnectorContract(chainID, st.evm.Context.BlockNumber, timestamp) && len(st.data) >= 36 && len(ret) == 32 {
 			if GetStateConnectorActivated(chainID, timestamp) &&
-				bytes.Equal(st.data[0:4], SubmitAttestationSelector(chainID, timestamp)) &&
+				bytes.Equal(st.data[0:4], SubmitAttestationSelector(chainID, st.evm.Context.BlockNumber, timestamp)) &&
 				binary.BigEndian.Uint64(ret[24:32]) > 0 {
-				err = st.FinalisePreviousRound(chainID, timestamp, st.data[4:36])
-				if err != nil {
+				// The system calls of the round are kept out of the trace of the transaction. Unlike
+				// the system hooks, the round is finalised before the gas is refunded, where it has
+				// always been, so that past blocks still execute the same way.
+				if err := st.FinalisePreviousRound(chainID, timestamp, st.data[4:36]); err != nil {
 					log.Warn("Error finalising state connector round", "error", err)
 				}
 			}
@@ -384,7 +416,7 @@ func (st *StateTransition) TransitionDb() (*ExecutionResult, error) {
 	}
 
 	st.refundGas(apricotPhase1)
-	if vmerr == nil && *msg.To() == GetPrioritisedFTSOContract(timestamp) {
+	if vmerr == nil && *msg.To() == GetPrioritisedFTSOContract(chainID, st.evm.Context.BlockNumber, timestamp) {
 		nominalGasUsed := uint64(21000)
 		nominalGasPrice := uint64(225_000_000_000)
 		nominalFee := new(big.Int).Mul(new(big.Int).SetUint64(nominalGasUsed), new(big.Int).SetUint64(nominalGasPrice))
@@ -402,16 +434,13 @@ func (st *StateTransition) TransitionDb() (*ExecutionResult, error) {
 		st.state.AddBalance(burnAddress, new(big.Int).Mul(new(big.Int).SetUint64(st.gasUsed()), st.gasPrice))
 	}
 
-	// Call the flareDaemon contract trigger method if there is no vm error
+	// Make the system calls triggered by the transaction if there is no vm error: the
+	// flareDaemon, unless it is triggered once per block by FinalizeSystemCalls, and the system
+	// hooks. They are kept out of the trace of the transaction.
 	if vmerr == nil {
-		// Temporarily disable EVM debugging
-		oldDebug := st.evm.Config.Debug
-		st.evm.Config.Debug = false
-		// Call the flareDaemon contract trigger
-		log := log.Root()
-		triggerFlareDaemonAndMint(st, log)
-		st.evm.Config.Debug = oldDebug
+		st.recordFlareDaemonExecution(runSystemHooks(st, &systemHookTx{to: msg.To(), data: st.data}))
 	}
+	st.recordSystemCalls()
 
 	return &ExecutionResult{
 		UsedGas:    st.gasUsed(),
@@ -17,7 +17,6 @@ import (
 	"errors"
 	"fmt"
 	"math/big"
-	"os"
 	"path/filepath"
 	"strings"
 	"sync"
@@ -36,6 +35,7 @@ import (
 	"github.com/ava-labs/coreth/rpc"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics/prometheus"
 	"github.com/ethereum/go-ethereum/rlp"
 
 	avalancheRPC "github.com/gorilla/rpc/v2"
@@ -197,6 +197,10 @@ type VM struct {
 	*chain.State
 
 	config Config
+	// Node config fields of the Flare namespaces, see FlareAPIConfig
+	flareAPIConfig FlareAPIConfig
+	// Sum of the genesis allocations, the supply before anything was minted
+	genesisSupply *big.Int
 
 	chainID     *big.Int
 	networkID   uint64
@@ -282,37 +286,35 @@ func (vm *VM) Initialize(
 	fxs []*commonEng.Fx,
 ) error {
 	vm.config.SetDefaults()
+	vm.config.setFlareDefaults()
 	if len(configBytes) > 0 {
 		if err := json.Unmarshal(configBytes, &vm.config); err != nil {
 			return fmt.Errorf("failed to unmarshal config %s: %w", string(configBytes), err)
 		}
 	}
-	vm.config.EthAPIEnabled = false
-	vm.config.NetAPIEnabled = false
-	vm.config.Web3APIEnabled = false
-	vm.config.DebugAPIEnabled = false
-	vm.config.MaxBlocksPerRequest = 1
-	web3API := os.Getenv("WEB3_API")
-	if web3API == "enabled" {
-		vm.config.EthAPIEnabled = true
-		vm.config.NetAPIEnabled = true
-		vm.config.Web3APIEnabled = true
-	} else if web3API == "debug" {
-		vm.config.EthAPIEnabled = true
-		vm.config.NetAPIEnabled = true
-		vm.config.Web3APIEnabled = true
-		vm.config.DebugAPIEnabled = true
-		vm.config.TxPoolAPIEnabled = true
-		vm.config.Pruning = false
-		vm.config.MaxBlocksPerRequest = 0
+	attestorConfig, err := core.ParseAttestorConfig(configBytes)
+	if err != nil {
+		return fmt.Errorf("failed to parse attestor config: %w", err)
+	}
+	attestorSets, err := core.SetAttestorConfig(attestorConfig)
+	if err != nil {
+		return fmt.Errorf("invalid attestor config: %w", err)
 	}
+	vm.flareAPIConfig, err = parseFlareAPIConfig(configBytes)
+	if err != nil {
+		return fmt.Errorf("failed to parse flare API config: %w", err)
+	}
+	core.SetSystemCallTracing(vm.flareAPIConfig.SystemCallTracingEnabled)
 
 	if b, err := json.Marshal(vm.config); err == nil {
-		log.Info("Initializing Coreth VM", "Version", Version, "Config", string(b))
+		log.Info("Initializing Coreth VM", "Version", Version, "Config", string(b), "StateConnectorAPIEnabled", vm.flareAPIConfig.StateConnectorAPIEnabled)
 	} else {
 		// Log a warning message since we have already successfully unmarshalled into the struct
 		log.Warn("Problem initializing Coreth VM", "Version", Version, "Config", string(b), "err", err)
 	}
+	for _, conflict := range append(vm.config.apiConflicts(), vm.flareAPIConfig.apiConflicts()...) {
+		log.Warn("Conflicting API config", "conflict", conflict)
+	}
 
 	if len(fxs) > 0 {
 		return errUnsupportedFXs
@@ -331,6 +333,7 @@ func (vm *VM) Initialize(
 	if err := json.Unmarshal(genesisBytes, g); err != nil {
 		return err
 	}
+	vm.genesisSupply = core.GenesisAllocSupply(g.Alloc)
 
 	// Set the chain config for mainnet/fuji chain IDs
 	switch {
@@ -350,6 +353,21 @@ func (vm *VM) Initialize(
 	mainnetExtDataHashes = nil
 
 	vm.chainID = g.Config.ChainID
+	flareConfig, err := core.ParseFlareChainConfig(genesisBytes)
+	if err != nil {
+		return fmt.Errorf("failed to parse flare chain config: %w", err)
+	}
+	if flareConfig != nil {
+		if err := core.SetFlareChainConfig(vm.chainID, flareConfig); err != nil {
+			return fmt.Errorf("invalid flare chain config: %w", err)
+		}
+	}
+	if err := core.ValidateFlareForkSchedule(vm.chainID); err != nil {
+		return fmt.Errorf("invalid flare fork schedule: %w", err)
+	}
+	core.RegisterStateConnectorProofVerifier(vm.chainID)
+	genesisAttestors, _ := core.GetGenesisAttestors(vm.chainID)
+	log.Info("Attestor sets", "genesis", genesisAttestors, "testing", attestorSets.Testing, "local", attestorSets.Local)
 
 	ethConfig := ethconfig.NewDefaultConfig()
 	ethConfig.Genesis = g
@@ -434,6 +452,8 @@ func (vm *VM) Initialize(
 
 	vm.shutdownWg.Add(1)
 	go vm.ctx.Log.RecoverAndPanic(vm.awaitSubmittedTxs)
+	vm.startFBARegistryUpdates()
+	vm.startBlockRecords()
 
 	go vm.ctx.Log.RecoverAndPanic(vm.startContinuousProfiler)
 
@@ -462,6 +482,17 @@ func (vm *VM) createConsensusCallbacks() *dummy.ConsensusCallbacks {
 }
 
 func (vm *VM) onFinalizeAndAssemble(header *types.Header, state *state.StateDB, txs []*types.Transaction) ([]byte, error) {
+	atomicTxBytes, err := vm.assembleAtomicTx(header, state, txs)
+	if err != nil {
+		return nil, err
+	}
+	core.FinalizeSystemCalls(vm.chainConfig, flareDaemonChainContext{vm}, header, state)
+	return atomicTxBytes, nil
+}
+
+// assembleAtomicTx applies the next valid atomic transaction of the mempool to [state] and
+// returns it to be included in the block of [header]
+func (vm *VM) assembleAtomicTx(header *types.Header, state *state.StateDB, txs []*types.Transaction) ([]byte, error) {
 	snapshot := state.Snapshot()
 	for {
 		tx, exists := vm.mempool.NextTx()
@@ -499,10 +530,15 @@ func (vm *VM) onExtraStateChange(block *types.Block, state *state.StateDB) error
 	if err != nil {
 		return err
 	}
-	if tx == nil {
-		return nil
+	if tx != nil {
+		if err := tx.UnsignedAtomicTx.EVMStateTransfer(vm.ctx, state); err != nil {
+			return err
+		}
 	}
-	return tx.UnsignedAtomicTx.EVMStateTransfer(vm.ctx, state)
+	// Same order as onFinalizeAndAssemble, the system calls run after the atomic transaction
+	core.FinalizeSystemCalls(vm.chainConfig, flareDaemonChainContext{vm}, block.Header(), state)
+	core.FinaliseBlockRecords(state, block.Hash())
+	return nil
 }
 
 func (vm *VM) pruneChain() error {
@@ -712,6 +748,8 @@ func (vm *VM) CreateHandlers() (map[string]*commonEng.HTTPHandler, error) {
 			return nil, fmt.Errorf("failed to get primary alias for chain due to %w", err)
 		}
 		errs.Add(handler.RegisterName("performance", NewPerformanceService(fmt.Sprintf("coreth_performance_%s", primaryAlias))))
+		errs.Add(handler.RegisterName("forkcheckpoint", &ForkCheckpointAPI{vm}))
+		errs.Add(handler.RegisterName("attestoradmin", &AttestorAdminAPI{}))
 		enabledAPIs = append(enabledAPIs, "coreth-admin")
 	}
 	if vm.config.NetAPIEnabled {
@@ -722,6 +760,18 @@ func (vm *VM) CreateHandlers() (map[string]*commonEng.HTTPHandler, error) {
 		errs.Add(handler.RegisterName("web3", &Web3API{}))
 		enabledAPIs = append(enabledAPIs, "web3")
 	}
+	if vm.flareAPIConfig.StateConnectorAPIEnabled {
+		errs.Add(handler.RegisterName("stateconnector", &StateConnectorAPI{vm}))
+		enabledAPIs = append(enabledAPIs, "stateconnector")
+	}
+	if vm.flareAPIConfig.FlareAPIEnabled {
+		errs.Add(handler.RegisterName("flare", &FlareForkAPI{vm}))
+		enabledAPIs = append(enabledAPIs, "flare")
+	}
+	if vm.flareAPIConfig.FlareDaemonAPIEnabled {
+		errs.Add(handler.RegisterName("flaredaemon", &FlareDaemonAPI{vm}))
+		enabledAPIs = append(enabledAPIs, "flaredaemon")
+	}
 	if errs.Errored() {
 		return nil, errs.Err
 	}
@@ -733,11 +783,15 @@ func (vm *VM) CreateHandlers() (map[string]*commonEng.HTTPHandler, error) {
 
 	log.Info(fmt.Sprintf("Enabled APIs: %s", strings.Join(enabledAPIs, ", ")))
 
-	return map[string]*commonEng.HTTPHandler{
+	handlers := map[string]*commonEng.HTTPHandler{
 		"/rpc":  {LockOptions: commonEng.NoLock, Handler: handler},
 		"/avax": avaxAPI,
 		"/ws":   {LockOptions: commonEng.NoLock, Handler: handler.WebsocketHandlerWithDuration([]string{"*"}, vm.config.APIMaxDuration.Duration)},
-	}, nil
+	}
+	if vm.flareAPIConfig.FlareMetricsAPIEnabled {
+		handlers["/metrics"] = &commonEng.HTTPHandler{LockOptions: commonEng.NoLock, Handler: prometheus.Handler(core.FlareDaemonMetrics)}
+	}
+	return handlers, nil
 }
 
 // CreateStaticHandlers makes new http handlers that can handle API calls
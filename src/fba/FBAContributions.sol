@@ -0,0 +1,104 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+// SPDX-License-Identifier: MIT
+pragma solidity 0.7.6;
+
+// Contribution records of the FBA validators to the safety of each underlying chain. Nodes
+// weight every validator by its share of the contributions to each chain, times the market cap
+// of that chain: the price reported by the chain's FTSO times its circulating supply. Weights
+// are recomputed every fbaWeightEpochBlocks blocks, see fba_weights.go.
+contract FBAContributions {
+
+    struct Chain {
+        address ftso;   // FTSO reporting the price of the chain's asset, getCurrentPrice()
+        uint256 supply; // Circulating supply of the chain's asset, in whole units
+        bytes20[] nodeIDs;
+        uint256[] amounts;
+    }
+
+    address public governance;
+    address public reporter; // Records contributions, e.g. from state connector proofs
+    uint256[] private chainIds;
+    mapping(uint256 => Chain) private chains;
+
+    event GovernanceTransferred(address previousGovernance, address newGovernance);
+    event ReporterChanged(address reporter);
+    event ChainSet(uint256 chainId, address ftso, uint256 supply);
+    event ChainRemoved(uint256 chainId);
+    event ContributionsChanged(uint256 chainId, uint256 blockNumber, uint256 numValidators);
+
+    modifier onlyGovernance {
+        require(msg.sender == governance, "only governance");
+        _;
+    }
+
+    modifier onlyReporter {
+        require(msg.sender == reporter || msg.sender == governance, "only reporter");
+        _;
+    }
+
+    constructor(address _governance) {
+        require(_governance != address(0), "governance zero");
+        governance = _governance;
+    }
+
+    function transferGovernance(address _governance) external onlyGovernance {
+        require(_governance != address(0), "governance zero");
+        emit GovernanceTransferred(governance, _governance);
+        governance = _governance;
+    }
+
+    function setReporter(address _reporter) external onlyGovernance {
+        reporter = _reporter;
+        emit ReporterChanged(_reporter);
+    }
+
+    function setChain(uint256 _chainId, address _ftso, uint256 _supply) external onlyGovernance {
+        require(_ftso != address(0), "ftso zero");
+        if (chains[_chainId].ftso == address(0)) {
+            chainIds.push(_chainId);
+        }
+        chains[_chainId].ftso = _ftso;
+        chains[_chainId].supply = _supply;
+        emit ChainSet(_chainId, _ftso, _supply);
+    }
+
+    function removeChain(uint256 _chainId) external onlyGovernance {
+        require(chains[_chainId].ftso != address(0), "unknown chain");
+        delete chains[_chainId];
+        for (uint256 i = 0; i < chainIds.length; i++) {
+            if (chainIds[i] == _chainId) {
+                chainIds[i] = chainIds[chainIds.length - 1];
+                chainIds.pop();
+                break;
+            }
+        }
+        emit ChainRemoved(_chainId);
+    }
+
+    function setContributions(uint256 _chainId, bytes20[] calldata _nodeIDs, uint256[] calldata _amounts) external onlyReporter {
+        require(chains[_chainId].ftso != address(0), "unknown chain");
+        require(_nodeIDs.length == _amounts.length, "length mismatch");
+        for (uint256 i = 0; i < _nodeIDs.length; i++) {
+            require(_nodeIDs[i] != bytes20(0), "node ID zero");
+        }
+        chains[_chainId].nodeIDs = _nodeIDs;
+        chains[_chainId].amounts = _amounts;
+        emit ContributionsChanged(_chainId, block.number, _nodeIDs.length);
+    }
+
+    function getChains() external view returns (uint256[] memory, address[] memory, uint256[] memory) {
+        address[] memory ftsos = new address[](chainIds.length);
+        uint256[] memory supplies = new uint256[](chainIds.length);
+        for (uint256 i = 0; i < chainIds.length; i++) {
+            ftsos[i] = chains[chainIds[i]].ftso;
+            supplies[i] = chains[chainIds[i]].supply;
+        }
+        return (chainIds, ftsos, supplies);
+    }
+
+    function getContributions(uint256 _chainId) external view returns (bytes20[] memory, uint256[] memory) {
+        return (chains[_chainId].nodeIDs, chains[_chainId].amounts);
+    }
+}
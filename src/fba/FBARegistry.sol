@@ -0,0 +1,50 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+// SPDX-License-Identifier: MIT
+pragma solidity 0.7.6;
+
+// The FBA validator set of the network. Nodes read getValidators() each time they accept a
+// C-chain block, so a change made by governance takes effect on every node at the height of
+// the block that includes it.
+contract FBARegistry {
+
+    address public governance;
+    bytes20[] private nodeIDs; // Short IDs of the validators, NodeID-<cb58> without the prefix
+    uint64[] private weights;
+
+    event GovernanceTransferred(address previousGovernance, address newGovernance);
+    event ValidatorsChanged(uint256 blockNumber, uint256 numValidators);
+
+    modifier onlyGovernance {
+        require(msg.sender == governance, "only governance");
+        _;
+    }
+
+    constructor(address _governance) {
+        require(_governance != address(0), "governance zero");
+        governance = _governance;
+    }
+
+    function transferGovernance(address _governance) external onlyGovernance {
+        require(_governance != address(0), "governance zero");
+        emit GovernanceTransferred(governance, _governance);
+        governance = _governance;
+    }
+
+    function setValidators(bytes20[] calldata _nodeIDs, uint64[] calldata _weights) external onlyGovernance {
+        require(_nodeIDs.length > 0, "no validators");
+        require(_nodeIDs.length == _weights.length, "length mismatch");
+        for (uint256 i = 0; i < _nodeIDs.length; i++) {
+            require(_nodeIDs[i] != bytes20(0), "node ID zero");
+            require(_weights[i] > 0, "weight zero");
+        }
+        nodeIDs = _nodeIDs;
+        weights = _weights;
+        emit ValidatorsChanged(block.number, _nodeIDs.length);
+    }
+
+    function getValidators() external view returns (bytes20[] memory, uint64[] memory) {
+        return (nodeIDs, weights);
+    }
+}
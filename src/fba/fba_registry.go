@@ -0,0 +1,105 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ava-labs/coreth/core/types"
+	"github.com/ava-labs/coreth/core/vm"
+	"github.com/ava-labs/coreth/params"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Gas available to a getValidators call on the FBA registry
+const fbaRegistryCallGas = 10000000
+
+var (
+	errMalformedFBARegistry      = errors.New("malformed FBA registry return data")
+	errFBARegistryLengthMismatch = errors.New("FBA registry returned a different number of node IDs and weights")
+)
+
+// FBAValidator is an entry of the FBA registry, see FBARegistry.sol
+type FBAValidator struct {
+	NodeID [20]byte
+	Weight uint64
+}
+
+func GetFBARegistryContract(chainID *big.Int, blockNumber *big.Int, blockTime *big.Int) common.Address {
+	return GetFlareParams(chainID, blockNumber, blockTime).FBARegistryContract
+}
+
+func GetFBARegistryValidatorsSelector(chainID *big.Int, blockTime *big.Int) []byte {
+	switch {
+	default:
+		return []byte{0xb7, 0xab, 0x4d, 0xb5}
+	}
+}
+
+// ReadFBARegistry calls getValidators on the FBA registry in effect at [header], against the
+// state of that block. It returns false if the chain has no registry at that block, or if no
+// contract has been deployed at its address yet.
+func ReadFBARegistry(config *params.ChainConfig, chain ChainContext, header *types.Header, statedb vm.StateDB) ([]FBAValidator, bool, error) {
+	blockTime := new(big.Int).SetUint64(header.Time)
+	registry := GetFBARegistryContract(config.ChainID, header.Number, blockTime)
+	if registry == (common.Address{}) || statedb.GetCodeSize(registry) == 0 {
+		return nil, false, nil
+	}
+	evm := vm.NewEVM(NewEVMBlockContext(header, chain, &header.Coinbase), vm.TxContext{}, statedb, config, vm.Config{})
+	ret, _, err := evm.StaticCall(vm.AccountRef(common.Address{}), registry, GetFBARegistryValidatorsSelector(config.ChainID, blockTime), fbaRegistryCallGas)
+	if err != nil {
+		return nil, true, fmt.Errorf("getValidators on FBA registry %s failed: %w", registry.Hex(), err)
+	}
+	validators, err := unpackFBAValidators(ret)
+	return validators, true, err
+}
+
+// unpackFBAValidators decodes the (bytes20[], uint64[]) returned by getValidators
+func unpackFBAValidators(data []byte) ([]FBAValidator, error) {
+	nodeIDs, err := unpackWordArray(data, 0)
+	if err != nil {
+		return nil, err
+	}
+	weights, err := unpackWordArray(data, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(nodeIDs) != len(weights) {
+		return nil, errFBARegistryLengthMismatch
+	}
+	validators := make([]FBAValidator, len(nodeIDs))
+	for i := range validators {
+		weight := new(big.Int).SetBytes(weights[i])
+		if !weight.IsUint64() {
+			return nil, errMalformedFBARegistry
+		}
+		copy(validators[i].NodeID[:], nodeIDs[i][:20])
+		validators[i].Weight = weight.Uint64()
+	}
+	return validators, nil
+}
+
+// unpackWordArray returns the 32 byte words of the dynamic array that is return value [index]
+// of the ABI encoded [data]
+func unpackWordArray(data []byte, index int) ([][]byte, error) {
+	if len(data) < (index+1)*common.HashLength {
+		return nil, errMalformedFBARegistry
+	}
+	offset := new(big.Int).SetBytes(data[index*common.HashLength : (index+1)*common.HashLength])
+	if !offset.IsUint64() || offset.Uint64() > uint64(len(data)-common.HashLength) {
+		return nil, errMalformedFBARegistry
+	}
+	start := offset.Uint64() + common.HashLength
+	length := new(big.Int).SetBytes(data[start-common.HashLength : start])
+	if !length.IsUint64() || length.Uint64() > (uint64(len(data))-start)/common.HashLength {
+		return nil, errMalformedFBARegistry
+	}
+	words := make([][]byte, length.Uint64())
+	for i := range words {
+		words[i] = data[start+uint64(i)*common.HashLength : start+uint64(i+1)*common.HashLength]
+	}
+	return words, nil
+}
@@ -0,0 +1,101 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package core
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ava-labs/coreth/core/rawdb"
+	"github.com/ava-labs/coreth/core/state"
+	"github.com/ava-labs/coreth/core/types"
+	"github.com/ava-labs/coreth/params"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// packFBAValidators ABI encodes [validators] the way getValidators returns them
+func packFBAValidators(validators []FBAValidator) []byte {
+	word := func(n uint64) []byte { return common.BigToHash(new(big.Int).SetUint64(n)).Bytes() }
+	n := uint64(len(validators))
+	data := append(word(2*common.HashLength), word((3+n)*common.HashLength)...)
+	data = append(data, word(n)...)
+	for _, v := range validators {
+		data = append(data, common.RightPadBytes(v.NodeID[:], common.HashLength)...)
+	}
+	data = append(data, word(n)...)
+	for _, v := range validators {
+		data = append(data, word(v.Weight)...)
+	}
+	return data
+}
+
+func testFBAValidators() []FBAValidator {
+	return []FBAValidator{
+		{NodeID: [20]byte{1}, Weight: 200000},
+		{NodeID: [20]byte{2}, Weight: 100000},
+	}
+}
+
+func TestUnpackFBAValidators(t *testing.T) {
+	valid := packFBAValidators(testFBAValidators())
+	mismatch := packFBAValidators(testFBAValidators())
+	mismatch[len(mismatch)-3*common.HashLength+common.HashLength-1] = 1 // weights length word
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr error
+	}{
+		{"valid", valid, nil},
+		{"empty registry", packFBAValidators(nil), nil},
+		{"no data", nil, errMalformedFBARegistry},
+		{"truncated", valid[:len(valid)-1], errMalformedFBARegistry},
+		{"offset out of range", append(common.BigToHash(big.NewInt(1<<20)).Bytes(), valid[common.HashLength:]...), errMalformedFBARegistry},
+		{"length mismatch", mismatch, errFBARegistryLengthMismatch},
+	}
+	for _, test := range tests {
+		validators, err := unpackFBAValidators(test.data)
+		if err != test.wantErr {
+			t.Errorf("%s: got error %v want %v", test.name, err, test.wantErr)
+		}
+		if test.name == "valid" && (len(validators) != 2 || validators[1] != testFBAValidators()[1]) {
+			t.Errorf("%s: got %v", test.name, validators)
+		}
+	}
+}
+
+func TestReadFBARegistry(t *testing.T) {
+	config := *params.TestChainConfig
+	config.ChainID = big.NewInt(987654322)
+	defer func() {
+		flareChainConfigsLock.Lock()
+		delete(flareChainConfigs, config.ChainID.Uint64())
+		flareChainConfigsLock.Unlock()
+	}()
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	header := &types.Header{Number: big.NewInt(10), Time: 100, GasLimit: 8000000, Difficulty: common.Big1}
+
+	if _, ok, err := ReadFBARegistry(&config, nil, header, statedb); ok || err != nil {
+		t.Fatalf("chain without registry: got (%v, %v)", ok, err)
+	}
+
+	registry := common.HexToAddress("0x1000000000000000000000000000000000000005")
+	registryBlock := uint64(5)
+	forks := FlareForkSchedule{genesisFlareFork(), {Name: "fbaRegistry", Block: &registryBlock, FlareForkParams: FlareForkParams{FBARegistryContract: &registry}}}
+	if err := SetFlareChainConfig(config.ChainID, &FlareChainConfig{Forks: forks}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := ReadFBARegistry(&config, nil, header, statedb); ok || err != nil {
+		t.Fatalf("registry not deployed: got (%v, %v)", ok, err)
+	}
+
+	statedb.SetCode(registry, returnDataCode(packFBAValidators(testFBAValidators())))
+	validators, ok, err := ReadFBARegistry(&config, nil, header, statedb)
+	if !ok || err != nil || len(validators) != 2 || validators[0] != testFBAValidators()[0] {
+		t.Errorf("got (%v, %v, %v)", validators, ok, err)
+	}
+	header.Number = big.NewInt(4)
+	if _, ok, _ := ReadFBARegistry(&config, nil, header, statedb); ok {
+		t.Errorf("registry read before its fork")
+	}
+}
@@ -0,0 +1,281 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/ava-labs/coreth/core/types"
+	"github.com/ava-labs/coreth/core/vm"
+	"github.com/ava-labs/coreth/params"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// Total weight that the market-cap weights of the FBA validators are scaled to
+const fbaWeightTotal = 1000000000000
+
+var (
+	errFBAContributionsLengthMismatch = errors.New("FBA contributions returned arrays of different lengths")
+
+	fbaWeightsPrefix = []byte("fba-weights-") // fbaWeightsPrefix + epoch start (uint64 big endian) -> fbaEpochWeights
+)
+
+// FBAChainContributions are the contribution records of one underlying chain, see
+// FBAContributions.sol. Price is nil if the chain's FTSO could not be read.
+type FBAChainContributions struct {
+	ChainID *big.Int
+	FTSO    common.Address
+	Supply  *big.Int
+	Price   *big.Int
+	NodeIDs [][20]byte
+	Amounts []*big.Int
+}
+
+func GetFBAContributionsContract(chainID *big.Int, blockNumber *big.Int, blockTime *big.Int) common.Address {
+	return GetFlareParams(chainID, blockNumber, blockTime).FBAContributionsContract
+}
+
+// GetFBAWeightEpochBlocks returns how many blocks the market-cap weights computed at the start
+// of an epoch stay in effect
+func GetFBAWeightEpochBlocks(chainID *big.Int, blockNumber *big.Int, blockTime *big.Int) uint64 {
+	return GetFlareParams(chainID, blockNumber, blockTime).FBAWeightEpochBlocks
+}
+
+// GetFBAContributionsChainsSelector returns the selector of getChains() on the FBA contributions contract
+func GetFBAContributionsChainsSelector(chainID *big.Int, blockTime *big.Int) []byte {
+	switch {
+	default:
+		return []byte{0x33, 0x1b, 0x06, 0x2a}
+	}
+}
+
+// GetFBAContributionsSelector returns the selector of getContributions(uint256) on the FBA contributions contract
+func GetFBAContributionsSelector(chainID *big.Int, blockTime *big.Int) []byte {
+	switch {
+	default:
+		return []byte{0xd0, 0x5e, 0x3c, 0xc5}
+	}
+}
+
+// GetFTSOCurrentPriceSelector returns the selector of getCurrentPrice() on an FTSO contract
+func GetFTSOCurrentPriceSelector(chainID *big.Int, blockTime *big.Int) []byte {
+	switch {
+	default:
+		return []byte{0xeb, 0x91, 0xd3, 0x7e}
+	}
+}
+
+// GetFBAWeightEpochStart returns the number of the block that starts the weight epoch of block
+// [blockNumber], false if the chain has no contributions contract or no weight epochs at that
+// block. Epochs are counted from the fork that last set either, so that an epoch never starts
+// before both are in effect. The weights of an epoch are computed against the state of the
+// block that starts it, so that every node computes the same weights for it.
+func GetFBAWeightEpochStart(chainID *big.Int, blockNumber *big.Int, blockTime *big.Int) (uint64, bool) {
+	p := GetFlareParams(chainID, blockNumber, blockTime)
+	if p.FBAContributionsContract == (common.Address{}) || p.FBAWeightEpochBlocks == 0 {
+		return 0, false
+	}
+	number := blockNumber.Uint64()
+	return number - (number-p.FBAWeightEpochsFrom)%p.FBAWeightEpochBlocks, true
+}
+
+// fbaEpochWeights are the stored weights of one epoch, Found is false if the contributions
+// contract could not be read at the start of the epoch
+type fbaEpochWeights struct {
+	Found      bool
+	Validators []FBAValidator
+}
+
+func fbaWeightsKey(epochStart uint64) []byte {
+	key := make([]byte, len(fbaWeightsPrefix)+8)
+	copy(key, fbaWeightsPrefix)
+	binary.BigEndian.PutUint64(key[len(fbaWeightsPrefix):], epochStart)
+	return key
+}
+
+// WriteFBAWeights stores the weights computed for the epoch that starts at block [epochStart],
+// so that they are applied again after a restart without the state of that block
+func WriteFBAWeights(db ethdb.KeyValueWriter, epochStart uint64, weights []FBAValidator, found bool) error {
+	enc, err := rlp.EncodeToBytes(&fbaEpochWeights{Found: found, Validators: weights})
+	if err != nil {
+		return err
+	}
+	return db.Put(fbaWeightsKey(epochStart), enc)
+}
+
+// ReadFBAWeights returns the weights stored for the epoch that starts at block [epochStart],
+// and whether they were found at the start of the epoch. The last result is false if none are
+// stored.
+func ReadFBAWeights(db ethdb.KeyValueReader, epochStart uint64) ([]FBAValidator, bool, bool, error) {
+	enc, err := readRecord(db, fbaWeightsKey(epochStart))
+	if err != nil || enc == nil {
+		return nil, false, false, err
+	}
+	var stored fbaEpochWeights
+	if err := rlp.DecodeBytes(enc, &stored); err != nil {
+		return nil, false, false, err
+	}
+	return stored.Validators, stored.Found, true, nil
+}
+
+// ReadFBAContributions reads the contribution records of every underlying chain and the current
+// price of each chain from its FTSO, against the state of [header]. It returns false if the
+// chain has no contributions contract at that block or no weight epoch length, or if no
+// contract has been deployed at its address yet.
+func ReadFBAContributions(config *params.ChainConfig, chain ChainContext, header *types.Header, statedb vm.StateDB) ([]FBAChainContributions, bool, error) {
+	blockTime := new(big.Int).SetUint64(header.Time)
+	contract := GetFBAContributionsContract(config.ChainID, header.Number, blockTime)
+	if contract == (common.Address{}) || GetFBAWeightEpochBlocks(config.ChainID, header.Number, blockTime) == 0 || statedb.GetCodeSize(contract) == 0 {
+		return nil, false, nil
+	}
+	evm := vm.NewEVM(NewEVMBlockContext(header, chain, &header.Coinbase), vm.TxContext{}, statedb, config, vm.Config{})
+	call := func(to common.Address, input []byte) ([]byte, error) {
+		ret, _, err := evm.StaticCall(vm.AccountRef(common.Address{}), to, input, fbaRegistryCallGas)
+		return ret, err
+	}
+
+	ret, err := call(contract, GetFBAContributionsChainsSelector(config.ChainID, blockTime))
+	if err != nil {
+		return nil, true, fmt.Errorf("getChains on FBA contributions %s failed: %w", contract.Hex(), err)
+	}
+	chains, err := unpackFBAChains(ret)
+	if err != nil {
+		return nil, true, err
+	}
+	for i := range chains {
+		c := &chains[i]
+		input := append(append([]byte{}, GetFBAContributionsSelector(config.ChainID, blockTime)...), common.BigToHash(c.ChainID).Bytes()...)
+		ret, err := call(contract, input)
+		if err != nil {
+			return nil, true, fmt.Errorf("getContributions(%d) on FBA contributions %s failed: %w", c.ChainID, contract.Hex(), err)
+		}
+		if c.NodeIDs, c.Amounts, err = unpackFBAContributions(ret); err != nil {
+			return nil, true, err
+		}
+		// A chain whose price cannot be read adds no weight rather than failing the epoch
+		ret, err = call(c.FTSO, GetFTSOCurrentPriceSelector(config.ChainID, blockTime))
+		if err == nil && len(ret) >= common.HashLength {
+			c.Price = new(big.Int).SetBytes(ret[:common.HashLength])
+		}
+	}
+	return chains, true, nil
+}
+
+// unpackFBAChains decodes the (uint256[], address[], uint256[]) returned by getChains
+func unpackFBAChains(data []byte) ([]FBAChainContributions, error) {
+	var arrays [3][][]byte
+	for i := range arrays {
+		words, err := unpackWordArray(data, i)
+		if err != nil {
+			return nil, err
+		}
+		arrays[i] = words
+	}
+	if len(arrays[1]) != len(arrays[0]) || len(arrays[2]) != len(arrays[0]) {
+		return nil, errFBAContributionsLengthMismatch
+	}
+	chains := make([]FBAChainContributions, len(arrays[0]))
+	for i := range chains {
+		chains[i].ChainID = new(big.Int).SetBytes(arrays[0][i])
+		chains[i].FTSO = common.BytesToAddress(arrays[1][i])
+		chains[i].Supply = new(big.Int).SetBytes(arrays[2][i])
+	}
+	return chains, nil
+}
+
+// unpackFBAContributions decodes the (bytes20[], uint256[]) returned by getContributions
+func unpackFBAContributions(data []byte) ([][20]byte, []*big.Int, error) {
+	nodeIDWords, err := unpackWordArray(data, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	amountWords, err := unpackWordArray(data, 1)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(nodeIDWords) != len(amountWords) {
+		return nil, nil, errFBAContributionsLengthMismatch
+	}
+	nodeIDs := make([][20]byte, len(nodeIDWords))
+	amounts := make([]*big.Int, len(amountWords))
+	for i := range nodeIDs {
+		copy(nodeIDs[i][:], nodeIDWords[i][:20])
+		amounts[i] = new(big.Int).SetBytes(amountWords[i])
+	}
+	return nodeIDs, amounts, nil
+}
+
+// ComputeFBAWeights weights every validator by its share of the contributions to each chain
+// times the market cap of the chain, price times supply, and scales the weights to add up to
+// fbaWeightTotal. Validators whose weight rounds to zero are left out, and the result is sorted
+// by node ID.
+func ComputeFBAWeights(chains []FBAChainContributions) []FBAValidator {
+	values := make(map[[20]byte]*big.Rat)
+	total := new(big.Rat)
+	for _, c := range chains {
+		if c.Price == nil || c.Supply == nil {
+			continue
+		}
+		marketCap := new(big.Int).Mul(c.Price, c.Supply)
+		contributed := new(big.Int)
+		for _, amount := range c.Amounts {
+			contributed.Add(contributed, amount)
+		}
+		if marketCap.Sign() == 0 || contributed.Sign() == 0 {
+			continue
+		}
+		for i, nodeID := range c.NodeIDs {
+			value := new(big.Rat).SetFrac(new(big.Int).Mul(marketCap, c.Amounts[i]), contributed)
+			if values[nodeID] == nil {
+				values[nodeID] = new(big.Rat)
+			}
+			values[nodeID].Add(values[nodeID], value)
+			total.Add(total, value)
+		}
+	}
+	if total.Sign() == 0 {
+		return nil
+	}
+
+	scale := new(big.Rat).Quo(new(big.Rat).SetInt64(fbaWeightTotal), total)
+	validators := make([]FBAValidator, 0, len(values))
+	for nodeID, value := range values {
+		scaled := new(big.Rat).Mul(value, scale)
+		weight := new(big.Int).Quo(scaled.Num(), scaled.Denom())
+		if weight.Sign() > 0 {
+			validators = append(validators, FBAValidator{NodeID: nodeID, Weight: weight.Uint64()})
+		}
+	}
+	sort.Slice(validators, func(i, j int) bool {
+		return bytes.Compare(validators[i].NodeID[:], validators[j].NodeID[:]) < 0
+	})
+	return validators
+}
+
+// ApplyFBAWeights gives the validators of the FBA registry their market-cap [weights]. Registry
+// validators without a weight are left out; the registry weights are kept if none of the
+// registry validators has one.
+func ApplyFBAWeights(registry []FBAValidator, weights []FBAValidator) []FBAValidator {
+	weightOf := make(map[[20]byte]uint64, len(weights))
+	for _, v := range weights {
+		weightOf[v.NodeID] = v.Weight
+	}
+	var weighted []FBAValidator
+	for _, v := range registry {
+		if weight, ok := weightOf[v.NodeID]; ok {
+			weighted = append(weighted, FBAValidator{NodeID: v.NodeID, Weight: weight})
+		}
+	}
+	if len(weighted) == 0 {
+		return registry
+	}
+	return weighted
+}
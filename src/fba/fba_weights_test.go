@@ -0,0 +1,156 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package core
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/ava-labs/coreth/core/rawdb"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestComputeFBAWeights(t *testing.T) {
+	a, b, c := [20]byte{1}, [20]byte{2}, [20]byte{3}
+	chain := func(price, supply int64, nodeIDs [][20]byte, amounts ...int64) FBAChainContributions {
+		contributions := FBAChainContributions{ChainID: big.NewInt(1), Supply: big.NewInt(supply), NodeIDs: nodeIDs}
+		if price >= 0 {
+			contributions.Price = big.NewInt(price)
+		}
+		for _, amount := range amounts {
+			contributions.Amounts = append(contributions.Amounts, big.NewInt(amount))
+		}
+		return contributions
+	}
+	tests := []struct {
+		name   string
+		chains []FBAChainContributions
+		want   []FBAValidator
+	}{
+		{"no chains", nil, nil},
+		{"one chain", []FBAChainContributions{chain(2, 100, [][20]byte{b, a}, 1, 3)},
+			[]FBAValidator{{a, 750000000000}, {b, 250000000000}}},
+		{"market cap weighted", []FBAChainContributions{
+			chain(3, 100, [][20]byte{a}, 5),       // market cap 300
+			chain(1, 100, [][20]byte{a, b}, 1, 1), // market cap 100, split evenly
+		}, []FBAValidator{{a, 875000000000}, {b, 125000000000}}},
+		{"unreadable price", []FBAChainContributions{
+			chain(-1, 100, [][20]byte{c}, 1),
+			chain(1, 100, [][20]byte{a}, 1),
+		}, []FBAValidator{{a, 1000000000000}}},
+		{"no contributions", []FBAChainContributions{chain(1, 100, [][20]byte{a, b}, 0, 0)}, nil},
+		{"zero supply", []FBAChainContributions{chain(1, 0, [][20]byte{a}, 1)}, nil},
+		{"repeated node ID", []FBAChainContributions{chain(1, 100, [][20]byte{a, b, a}, 1, 2, 1)},
+			[]FBAValidator{{a, 500000000000}, {b, 500000000000}}},
+	}
+	for _, test := range tests {
+		if got := ComputeFBAWeights(test.chains); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestApplyFBAWeights(t *testing.T) {
+	registry := []FBAValidator{{[20]byte{1}, 10}, {[20]byte{2}, 20}}
+	tests := []struct {
+		name    string
+		weights []FBAValidator
+		want    []FBAValidator
+	}{
+		{"all weighted", []FBAValidator{{[20]byte{1}, 7}, {[20]byte{2}, 3}}, []FBAValidator{{[20]byte{1}, 7}, {[20]byte{2}, 3}}},
+		{"unweighted left out", []FBAValidator{{[20]byte{2}, 3}}, []FBAValidator{{[20]byte{2}, 3}}},
+		{"non-registry ignored", []FBAValidator{{[20]byte{3}, 3}}, registry},
+		{"no weights", nil, registry},
+	}
+	for _, test := range tests {
+		if got := ApplyFBAWeights(registry, test.weights); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestGetFBAWeightEpochStart(t *testing.T) {
+	chainID := big.NewInt(987654350)
+	block := func(n uint64) *uint64 { return &n }
+	contract := common.HexToAddress("0x1000000000000000000000000000000000000008")
+	forks := FlareForkSchedule{
+		genesisFlareFork(),
+		{Name: "epochs", Block: block(500), FlareForkParams: FlareForkParams{FBAWeightEpochBlocks: block(100)}},
+		{Name: "contributions", Block: block(1005), FlareForkParams: FlareForkParams{FBAContributionsContract: &contract}},
+		{Name: "unrelated", Block: block(1500), FlareForkParams: FlareForkParams{FlareDaemonPerBlock: new(bool)}},
+		{Name: "longerEpochs", Block: block(2050), FlareForkParams: FlareForkParams{FBAWeightEpochBlocks: block(500)}},
+	}
+	if err := SetFlareChainConfig(chainID, &FlareChainConfig{Forks: forks}); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		flareChainConfigsLock.Lock()
+		delete(flareChainConfigs, chainID.Uint64())
+		flareChainConfigsLock.Unlock()
+	}()
+	for _, test := range []struct {
+		number     int64
+		want       uint64
+		wantEpochs bool
+	}{
+		{600, 0, false}, {1004, 0, false},
+		{1005, 1005, true}, {1104, 1005, true}, {1105, 1105, true}, {1500, 1405, true},
+		{2049, 2005, true}, {2050, 2050, true}, {2549, 2050, true}, {2550, 2550, true},
+	} {
+		got, epochs := GetFBAWeightEpochStart(chainID, big.NewInt(test.number), big.NewInt(0))
+		if got != test.want || epochs != test.wantEpochs {
+			t.Errorf("block %d: got (%d, %v) want (%d, %v)", test.number, got, epochs, test.want, test.wantEpochs)
+		}
+	}
+}
+
+func TestFBAWeightsAreStoredByEpoch(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	weights := []FBAValidator{{[20]byte{1}, 7}, {[20]byte{2}, 3}}
+	if err := WriteFBAWeights(db, 100, weights, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFBAWeights(db, 200, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		epochStart uint64
+		want       []FBAValidator
+		wantFound  bool
+		wantStored bool
+	}{
+		{100, weights, true, true},
+		{200, nil, false, true},
+		{300, nil, false, false},
+	} {
+		got, found, stored, err := ReadFBAWeights(db, test.epochStart)
+		if err != nil || found != test.wantFound || stored != test.wantStored || len(got) != len(test.want) || (len(got) > 0 && !reflect.DeepEqual(got, test.want)) {
+			t.Errorf("epoch %d: got (%v, %v, %v, %v)", test.epochStart, got, found, stored, err)
+		}
+	}
+	if _, _, _, err := ReadFBAWeights(FailingDBMock{}, 100); err != errRoundsTestDB {
+		t.Errorf("got %v want %v", err, errRoundsTestDB)
+	}
+}
+
+func TestUnpackFBAChains(t *testing.T) {
+	word := func(n uint64) []byte { return common.BigToHash(new(big.Int).SetUint64(n)).Bytes() }
+	ftso := common.HexToAddress("0x1000000000000000000000000000000000000007")
+	data := append(word(3*common.HashLength), word(5*common.HashLength)...)
+	data = append(data, word(7*common.HashLength)...)
+	data = append(data, word(1)...)
+	data = append(data, word(2)...) // chain IDs
+	data = append(data, word(1)...)
+	data = append(data, common.LeftPadBytes(ftso.Bytes(), common.HashLength)...) // FTSOs
+	data = append(data, word(1)...)
+	data = append(data, word(21000000)...) // supplies
+	chains, err := unpackFBAChains(data)
+	if err != nil || len(chains) != 1 || chains[0].ChainID.Uint64() != 2 || chains[0].FTSO != ftso || chains[0].Supply.Uint64() != 21000000 {
+		t.Errorf("got (%v, %v)", chains, err)
+	}
+	if _, err := unpackFBAChains(data[:len(data)-1]); err != errMalformedFBARegistry {
+		t.Errorf("truncated: got error %v", err)
+	}
+}
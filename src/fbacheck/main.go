@@ -0,0 +1,82 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+// fbacheck checks that the quorum slices declared in the FBA validator files of a set of nodes
+// intersect, so that no two groups of nodes can each form a quorum under the declared
+// thresholds. Consensus does not enforce the thresholds, the check tells whether the trust the
+// nodes declare is consistent. Every node is given as NodeID-...=path/to/its/FBA_VALs/file.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/ava-labs/avalanchego/ids"
+	"github.com/ava-labs/avalanchego/snow/validators"
+	"github.com/ava-labs/avalanchego/utils/constants"
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s NodeID-...=fba.json [NodeID-...=fba.json ...]\n", os.Args[0])
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := run(flag.Args()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Printf("every two quorums of the %d nodes intersect\n", flag.NArg())
+}
+
+func run(args []string) error {
+	nodes := make(map[ids.ShortID][]validators.QuorumSlice, len(args))
+	for _, arg := range args {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("%q is not of the form NodeID-...=fba.json", arg)
+		}
+		nodeID, err := ids.ShortFromPrefixedString(parts[0], constants.NodeIDPrefix)
+		if err != nil {
+			return fmt.Errorf("invalid node ID %q: %w", parts[0], err)
+		}
+		if _, ok := nodes[nodeID]; ok {
+			return fmt.Errorf("%s is given twice", parts[0])
+		}
+		b, err := ioutil.ReadFile(parts[1])
+		if err != nil {
+			return err
+		}
+		slices, err := validators.ParseFBAQuorumSlices(b)
+		if err != nil {
+			return fmt.Errorf("invalid quorum slices in %s: %w", parts[1], err)
+		}
+		if len(slices) == 0 {
+			return fmt.Errorf("%s declares no quorum slices", parts[1])
+		}
+		nodes[nodeID] = slices
+	}
+
+	a, b, err := validators.FindDisjointFBAQuorums(nodes)
+	if err != nil {
+		return err
+	}
+	if a != nil {
+		return fmt.Errorf("quorums %s and %s do not intersect", formatNodeIDs(a), formatNodeIDs(b))
+	}
+	return nil
+}
+
+func formatNodeIDs(nodeIDs []ids.ShortID) string {
+	strs := make([]string, len(nodeIDs))
+	for i, nodeID := range nodeIDs {
+		strs[i] = nodeID.PrefixedString(constants.NodeIDPrefix)
+	}
+	return "{" + strings.Join(strs, ", ") + "}"
+}
@@ -0,0 +1,182 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Sources of the default attestor set
+const (
+	AttestorSourceFTSO    = "ftso"    // the whitelisted FTSO price providers
+	AttestorSourceGenesis = "genesis" // the attestors listed in the flare config
+)
+
+var (
+	errUnknownAttestorSource = errors.New("attestorSource must be \"ftso\" or \"genesis\"")
+	errNoGenesisAttestors    = errors.New("attestorSource \"genesis\" requires at least one attestor")
+	errBuiltinChainOverride  = errors.New("the flare config of Flare and Songbird is built into the node and may only be restated by the genesis")
+)
+
+// FlareChainConfig is the `flare` section of the `config` block of a C-chain genesis. It
+// defines the Flare parameters of a chain that is not built into the node:
+//
+//	"flare": {
+//		"testingChain": false,
+//		"stateConnectorContract": "0x1000000000000000000000000000000000000001",
+//		"stateConnectorActivationTime": 1636070400,
+//		"flareDaemonContract": "0x1000000000000000000000000000000000000002",
+//		"maximumMintRequest": 50000000000000000000000000,
+//		"attestorSource": "genesis",
+//		"attestors": ["0xff57CaF5B871db64F2a7F4C5bc2d17A5E666F7E8"],
+//		"forks": [...]
+//	}
+//
+// Every field is optional. The contract addresses and mint cap set the genesis fork of [Forks],
+// which defaults to the schedule of a testing chain. Flare and Songbird keep the parameters
+// built into the node, their genesis may carry a flare section only if it matches them.
+type FlareChainConfig struct {
+	TestingChain                 bool              `json:"testingChain"`
+	StateConnectorContract       *common.Address   `json:"stateConnectorContract,omitempty"`
+	StateConnectorActivationTime *uint64           `json:"stateConnectorActivationTime,omitempty"`
+	FlareDaemonContract          *common.Address   `json:"flareDaemonContract,omitempty"`
+	MaximumMintRequest           *big.Int          `json:"maximumMintRequest,omitempty"`
+	AttestorSource               string            `json:"attestorSource,omitempty"`
+	Attestors                    []common.Address  `json:"attestors,omitempty"`
+	Forks                        FlareForkSchedule `json:"forks,omitempty"`
+}
+
+var (
+	flareChainConfigsLock sync.RWMutex
+	flareChainConfigs     = make(map[uint64]*FlareChainConfig)
+)
+
+// ParseFlareChainConfig returns the flare section of the C-chain genesis [genesisBytes], or nil
+// if the genesis has none
+func ParseFlareChainConfig(genesisBytes []byte) (*FlareChainConfig, error) {
+	var genesis struct {
+		Config struct {
+			Flare *FlareChainConfig `json:"flare"`
+		} `json:"config"`
+	}
+	if err := json.Unmarshal(genesisBytes, &genesis); err != nil {
+		return nil, err
+	}
+	return genesis.Config.Flare, nil
+}
+
+// schedule returns [Forks], or the testing schedule, with the genesis fork overridden by the
+// top level parameters
+func (c *FlareChainConfig) schedule() FlareForkSchedule {
+	schedule := c.Forks
+	if len(schedule) == 0 {
+		schedule = testingForkSchedule
+	}
+	schedule = append(FlareForkSchedule{}, schedule...)
+	genesis := &schedule[0].FlareForkParams
+	if c.StateConnectorContract != nil {
+		genesis.StateConnectorContract = c.StateConnectorContract
+	}
+	if c.FlareDaemonContract != nil {
+		genesis.FlareDaemonContract = c.FlareDaemonContract
+	}
+	if c.MaximumMintRequest != nil {
+		genesis.MaximumMintRequest = c.MaximumMintRequest
+	}
+	return schedule
+}
+
+// Validate checks the attestor source and the resulting fork schedule
+func (c *FlareChainConfig) Validate() error {
+	switch c.AttestorSource {
+	case "", AttestorSourceFTSO:
+	case AttestorSourceGenesis:
+		if len(c.Attestors) == 0 {
+			return errNoGenesisAttestors
+		}
+	default:
+		return errUnknownAttestorSource
+	}
+	return c.schedule().Validate()
+}
+
+// overridesBuiltinChain returns whether [c] differs from the parameters built into the node
+// for chain [chainID], which only Flare and Songbird have
+func (c *FlareChainConfig) overridesBuiltinChain(chainID *big.Int) (bool, error) {
+	var (
+		schedule       FlareForkSchedule
+		activationTime *big.Int
+	)
+	switch {
+	case chainID.Cmp(flareChainID) == 0:
+		schedule, activationTime = flareForkSchedule, flareStateConnectorActivationTime
+	case chainID.Cmp(songbirdChainID) == 0:
+		schedule, activationTime = songbirdForkSchedule, songbirdStateConnectorActivationTime
+	default:
+		return false, nil
+	}
+	if c.TestingChain || c.AttestorSource == AttestorSourceGenesis || len(c.Attestors) != 0 ||
+		(c.StateConnectorActivationTime != nil && new(big.Int).SetUint64(*c.StateConnectorActivationTime).Cmp(activationTime) != 0) {
+		return true, nil
+	}
+	// Compare the encodings, the schedules hold pointers
+	got, err := json.Marshal(c.schedule())
+	if err != nil {
+		return false, err
+	}
+	want, err := json.Marshal(schedule)
+	if err != nil {
+		return false, err
+	}
+	return string(got) != string(want), nil
+}
+
+// SetFlareChainConfig validates [config] and makes it the configuration of chain [chainID],
+// in place of the one built into the node. It fails for a config that changes the parameters
+// of Flare or Songbird.
+func SetFlareChainConfig(chainID *big.Int, config *FlareChainConfig) error {
+	if !chainID.IsUint64() {
+		return errors.New("chain ID does not fit in 64 bits")
+	}
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	if overrides, err := config.overridesBuiltinChain(chainID); err != nil {
+		return err
+	} else if overrides {
+		return errBuiltinChainOverride
+	}
+	cpy := *config
+	cpy.Forks = config.schedule()
+	flareChainConfigsLock.Lock()
+	flareChainConfigs[chainID.Uint64()] = &cpy
+	flareChainConfigsLock.Unlock()
+	return nil
+}
+
+// GetFlareChainConfig returns the configuration set for chain [chainID], or nil if the chain
+// uses the one built into the node
+func GetFlareChainConfig(chainID *big.Int) *FlareChainConfig {
+	if chainID == nil || !chainID.IsUint64() {
+		return nil
+	}
+	flareChainConfigsLock.RLock()
+	defer flareChainConfigsLock.RUnlock()
+	return flareChainConfigs[chainID.Uint64()]
+}
+
+// GetGenesisAttestors returns the attestors listed in the flare config of chain [chainID] if
+// they are its attestor source
+func GetGenesisAttestors(chainID *big.Int) ([]common.Address, bool) {
+	config := GetFlareChainConfig(chainID)
+	if config == nil || config.AttestorSource != AttestorSourceGenesis {
+		return nil, false
+	}
+	return config.Attestors, true
+}
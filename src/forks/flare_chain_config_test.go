@@ -0,0 +1,147 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package core
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestParseFlareChainConfig(t *testing.T) {
+	config, err := ParseFlareChainConfig([]byte(`{"config": {"chainId": 1}}`))
+	if err != nil || config != nil {
+		t.Fatalf("genesis without flare section: got (%v, %v)", config, err)
+	}
+	config, err = ParseFlareChainConfig([]byte(`{"config": {"chainId": 1, "flare": {
+		"testingChain": true,
+		"stateConnectorContract": "0x1000000000000000000000000000000000000011",
+		"stateConnectorActivationTime": 500,
+		"maximumMintRequest": 1000,
+		"attestorSource": "genesis",
+		"attestors": ["0xff57CaF5B871db64F2a7F4C5bc2d17A5E666F7E8"]
+	}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !config.TestingChain || *config.StateConnectorActivationTime != 500 || config.MaximumMintRequest.Int64() != 1000 || len(config.Attestors) != 1 {
+		t.Errorf("unexpected config %+v", config)
+	}
+	if err := config.Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFlareChainConfigValidate(t *testing.T) {
+	zero := common.Address{}
+	tests := []struct {
+		name    string
+		config  FlareChainConfig
+		wantErr string
+	}{
+		{"unknown attestor source", FlareChainConfig{AttestorSource: "oracle"}, "attestorSource"},
+		{"genesis source without attestors", FlareChainConfig{AttestorSource: AttestorSourceGenesis}, "at least one attestor"},
+		{"zero contract", FlareChainConfig{StateConnectorContract: &zero}, "stateConnectorContract"},
+		{"negative mint", FlareChainConfig{MaximumMintRequest: big.NewInt(-1)}, "maximumMintRequest"},
+	}
+	for _, test := range tests {
+		err := test.config.Validate()
+		if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("%s: got %v want error containing %q", test.name, err, test.wantErr)
+		}
+	}
+}
+
+func TestSetFlareChainConfig(t *testing.T) {
+	chainID := big.NewInt(987654321)
+	defer func() {
+		flareChainConfigsLock.Lock()
+		delete(flareChainConfigs, chainID.Uint64())
+		flareChainConfigsLock.Unlock()
+	}()
+	var (
+		contract       = common.HexToAddress("0x1000000000000000000000000000000000000011")
+		activationTime = uint64(500)
+		attestor       = common.HexToAddress("0xff57CaF5B871db64F2a7F4C5bc2d17A5E666F7E8")
+	)
+	config := &FlareChainConfig{
+		StateConnectorContract:       &contract,
+		StateConnectorActivationTime: &activationTime,
+		MaximumMintRequest:           big.NewInt(1000),
+		AttestorSource:               AttestorSourceGenesis,
+		Attestors:                    []common.Address{attestor},
+	}
+	if err := SetFlareChainConfig(chainID, config); err != nil {
+		t.Fatal(err)
+	}
+	if config.Forks != nil {
+		t.Errorf("caller's config was modified")
+	}
+	if GetTestingChain(chainID) {
+		t.Errorf("chain is a testing chain")
+	}
+	if GetStateConnectorActivated(chainID, big.NewInt(499)) || !GetStateConnectorActivated(chainID, big.NewInt(500)) {
+		t.Errorf("state connector not activated at the configured time")
+	}
+	params := GetFlareParams(chainID, big.NewInt(0), big.NewInt(0))
+	if params.StateConnectorContract != contract || params.MaximumMintRequest.Int64() != 1000 {
+		t.Errorf("genesis fork not overridden: %+v", params)
+	}
+	if params.FlareDaemonContract != *testingForkSchedule[0].FlareDaemonContract {
+		t.Errorf("unset parameter not taken from the testing schedule: %+v", params)
+	}
+	if *testingForkSchedule[0].StateConnectorContract == contract {
+		t.Errorf("testing schedule was modified")
+	}
+	if attestors, ok := GetGenesisAttestors(chainID); !ok || len(attestors) != 1 || attestors[0] != attestor {
+		t.Errorf("got genesis attestors (%v, %v)", attestors, ok)
+	}
+}
+
+func TestSetFlareChainConfigRejectsBuiltinOverrides(t *testing.T) {
+	defer func() {
+		flareChainConfigsLock.Lock()
+		delete(flareChainConfigs, flareChainID.Uint64())
+		delete(flareChainConfigs, songbirdChainID.Uint64())
+		flareChainConfigsLock.Unlock()
+	}()
+	restated := `{
+		"stateConnectorContract": "0x1000000000000000000000000000000000000001",
+		"stateConnectorActivationTime": 1000000000000,
+		"flareDaemonContract": "0x1000000000000000000000000000000000000002",
+		"maximumMintRequest": 50000000000000000000000000,
+		"attestorSource": "ftso"
+	}`
+	tests := []struct {
+		name    string
+		chainID *big.Int
+		flare   string
+		wantErr bool
+	}{
+		{"songbird restated", songbirdChainID, restated, false},
+		{"flare restated", flareChainID, restated, false},
+		{"empty section", songbirdChainID, `{}`, false},
+		{"testing chain", songbirdChainID, `{"testingChain": true}`, true},
+		{"other contract", flareChainID, `{"stateConnectorContract": "0x1000000000000000000000000000000000000011"}`, true},
+		{"other activation time", songbirdChainID, `{"stateConnectorActivationTime": 0}`, true},
+		{"other mint cap", songbirdChainID, `{"maximumMintRequest": 1}`, true},
+		{"genesis attestors", flareChainID, `{"attestorSource": "genesis", "attestors": ["0xff57CaF5B871db64F2a7F4C5bc2d17A5E666F7E8"]}`, true},
+		{"other chain", big.NewInt(987654346), `{"testingChain": true, "maximumMintRequest": 1}`, false},
+	}
+	for _, test := range tests {
+		config, err := ParseFlareChainConfig([]byte(`{"config": {"chainId": 1, "flare": ` + test.flare + `}}`))
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		err = SetFlareChainConfig(test.chainID, config)
+		if (err != nil) != test.wantErr || (err != nil && err != errBuiltinChainOverride) {
+			t.Errorf("%s: got %v want error %v", test.name, err, test.wantErr)
+		}
+	}
+	flareChainConfigsLock.Lock()
+	delete(flareChainConfigs, 987654346)
+	flareChainConfigsLock.Unlock()
+}
@@ -0,0 +1,388 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// Bounds of mintEpochSeconds. A rolling year is kept as one bucket per epoch, so epochs
+// shorter than an hour would make the mint ledger too large.
+const (
+	minMintEpochSeconds = 60 * 60
+	mintYearSeconds     = 365 * 24 * 60 * 60
+)
+
+var (
+	errEmptyForkSchedule    = errors.New("fork schedule has no forks")
+	errGenesisForkNotFirst  = errors.New("first fork must activate at block 0")
+	errMintCapsWithoutEpoch = errors.New("maximumMintPerEpoch and maximumMintPerYear need mintEpochSeconds to be set")
+	errFBAWeightsByTime     = errors.New("fbaContributionsContract and fbaWeightEpochBlocks can only be set by a block fork")
+
+	// Majorities of the attestations until a fork sets attestationMajority or
+	// weightedAttestationMajority
+	defaultAttestationMajority         = AttestationMajorityRule{Numerator: 1, Denominator: 2}
+	defaultWeightedAttestationMajority = AttestationMajorityRule{Numerator: 2, Denominator: 3}
+)
+
+// FlareForkParams holds the parameters that a fork changes. Unset fields keep the value of the
+// previous fork. The genesis fork has to set the StateConnector, FlareDaemon and prioritised
+// FTSO contracts, both selectors, flareDaemonGasMultiplier and maximumMintRequest. Every other
+// field is optional, see validateParams.
+// A fork that sets systemHooks replaces the whole list, an empty list removes every hook.
+type FlareForkParams struct {
+	StateConnectorContract      *common.Address          `json:"stateConnectorContract,omitempty"`
+	SubmitAttestationSelector   hexutil.Bytes            `json:"submitAttestationSelector,omitempty"`
+	FlareDaemonContract         *common.Address          `json:"flareDaemonContract,omitempty"`
+	FlareDaemonSelector         hexutil.Bytes            `json:"flareDaemonSelector,omitempty"`
+	FlareDaemonGasMultiplier    *uint64                  `json:"flareDaemonGasMultiplier,omitempty"`
+	MaximumMintRequest          *big.Int                 `json:"maximumMintRequest,omitempty"`
+	PrioritisedFTSOContract     *common.Address          `json:"prioritisedFTSOContract,omitempty"`
+	FBARegistryContract         *common.Address          `json:"fbaRegistryContract,omitempty"`
+	FBAContributionsContract    *common.Address          `json:"fbaContributionsContract,omitempty"`
+	FBAWeightEpochBlocks        *uint64                  `json:"fbaWeightEpochBlocks,omitempty"`
+	MintEpochSeconds            *uint64                  `json:"mintEpochSeconds,omitempty"`
+	MaximumMintPerEpoch         *big.Int                 `json:"maximumMintPerEpoch,omitempty"`
+	MaximumMintPerYear          *big.Int                 `json:"maximumMintPerYear,omitempty"`
+	FlareDaemonPerBlock         *bool                    `json:"flareDaemonPerBlock,omitempty"`
+	SystemCallGasBudget         *uint64                  `json:"systemCallGasBudget,omitempty"`
+	SystemHooks                 []SystemHook             `json:"systemHooks,omitempty"`
+	MaxConsecutiveDivergences   *uint64                  `json:"maxConsecutiveDivergences,omitempty"`
+	MaxConsecutiveAbstentions   *uint64                  `json:"maxConsecutiveAbstentions,omitempty"`
+	StateConnectorProofVerifier *bool                    `json:"stateConnectorProofVerifier,omitempty"`
+	AttestationMajority         *AttestationMajorityRule `json:"attestationMajority,omitempty"`
+	WeightedAttestations        *bool                    `json:"weightedAttestations,omitempty"`
+	WeightedAttestationMajority *AttestationMajorityRule `json:"weightedAttestationMajority,omitempty"`
+}
+
+// FlareFork activates a set of parameters at a block number or at a block timestamp
+type FlareFork struct {
+	Name  string  `json:"name"`
+	Block *uint64 `json:"block,omitempty"`
+	Time  *uint64 `json:"time,omitempty"`
+	FlareForkParams
+}
+
+func (f *FlareFork) activated(blockNumber *big.Int, blockTime *big.Int) bool {
+	if f.Block != nil {
+		return blockNumber != nil && blockNumber.Cmp(new(big.Int).SetUint64(*f.Block)) >= 0
+	}
+	return blockTime != nil && blockTime.Cmp(new(big.Int).SetUint64(*f.Time)) >= 0
+}
+
+// FlareForkSchedule lists the forks of one chain. Activated forks are applied in list order.
+type FlareForkSchedule []FlareFork
+
+// FlareParams are the parameters in effect at a block
+type FlareParams struct {
+	StateConnectorContract    common.Address `json:"stateConnectorContract"`
+	SubmitAttestationSelector hexutil.Bytes  `json:"submitAttestationSelector"`
+	FlareDaemonContract       common.Address `json:"flareDaemonContract"`
+	FlareDaemonSelector       hexutil.Bytes  `json:"flareDaemonSelector"`
+	FlareDaemonGasMultiplier  uint64         `json:"flareDaemonGasMultiplier"`
+	MaximumMintRequest        *big.Int       `json:"maximumMintRequest"`
+	PrioritisedFTSOContract   common.Address `json:"prioritisedFTSOContract"`
+	FBARegistryContract       common.Address `json:"fbaRegistryContract"`
+	FBAContributionsContract  common.Address `json:"fbaContributionsContract"`
+	FBAWeightEpochBlocks      uint64         `json:"fbaWeightEpochBlocks"`
+	// Block of the fork that last set fbaContributionsContract or fbaWeightEpochBlocks, the
+	// weight epochs are counted from it
+	FBAWeightEpochsFrom uint64 `json:"fbaWeightEpochsFrom"`
+	// The mint ledger is kept once MintEpochSeconds is set, nil caps do not limit minting
+	MintEpochSeconds    uint64   `json:"mintEpochSeconds"`
+	MaximumMintPerEpoch *big.Int `json:"maximumMintPerEpoch"`
+	MaximumMintPerYear  *big.Int `json:"maximumMintPerYear"`
+	// The flareDaemon is triggered once at the end of each block instead of after every
+	// successful transaction
+	FlareDaemonPerBlock bool `json:"flareDaemonPerBlock"`
+	// Gas that the system calls of each kind may use during a transaction or at the end of a
+	// block, zero while each system call gets the legacy gas of its kind
+	SystemCallGasBudget uint64 `json:"systemCallGasBudget"`
+	// Protocol contracts called by the chain, in the order they are called
+	SystemHooks []SystemHook `json:"systemHooks"`
+	// Rounds in a row in which an attestor may disagree with or abstain from the majority before
+	// the penalty ledger excludes it. Zero disables that exclusion, the ledger is only kept while
+	// one of them is set.
+	MaxConsecutiveDivergences uint64 `json:"maxConsecutiveDivergences"`
+	MaxConsecutiveAbstentions uint64 `json:"maxConsecutiveAbstentions"`
+	// The precompile that verifies attestation request proofs is activated, see
+	// RegisterStateConnectorProofVerifier
+	StateConnectorProofVerifier bool `json:"stateConnectorProofVerifier"`
+	// Share of the attestors that the plurality of a round counted per address has to exceed
+	AttestationMajority AttestationMajorityRule `json:"attestationMajority"`
+	// The default attestors vote with their FTSO vote power instead of one vote per address,
+	// and the plurality of their weight has to exceed WeightedAttestationMajority
+	WeightedAttestations        bool                    `json:"weightedAttestations"`
+	WeightedAttestationMajority AttestationMajorityRule `json:"weightedAttestationMajority"`
+}
+
+func (p *FlareParams) apply(f *FlareForkParams) {
+	if f.StateConnectorContract != nil {
+		p.StateConnectorContract = *f.StateConnectorContract
+	}
+	if f.SubmitAttestationSelector != nil {
+		p.SubmitAttestationSelector = common.CopyBytes(f.SubmitAttestationSelector)
+	}
+	if f.FlareDaemonContract != nil {
+		p.FlareDaemonContract = *f.FlareDaemonContract
+	}
+	if f.FlareDaemonSelector != nil {
+		p.FlareDaemonSelector = common.CopyBytes(f.FlareDaemonSelector)
+	}
+	if f.FlareDaemonGasMultiplier != nil {
+		p.FlareDaemonGasMultiplier = *f.FlareDaemonGasMultiplier
+	}
+	if f.MaximumMintRequest != nil {
+		p.MaximumMintRequest = new(big.Int).Set(f.MaximumMintRequest)
+	}
+	if f.PrioritisedFTSOContract != nil {
+		p.PrioritisedFTSOContract = *f.PrioritisedFTSOContract
+	}
+	if f.FBARegistryContract != nil {
+		p.FBARegistryContract = *f.FBARegistryContract
+	}
+	if f.FBAContributionsContract != nil {
+		p.FBAContributionsContract = *f.FBAContributionsContract
+	}
+	if f.FBAWeightEpochBlocks != nil {
+		p.FBAWeightEpochBlocks = *f.FBAWeightEpochBlocks
+	}
+	if f.MintEpochSeconds != nil {
+		p.MintEpochSeconds = *f.MintEpochSeconds
+	}
+	if f.MaximumMintPerEpoch != nil {
+		p.MaximumMintPerEpoch = new(big.Int).Set(f.MaximumMintPerEpoch)
+	}
+	if f.MaximumMintPerYear != nil {
+		p.MaximumMintPerYear = new(big.Int).Set(f.MaximumMintPerYear)
+	}
+	if f.FlareDaemonPerBlock != nil {
+		p.FlareDaemonPerBlock = *f.FlareDaemonPerBlock
+	}
+	if f.SystemCallGasBudget != nil {
+		p.SystemCallGasBudget = *f.SystemCallGasBudget
+	}
+	if f.SystemHooks != nil {
+		p.SystemHooks = make([]SystemHook, len(f.SystemHooks))
+		for i := range f.SystemHooks {
+			p.SystemHooks[i] = f.SystemHooks[i].copy()
+		}
+	}
+	if f.MaxConsecutiveDivergences != nil {
+		p.MaxConsecutiveDivergences = *f.MaxConsecutiveDivergences
+	}
+	if f.MaxConsecutiveAbstentions != nil {
+		p.MaxConsecutiveAbstentions = *f.MaxConsecutiveAbstentions
+	}
+	if f.StateConnectorProofVerifier != nil {
+		p.StateConnectorProofVerifier = *f.StateConnectorProofVerifier
+	}
+	if f.AttestationMajority != nil {
+		p.AttestationMajority = *f.AttestationMajority
+	}
+	if f.WeightedAttestations != nil {
+		p.WeightedAttestations = *f.WeightedAttestations
+	}
+	if f.WeightedAttestationMajority != nil {
+		p.WeightedAttestationMajority = *f.WeightedAttestationMajority
+	}
+}
+
+// ParamsAt returns the parameters in effect at [blockNumber] and [blockTime]. The returned
+// selectors, mint amounts and system hooks are copies, so the caller may modify them without
+// changing the schedule.
+func (s FlareForkSchedule) ParamsAt(blockNumber *big.Int, blockTime *big.Int) *FlareParams {
+	p := &FlareParams{
+		AttestationMajority:         defaultAttestationMajority,
+		WeightedAttestationMajority: defaultWeightedAttestationMajority,
+	}
+	for i := range s {
+		if i == 0 || s[i].activated(blockNumber, blockTime) {
+			p.apply(&s[i].FlareForkParams)
+			if s[i].Block != nil && (s[i].FBAContributionsContract != nil || s[i].FBAWeightEpochBlocks != nil) {
+				p.FBAWeightEpochsFrom = *s[i].Block
+			}
+		}
+	}
+	return p
+}
+
+// Validate checks that the genesis fork sets every required parameter, that every fork has a
+// unique name and exactly one activation, that activations of the same kind increase down the
+// list, that every value set is usable, that mint caps only apply together with a mint epoch
+// and that the FBA weight epochs are set by block forks
+func (s FlareForkSchedule) Validate() error {
+	if len(s) == 0 {
+		return errEmptyForkSchedule
+	}
+	if s[0].Block == nil || *s[0].Block != 0 {
+		return errGenesisForkNotFirst
+	}
+	names := make(map[string]bool)
+	var lastBlock, lastTime *uint64
+	applied := &FlareParams{}
+	for i := range s {
+		f := &s[i]
+		switch {
+		case f.Name == "":
+			return fmt.Errorf("fork %d has no name", i)
+		case names[f.Name]:
+			return fmt.Errorf("fork %q is defined twice", f.Name)
+		case (f.Block == nil) == (f.Time == nil):
+			return fmt.Errorf("fork %q must set exactly one of block and time", f.Name)
+		case i > 0 && f.Block != nil && *f.Block <= *lastBlock:
+			return fmt.Errorf("fork %q activates at block %d, not after the previous block fork", f.Name, *f.Block)
+		case f.Time != nil && lastTime != nil && *f.Time <= *lastTime:
+			return fmt.Errorf("fork %q activates at time %d, not after the previous time fork", f.Name, *f.Time)
+		}
+		names[f.Name] = true
+		if f.Block != nil {
+			lastBlock = f.Block
+		} else {
+			lastTime = f.Time
+		}
+		if err := f.validateParams(i == 0); err != nil {
+			return fmt.Errorf("fork %q: %w", f.Name, err)
+		}
+		// Weight epochs are counted in blocks from the fork that sets them
+		if f.Time != nil && (f.FBAContributionsContract != nil || f.FBAWeightEpochBlocks != nil) {
+			return fmt.Errorf("fork %q: %w", f.Name, errFBAWeightsByTime)
+		}
+		applied.apply(&f.FlareForkParams)
+		if applied.MintEpochSeconds == 0 && (applied.MaximumMintPerEpoch != nil || applied.MaximumMintPerYear != nil) {
+			return fmt.Errorf("fork %q: %w", f.Name, errMintCapsWithoutEpoch)
+		}
+	}
+	return nil
+}
+
+func (f *FlareForkParams) validateParams(genesis bool) error {
+	for name, addr := range map[string]*common.Address{
+		"stateConnectorContract":  f.StateConnectorContract,
+		"flareDaemonContract":     f.FlareDaemonContract,
+		"prioritisedFTSOContract": f.PrioritisedFTSOContract,
+	} {
+		if (addr == nil && genesis) || (addr != nil && *addr == (common.Address{})) {
+			return fmt.Errorf("%s must be set to a nonzero address", name)
+		}
+	}
+	for name, selector := range map[string]hexutil.Bytes{
+		"submitAttestationSelector": f.SubmitAttestationSelector,
+		"flareDaemonSelector":       f.FlareDaemonSelector,
+	} {
+		if (selector == nil && genesis) || (selector != nil && len(selector) != 4) {
+			return fmt.Errorf("%s must be set to 4 bytes", name)
+		}
+	}
+	if (f.FlareDaemonGasMultiplier == nil && genesis) || (f.FlareDaemonGasMultiplier != nil && *f.FlareDaemonGasMultiplier == 0) {
+		return errors.New("flareDaemonGasMultiplier must be set to a positive value")
+	}
+	if (f.MaximumMintRequest == nil && genesis) || (f.MaximumMintRequest != nil && f.MaximumMintRequest.Sign() < 0) {
+		return errors.New("maximumMintRequest must be set to a non-negative value")
+	}
+	// A chain may run without an FBA registry or weighting, so the genesis fork does not have
+	// to set them
+	if f.FBARegistryContract != nil && *f.FBARegistryContract == (common.Address{}) {
+		return errors.New("fbaRegistryContract must be a nonzero address if set")
+	}
+	if f.FBAContributionsContract != nil && *f.FBAContributionsContract == (common.Address{}) {
+		return errors.New("fbaContributionsContract must be a nonzero address if set")
+	}
+	if f.FBAWeightEpochBlocks != nil && *f.FBAWeightEpochBlocks == 0 {
+		return errors.New("fbaWeightEpochBlocks must be positive if set")
+	}
+	if f.SystemCallGasBudget != nil && *f.SystemCallGasBudget == 0 {
+		return errors.New("systemCallGasBudget must be positive if set")
+	}
+	if f.AttestationMajority != nil {
+		if err := f.AttestationMajority.validate(); err != nil {
+			return fmt.Errorf("attestationMajority: %w", err)
+		}
+	}
+	if f.WeightedAttestationMajority != nil {
+		if err := f.WeightedAttestationMajority.validate(); err != nil {
+			return fmt.Errorf("weightedAttestationMajority: %w", err)
+		}
+	}
+	if err := validateSystemHooks(f.SystemHooks); err != nil {
+		return fmt.Errorf("systemHooks: %w", err)
+	}
+	if f.MintEpochSeconds != nil && (*f.MintEpochSeconds < minMintEpochSeconds || *f.MintEpochSeconds > mintYearSeconds) {
+		return fmt.Errorf("mintEpochSeconds must be between %d and %d if set", minMintEpochSeconds, mintYearSeconds)
+	}
+	for name, limit := range map[string]*big.Int{
+		"maximumMintPerEpoch": f.MaximumMintPerEpoch,
+		"maximumMintPerYear":  f.MaximumMintPerYear,
+	} {
+		if limit != nil && limit.Sign() < 0 {
+			return fmt.Errorf("%s must be non-negative if set", name)
+		}
+	}
+	return nil
+}
+
+func genesisFlareFork() FlareFork {
+	var (
+		genesisBlock             = uint64(0)
+		stateConnectorContract   = common.HexToAddress("0x1000000000000000000000000000000000000001")
+		flareDaemonContract      = common.HexToAddress("0x1000000000000000000000000000000000000002")
+		prioritisedFTSOContract  = common.HexToAddress("0x1000000000000000000000000000000000000003")
+		flareDaemonGasMultiplier = uint64(100)
+		maximumMintRequest, _    = new(big.Int).SetString("50000000000000000000000000", 10)
+	)
+	return FlareFork{
+		Name:  "genesis",
+		Block: &genesisBlock,
+		FlareForkParams: FlareForkParams{
+			StateConnectorContract:    &stateConnectorContract,
+			SubmitAttestationSelector: hexutil.Bytes{0xcf, 0xd1, 0xfd, 0xad},
+			FlareDaemonContract:       &flareDaemonContract,
+			FlareDaemonSelector:       hexutil.Bytes{0x7f, 0xec, 0x8d, 0x38},
+			FlareDaemonGasMultiplier:  &flareDaemonGasMultiplier,
+			MaximumMintRequest:        maximumMintRequest,
+			PrioritisedFTSOContract:   &prioritisedFTSOContract,
+		},
+	}
+}
+
+// Fork schedules of the public networks. Testing chains, any other chain ID, use their own.
+// They all start from the same genesis fork, the forks that follow are specific to a network.
+var (
+	sharedGenesisFork = genesisFlareFork()
+
+	flareForkSchedule    = FlareForkSchedule{sharedGenesisFork}
+	songbirdForkSchedule = FlareForkSchedule{sharedGenesisFork}
+	testingForkSchedule  = FlareForkSchedule{sharedGenesisFork}
+)
+
+// GetFlareForkSchedule returns the fork schedule of chain [chainID], from its genesis flare
+// config if it has one
+func GetFlareForkSchedule(chainID *big.Int) FlareForkSchedule {
+	if config := GetFlareChainConfig(chainID); config != nil {
+		return config.Forks
+	}
+	switch {
+	case chainID != nil && chainID.Cmp(flareChainID) == 0:
+		return flareForkSchedule
+	case chainID != nil && chainID.Cmp(songbirdChainID) == 0:
+		return songbirdForkSchedule
+	default:
+		return testingForkSchedule
+	}
+}
+
+// ValidateFlareForkSchedule checks the fork schedule of chain [chainID], it is called when the
+// VM starts so that a malformed schedule stops the node before it processes any block
+func ValidateFlareForkSchedule(chainID *big.Int) error {
+	return GetFlareForkSchedule(chainID).Validate()
+}
+
+func GetFlareParams(chainID *big.Int, blockNumber *big.Int, blockTime *big.Int) *FlareParams {
+	return GetFlareForkSchedule(chainID).ParamsAt(blockNumber, blockTime)
+}
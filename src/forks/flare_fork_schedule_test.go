@@ -0,0 +1,209 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package core
+
+import (
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestBuiltinFlareForkSchedulesAreValid(t *testing.T) {
+	for _, chainID := range []*big.Int{flareChainID, songbirdChainID, big.NewInt(16), nil} {
+		if err := ValidateFlareForkSchedule(chainID); err != nil {
+			t.Errorf("chain %v: %v", chainID, err)
+		}
+	}
+}
+
+func TestFlareForkScheduleParamsAt(t *testing.T) {
+	var schedule FlareForkSchedule
+	err := json.Unmarshal([]byte(`[
+		{
+			"name": "genesis", "block": 0,
+			"stateConnectorContract": "0x1000000000000000000000000000000000000001",
+			"submitAttestationSelector": "0xcfd1fdad",
+			"flareDaemonContract": "0x1000000000000000000000000000000000000002",
+			"flareDaemonSelector": "0x7fec8d38",
+			"flareDaemonGasMultiplier": 100,
+			"maximumMintRequest": 1000,
+			"prioritisedFTSOContract": "0x1000000000000000000000000000000000000003"
+		},
+		{"name": "mintIncrease", "block": 10, "maximumMintRequest": 2000},
+		{"name": "newDaemon", "time": 5000, "flareDaemonContract": "0x1000000000000000000000000000000000000004", "flareDaemonGasMultiplier": 50}
+	]`), &schedule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := schedule.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		blockNumber    int64
+		blockTime      int64
+		wantMint       int64
+		wantDaemon     common.Address
+		wantMultiplier uint64
+	}{
+		{0, 0, 1000, common.HexToAddress("0x1000000000000000000000000000000000000002"), 100},
+		{10, 4999, 2000, common.HexToAddress("0x1000000000000000000000000000000000000002"), 100},
+		{9, 5000, 1000, common.HexToAddress("0x1000000000000000000000000000000000000004"), 50},
+		{10, 5000, 2000, common.HexToAddress("0x1000000000000000000000000000000000000004"), 50},
+	}
+	for _, test := range tests {
+		p := schedule.ParamsAt(big.NewInt(test.blockNumber), big.NewInt(test.blockTime))
+		if p.MaximumMintRequest.Int64() != test.wantMint || p.FlareDaemonContract != test.wantDaemon || p.FlareDaemonGasMultiplier != test.wantMultiplier {
+			t.Errorf("block %d at %d: got %+v", test.blockNumber, test.blockTime, p)
+		}
+		if p.StateConnectorContract != common.HexToAddress("0x1000000000000000000000000000000000000001") || len(p.SubmitAttestationSelector) != 4 {
+			t.Errorf("block %d at %d: genesis parameters not carried forward", test.blockNumber, test.blockTime)
+		}
+	}
+}
+
+func TestFlareForkScheduleAttestationMajorities(t *testing.T) {
+	var schedule FlareForkSchedule
+	err := json.Unmarshal([]byte(`[
+		{"name": "weighted", "block": 10, "weightedAttestations": true},
+		{"name": "majority", "block": 20, "weightedAttestationMajority": {"numerator": 3, "denominator": 4, "ofParticipating": true}},
+		{"name": "participating", "block": 30, "attestationMajority": {"numerator": 1, "denominator": 2, "ofParticipating": true}}
+	]`), &schedule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schedule = append(FlareForkSchedule{genesisFlareFork()}, schedule...)
+	if err := schedule.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	weightedRule := AttestationMajorityRule{Numerator: 3, Denominator: 4, OfParticipating: true}
+	tests := []struct {
+		blockNumber      int64
+		wantRule         AttestationMajorityRule
+		wantWeighted     bool
+		wantWeightedRule AttestationMajorityRule
+	}{
+		{9, defaultAttestationMajority, false, defaultWeightedAttestationMajority},
+		{10, defaultAttestationMajority, true, defaultWeightedAttestationMajority},
+		{20, defaultAttestationMajority, true, weightedRule},
+		{30, AttestationMajorityRule{Numerator: 1, Denominator: 2, OfParticipating: true}, true, weightedRule},
+	}
+	for _, test := range tests {
+		p := schedule.ParamsAt(big.NewInt(test.blockNumber), big.NewInt(0))
+		if p.AttestationMajority != test.wantRule || p.WeightedAttestations != test.wantWeighted || p.WeightedAttestationMajority != test.wantWeightedRule {
+			t.Errorf("block %d: got %+v, %v, %+v", test.blockNumber, p.AttestationMajority, p.WeightedAttestations, p.WeightedAttestationMajority)
+		}
+	}
+}
+
+func TestFlareForkScheduleParamsAtReturnsCopies(t *testing.T) {
+	onContract := common.Address{0xaa}
+	genesis := genesisFlareFork()
+	genesis.MaximumMintPerEpoch = big.NewInt(100)
+	genesis.MaximumMintPerYear = big.NewInt(1000)
+	genesis.SystemHooks = []SystemHook{{Name: "h", Contract: common.Address{1}, Selector: []byte{1, 2, 3, 4}, Trigger: SystemHookOnSelector, OnContract: &onContract, OnSelector: []byte{5, 6, 7, 8}, Gas: 1}}
+	schedule := FlareForkSchedule{genesis}
+
+	p := schedule.ParamsAt(big.NewInt(0), big.NewInt(0))
+	p.MaximumMintRequest.SetInt64(1)
+	p.MaximumMintPerEpoch.SetInt64(1)
+	p.MaximumMintPerYear.SetInt64(1)
+	p.SubmitAttestationSelector[0] = 0
+	p.FlareDaemonSelector[0] = 0
+	p.SystemHooks[0].Selector[0] = 0
+	p.SystemHooks[0].OnSelector[0] = 0
+	*p.SystemHooks[0].OnContract = common.Address{}
+	p.SystemHooks[0].Name = "changed"
+
+	if got, want := schedule.ParamsAt(big.NewInt(0), big.NewInt(0)), genesisFlareFork(); got.MaximumMintRequest.Cmp(want.MaximumMintRequest) != 0 ||
+		got.SubmitAttestationSelector[0] != want.SubmitAttestationSelector[0] || got.FlareDaemonSelector[0] != want.FlareDaemonSelector[0] {
+		t.Errorf("genesis parameters modified through ParamsAt: %+v", got)
+	}
+	if genesis.MaximumMintPerEpoch.Int64() != 100 || genesis.MaximumMintPerYear.Int64() != 1000 {
+		t.Errorf("mint caps modified through ParamsAt: %s, %s", genesis.MaximumMintPerEpoch, genesis.MaximumMintPerYear)
+	}
+	if hook := genesis.SystemHooks[0]; hook.Name != "h" || hook.Selector[0] != 1 || hook.OnSelector[0] != 5 || *hook.OnContract != onContract {
+		t.Errorf("system hook modified through ParamsAt: %+v", hook)
+	}
+}
+
+func TestFlareForkScheduleValidate(t *testing.T) {
+	block := func(n uint64) *uint64 { return &n }
+	zero := common.Address{}
+	tests := []struct {
+		name     string
+		schedule func(FlareForkSchedule) FlareForkSchedule
+		wantErr  string
+	}{
+		{"empty", func(FlareForkSchedule) FlareForkSchedule { return nil }, "no forks"},
+		{"genesis not at block 0", func(s FlareForkSchedule) FlareForkSchedule { s[0].Block = block(1); return s }, "block 0"},
+		{"genesis missing a parameter", func(s FlareForkSchedule) FlareForkSchedule { s[0].FlareDaemonSelector = nil; return s }, "flareDaemonSelector"},
+		{"duplicate name", func(s FlareForkSchedule) FlareForkSchedule {
+			return append(s, FlareFork{Name: "genesis", Block: block(5)})
+		}, "defined twice"},
+		{"both activations", func(s FlareForkSchedule) FlareForkSchedule {
+			return append(s, FlareFork{Name: "a", Block: block(5), Time: block(5)})
+		}, "exactly one"},
+		{"blocks out of order", func(s FlareForkSchedule) FlareForkSchedule {
+			return append(s, FlareFork{Name: "a", Block: block(5)}, FlareFork{Name: "b", Block: block(5)})
+		}, "not after"},
+		{"times out of order", func(s FlareForkSchedule) FlareForkSchedule {
+			return append(s, FlareFork{Name: "a", Time: block(5)}, FlareFork{Name: "b", Time: block(4)})
+		}, "not after"},
+		{"bad selector", func(s FlareForkSchedule) FlareForkSchedule {
+			return append(s, FlareFork{Name: "a", Block: block(5), FlareForkParams: FlareForkParams{SubmitAttestationSelector: []byte{1}}})
+		}, "submitAttestationSelector"},
+		{"zero address", func(s FlareForkSchedule) FlareForkSchedule {
+			return append(s, FlareFork{Name: "a", Block: block(5), FlareForkParams: FlareForkParams{StateConnectorContract: &zero}})
+		}, "stateConnectorContract"},
+		{"zero registry", func(s FlareForkSchedule) FlareForkSchedule {
+			return append(s, FlareFork{Name: "a", Block: block(5), FlareForkParams: FlareForkParams{FBARegistryContract: &zero}})
+		}, "fbaRegistryContract"},
+		{"zero weight epoch", func(s FlareForkSchedule) FlareForkSchedule {
+			return append(s, FlareFork{Name: "a", Block: block(5), FlareForkParams: FlareForkParams{FBAWeightEpochBlocks: block(0)}})
+		}, "fbaWeightEpochBlocks"},
+		{"weight epochs by time", func(s FlareForkSchedule) FlareForkSchedule {
+			return append(s, FlareFork{Name: "a", Time: block(5), FlareForkParams: FlareForkParams{FBAWeightEpochBlocks: block(10)}})
+		}, "block fork"},
+		{"negative mint", func(s FlareForkSchedule) FlareForkSchedule {
+			return append(s, FlareFork{Name: "a", Block: block(5), FlareForkParams: FlareForkParams{MaximumMintRequest: big.NewInt(-1)}})
+		}, "maximumMintRequest"},
+		{"short mint epoch", func(s FlareForkSchedule) FlareForkSchedule {
+			return append(s, FlareFork{Name: "a", Block: block(5), FlareForkParams: FlareForkParams{MintEpochSeconds: block(60)}})
+		}, "mintEpochSeconds"},
+		{"negative epoch mint cap", func(s FlareForkSchedule) FlareForkSchedule {
+			return append(s, FlareFork{Name: "a", Block: block(5), FlareForkParams: FlareForkParams{MintEpochSeconds: block(3600), MaximumMintPerEpoch: big.NewInt(-1)}})
+		}, "maximumMintPerEpoch"},
+		{"zero system call gas budget", func(s FlareForkSchedule) FlareForkSchedule {
+			return append(s, FlareFork{Name: "a", Block: block(5), FlareForkParams: FlareForkParams{SystemCallGasBudget: block(0)}})
+		}, "systemCallGasBudget"},
+		{"system hook without gas", func(s FlareForkSchedule) FlareForkSchedule {
+			hook := SystemHook{Name: "h", Contract: common.Address{1}, Selector: []byte{1, 2, 3, 4}, Trigger: SystemHookPerBlock}
+			return append(s, FlareFork{Name: "a", Block: block(5), FlareForkParams: FlareForkParams{SystemHooks: []SystemHook{hook}}})
+		}, "systemHooks"},
+		{"mint cap without epoch", func(s FlareForkSchedule) FlareForkSchedule {
+			return append(s, FlareFork{Name: "a", Block: block(5), FlareForkParams: FlareForkParams{MaximumMintPerYear: big.NewInt(1)}})
+		}, "mintEpochSeconds"},
+		{"majority without numerator", func(s FlareForkSchedule) FlareForkSchedule {
+			rule := AttestationMajorityRule{Denominator: 2, OfParticipating: true}
+			return append(s, FlareFork{Name: "a", Block: block(5), FlareForkParams: FlareForkParams{AttestationMajority: &rule}})
+		}, "attestationMajority"},
+		{"weighted majority of all the weight", func(s FlareForkSchedule) FlareForkSchedule {
+			rule := AttestationMajorityRule{Numerator: 3, Denominator: 3}
+			return append(s, FlareFork{Name: "a", Block: block(5), FlareForkParams: FlareForkParams{WeightedAttestationMajority: &rule}})
+		}, "weightedAttestationMajority"},
+		{"weighted majority without denominator", func(s FlareForkSchedule) FlareForkSchedule {
+			rule := AttestationMajorityRule{Numerator: 1}
+			return append(s, FlareFork{Name: "a", Block: block(5), FlareForkParams: FlareForkParams{WeightedAttestationMajority: &rule}})
+		}, "weightedAttestationMajority"},
+	}
+	for _, test := range tests {
+		err := test.schedule(FlareForkSchedule{genesisFlareFork()}).Validate()
+		if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("%s: got %v want error containing %q", test.name, err, test.wantErr)
+		}
+	}
+}
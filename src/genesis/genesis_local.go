@@ -20,7 +20,16 @@ var (
 			"istanbulBlock": 0,
 			"muirGlacierBlock": 0,
 			"apricotPhase1BlockTimestamp": 0,
-			"apricotPhase2BlockTimestamp": 0
+			"apricotPhase2BlockTimestamp": 0,
+			"flare": {
+				"testingChain": true,
+				"stateConnectorContract": "0x1000000000000000000000000000000000000001",
+				"stateConnectorActivationTime": 0,
+				"flareDaemonContract": "0x1000000000000000000000000000000000000002",
+				"maximumMintRequest": 50000000000000000000000000,
+				"attestorSource": "genesis",
+				"attestors": ["0xff57CaF5B871db64F2a7F4C5bc2d17A5E666F7E8"]
+			}
 		},
 		"nonce": "0x0",
 		"timestamp": "0x0",
@@ -20,7 +20,14 @@ var (
 			"istanbulBlock": 0,
 			"muirGlacierBlock": 0,
 			"apricotPhase1BlockTimestamp": 0,
-			"apricotPhase2BlockTimestamp": 0
+			"apricotPhase2BlockTimestamp": 0,
+			"flare": {
+				"stateConnectorContract": "0x1000000000000000000000000000000000000001",
+				"stateConnectorActivationTime": 1000000000000,
+				"flareDaemonContract": "0x1000000000000000000000000000000000000002",
+				"maximumMintRequest": 50000000000000000000000000,
+				"attestorSource": "ftso"
+			}
 		},
 		"nonce": "0x0",
 		"timestamp": "0x0",
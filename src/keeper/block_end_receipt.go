@@ -0,0 +1,111 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package core
+
+import (
+	"encoding/binary"
+
+	"github.com/ava-labs/coreth/core/types"
+	"github.com/ava-labs/coreth/core/vm"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+var blockEndReceiptPrefix = []byte("blockend-receipt-") // blockEndReceiptPrefix + blockNumber (uint64 big endian) -> BlockEndReceipt
+
+// BlockEndReceipt records what the system calls at the end of a block did. These calls belong
+// to no transaction, and the receipts and the bloom of a block are derived from its
+// transactions alone, so the logs of the calls are kept here instead.
+type BlockEndReceipt struct {
+	BlockNumber uint64      `json:"blockNumber"`
+	BlockHash   common.Hash `json:"blockHash"`
+	// Gas used by the system calls, which is not taken from the block
+	GasUsed uint64       `json:"gasUsed"`
+	Logs    []*types.Log `json:"logs"`
+	Bloom   types.Bloom  `json:"logsBloom"`
+}
+
+func newBlockEndReceipt(blockNumber uint64, calls *SystemCalls, logs []*types.Log) *BlockEndReceipt {
+	receipt := &BlockEndReceipt{BlockNumber: blockNumber, Logs: logs}
+	for _, trace := range calls.traces {
+		receipt.GasUsed += trace.GasUsed
+	}
+	for i, l := range logs {
+		l.Index = uint(i)
+	}
+	receipt.Bloom = types.CreateBloom(types.Receipts{{Logs: logs}})
+	return receipt
+}
+
+// systemLogState collects the logs of the system calls made at the end of a block, apart from
+// the logs of the block's transactions. The logs of a call that is reverted are dropped.
+type systemLogState struct {
+	vm.StateDB
+	logs []*types.Log
+	// Number of logs when each snapshot was taken
+	snapshots map[int]int
+}
+
+func newSystemLogState(statedb vm.StateDB) *systemLogState {
+	return &systemLogState{StateDB: statedb, snapshots: make(map[int]int)}
+}
+
+func (s *systemLogState) AddLog(l *types.Log) {
+	s.logs = append(s.logs, l)
+}
+
+func (s *systemLogState) Snapshot() int {
+	id := s.StateDB.Snapshot()
+	s.snapshots[id] = len(s.logs)
+	return id
+}
+
+func (s *systemLogState) RevertToSnapshot(id int) {
+	s.StateDB.RevertToSnapshot(id)
+	if n, ok := s.snapshots[id]; ok {
+		s.logs = s.logs[:n]
+	}
+}
+
+// AcceptBlockEndReceipt stores the receipt of the end of the accepted [block] in [db], if the
+// block made system calls at its end
+func AcceptBlockEndReceipt(db ethdb.KeyValueWriter, block *types.Block) (*BlockEndReceipt, error) {
+	records := takeBlockRecords(block, blockRecordBlockEndReceipt)
+	if len(records) == 0 {
+		return nil, nil
+	}
+	receipt := records[len(records)-1].(*BlockEndReceipt)
+	receipt.BlockHash = block.Hash()
+	enc, err := rlp.EncodeToBytes(receipt)
+	if err != nil {
+		return receipt, err
+	}
+	return receipt, db.Put(blockEndReceiptKey(receipt.BlockNumber), enc)
+}
+
+func blockEndReceiptKey(blockNumber uint64) []byte {
+	key := make([]byte, len(blockEndReceiptPrefix)+8)
+	copy(key, blockEndReceiptPrefix)
+	binary.BigEndian.PutUint64(key[len(blockEndReceiptPrefix):], blockNumber)
+	return key
+}
+
+// ReadBlockEndReceipt returns the receipt of the end of accepted block [blockNumber], or nil if
+// none was recorded
+func ReadBlockEndReceipt(db ethdb.KeyValueReader, blockNumber uint64) (*BlockEndReceipt, error) {
+	enc, err := readRecord(db, blockEndReceiptKey(blockNumber))
+	if err != nil || len(enc) == 0 {
+		return nil, err
+	}
+	receipt := new(BlockEndReceipt)
+	if err := rlp.DecodeBytes(enc, receipt); err != nil {
+		return nil, err
+	}
+	// Only the consensus fields of the logs are stored
+	for i, l := range receipt.Logs {
+		l.BlockNumber, l.BlockHash, l.Index = receipt.BlockNumber, receipt.BlockHash, uint(i)
+	}
+	return receipt, nil
+}
@@ -0,0 +1,132 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package core
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ava-labs/coreth/core/rawdb"
+	"github.com/ava-labs/coreth/core/state"
+	"github.com/ava-labs/coreth/core/types"
+	"github.com/ava-labs/coreth/params"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+var blockEndTestTopic = common.Hash{0xee}
+
+// logCode is runtime code that logs blockEndTestTopic and then returns 32 zero bytes, or
+// reverts if [revert] is set
+func logCode(revert bool) []byte {
+	code := append([]byte{0x7f}, blockEndTestTopic.Bytes()...)
+	code = append(code, 0x60, 0x00, 0x60, 0x00, 0xa1)
+	if revert {
+		return append(code, 0x60, 0x00, 0x60, 0x00, 0xfd)
+	}
+	return append(code, 0x60, 0x20, 0x60, 0x00, 0xf3)
+}
+
+// Run the end of block 1 with the flareDaemon triggered per block and running [code], and
+// accept it
+func runBlockEndTestBlock(t *testing.T, code []byte) (*types.Block, *BlockEndReceipt, *FlareDaemonExecution, *state.StateDB) {
+	config := *params.TestChainConfig
+	config.ChainID = big.NewInt(987654352)
+	perBlock := true
+	genesis := genesisFlareFork()
+	genesis.FlareDaemonPerBlock = &perBlock
+	if err := SetFlareChainConfig(config.ChainID, &FlareChainConfig{Forks: FlareForkSchedule{genesis}}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		flareChainConfigsLock.Lock()
+		delete(flareChainConfigs, config.ChainID.Uint64())
+		flareChainConfigsLock.Unlock()
+	})
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statedb.SetCode(GetFlareDaemonContract(config.ChainID, big.NewInt(1), big.NewInt(0)), code)
+	header := &types.Header{
+		ParentHash: common.Hash{1},
+		Coinbase:   common.HexToAddress("0x0100000000000000000000000000000000000000"),
+		Number:     big.NewInt(1),
+		Time:       100,
+		GasLimit:   8000000,
+		Difficulty: common.Big1,
+		BaseFee:    big.NewInt(0),
+	}
+	execution := FinalizeSystemCalls(&config, nil, header, statedb)
+	block := types.NewBlockWithHeader(header)
+	FinaliseBlockRecords(statedb, block.Hash())
+	receipt, err := AcceptBlockEndReceipt(rawdb.NewMemoryDatabase(), block)
+	if err != nil || receipt == nil {
+		t.Fatalf("got (%v, %v)", receipt, err)
+	}
+	return block, receipt, execution, statedb
+}
+
+func TestBlockEndReceipt(t *testing.T) {
+	block, receipt, execution, statedb := runBlockEndTestBlock(t, logCode(false))
+	daemon := GetFlareDaemonContract(big.NewInt(987654352), big.NewInt(1), big.NewInt(0))
+	if execution == nil || execution.ErrorClass != FlareDaemonOK {
+		t.Fatalf("got execution %+v", execution)
+	}
+	if len(receipt.Logs) != 1 || receipt.Logs[0].Address != daemon || receipt.Logs[0].Topics[0] != blockEndTestTopic {
+		t.Fatalf("got logs %v", receipt.Logs)
+	}
+	if !types.BloomLookup(receipt.Bloom, blockEndTestTopic) || !types.BloomLookup(receipt.Bloom, daemon) {
+		t.Error("log missing from the bloom")
+	}
+	if receipt.BlockNumber != 1 || receipt.BlockHash != block.Hash() || receipt.GasUsed != execution.GasUsed || receipt.GasUsed == 0 {
+		t.Errorf("got receipt %+v", receipt)
+	}
+	// The log belongs to no transaction
+	if logs := statedb.Logs(); len(logs) != 0 {
+		t.Errorf("log added to the transactions: %v", logs)
+	}
+
+	// The log of a call that reverts is dropped
+	_, receipt, execution, _ = runBlockEndTestBlock(t, logCode(true))
+	if execution.ErrorClass != FlareDaemonReverted || len(receipt.Logs) != 0 || types.BloomLookup(receipt.Bloom, blockEndTestTopic) {
+		t.Errorf("reverted call: got execution %+v and receipt %+v", execution, receipt)
+	}
+}
+
+func TestReadBlockEndReceipt(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	block := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(3)})
+	logs := []*types.Log{
+		{Address: common.Address{1}, Topics: []common.Hash{blockEndTestTopic}, Data: []byte{1}, BlockNumber: 3},
+		{Address: common.Address{2}, BlockNumber: 3},
+	}
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addBlockRecord(statedb, 3, blockRecordBlockEndReceipt, newBlockEndReceipt(3, &SystemCalls{traces: []*SystemCallTrace{{GasUsed: 100}, {GasUsed: 20}}}, logs))
+	FinaliseBlockRecords(statedb, block.Hash())
+	if _, err := AcceptBlockEndReceipt(db, block); err != nil {
+		t.Fatal(err)
+	}
+
+	receipt, err := ReadBlockEndReceipt(db, 3)
+	if err != nil || receipt == nil {
+		t.Fatalf("got (%v, %v)", receipt, err)
+	}
+	if receipt.GasUsed != 120 || receipt.BlockHash != block.Hash() || len(receipt.Logs) != 2 || !types.BloomLookup(receipt.Bloom, common.Address{2}) {
+		t.Errorf("got receipt %+v", receipt)
+	}
+	for i, l := range receipt.Logs {
+		if l.Address != logs[i].Address || l.BlockNumber != 3 || l.BlockHash != block.Hash() || l.Index != uint(i) {
+			t.Errorf("log %d: got %+v", i, l)
+		}
+	}
+	if receipt, err := ReadBlockEndReceipt(db, 4); receipt != nil || err != nil {
+		t.Errorf("unaccepted block: got (%v, %v)", receipt, err)
+	}
+	if receipt, err := ReadBlockEndReceipt(FailingDBMock{}, 3); receipt != nil || err != errRoundsTestDB {
+		t.Errorf("failing database: got (%v, %v)", receipt, err)
+	}
+}
@@ -0,0 +1,174 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package core
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/big"
+
+	"github.com/ava-labs/coreth/core/types"
+	"github.com/ava-labs/coreth/core/vm"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// Error classes of a FlareDaemonExecution
+const (
+	FlareDaemonOK              = ""
+	FlareDaemonInvalidData     = "invalidData"     // ErrInvalidFlareDaemonData
+	FlareDaemonDataEmpty       = "dataEmpty"       // ErrFlareDaemonDataEmpty
+	FlareDaemonMaxMintExceeded = "maxMintExceeded" // ErrMaxMintExceeded
+	FlareDaemonMintNegative    = "mintNegative"    // ErrMintNegative
+	FlareDaemonMintCapExceeded = "mintCapExceeded" // ErrMintCapExceeded
+	FlareDaemonReverted        = "reverted"        // the trigger reverted
+	FlareDaemonCallFailed      = "callFailed"      // any other error of the trigger call, e.g. out of gas
+)
+
+var (
+	flareDaemonRecordPrefix = []byte("flaredaemon-record-") // flareDaemonRecordPrefix + blockNumber (uint64 big endian) -> []*FlareDaemonExecution
+
+	// FlareDaemonMetrics holds the flaredaemon counters and nothing else, so that they can be
+	// served without the rest of the node's metrics. The counters are kept whether or not
+	// metrics are enabled.
+	FlareDaemonMetrics = metrics.NewRegistry()
+
+	flareDaemonExecutions    = metrics.NewRegisteredCounterForced("flaredaemon/executions", FlareDaemonMetrics)
+	flareDaemonGasUsed       = metrics.NewRegisteredCounterForced("flaredaemon/gas", FlareDaemonMetrics)
+	flareDaemonMints         = metrics.NewRegisteredCounterForced("flaredaemon/mints", FlareDaemonMetrics)
+	flareDaemonMintedGwei    = metrics.NewRegisteredCounterForced("flaredaemon/minted/gwei", FlareDaemonMetrics)
+	flareDaemonFailures      = metrics.NewRegisteredCounterForced("flaredaemon/failures", FlareDaemonMetrics)
+	flareDaemonFailureCounts = map[string]metrics.Counter{
+		FlareDaemonInvalidData:     metrics.NewRegisteredCounterForced("flaredaemon/failures/invaliddata", FlareDaemonMetrics),
+		FlareDaemonDataEmpty:       metrics.NewRegisteredCounterForced("flaredaemon/failures/dataempty", FlareDaemonMetrics),
+		FlareDaemonMaxMintExceeded: metrics.NewRegisteredCounterForced("flaredaemon/failures/maxmintexceeded", FlareDaemonMetrics),
+		FlareDaemonMintNegative:    metrics.NewRegisteredCounterForced("flaredaemon/failures/mintnegative", FlareDaemonMetrics),
+		FlareDaemonMintCapExceeded: metrics.NewRegisteredCounterForced("flaredaemon/failures/mintcapexceeded", FlareDaemonMetrics),
+		FlareDaemonReverted:        metrics.NewRegisteredCounterForced("flaredaemon/failures/reverted", FlareDaemonMetrics),
+		FlareDaemonCallFailed:      metrics.NewRegisteredCounterForced("flaredaemon/failures/callfailed", FlareDaemonMetrics),
+	}
+
+	gwei = big.NewInt(1000000000)
+)
+
+// FlareDaemonExecution records one call of the flareDaemon trigger and the mint that followed
+type FlareDaemonExecution struct {
+	BlockNumber uint64 `json:"blockNumber"`
+	// Gas used by the block up to and including the transaction that triggered the daemon, or by
+	// the whole block when the daemon is triggered once per block
+	BlockGasUsed  uint64   `json:"blockGasUsed"`
+	GasUsed       uint64   `json:"gasUsed"`
+	MintRequested *big.Int `json:"mintRequested"`
+	MintApplied   *big.Int `json:"mintApplied"`
+	ErrorClass    string   `json:"errorClass"`
+	Error         string   `json:"error"`
+}
+
+func (e *FlareDaemonExecution) setError(err error) {
+	e.ErrorClass, e.Error = flareDaemonErrorClass(err), err.Error()
+}
+
+func flareDaemonErrorClass(err error) string {
+	var (
+		invalidData  *ErrInvalidFlareDaemonData
+		dataEmpty    *ErrFlareDaemonDataEmpty
+		maxMint      *ErrMaxMintExceeded
+		mintNegative *ErrMintNegative
+		mintCap      *ErrMintCapExceeded
+	)
+	switch {
+	case err == nil:
+		return FlareDaemonOK
+	case errors.As(err, &invalidData):
+		return FlareDaemonInvalidData
+	case errors.As(err, &dataEmpty):
+		return FlareDaemonDataEmpty
+	case errors.As(err, &maxMint):
+		return FlareDaemonMaxMintExceeded
+	case errors.As(err, &mintNegative):
+		return FlareDaemonMintNegative
+	case errors.As(err, &mintCap):
+		return FlareDaemonMintCapExceeded
+	case errors.Is(err, vm.ErrExecutionReverted):
+		return FlareDaemonReverted
+	default:
+		return FlareDaemonCallFailed
+	}
+}
+
+// recordFlareDaemonExecution keeps [execution], triggered after the transaction of [st], until
+// its block is accepted
+func (st *StateTransition) recordFlareDaemonExecution(execution *FlareDaemonExecution) {
+	if st.msg.IsFake() || execution == nil {
+		return
+	}
+	number := st.evm.Context.BlockNumber.Uint64()
+	if number == 0 {
+		return
+	}
+	execution.BlockGasUsed = st.evm.Context.GasLimit - st.gp.Gas()
+	addBlockRecord(st.state, number, blockRecordFlareDaemonExecution, execution)
+}
+
+// AcceptFlareDaemonExecutions stores the flareDaemon executions of the accepted [block] in [db]
+// and adds them to the flaredaemon metrics
+func AcceptFlareDaemonExecutions(db ethdb.KeyValueWriter, block *types.Block) ([]*FlareDaemonExecution, error) {
+	records := takeBlockRecords(block, blockRecordFlareDaemonExecution)
+	executions := make([]*FlareDaemonExecution, len(records))
+	for i, record := range records {
+		executions[i] = record.(*FlareDaemonExecution)
+	}
+	if len(executions) == 0 {
+		return executions, nil
+	}
+	for _, execution := range executions {
+		countFlareDaemonExecution(execution)
+	}
+	return executions, writeFlareDaemonExecutions(db, block.NumberU64(), executions)
+}
+
+func countFlareDaemonExecution(execution *FlareDaemonExecution) {
+	flareDaemonExecutions.Inc(1)
+	flareDaemonGasUsed.Inc(int64(execution.GasUsed))
+	if execution.ErrorClass != FlareDaemonOK {
+		flareDaemonFailures.Inc(1)
+		if counter, ok := flareDaemonFailureCounts[execution.ErrorClass]; ok {
+			counter.Inc(1)
+		}
+	}
+	if execution.MintApplied != nil && execution.MintApplied.Sign() > 0 {
+		flareDaemonMints.Inc(1)
+		flareDaemonMintedGwei.Inc(new(big.Int).Quo(execution.MintApplied, gwei).Int64())
+	}
+}
+
+func flareDaemonRecordKey(blockNumber uint64) []byte {
+	key := make([]byte, len(flareDaemonRecordPrefix)+8)
+	copy(key, flareDaemonRecordPrefix)
+	binary.BigEndian.PutUint64(key[len(flareDaemonRecordPrefix):], blockNumber)
+	return key
+}
+
+func writeFlareDaemonExecutions(db ethdb.KeyValueWriter, blockNumber uint64, executions []*FlareDaemonExecution) error {
+	enc, err := rlp.EncodeToBytes(executions)
+	if err != nil {
+		return err
+	}
+	return db.Put(flareDaemonRecordKey(blockNumber), enc)
+}
+
+// ReadFlareDaemonExecutions returns the flareDaemon executions of accepted block [blockNumber],
+// or nil if none were recorded
+func ReadFlareDaemonExecutions(db ethdb.KeyValueReader, blockNumber uint64) ([]*FlareDaemonExecution, error) {
+	enc, err := readRecord(db, flareDaemonRecordKey(blockNumber))
+	if err != nil || len(enc) == 0 {
+		return nil, err
+	}
+	var executions []*FlareDaemonExecution
+	if err := rlp.DecodeBytes(enc, &executions); err != nil {
+		return nil, err
+	}
+	return executions, nil
+}
@@ -0,0 +1,111 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package core
+
+import (
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/ava-labs/coreth/core/rawdb"
+	"github.com/ava-labs/coreth/core/types"
+	"github.com/ava-labs/coreth/core/vm"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+func TestFlareDaemonErrorClass(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{nil, FlareDaemonOK},
+		{&ErrInvalidFlareDaemonData{}, FlareDaemonInvalidData},
+		{&ErrFlareDaemonDataEmpty{}, FlareDaemonDataEmpty},
+		{&ErrMaxMintExceeded{mintMax: big.NewInt(1), mintRequest: big.NewInt(2)}, FlareDaemonMaxMintExceeded},
+		{&ErrMintNegative{}, FlareDaemonMintNegative},
+		{&ErrMintCapExceeded{period: "epoch", mintCap: big.NewInt(1), minted: big.NewInt(1), mintRequest: big.NewInt(1)}, FlareDaemonMintCapExceeded},
+		{vm.ErrExecutionReverted, FlareDaemonReverted},
+		{fmt.Errorf("wrapped: %w", vm.ErrExecutionReverted), FlareDaemonReverted},
+		{vm.ErrOutOfGas, FlareDaemonCallFailed},
+	}
+	for _, test := range tests {
+		if got := flareDaemonErrorClass(test.err); got != test.want {
+			t.Errorf("flareDaemonErrorClass(%v) = %q want %q", test.err, got, test.want)
+		}
+	}
+}
+
+func TestFlareDaemonTriggerAndMintRecordsExecution(t *testing.T) {
+	mintRequest := big.NewInt(1000)
+	minted := triggerFlareDaemonAndMint(&DefaultEVMMock{mockEVMCallerData: MockEVMCallerData{blockNumber: *big.NewInt(7), mintRequestReturn: *mintRequest}}, log.New())
+	if minted.BlockNumber != 7 || minted.MintRequested.Cmp(mintRequest) != 0 || minted.MintApplied.Cmp(mintRequest) != 0 || minted.ErrorClass != FlareDaemonOK {
+		t.Errorf("minted: got %+v", minted)
+	}
+
+	overMax, _ := new(big.Int).SetString("50000000000000000000000001", 10)
+	rejected := triggerFlareDaemonAndMint(&DefaultEVMMock{mockEVMCallerData: MockEVMCallerData{mintRequestReturn: *overMax}}, log.New())
+	if rejected.MintRequested.Cmp(overMax) != 0 || rejected.MintApplied.Sign() != 0 || rejected.ErrorClass != FlareDaemonMaxMintExceeded {
+		t.Errorf("over max: got %+v", rejected)
+	}
+
+	failed := triggerFlareDaemonAndMint(&BadTriggerCallEVMMock{}, &LoggerMock{})
+	if failed.MintApplied.Sign() != 0 || failed.ErrorClass != FlareDaemonCallFailed || failed.Error != "Call error happened" {
+		t.Errorf("call error: got %+v", failed)
+	}
+}
+
+// Record [execution] after a transaction that brings the gas used by the block executed on the
+// state of [st] to [blockGasUsed]
+func recordTestFlareDaemonExecution(st *StateTransition, blockGasUsed uint64, execution *FlareDaemonExecution) {
+	to := stateConnectorTestAddr
+	st.msg = types.NewMessage(common.Address{}, &to, 0, big.NewInt(0), 0, big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, false)
+	st.gp = new(GasPool).AddGas(st.evm.Context.GasLimit - blockGasUsed)
+	st.recordFlareDaemonExecution(execution)
+}
+
+func TestAcceptFlareDaemonExecutions(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	accepted := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1), Extra: []byte("accepted")})
+	rejected := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1), Extra: []byte("rejected")})
+	next := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(2), ParentHash: accepted.Hash()})
+
+	// The accepted block is executed twice, to build and to verify it, and a block built at the
+	// same height on the same parent is rejected
+	for _, hash := range []common.Hash{accepted.Hash(), accepted.Hash(), rejected.Hash()} {
+		st := newStateConnectorTestTransition(t)
+		recordTestFlareDaemonExecution(st, 21000, &FlareDaemonExecution{BlockNumber: 1, GasUsed: 300, MintRequested: big.NewInt(10), MintApplied: big.NewInt(10)})
+		if hash == accepted.Hash() {
+			recordTestFlareDaemonExecution(st, 42000, &FlareDaemonExecution{BlockNumber: 1, MintRequested: new(big.Int), MintApplied: new(big.Int), ErrorClass: FlareDaemonReverted, Error: vm.ErrExecutionReverted.Error()})
+		}
+		FinaliseBlockRecords(st.state, hash)
+	}
+	st := newStateConnectorTestTransition(t)
+	st.evm.Context.BlockNumber = big.NewInt(2)
+	recordTestFlareDaemonExecution(st, 21000, &FlareDaemonExecution{BlockNumber: 2, MintRequested: new(big.Int), MintApplied: new(big.Int)})
+
+	executions, err := AcceptFlareDaemonExecutions(db, accepted)
+	if err != nil || len(executions) != 2 || executions[0].BlockGasUsed != 21000 || executions[1].BlockGasUsed != 42000 {
+		t.Fatalf("got (%v, %v)", executions, err)
+	}
+	stored, err := ReadFlareDaemonExecutions(db, 1)
+	if err != nil || len(stored) != 2 || stored[0].MintApplied.Cmp(big.NewInt(10)) != 0 || stored[1].ErrorClass != FlareDaemonReverted {
+		t.Errorf("stored: got (%v, %v)", stored, err)
+	}
+	if stored, err := ReadFlareDaemonExecutions(db, 2); stored != nil || err != nil {
+		t.Errorf("unaccepted block: got (%v, %v)", stored, err)
+	}
+
+	// The execution of the next block is kept until that block is accepted
+	FinaliseBlockRecords(st.state, next.Hash())
+	if executions, err := AcceptFlareDaemonExecutions(db, next); err != nil || len(executions) != 1 || executions[0].BlockNumber != 2 {
+		t.Errorf("next block: got (%v, %v)", executions, err)
+	}
+}
+
+func TestReadFlareDaemonExecutionsFailingDB(t *testing.T) {
+	if executions, err := ReadFlareDaemonExecutions(FailingDBMock{}, 1); executions != nil || err != errRoundsTestDB {
+		t.Errorf("got (%v, %v)", executions, err)
+	}
+}
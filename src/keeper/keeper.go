@@ -7,7 +7,9 @@ import (
 	"fmt"
 	"math/big"
 
+	"github.com/ava-labs/coreth/core/types"
 	"github.com/ava-labs/coreth/core/vm"
+	"github.com/ava-labs/coreth/params"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/log"
 )
@@ -39,59 +41,152 @@ func (e *ErrMintNegative) Error() string { return "mint request cannot be negati
 // Define interface for dependencies
 type EVMCaller interface {
 	Call(caller vm.ContractRef, addr common.Address, input []byte, gas uint64, value *big.Int) (ret []byte, leftOverGas uint64, err error)
+	GetChainID() *big.Int
 	GetBlockNumber() *big.Int
+	GetBlockTime() *big.Int
 	GetGasLimit() uint64
 	AddBalance(addr common.Address, amount *big.Int)
+	SubBalance(addr common.Address, amount *big.Int)
+	GetState(addr common.Address, key common.Hash) common.Hash
+	SetState(addr common.Address, key common.Hash, value common.Hash)
+	GetNonce(addr common.Address) uint64
+	SetNonce(addr common.Address, nonce uint64)
+	// The SystemCalls that the calls of the flareDaemon and the state connector are made in
+	systemCalls() *SystemCalls
 }
 
-// Define maximums that can change by block height
-func GetFlareDaemonGasMultiplier(blockNumber *big.Int) uint64 {
-	switch {
-	default:
-		return 100
-	}
+// Define maximums that can change by fork, see GetFlareParams
+func GetFlareDaemonGasMultiplier(chainID *big.Int, blockNumber *big.Int, blockTime *big.Int) uint64 {
+	return GetFlareParams(chainID, blockNumber, blockTime).FlareDaemonGasMultiplier
 }
 
-func GetFlareDaemonContract(blockNumber *big.Int) string {
-	switch {
-	default:
-		return "0x1000000000000000000000000000000000000002"
-	}
+func GetFlareDaemonContract(chainID *big.Int, blockNumber *big.Int, blockTime *big.Int) common.Address {
+	return GetFlareParams(chainID, blockNumber, blockTime).FlareDaemonContract
 }
 
-func GetFlareDaemonSelector(blockNumber *big.Int) []byte {
-	switch {
-	default:
-		return []byte{0x7f, 0xec, 0x8d, 0x38}
-	}
+func GetFlareDaemonSelector(chainID *big.Int, blockNumber *big.Int, blockTime *big.Int) []byte {
+	return GetFlareParams(chainID, blockNumber, blockTime).FlareDaemonSelector
 }
 
-func GetPrioritisedFTSOContract(blockTime *big.Int) common.Address {
-	switch {
-	default:
-		return common.HexToAddress("0x1000000000000000000000000000000000000003")
-	}
+func GetPrioritisedFTSOContract(chainID *big.Int, blockNumber *big.Int, blockTime *big.Int) common.Address {
+	return GetFlareParams(chainID, blockNumber, blockTime).PrioritisedFTSOContract
+}
+
+func GetMaximumMintRequest(chainID *big.Int, blockNumber *big.Int, blockTime *big.Int) *big.Int {
+	return GetFlareParams(chainID, blockNumber, blockTime).MaximumMintRequest
+}
+
+// GetFlareDaemonPerBlock returns whether the flareDaemon is triggered once at the end of each
+// block rather than after every successful transaction
+func GetFlareDaemonPerBlock(chainID *big.Int, blockNumber *big.Int, blockTime *big.Int) bool {
+	return GetFlareParams(chainID, blockNumber, blockTime).FlareDaemonPerBlock
+}
+
+// blockEVMCaller implements EVMCaller for the flareDaemon trigger at the end of a block, which
+// is not part of any transaction
+type blockEVMCaller struct {
+	evm   *vm.EVM
+	calls *SystemCalls
+}
+
+func (c *blockEVMCaller) systemCalls() *SystemCalls {
+	return c.calls
+}
+
+func (c *blockEVMCaller) Call(caller vm.ContractRef, addr common.Address, input []byte, gas uint64, value *big.Int) (ret []byte, leftOverGas uint64, err error) {
+	return c.evm.Call(caller, addr, input, gas, value)
+}
+
+func (c *blockEVMCaller) GetChainID() *big.Int {
+	return c.evm.ChainConfig().ChainID
+}
+
+func (c *blockEVMCaller) GetBlockNumber() *big.Int {
+	return c.evm.Context.BlockNumber
+}
+
+func (c *blockEVMCaller) GetBlockTime() *big.Int {
+	return c.evm.Context.Time
+}
+
+func (c *blockEVMCaller) GetGasLimit() uint64 {
+	return c.evm.Context.GasLimit
+}
+
+func (c *blockEVMCaller) AddBalance(addr common.Address, amount *big.Int) {
+	c.evm.StateDB.AddBalance(addr, amount)
+}
+
+func (c *blockEVMCaller) SubBalance(addr common.Address, amount *big.Int) {
+	c.evm.StateDB.SubBalance(addr, amount)
+}
+
+func (c *blockEVMCaller) GetState(addr common.Address, key common.Hash) common.Hash {
+	return c.evm.StateDB.GetState(addr, key)
+}
+
+func (c *blockEVMCaller) SetState(addr common.Address, key common.Hash, value common.Hash) {
+	c.evm.StateDB.SetState(addr, key, value)
+}
+
+func (c *blockEVMCaller) GetNonce(addr common.Address) uint64 {
+	return c.evm.StateDB.GetNonce(addr)
 }
 
-func GetMaximumMintRequest(blockNumber *big.Int) *big.Int {
-	switch {
-	default:
-		maxRequest, _ := new(big.Int).SetString("50000000000000000000000000", 10)
-		return maxRequest
+func (c *blockEVMCaller) SetNonce(addr common.Address, nonce uint64) {
+	c.evm.StateDB.SetNonce(addr, nonce)
+}
+
+// FinalizeSystemCalls makes the system calls of the end of the block of [header]: it triggers
+// the flareDaemon and mints what it requests, once the fork schedule runs the trigger per block,
+// and then calls the block system hooks. It is called both when the block is built and when it
+// is processed, against the state its transactions left, so the calls are made exactly once per
+// block whether the block is full or has no transactions at all. They are system calls of the
+// end of the block, their gas is not taken from the block, and their logs are kept in a
+// BlockEndReceipt. The flareDaemon execution is returned if the trigger ran.
+func FinalizeSystemCalls(config *params.ChainConfig, chain ChainContext, header *types.Header, statedb vm.StateDB) *FlareDaemonExecution {
+	blockTime := new(big.Int).SetUint64(header.Time)
+	perBlock := GetFlareDaemonPerBlock(config.ChainID, header.Number, blockTime)
+	if !perBlock && !hasBlockSystemHooks(config.ChainID, header.Number, blockTime) {
+		return nil
+	}
+	logs := newSystemLogState(statedb)
+	evm := vm.NewEVM(NewEVMBlockContext(header, chain, &header.Coinbase), vm.TxContext{GasPrice: new(big.Int)}, logs, config, vm.Config{})
+	calls := newSystemCalls(config.ChainID, header.Number, blockTime, &evm.Config)
+	execution := runSystemHooks(&blockEVMCaller{evm: evm, calls: calls}, nil)
+	if execution != nil {
+		execution.BlockGasUsed = header.GasUsed
+	}
+	if header.Number.Sign() > 0 {
+		if execution != nil {
+			addBlockRecord(statedb, header.Number.Uint64(), blockRecordFlareDaemonExecution, execution)
+		}
+		addBlockRecord(statedb, header.Number.Uint64(), blockRecordBlockEndReceipt, newBlockEndReceipt(header.Number.Uint64(), calls, logs.logs))
+		addSystemCallRecords(statedb, header.Number.Uint64(), header.GasUsed, true, calls)
 	}
+	return execution
 }
 
 func triggerFlareDaemon(evm EVMCaller) (*big.Int, error) {
+	mintRequest, _, err := callFlareDaemon(evm)
+	return mintRequest, err
+}
+
+// callFlareDaemon calls the flareDaemon trigger and returns the mint request and the gas the
+// call used
+func callFlareDaemon(evm EVMCaller) (*big.Int, uint64, error) {
 	bigZero := big.NewInt(0)
+	chainID, blockNumber, blockTime := evm.GetChainID(), evm.GetBlockNumber(), evm.GetBlockTime()
 	// Get the contract to call
-	flareDaemonContract := common.HexToAddress(GetFlareDaemonContract(evm.GetBlockNumber()))
+	flareDaemonContract := GetFlareDaemonContract(chainID, blockNumber, blockTime)
 	// Call the method
-	triggerRet, _, triggerErr := evm.Call(
+	triggerRet, gasUsed, triggerErr := evm.systemCalls().call(
+		evm,
+		SystemCallFlareDaemon,
 		vm.AccountRef(flareDaemonContract),
 		flareDaemonContract,
-		GetFlareDaemonSelector(evm.GetBlockNumber()),
-		GetFlareDaemonGasMultiplier(evm.GetBlockNumber())*evm.GetGasLimit(),
-		bigZero)
+		GetFlareDaemonSelector(chainID, blockNumber, blockTime),
+		GetFlareDaemonGasMultiplier(chainID, blockNumber, blockTime)*evm.GetGasLimit())
 	// If no error and a value came back...
 	if triggerErr == nil && triggerRet != nil {
 		// Did we get one big int?
@@ -100,27 +195,33 @@ func triggerFlareDaemon(evm EVMCaller) (*big.Int, error) {
 			// Mint request cannot be less than 0 as SetBytes treats value as unsigned
 			mintRequest := new(big.Int).SetBytes(triggerRet)
 			// return the mint request
-			return mintRequest, nil
+			return mintRequest, gasUsed, nil
 		} else {
 			// Returned length was not 32 bytes
-			return bigZero, &ErrInvalidFlareDaemonData{}
+			return bigZero, gasUsed, &ErrInvalidFlareDaemonData{}
 		}
 	} else {
 		if triggerErr != nil {
-			return bigZero, triggerErr
+			return bigZero, gasUsed, triggerErr
 		} else {
-			return bigZero, &ErrFlareDaemonDataEmpty{}
+			return bigZero, gasUsed, &ErrFlareDaemonDataEmpty{}
 		}
 	}
 }
 
 func mint(evm EVMCaller, mintRequest *big.Int) error {
 	// If the mint request is greater than zero and less than max
-	max := GetMaximumMintRequest(evm.GetBlockNumber())
+	chainID, blockNumber, blockTime := evm.GetChainID(), evm.GetBlockNumber(), evm.GetBlockTime()
+	max := GetMaximumMintRequest(chainID, blockNumber, blockTime)
 	if mintRequest.Cmp(big.NewInt(0)) > 0 &&
 		mintRequest.Cmp(max) <= 0 {
+		// Check the epoch and year caps of the mint ledger
+		if err := checkMintCaps(evm, mintRequest); err != nil {
+			return err
+		}
 		// Mint the amount asked for on to the flareDaemon contract
-		evm.AddBalance(common.HexToAddress(GetFlareDaemonContract(evm.GetBlockNumber())), mintRequest)
+		evm.AddBalance(GetFlareDaemonContract(chainID, blockNumber, blockTime), mintRequest)
+		recordMint(evm, mintRequest)
 	} else if mintRequest.Cmp(max) > 0 {
 		// Return error
 		return &ErrMaxMintExceeded{
@@ -135,16 +236,29 @@ func mint(evm EVMCaller, mintRequest *big.Int) error {
 	return nil
 }
 
-func triggerFlareDaemonAndMint(evm EVMCaller, log log.Logger) {
+// triggerFlareDaemonAndMint calls the flareDaemon, mints what it requests and returns a record
+// of the outcome
+func triggerFlareDaemonAndMint(evm EVMCaller, log log.Logger) *FlareDaemonExecution {
 	// Call the flareDaemon
-	mintRequest, triggerErr := triggerFlareDaemon(evm)
+	mintRequest, gasUsed, triggerErr := callFlareDaemon(evm)
+	execution := &FlareDaemonExecution{
+		BlockNumber:   evm.GetBlockNumber().Uint64(),
+		GasUsed:       gasUsed,
+		MintRequested: mintRequest,
+		MintApplied:   new(big.Int),
+	}
 	// If no error...
 	if triggerErr == nil {
 		// time to mint
 		if mintError := mint(evm, mintRequest); mintError != nil {
 			log.Warn("Error minting inflation request", "error", mintError)
+			execution.setError(mintError)
+		} else {
+			execution.MintApplied = mintRequest
 		}
 	} else {
 		log.Warn("FlareDaemon trigger in error", "error", triggerErr)
+		execution.setError(triggerErr)
 	}
+	return execution
 }
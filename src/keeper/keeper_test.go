@@ -8,7 +8,11 @@ import (
 	"math/big"
 	"testing"
 
+	"github.com/ava-labs/coreth/core/rawdb"
+	"github.com/ava-labs/coreth/core/state"
+	"github.com/ava-labs/coreth/core/types"
 	"github.com/ava-labs/coreth/core/vm"
+	"github.com/ava-labs/coreth/params"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/log"
 )
@@ -17,11 +21,17 @@ import (
 type MockEVMCallerData struct {
 	callCalls            int
 	addBalanceCalls      int
+	subBalanceCalls      int
+	chainID              big.Int
 	blockNumber          big.Int
+	blockTime            big.Int
 	gasLimit             uint64
 	mintRequestReturn    big.Int
 	lastAddBalanceAddr   common.Address
 	lastAddBalanceAmount *big.Int
+	storage              map[common.Address]map[common.Hash]common.Hash
+	nonces               map[common.Address]uint64
+	systemCalls          *SystemCalls
 }
 
 // Define a mock structure to spy and mock values for logger calls
@@ -37,10 +47,18 @@ func defautCall(e *MockEVMCallerData, caller vm.ContractRef, addr common.Address
 	return e.mintRequestReturn.FillBytes(buffer), 0, nil
 }
 
+func defaultGetChainID(e *MockEVMCallerData) *big.Int {
+	return &e.chainID
+}
+
 func defaultGetBlockNumber(e *MockEVMCallerData) *big.Int {
 	return &e.blockNumber
 }
 
+func defaultGetBlockTime(e *MockEVMCallerData) *big.Int {
+	return &e.blockTime
+}
+
 func defaultGetGasLimit(e *MockEVMCallerData) uint64 {
 	return e.gasLimit
 }
@@ -51,6 +69,42 @@ func defaultAddBalance(e *MockEVMCallerData, addr common.Address, amount *big.In
 	e.lastAddBalanceAmount = amount
 }
 
+func defaultSubBalance(e *MockEVMCallerData, addr common.Address, amount *big.Int) {
+	e.subBalanceCalls++
+}
+
+func defaultGetState(e *MockEVMCallerData, addr common.Address, key common.Hash) common.Hash {
+	return e.storage[addr][key]
+}
+
+func defaultSetState(e *MockEVMCallerData, addr common.Address, key common.Hash, value common.Hash) {
+	if e.storage == nil {
+		e.storage = make(map[common.Address]map[common.Hash]common.Hash)
+	}
+	if e.storage[addr] == nil {
+		e.storage[addr] = make(map[common.Hash]common.Hash)
+	}
+	e.storage[addr][key] = value
+}
+
+func defaultGetNonce(e *MockEVMCallerData, addr common.Address) uint64 {
+	return e.nonces[addr]
+}
+
+func defaultSetNonce(e *MockEVMCallerData, addr common.Address, nonce uint64) {
+	if e.nonces == nil {
+		e.nonces = make(map[common.Address]uint64)
+	}
+	e.nonces[addr] = nonce
+}
+
+func defaultSystemCalls(e *MockEVMCallerData) *SystemCalls {
+	if e.systemCalls == nil {
+		e.systemCalls = newSystemCalls(&e.chainID, &e.blockNumber, &e.blockTime, nil)
+	}
+	return e.systemCalls
+}
+
 // Define the default EVM mock and define default mock receiver functions
 type DefaultEVMMock struct {
 	mockEVMCallerData MockEVMCallerData
@@ -60,10 +114,18 @@ func (e *DefaultEVMMock) Call(caller vm.ContractRef, addr common.Address, input
 	return defautCall(&e.mockEVMCallerData, caller, addr, input, gas, value)
 }
 
+func (e *DefaultEVMMock) GetChainID() *big.Int {
+	return defaultGetChainID(&e.mockEVMCallerData)
+}
+
 func (e *DefaultEVMMock) GetBlockNumber() *big.Int {
 	return defaultGetBlockNumber(&e.mockEVMCallerData)
 }
 
+func (e *DefaultEVMMock) GetBlockTime() *big.Int {
+	return defaultGetBlockTime(&e.mockEVMCallerData)
+}
+
 func (e *DefaultEVMMock) GetGasLimit() uint64 {
 	return defaultGetGasLimit(&e.mockEVMCallerData)
 }
@@ -72,6 +134,30 @@ func (e *DefaultEVMMock) AddBalance(addr common.Address, amount *big.Int) {
 	defaultAddBalance(&e.mockEVMCallerData, addr, amount)
 }
 
+func (e *DefaultEVMMock) SubBalance(addr common.Address, amount *big.Int) {
+	defaultSubBalance(&e.mockEVMCallerData, addr, amount)
+}
+
+func (e *DefaultEVMMock) GetState(addr common.Address, key common.Hash) common.Hash {
+	return defaultGetState(&e.mockEVMCallerData, addr, key)
+}
+
+func (e *DefaultEVMMock) SetState(addr common.Address, key common.Hash, value common.Hash) {
+	defaultSetState(&e.mockEVMCallerData, addr, key, value)
+}
+
+func (e *DefaultEVMMock) GetNonce(addr common.Address) uint64 {
+	return defaultGetNonce(&e.mockEVMCallerData, addr)
+}
+
+func (e *DefaultEVMMock) SetNonce(addr common.Address, nonce uint64) {
+	defaultSetNonce(&e.mockEVMCallerData, addr, nonce)
+}
+
+func (e *DefaultEVMMock) systemCalls() *SystemCalls {
+	return defaultSystemCalls(&e.mockEVMCallerData)
+}
+
 func TestFlareDaemonTriggerShouldReturnMintRequest(t *testing.T) {
 	mintRequestReturn, _ := new(big.Int).SetString("50000000000000000000000000", 10)
 	mockEVMCallerData := &MockEVMCallerData{
@@ -128,10 +214,18 @@ func (e *BadMintReturnSizeEVMMock) Call(caller vm.ContractRef, addr common.Addre
 	return e.mockEVMCallerData.mintRequestReturn.FillBytes(buffer), 0, nil
 }
 
+func (e *BadMintReturnSizeEVMMock) GetChainID() *big.Int {
+	return defaultGetChainID(&e.mockEVMCallerData)
+}
+
 func (e *BadMintReturnSizeEVMMock) GetBlockNumber() *big.Int {
 	return defaultGetBlockNumber(&e.mockEVMCallerData)
 }
 
+func (e *BadMintReturnSizeEVMMock) GetBlockTime() *big.Int {
+	return defaultGetBlockTime(&e.mockEVMCallerData)
+}
+
 func (e *BadMintReturnSizeEVMMock) GetGasLimit() uint64 {
 	return defaultGetGasLimit(&e.mockEVMCallerData)
 }
@@ -140,6 +234,30 @@ func (e *BadMintReturnSizeEVMMock) AddBalance(addr common.Address, amount *big.I
 	defaultAddBalance(&e.mockEVMCallerData, addr, amount)
 }
 
+func (e *BadMintReturnSizeEVMMock) SubBalance(addr common.Address, amount *big.Int) {
+	defaultSubBalance(&e.mockEVMCallerData, addr, amount)
+}
+
+func (e *BadMintReturnSizeEVMMock) GetState(addr common.Address, key common.Hash) common.Hash {
+	return defaultGetState(&e.mockEVMCallerData, addr, key)
+}
+
+func (e *BadMintReturnSizeEVMMock) SetState(addr common.Address, key common.Hash, value common.Hash) {
+	defaultSetState(&e.mockEVMCallerData, addr, key, value)
+}
+
+func (e *BadMintReturnSizeEVMMock) GetNonce(addr common.Address) uint64 {
+	return defaultGetNonce(&e.mockEVMCallerData, addr)
+}
+
+func (e *BadMintReturnSizeEVMMock) SetNonce(addr common.Address, nonce uint64) {
+	defaultSetNonce(&e.mockEVMCallerData, addr, nonce)
+}
+
+func (e *BadMintReturnSizeEVMMock) systemCalls() *SystemCalls {
+	return defaultSystemCalls(&e.mockEVMCallerData)
+}
+
 func TestFlareDaemonTriggerValidatesMintRequestReturnValueSize(t *testing.T) {
 	var mintRequestReturn big.Int
 	// TODO: Compact with exponent?
@@ -179,10 +297,18 @@ func (e *BadTriggerCallEVMMock) Call(caller vm.ContractRef, addr common.Address,
 	return e.mockEVMCallerData.mintRequestReturn.FillBytes(buffer), 0, errors.New("Call error happened")
 }
 
+func (e *BadTriggerCallEVMMock) GetChainID() *big.Int {
+	return defaultGetChainID(&e.mockEVMCallerData)
+}
+
 func (e *BadTriggerCallEVMMock) GetBlockNumber() *big.Int {
 	return defaultGetBlockNumber(&e.mockEVMCallerData)
 }
 
+func (e *BadTriggerCallEVMMock) GetBlockTime() *big.Int {
+	return defaultGetBlockTime(&e.mockEVMCallerData)
+}
+
 func (e *BadTriggerCallEVMMock) GetGasLimit() uint64 {
 	return defaultGetGasLimit(&e.mockEVMCallerData)
 }
@@ -191,6 +317,30 @@ func (e *BadTriggerCallEVMMock) AddBalance(addr common.Address, amount *big.Int)
 	defaultAddBalance(&e.mockEVMCallerData, addr, amount)
 }
 
+func (e *BadTriggerCallEVMMock) SubBalance(addr common.Address, amount *big.Int) {
+	defaultSubBalance(&e.mockEVMCallerData, addr, amount)
+}
+
+func (e *BadTriggerCallEVMMock) GetState(addr common.Address, key common.Hash) common.Hash {
+	return defaultGetState(&e.mockEVMCallerData, addr, key)
+}
+
+func (e *BadTriggerCallEVMMock) SetState(addr common.Address, key common.Hash, value common.Hash) {
+	defaultSetState(&e.mockEVMCallerData, addr, key, value)
+}
+
+func (e *BadTriggerCallEVMMock) GetNonce(addr common.Address) uint64 {
+	return defaultGetNonce(&e.mockEVMCallerData, addr)
+}
+
+func (e *BadTriggerCallEVMMock) SetNonce(addr common.Address, nonce uint64) {
+	defaultSetNonce(&e.mockEVMCallerData, addr, nonce)
+}
+
+func (e *BadTriggerCallEVMMock) systemCalls() *SystemCalls {
+	return defaultSystemCalls(&e.mockEVMCallerData)
+}
+
 func TestFlareDaemonTriggerReturnsCallError(t *testing.T) {
 	mockEVMCallerData := &MockEVMCallerData{}
 	badTriggerCallEVMMock := &BadTriggerCallEVMMock{
@@ -266,10 +416,18 @@ func (e *ReturnNilMintRequestEVMMock) Call(caller vm.ContractRef, addr common.Ad
 	return nil, 0, nil
 }
 
+func (e *ReturnNilMintRequestEVMMock) GetChainID() *big.Int {
+	return defaultGetChainID(&e.mockEVMCallerData)
+}
+
 func (e *ReturnNilMintRequestEVMMock) GetBlockNumber() *big.Int {
 	return defaultGetBlockNumber(&e.mockEVMCallerData)
 }
 
+func (e *ReturnNilMintRequestEVMMock) GetBlockTime() *big.Int {
+	return defaultGetBlockTime(&e.mockEVMCallerData)
+}
+
 func (e *ReturnNilMintRequestEVMMock) GetGasLimit() uint64 {
 	return defaultGetGasLimit(&e.mockEVMCallerData)
 }
@@ -278,6 +436,30 @@ func (e *ReturnNilMintRequestEVMMock) AddBalance(addr common.Address, amount *bi
 	defaultAddBalance(&e.mockEVMCallerData, addr, amount)
 }
 
+func (e *ReturnNilMintRequestEVMMock) SubBalance(addr common.Address, amount *big.Int) {
+	defaultSubBalance(&e.mockEVMCallerData, addr, amount)
+}
+
+func (e *ReturnNilMintRequestEVMMock) GetState(addr common.Address, key common.Hash) common.Hash {
+	return defaultGetState(&e.mockEVMCallerData, addr, key)
+}
+
+func (e *ReturnNilMintRequestEVMMock) SetState(addr common.Address, key common.Hash, value common.Hash) {
+	defaultSetState(&e.mockEVMCallerData, addr, key, value)
+}
+
+func (e *ReturnNilMintRequestEVMMock) GetNonce(addr common.Address) uint64 {
+	return defaultGetNonce(&e.mockEVMCallerData, addr)
+}
+
+func (e *ReturnNilMintRequestEVMMock) SetNonce(addr common.Address, nonce uint64) {
+	defaultSetNonce(&e.mockEVMCallerData, addr, nonce)
+}
+
+func (e *ReturnNilMintRequestEVMMock) systemCalls() *SystemCalls {
+	return defaultSystemCalls(&e.mockEVMCallerData)
+}
+
 func TestFlareDaemonTriggerHandlesNilMintRequest(t *testing.T) {
 	mockEVMCallerData := &MockEVMCallerData{}
 	returnNilMintRequestEVMMock := &ReturnNilMintRequestEVMMock{
@@ -313,7 +495,7 @@ func TestFlareDaemonTriggerShouldNotMintMoreThanMax(t *testing.T) {
 		if err, ok := err.(*ErrMaxMintExceeded); !ok {
 			want := &ErrMaxMintExceeded{
 				mintRequest: mintRequest,
-				mintMax:     GetMaximumMintRequest(big.NewInt(0)),
+				mintMax:     GetMaximumMintRequest(big.NewInt(0), big.NewInt(0), big.NewInt(0)),
 			}
 			t.Errorf("got '%s' want '%s'", err.Error(), want.Error())
 		}
@@ -448,3 +630,91 @@ func TestFlareDaemonTriggerShouldNotMintMoreThanLimit(t *testing.T) {
 		t.Errorf("Add balance call count not as expected. got %d want 1", defaultEVMMock.mockEVMCallerData.addBalanceCalls)
 	}
 }
+
+// returnDataCode is runtime code that returns [data] whatever it is called with
+func returnDataCode(data []byte) []byte {
+	size := []byte{byte(len(data) >> 8), byte(len(data))}
+	code := []byte{0x61, size[0], size[1], 0x61, 0x00, 0x0f, 0x60, 0x00, 0x39, 0x61, size[0], size[1], 0x60, 0x00, 0xf3}
+	return append(code, data...)
+}
+
+// Apply [txCount] transfers in a block and finalise it, returning how many times the flareDaemon
+// minted, the execution recorded by FinalizeSystemCalls and the block
+func runFlareDaemonTestBlock(t *testing.T, config *params.ChainConfig, txCount int) (int64, *FlareDaemonExecution, *types.Block) {
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	daemon := GetFlareDaemonContract(config.ChainID, big.NewInt(1), big.NewInt(0))
+	statedb.SetCode(daemon, returnDataCode(common.BigToHash(big.NewInt(1000)).Bytes()))
+	header := &types.Header{
+		ParentHash: common.Hash{1},
+		Coinbase:   common.HexToAddress("0x0100000000000000000000000000000000000000"),
+		Number:     big.NewInt(1),
+		Time:       100,
+		GasLimit:   8000000,
+		Difficulty: common.Big1,
+		BaseFee:    big.NewInt(0),
+	}
+	gp := new(GasPool).AddGas(header.GasLimit)
+	to := common.Address{0xaa}
+	for i := 0; i < txCount; i++ {
+		evm := vm.NewEVM(NewEVMBlockContext(header, nil, &header.Coinbase), vm.TxContext{GasPrice: big.NewInt(0)}, statedb, config, vm.Config{})
+		msg := types.NewMessage(common.Address{0xbb}, &to, 0, big.NewInt(0), 21000, big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, true)
+		if _, err := ApplyMessage(evm, msg, gp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	header.GasUsed = header.GasLimit - gp.Gas()
+	execution := FinalizeSystemCalls(config, nil, header, statedb)
+	block := types.NewBlockWithHeader(header)
+	FinaliseBlockRecords(statedb, block.Hash())
+	return new(big.Int).Div(statedb.GetBalance(daemon), big.NewInt(1000)).Int64(), execution, block
+}
+
+func TestFinalizeFlareDaemon(t *testing.T) {
+	config := *params.TestChainConfig
+	config.ChainID = big.NewInt(987654323)
+	defer func() {
+		flareChainConfigsLock.Lock()
+		delete(flareChainConfigs, config.ChainID.Uint64())
+		flareChainConfigsLock.Unlock()
+	}()
+
+	tests := []struct {
+		perBlock  bool
+		txCount   int
+		wantMints int64
+	}{
+		{false, 0, 0},
+		{false, 1, 1},
+		{false, 200, 200},
+		{true, 0, 1},
+		{true, 1, 1},
+		{true, 200, 1},
+	}
+	for _, test := range tests {
+		perBlock := test.perBlock
+		genesis := genesisFlareFork()
+		genesis.FlareDaemonPerBlock = &perBlock
+		if err := SetFlareChainConfig(config.ChainID, &FlareChainConfig{Forks: FlareForkSchedule{genesis}}); err != nil {
+			t.Fatal(err)
+		}
+		mints, execution, block := runFlareDaemonTestBlock(t, &config, test.txCount)
+		if mints != test.wantMints {
+			t.Errorf("per block %v, %d txs: got %d mints want %d", test.perBlock, test.txCount, mints, test.wantMints)
+		}
+		switch {
+		case !test.perBlock && execution != nil:
+			t.Errorf("per block %v, %d txs: triggered at finalisation", test.perBlock, test.txCount)
+		case test.perBlock && (execution == nil || execution.ErrorClass != FlareDaemonOK || execution.GasUsed == 0 || execution.BlockGasUsed != uint64(test.txCount)*21000):
+			t.Errorf("per block %v, %d txs: got execution %+v", test.perBlock, test.txCount, execution)
+		}
+		// The transactions are fake messages, which are not recorded, the end of the block is
+		// recorded once for the accepted block
+		executions, err := AcceptFlareDaemonExecutions(rawdb.NewMemoryDatabase(), block)
+		if err != nil || (len(executions) == 1) != test.perBlock || (test.perBlock && executions[0] != execution) {
+			t.Errorf("per block %v, %d txs: got recorded (%v, %v)", test.perBlock, test.txCount, executions, err)
+		}
+	}
+}
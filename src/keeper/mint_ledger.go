@@ -0,0 +1,241 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package core
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Storage layout of the mint ledger account. Slot mintLedgerTotalSlot holds everything minted
+// since the ledger was activated at block mintLedgerActivationSlot. The rolling year is kept as
+// one bucket per epoch in a ring at keccak(mintLedgerBucketsSlot), bucket epoch % n holding what
+// was minted in that epoch, with n the number of epochs in a year. Slot mintLedgerYearSlot holds
+// the sum of the ring, which is brought up to date by clearing the buckets of the epochs that
+// passed since slot mintLedgerEpochSlot was written.
+const (
+	mintLedgerTotalSlot        = 0
+	mintLedgerEpochSlot        = 1
+	mintLedgerEpochSecondsSlot = 2
+	mintLedgerYearSlot         = 3
+	mintLedgerBucketsSlot      = 4
+	mintLedgerActivationSlot   = 5
+)
+
+var (
+	mintLedgerTotalSlotHash        = common.BigToHash(big.NewInt(mintLedgerTotalSlot))
+	mintLedgerEpochSlotHash        = common.BigToHash(big.NewInt(mintLedgerEpochSlot))
+	mintLedgerEpochSecondsSlotHash = common.BigToHash(big.NewInt(mintLedgerEpochSecondsSlot))
+	mintLedgerYearSlotHash         = common.BigToHash(big.NewInt(mintLedgerYearSlot))
+	mintLedgerActivationSlotHash   = common.BigToHash(big.NewInt(mintLedgerActivationSlot))
+	mintLedgerBucketsBase          = new(big.Int).SetBytes(crypto.Keccak256(common.BigToHash(big.NewInt(mintLedgerBucketsSlot)).Bytes()))
+)
+
+// ErrMintCapExceeded is returned for a mint request that would take the amount minted in the
+// current epoch or rolling year over its cap
+type ErrMintCapExceeded struct {
+	period      string
+	mintCap     *big.Int
+	minted      *big.Int
+	mintRequest *big.Int
+}
+
+func (e *ErrMintCapExceeded) Error() string {
+	return fmt.Sprintf("mint request of %s exceeded %s cap of %s with %s already minted", e.mintRequest.Text(10), e.period, e.mintCap.Text(10), e.minted.Text(10))
+}
+
+// MintLedger is the state of the mint ledger at a point in time
+type MintLedger struct {
+	ActivationBlock uint64   `json:"activationBlock"`
+	EpochSeconds    uint64   `json:"epochSeconds"`
+	Epoch           uint64   `json:"epoch"`
+	TotalMinted     *big.Int `json:"totalMinted"`
+	EpochMinted     *big.Int `json:"epochMinted"`
+	YearMinted      *big.Int `json:"yearMinted"`
+}
+
+// mintLedgerState is the part of the state that the mint ledger is kept in, implemented by both
+// EVMCaller and vm.StateDB
+type mintLedgerState interface {
+	GetState(addr common.Address, key common.Hash) common.Hash
+	SetState(addr common.Address, key common.Hash, value common.Hash)
+}
+
+// GetMintLedgerAddr returns the account whose storage holds the mint ledger. It has no code, the
+// ledger is only ever written by the state transition, which gives the account a nonce so that
+// it is not removed as empty.
+func GetMintLedgerAddr(chainID *big.Int, blockTime *big.Int) common.Address {
+	switch {
+	default:
+		return common.HexToAddress("0x1000000000000000000000000000000000000101")
+	}
+}
+
+// GetMintEpochSeconds returns the length of a mint epoch, zero while the mint ledger is not kept
+func GetMintEpochSeconds(chainID *big.Int, blockNumber *big.Int, blockTime *big.Int) uint64 {
+	return GetFlareParams(chainID, blockNumber, blockTime).MintEpochSeconds
+}
+
+// GetMaximumMintPerEpoch returns the most that may be minted in one mint epoch, nil if uncapped
+func GetMaximumMintPerEpoch(chainID *big.Int, blockNumber *big.Int, blockTime *big.Int) *big.Int {
+	return GetFlareParams(chainID, blockNumber, blockTime).MaximumMintPerEpoch
+}
+
+// GetMaximumMintPerYear returns the most that may be minted in the mint epochs of a rolling
+// year, nil if uncapped
+func GetMaximumMintPerYear(chainID *big.Int, blockNumber *big.Int, blockTime *big.Int) *big.Int {
+	return GetFlareParams(chainID, blockNumber, blockTime).MaximumMintPerYear
+}
+
+// mintEpochsPerYear returns the number of buckets of the rolling year for [epochSeconds]
+func mintEpochsPerYear(epochSeconds uint64) uint64 {
+	return (mintYearSeconds + epochSeconds - 1) / epochSeconds
+}
+
+func mintLedgerBucketSlot(epoch uint64, epochSeconds uint64) common.Hash {
+	return storageSlot(mintLedgerBucketsBase, new(big.Int).SetUint64(epoch%mintEpochsPerYear(epochSeconds)))
+}
+
+// ReadMintLedger returns the mint ledger kept in [statedb] as of [blockTime], when epochs are
+// [epochSeconds] long
+func ReadMintLedger(statedb mintLedgerState, ledger common.Address, epochSeconds uint64, blockTime uint64) MintLedger {
+	l := MintLedger{
+		ActivationBlock: statedb.GetState(ledger, mintLedgerActivationSlotHash).Big().Uint64(),
+		EpochSeconds:    epochSeconds,
+		TotalMinted:     statedb.GetState(ledger, mintLedgerTotalSlotHash).Big(),
+		EpochMinted:     new(big.Int),
+		YearMinted:      new(big.Int),
+	}
+	if epochSeconds == 0 {
+		return l
+	}
+	l.Epoch = blockTime / epochSeconds
+	if statedb.GetState(ledger, mintLedgerEpochSecondsSlotHash).Big().Uint64() != epochSeconds {
+		// The windows start over when the epoch length changes
+		return l
+	}
+	last := statedb.GetState(ledger, mintLedgerEpochSlotHash).Big().Uint64()
+	l.YearMinted = statedb.GetState(ledger, mintLedgerYearSlotHash).Big()
+	for _, epoch := range expiredMintEpochs(last, l.Epoch, epochSeconds) {
+		l.YearMinted.Sub(l.YearMinted, statedb.GetState(ledger, mintLedgerBucketSlot(epoch, epochSeconds)).Big())
+	}
+	if last == l.Epoch {
+		l.EpochMinted = statedb.GetState(ledger, mintLedgerBucketSlot(l.Epoch, epochSeconds)).Big()
+	}
+	return l
+}
+
+// expiredMintEpochs returns the epochs whose buckets are reused between epoch [last] and epoch
+// [current], at most one year of them
+func expiredMintEpochs(last uint64, current uint64, epochSeconds uint64) []uint64 {
+	if current <= last {
+		return nil
+	}
+	n := current - last
+	if perYear := mintEpochsPerYear(epochSeconds); n > perYear {
+		n = perYear
+	}
+	epochs := make([]uint64, 0, n)
+	for epoch := current - n + 1; epoch <= current; epoch++ {
+		epochs = append(epochs, epoch)
+	}
+	return epochs
+}
+
+// advanceMintLedger brings the ledger kept in [statedb] to the epoch of [blockTime], clearing
+// the buckets of the epochs that left the rolling year, and activates it at [blockNumber] if
+// it was not kept yet
+func advanceMintLedger(statedb mintLedgerState, ledger common.Address, epochSeconds uint64, blockNumber uint64, blockTime uint64) {
+	if statedb.GetState(ledger, mintLedgerActivationSlotHash) == (common.Hash{}) {
+		statedb.SetState(ledger, mintLedgerActivationSlotHash, common.BigToHash(new(big.Int).SetUint64(blockNumber)))
+	}
+	epoch := blockTime / epochSeconds
+	last := statedb.GetState(ledger, mintLedgerEpochSlotHash).Big().Uint64()
+	if previousSeconds := statedb.GetState(ledger, mintLedgerEpochSecondsSlotHash).Big().Uint64(); previousSeconds != epochSeconds {
+		if previousSeconds != 0 {
+			for i := uint64(0); i < mintEpochsPerYear(previousSeconds); i++ {
+				statedb.SetState(ledger, mintLedgerBucketSlot(i, previousSeconds), common.Hash{})
+			}
+		}
+		statedb.SetState(ledger, mintLedgerEpochSecondsSlotHash, common.BigToHash(new(big.Int).SetUint64(epochSeconds)))
+		statedb.SetState(ledger, mintLedgerYearSlotHash, common.Hash{})
+		statedb.SetState(ledger, mintLedgerEpochSlotHash, common.BigToHash(new(big.Int).SetUint64(epoch)))
+		return
+	}
+	expired := expiredMintEpochs(last, epoch, epochSeconds)
+	if len(expired) == 0 {
+		return
+	}
+	year := statedb.GetState(ledger, mintLedgerYearSlotHash).Big()
+	for _, e := range expired {
+		slot := mintLedgerBucketSlot(e, epochSeconds)
+		year.Sub(year, statedb.GetState(ledger, slot).Big())
+		statedb.SetState(ledger, slot, common.Hash{})
+	}
+	statedb.SetState(ledger, mintLedgerYearSlotHash, common.BigToHash(year))
+	statedb.SetState(ledger, mintLedgerEpochSlotHash, common.BigToHash(new(big.Int).SetUint64(epoch)))
+}
+
+// checkMintCaps returns an ErrMintCapExceeded if minting [mintRequest] would exceed the epoch
+// or year cap in effect
+func checkMintCaps(evm EVMCaller, mintRequest *big.Int) error {
+	chainID, blockNumber, blockTime := evm.GetChainID(), evm.GetBlockNumber(), evm.GetBlockTime()
+	epochSeconds := GetMintEpochSeconds(chainID, blockNumber, blockTime)
+	if epochSeconds == 0 {
+		return nil
+	}
+	l := ReadMintLedger(evm, GetMintLedgerAddr(chainID, blockTime), epochSeconds, blockTime.Uint64())
+	for _, limit := range []struct {
+		period string
+		cap    *big.Int
+		minted *big.Int
+	}{
+		{"epoch", GetMaximumMintPerEpoch(chainID, blockNumber, blockTime), l.EpochMinted},
+		{"year", GetMaximumMintPerYear(chainID, blockNumber, blockTime), l.YearMinted},
+	} {
+		if limit.cap != nil && new(big.Int).Add(limit.minted, mintRequest).Cmp(limit.cap) > 0 {
+			return &ErrMintCapExceeded{period: limit.period, mintCap: limit.cap, minted: limit.minted, mintRequest: mintRequest}
+		}
+	}
+	return nil
+}
+
+// recordMint adds [minted] to the mint ledger, if it is kept
+func recordMint(evm EVMCaller, minted *big.Int) {
+	chainID, blockNumber, blockTime := evm.GetChainID(), evm.GetBlockNumber(), evm.GetBlockTime()
+	epochSeconds := GetMintEpochSeconds(chainID, blockNumber, blockTime)
+	if epochSeconds == 0 {
+		return
+	}
+	ledger := GetMintLedgerAddr(chainID, blockTime)
+	advanceMintLedger(evm, ledger, epochSeconds, blockNumber.Uint64(), blockTime.Uint64())
+	// An account without nonce, balance or code is removed at the end of the transaction
+	if evm.GetNonce(ledger) == 0 {
+		evm.SetNonce(ledger, 1)
+	}
+	if minted.Sign() == 0 {
+		return
+	}
+	for _, slot := range []common.Hash{
+		mintLedgerTotalSlotHash,
+		mintLedgerYearSlotHash,
+		mintLedgerBucketSlot(blockTime.Uint64()/epochSeconds, epochSeconds),
+	} {
+		evm.SetState(ledger, slot, common.BigToHash(new(big.Int).Add(evm.GetState(ledger, slot).Big(), minted)))
+	}
+}
+
+// GenesisAllocSupply returns the sum of the balances allocated in [alloc]
+func GenesisAllocSupply(alloc GenesisAlloc) *big.Int {
+	supply := new(big.Int)
+	for _, account := range alloc {
+		if account.Balance != nil {
+			supply.Add(supply, account.Balance)
+		}
+	}
+	return supply
+}
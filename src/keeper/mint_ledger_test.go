@@ -0,0 +1,166 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package core
+
+import (
+	"errors"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/ava-labs/coreth/core/rawdb"
+	"github.com/ava-labs/coreth/core/state"
+	"github.com/ava-labs/coreth/core/types"
+	"github.com/ava-labs/coreth/core/vm"
+	"github.com/ava-labs/coreth/params"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func setMintLedgerChainConfig(t *testing.T, chainID *big.Int, epochSeconds uint64, epochCap *big.Int, yearCap *big.Int) {
+	genesis := genesisFlareFork()
+	genesis.MintEpochSeconds = &epochSeconds
+	genesis.MaximumMintPerEpoch = epochCap
+	genesis.MaximumMintPerYear = yearCap
+	if err := SetFlareChainConfig(chainID, &FlareChainConfig{Forks: FlareForkSchedule{genesis}}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		flareChainConfigsLock.Lock()
+		delete(flareChainConfigs, chainID.Uint64())
+		flareChainConfigsLock.Unlock()
+	})
+}
+
+func TestExpiredMintEpochs(t *testing.T) {
+	tests := []struct {
+		last, current, epochSeconds uint64
+		want                        []uint64
+	}{
+		{5, 5, 3600, nil},
+		{5, 4, 3600, nil},
+		{5, 7, 3600, []uint64{6, 7}},
+		{0, 100, 30 * 24 * 3600, []uint64{88, 89, 90, 91, 92, 93, 94, 95, 96, 97, 98, 99, 100}},
+	}
+	for _, test := range tests {
+		if got := expiredMintEpochs(test.last, test.current, test.epochSeconds); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("expiredMintEpochs(%d, %d, %d) = %v want %v", test.last, test.current, test.epochSeconds, got, test.want)
+		}
+	}
+}
+
+func TestMintCaps(t *testing.T) {
+	chainID := big.NewInt(987654322)
+	setMintLedgerChainConfig(t, chainID, 3600, big.NewInt(100), big.NewInt(250))
+	evm := &DefaultEVMMock{mockEVMCallerData: MockEVMCallerData{chainID: *chainID, blockNumber: *big.NewInt(3)}}
+	ledger := GetMintLedgerAddr(chainID, big.NewInt(0))
+
+	steps := []struct {
+		time    int64
+		request int64
+		wantCap string
+	}{
+		{10, 60, ""},
+		{20, 50, "epoch"},
+		{30, 40, ""},
+		{3600, 100, ""},
+		{7200, 100, "year"},
+		{7300, 50, ""},
+		{mintYearSeconds, 100, ""},
+	}
+	for _, step := range steps {
+		evm.mockEVMCallerData.blockTime.SetInt64(step.time)
+		err := mint(evm, big.NewInt(step.request))
+		var capErr *ErrMintCapExceeded
+		switch {
+		case step.wantCap == "" && err != nil:
+			t.Errorf("mint of %d at %d: unexpected error %v", step.request, step.time, err)
+		case step.wantCap != "" && (!errors.As(err, &capErr) || capErr.period != step.wantCap):
+			t.Errorf("mint of %d at %d: got %v want %s cap error", step.request, step.time, err, step.wantCap)
+		}
+	}
+
+	// The first year ends at the last step, which reuses the bucket of epoch 0
+	got := ReadMintLedger(evm, ledger, 3600, mintYearSeconds)
+	want := MintLedger{ActivationBlock: 3, EpochSeconds: 3600, Epoch: mintYearSeconds / 3600, TotalMinted: big.NewInt(350), EpochMinted: big.NewInt(100), YearMinted: big.NewInt(250)}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ledger: got %+v want %+v", got, want)
+	}
+	// Reading ahead of the last mint expires the epochs in between
+	got = ReadMintLedger(evm, ledger, 3600, mintYearSeconds+2*3600)
+	if got.EpochMinted.Sign() != 0 || got.YearMinted.Cmp(big.NewInt(100)) != 0 {
+		t.Errorf("ledger ahead: got %+v", got)
+	}
+	// A new epoch length starts the windows over
+	got = ReadMintLedger(evm, ledger, 7200, mintYearSeconds)
+	if got.EpochMinted.Sign() != 0 || got.YearMinted.Sign() != 0 || got.TotalMinted.Cmp(big.NewInt(350)) != 0 {
+		t.Errorf("ledger with new epoch length: got %+v", got)
+	}
+}
+
+func TestMintLedgerSurvivesFinalise(t *testing.T) {
+	config := *params.TestChainConfig
+	config.ChainID = big.NewInt(987654351)
+	setMintLedgerChainConfig(t, config.ChainID, 3600, big.NewInt(1500), nil)
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	daemon := GetFlareDaemonContract(config.ChainID, big.NewInt(1), big.NewInt(0))
+	statedb.SetCode(daemon, returnDataCode(common.BigToHash(big.NewInt(1000)).Bytes()))
+	header := &types.Header{
+		ParentHash: common.Hash{1},
+		Coinbase:   common.HexToAddress("0x0100000000000000000000000000000000000000"),
+		Number:     big.NewInt(1),
+		Time:       100,
+		GasLimit:   8000000,
+		Difficulty: common.Big1,
+		BaseFee:    big.NewInt(0),
+	}
+	gp := new(GasPool).AddGas(header.GasLimit)
+	to := common.Address{0xaa}
+
+	// Each transaction triggers a mint of 1000, the second one would take the epoch over its
+	// cap. The state is finalised after each transaction, as when a block is processed, which
+	// removes the accounts left empty.
+	for i := 0; i < 2; i++ {
+		evm := vm.NewEVM(NewEVMBlockContext(header, nil, &header.Coinbase), vm.TxContext{GasPrice: big.NewInt(0)}, statedb, &config, vm.Config{})
+		msg := types.NewMessage(common.Address{0xbb}, &to, 0, big.NewInt(0), 21000, big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, true)
+		if _, err := ApplyMessage(evm, msg, gp); err != nil {
+			t.Fatal(err)
+		}
+		statedb.Finalise(true)
+	}
+	if got := statedb.GetBalance(daemon); got.Cmp(big.NewInt(1000)) != 0 {
+		t.Errorf("got minted %s want 1000", got)
+	}
+	ledger := GetMintLedgerAddr(config.ChainID, big.NewInt(100))
+	if !statedb.Exist(ledger) {
+		t.Fatal("mint ledger account removed")
+	}
+	l := ReadMintLedger(statedb, ledger, 3600, 100)
+	if l.ActivationBlock != 1 || l.TotalMinted.Cmp(big.NewInt(1000)) != 0 || l.EpochMinted.Cmp(big.NewInt(1000)) != 0 {
+		t.Errorf("got ledger %+v", l)
+	}
+}
+
+func TestMintWithoutLedger(t *testing.T) {
+	evm := &DefaultEVMMock{}
+	if err := mint(evm, big.NewInt(1000)); err != nil {
+		t.Fatal(err)
+	}
+	if len(evm.mockEVMCallerData.storage) != 0 {
+		t.Errorf("mint ledger written while not kept: %v", evm.mockEVMCallerData.storage)
+	}
+}
+
+func TestGenesisAllocSupply(t *testing.T) {
+	alloc := GenesisAlloc{
+		common.Address{1}: {Balance: big.NewInt(10)},
+		common.Address{2}: {Balance: big.NewInt(32)},
+		common.Address{3}: {Code: []byte{0}},
+	}
+	if got := GenesisAllocSupply(alloc); got.Cmp(big.NewInt(42)) != 0 {
+		t.Errorf("got %s want 42", got)
+	}
+}
@@ -0,0 +1,310 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package core
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ava-labs/coreth/core/types"
+	"github.com/ava-labs/coreth/core/vm"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// Kinds of SystemCallTrace
+const (
+	SystemCallFlareDaemon    = "flareDaemon"    // the flareDaemon trigger
+	SystemCallAttestorLookup = "attestorLookup" // the FTSO lookups of the attestor candidates
+	SystemCallAttestorWeight = "attestorWeight" // the vote powers of the attestors
+	SystemCallFinaliseRound  = "finaliseRound"  // finaliseRound on the StateConnector
+	SystemCallAttestorReward = "attestorReward" // the attestor reward contract
+	SystemCallHook           = "hook"           // a system hook of the fork schedule
+)
+
+var (
+	ErrSystemCallGasExhausted = errors.New("system call gas budget exhausted")
+
+	systemCallRecordPrefix = []byte("systemcall-record-") // systemCallRecordPrefix + blockNumber (uint64 big endian) -> []*SystemCallTrace
+
+	// Read-only system calls are made from the zero address once the gas budget is activated,
+	// rather than from the sender of the transaction that caused them
+	systemCaller = vm.AccountRef(common.Address{})
+
+	// Whether the calls made by each system call are recorded with its trace
+	systemCallTracingLock sync.RWMutex
+	systemCallTracing     bool
+)
+
+// GetSystemCallGasBudget returns the gas that the system calls of each kind may use during one
+// transaction or at the end of one block, zero before the budget is activated
+func GetSystemCallGasBudget(chainID *big.Int, blockNumber *big.Int, blockTime *big.Int) uint64 {
+	return GetFlareParams(chainID, blockNumber, blockTime).SystemCallGasBudget
+}
+
+// SystemCallTrace records a call that the chain made on its own behalf. It is kept apart from
+// the trace and the gas of the transaction during which it was made.
+type SystemCallTrace struct {
+	BlockNumber uint64 `json:"blockNumber"`
+	// Gas used by the block up to and including the transaction during which the call was made,
+	// or by the whole block for the calls made at its end
+	BlockGasUsed uint64         `json:"blockGasUsed"`
+	BlockEnd     bool           `json:"blockEnd"`
+	Kind         string         `json:"kind"`
+	Caller       common.Address `json:"caller"`
+	To           common.Address `json:"to"`
+	Selector     hexutil.Bytes  `json:"selector"`
+	Gas          uint64         `json:"gas"`
+	GasUsed      uint64         `json:"gasUsed"`
+	Error        string         `json:"error"`
+	// Name of the system hook, for calls of kind hook
+	Hook string `json:"hook,omitempty"`
+	// Calls made by the system call, recorded once system call tracing is enabled
+	Calls []*SystemCallFrame `json:"calls,omitempty"`
+}
+
+// SystemCallFrame is a call made by a system call, in the format of the call tracer
+type SystemCallFrame struct {
+	Type    string             `json:"type"`
+	From    common.Address     `json:"from"`
+	To      common.Address     `json:"to"`
+	Input   hexutil.Bytes      `json:"input"`
+	Gas     uint64             `json:"gas"`
+	GasUsed uint64             `json:"gasUsed"`
+	Output  hexutil.Bytes      `json:"output"`
+	Error   string             `json:"error,omitempty"`
+	Calls   []*SystemCallFrame `json:"calls,omitempty"`
+}
+
+// SetSystemCallTracing sets whether the calls made by each system call are recorded with its
+// trace, from the node config
+func SetSystemCallTracing(enabled bool) {
+	systemCallTracingLock.Lock()
+	systemCallTracing = enabled
+	systemCallTracingLock.Unlock()
+}
+
+func getSystemCallTracing() bool {
+	systemCallTracingLock.RLock()
+	defer systemCallTracingLock.RUnlock()
+	return systemCallTracing
+}
+
+// systemCallTracer records the calls made by a system call. The system call itself is recorded
+// by its SystemCallTrace.
+type systemCallTracer struct {
+	calls []*SystemCallFrame
+	// Calls that have been entered and not exited yet
+	stack []*SystemCallFrame
+}
+
+func (t *systemCallTracer) CaptureStart(env *vm.EVM, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) {
+}
+
+func (t *systemCallTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, rData []byte, depth int, err error) {
+}
+
+func (t *systemCallTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, depth int, err error) {
+}
+
+func (t *systemCallTracer) CaptureEnd(output []byte, gasUsed uint64, d time.Duration, err error) {
+}
+
+func (t *systemCallTracer) CaptureEnter(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+	frame := &SystemCallFrame{Type: typ.String(), From: from, To: to, Input: common.CopyBytes(input), Gas: gas}
+	if len(t.stack) == 0 {
+		t.calls = append(t.calls, frame)
+	} else {
+		parent := t.stack[len(t.stack)-1]
+		parent.Calls = append(parent.Calls, frame)
+	}
+	t.stack = append(t.stack, frame)
+}
+
+func (t *systemCallTracer) CaptureExit(output []byte, gasUsed uint64, err error) {
+	if len(t.stack) == 0 {
+		return
+	}
+	frame := t.stack[len(t.stack)-1]
+	t.stack = t.stack[:len(t.stack)-1]
+	frame.GasUsed, frame.Output = gasUsed, common.CopyBytes(output)
+	if err != nil {
+		frame.Error = err.Error()
+	}
+}
+
+// systemCallEVM is the part of the EVM that system calls need, implemented by both *vm.EVM and
+// EVMCaller
+type systemCallEVM interface {
+	Call(caller vm.ContractRef, addr common.Address, input []byte, gas uint64, value *big.Int) (ret []byte, leftOverGas uint64, err error)
+}
+
+// SystemCalls is the execution context of the system calls made during one transaction or at
+// the end of one block. Once a fork sets systemCallGasBudget the calls of each kind get that
+// budget, so that calls of one kind cannot use up the gas of another, before that each call
+// gets the legacy gas of its kind. The gas is never charged to a sender.
+type SystemCalls struct {
+	budgeted bool
+	budget   uint64
+	// Gas used by the calls of each kind
+	gasUsed map[string]uint64
+	// Config of the EVM that makes the calls, nil if it has none
+	config *vm.Config
+	traces []*SystemCallTrace
+}
+
+func newSystemCalls(chainID *big.Int, blockNumber *big.Int, blockTime *big.Int, config *vm.Config) *SystemCalls {
+	budget := GetSystemCallGasBudget(chainID, blockNumber, blockTime)
+	return &SystemCalls{budgeted: budget > 0, budget: budget, gasUsed: make(map[string]uint64), config: config}
+}
+
+// gasLeft returns what is left of the gas budget of [kind]
+func (s *SystemCalls) gasLeft(kind string) uint64 {
+	return s.budget - s.gasUsed[kind]
+}
+
+// caller returns the account that a read-only system call is made from, [legacy] until the gas
+// budget is activated
+func (s *SystemCalls) caller(legacy vm.ContractRef) vm.ContractRef {
+	if s.budgeted {
+		return systemCaller
+	}
+	return legacy
+}
+
+// call makes a system call of [kind] with no value, returning what it returned and the gas it
+// used. [legacyGas] is the gas given to the call until the gas budget is activated.
+func (s *SystemCalls) call(evm systemCallEVM, kind string, caller vm.ContractRef, to common.Address, input []byte, legacyGas uint64) ([]byte, uint64, error) {
+	gas := legacyGas
+	if s.budgeted {
+		gas = s.gasLeft(kind)
+	}
+	return s.callWithGas(evm, &SystemCallTrace{Kind: kind}, caller, to, input, gas)
+}
+
+// callWithGas makes a system call given [gas], or what is left of the gas budget of the kind of
+// [trace] if that is less, and records it in [trace]
+func (s *SystemCalls) callWithGas(evm systemCallEVM, trace *SystemCallTrace, caller vm.ContractRef, to common.Address, input []byte, gas uint64) ([]byte, uint64, error) {
+	if s.budgeted && gas > s.gasLeft(trace.Kind) {
+		gas = s.gasLeft(trace.Kind)
+	}
+	trace.Caller, trace.To, trace.Gas = caller.Address(), to, gas
+	if len(input) >= 4 {
+		trace.Selector = common.CopyBytes(input[:4])
+	}
+	s.traces = append(s.traces, trace)
+	if s.budgeted && gas == 0 {
+		trace.Error = ErrSystemCallGasExhausted.Error()
+		return nil, 0, ErrSystemCallGasExhausted
+	}
+	ret, leftOverGas, err := s.traceCall(evm, trace, caller, to, input, gas)
+	if leftOverGas < gas {
+		trace.GasUsed = gas - leftOverGas
+	}
+	if s.budgeted {
+		if s.gasUsed == nil {
+			s.gasUsed = make(map[string]uint64)
+		}
+		s.gasUsed[trace.Kind] += trace.GasUsed
+	}
+	if err != nil {
+		trace.Error = err.Error()
+	}
+	return ret, trace.GasUsed, err
+}
+
+// traceCall makes the call of [trace]. The tracer of the EVM, which traces the transaction
+// during which the call is made, does not see it. Once system call tracing is enabled, the calls
+// it makes are recorded in [trace] instead.
+func (s *SystemCalls) traceCall(evm systemCallEVM, trace *SystemCallTrace, caller vm.ContractRef, to common.Address, input []byte, gas uint64) ([]byte, uint64, error) {
+	if s.config == nil {
+		return evm.Call(caller, to, input, gas, big.NewInt(0))
+	}
+	debug, tracer := s.config.Debug, s.config.Tracer
+	defer func() {
+		s.config.Debug, s.config.Tracer = debug, tracer
+	}()
+	if !getSystemCallTracing() {
+		s.config.Debug = false
+		return evm.Call(caller, to, input, gas, big.NewInt(0))
+	}
+	calls := &systemCallTracer{}
+	s.config.Debug, s.config.Tracer = true, calls
+	ret, leftOverGas, err := evm.Call(caller, to, input, gas, big.NewInt(0))
+	trace.Calls = calls.calls
+	return ret, leftOverGas, err
+}
+
+func (st *StateTransition) systemCalls() *SystemCalls {
+	if st.sysCalls == nil {
+		st.sysCalls = newSystemCalls(st.evm.ChainConfig().ChainID, st.evm.Context.BlockNumber, st.evm.Context.Time, &st.evm.Config)
+	}
+	return st.sysCalls
+}
+
+// recordSystemCalls keeps the system calls made during the transaction of [st] until its block
+// is accepted
+func (st *StateTransition) recordSystemCalls() {
+	if st.sysCalls == nil || st.msg.IsFake() {
+		return
+	}
+	number := st.evm.Context.BlockNumber.Uint64()
+	if number == 0 {
+		return
+	}
+	addSystemCallRecords(st.state, number, st.evm.Context.GasLimit-st.gp.Gas(), false, st.sysCalls)
+}
+
+// addSystemCallRecords keeps the traces of [calls], made during a transaction or at the end of
+// the block at height [number] that is executed on [statedb], until that block is accepted
+func addSystemCallRecords(statedb vm.StateDB, number uint64, blockGasUsed uint64, blockEnd bool, calls *SystemCalls) {
+	for _, trace := range calls.traces {
+		trace.BlockNumber, trace.BlockGasUsed, trace.BlockEnd = number, blockGasUsed, blockEnd
+		addBlockRecord(statedb, number, blockRecordSystemCall, trace)
+	}
+}
+
+// AcceptSystemCallTraces stores the system calls of the accepted [block] in [db], in the order
+// they were made
+func AcceptSystemCallTraces(db ethdb.KeyValueWriter, block *types.Block) ([]*SystemCallTrace, error) {
+	records := takeBlockRecords(block, blockRecordSystemCall)
+	traces := make([]*SystemCallTrace, len(records))
+	for i, record := range records {
+		traces[i] = record.(*SystemCallTrace)
+	}
+	if len(traces) == 0 {
+		return traces, nil
+	}
+	enc, err := rlp.EncodeToBytes(traces)
+	if err != nil {
+		return traces, err
+	}
+	return traces, db.Put(systemCallRecordKey(block.NumberU64()), enc)
+}
+
+func systemCallRecordKey(blockNumber uint64) []byte {
+	key := make([]byte, len(systemCallRecordPrefix)+8)
+	copy(key, systemCallRecordPrefix)
+	binary.BigEndian.PutUint64(key[len(systemCallRecordPrefix):], blockNumber)
+	return key
+}
+
+// ReadSystemCallTraces returns the system calls of accepted block [blockNumber], or nil if none
+// were recorded
+func ReadSystemCallTraces(db ethdb.KeyValueReader, blockNumber uint64) ([]*SystemCallTrace, error) {
+	enc, err := readRecord(db, systemCallRecordKey(blockNumber))
+	if err != nil || len(enc) == 0 {
+		return nil, err
+	}
+	var traces []*SystemCallTrace
+	if err := rlp.DecodeBytes(enc, &traces); err != nil {
+		return nil, err
+	}
+	return traces, nil
+}
@@ -0,0 +1,168 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package core
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ava-labs/coreth/core/rawdb"
+	"github.com/ava-labs/coreth/core/state"
+	"github.com/ava-labs/coreth/core/types"
+	"github.com/ava-labs/coreth/core/vm"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Define a mock EVM whose calls use up to [gasUsed] gas each
+type GasBurningEVMMock struct {
+	gasUsed uint64
+	calls   int
+}
+
+func (e *GasBurningEVMMock) Call(caller vm.ContractRef, addr common.Address, input []byte, gas uint64, value *big.Int) (ret []byte, leftOverGas uint64, err error) {
+	e.calls++
+	if gas < e.gasUsed {
+		return nil, 0, vm.ErrOutOfGas
+	}
+	return nil, gas - e.gasUsed, nil
+}
+
+func TestSystemCallsBudget(t *testing.T) {
+	evm := &GasBurningEVMMock{gasUsed: 100}
+	user := vm.AccountRef(common.Address{0xbb})
+	to := common.Address{0xaa}
+	calls := &SystemCalls{budgeted: true, budget: 250}
+
+	tests := []struct {
+		wantGas     uint64
+		wantGasUsed uint64
+		wantErr     error
+	}{
+		{250, 100, nil},
+		{150, 100, nil},
+		{50, 50, vm.ErrOutOfGas},
+		{0, 0, ErrSystemCallGasExhausted},
+	}
+	for i, test := range tests {
+		_, gasUsed, err := calls.call(evm, SystemCallAttestorWeight, calls.caller(user), to, []byte{1, 2, 3, 4, 5}, 1000000)
+		trace := calls.traces[i]
+		if gasUsed != test.wantGasUsed || err != test.wantErr || trace.Gas != test.wantGas || trace.GasUsed != test.wantGasUsed {
+			t.Errorf("call %d: got (%d, %v) trace %+v", i, gasUsed, err, trace)
+		}
+		if trace.Caller != (common.Address{}) || trace.Kind != SystemCallAttestorWeight || len(trace.Selector) != 4 {
+			t.Errorf("call %d: got trace %+v", i, trace)
+		}
+	}
+	if evm.calls != 3 {
+		t.Errorf("got %d calls want 3, the exhausted budget must not reach the EVM", evm.calls)
+	}
+
+	// The calls of another kind have a budget of their own
+	if _, gasUsed, err := calls.call(evm, SystemCallFlareDaemon, calls.caller(user), to, nil, 1000000); gasUsed != 100 || err != nil || calls.traces[4].Gas != 250 {
+		t.Errorf("flareDaemon call: got (%d, %v) trace %+v", gasUsed, err, calls.traces[4])
+	}
+
+	legacy := &SystemCalls{}
+	for i := 0; i < 3; i++ {
+		if _, gasUsed, err := legacy.call(evm, SystemCallFlareDaemon, legacy.caller(user), to, nil, 1000); gasUsed != 100 || err != nil {
+			t.Errorf("legacy call %d: got (%d, %v)", i, gasUsed, err)
+		}
+	}
+	for _, trace := range legacy.traces {
+		if trace.Gas != 1000 || trace.Caller != user.Address() || trace.Selector != nil {
+			t.Errorf("legacy trace: got %+v", trace)
+		}
+	}
+}
+
+// Define a mock EVM that records the config its calls are made with, and reports a call made
+// by each call to the system call tracer
+type ConfigRecordingEVMMock struct {
+	config *vm.Config
+	debug  bool
+	tracer vm.Tracer
+}
+
+func (e *ConfigRecordingEVMMock) Call(caller vm.ContractRef, addr common.Address, input []byte, gas uint64, value *big.Int) (ret []byte, leftOverGas uint64, err error) {
+	e.debug, e.tracer = e.config.Debug, e.config.Tracer
+	if tracer, ok := e.config.Tracer.(*systemCallTracer); ok {
+		tracer.CaptureEnter(vm.STATICCALL, addr, common.Address{0xcc}, []byte{9}, 500, nil)
+		tracer.CaptureExit([]byte{1}, 300, nil)
+	}
+	return nil, gas - 400, nil
+}
+
+func TestSystemCallTracing(t *testing.T) {
+	defer SetSystemCallTracing(false)
+	txTracer := vm.NewStructLogger(nil)
+	config := &vm.Config{Debug: true, Tracer: txTracer}
+	evm := &ConfigRecordingEVMMock{config: config}
+	calls := &SystemCalls{config: config}
+	to := common.Address{0xaa}
+
+	// The tracer of the transaction does not see the call
+	calls.call(evm, SystemCallHook, vm.AccountRef(to), to, nil, 1000)
+	if evm.debug || calls.traces[0].Calls != nil {
+		t.Errorf("tracing disabled: got debug %v and calls %+v", evm.debug, calls.traces[0].Calls)
+	}
+
+	SetSystemCallTracing(true)
+	calls.call(evm, SystemCallHook, vm.AccountRef(to), to, nil, 1000)
+	if _, ok := evm.tracer.(*systemCallTracer); !evm.debug || !ok {
+		t.Errorf("tracing enabled: got debug %v and tracer %T", evm.debug, evm.tracer)
+	}
+	frames := calls.traces[1].Calls
+	if len(frames) != 1 || frames[0].Type != "STATICCALL" || frames[0].From != to || frames[0].Gas != 500 || frames[0].GasUsed != 300 || len(frames[0].Output) != 1 {
+		t.Errorf("tracing enabled: got calls %+v", frames)
+	}
+	if !config.Debug || config.Tracer != txTracer {
+		t.Errorf("transaction tracer not restored: got %+v", config)
+	}
+}
+
+func TestAcceptSystemCallTraces(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	block := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(8), ParentHash: common.Hash{3}, Time: 80})
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trace := func(kind string) *SystemCallTrace { return &SystemCallTrace{Kind: kind} }
+	addSystemCallRecords(statedb, 8, 42000, false, &SystemCalls{traces: []*SystemCallTrace{trace(SystemCallAttestorReward)}})
+	addSystemCallRecords(statedb, 8, 90000, false, &SystemCalls{traces: []*SystemCallTrace{trace(SystemCallAttestorLookup), trace(SystemCallFinaliseRound)}})
+	addSystemCallRecords(statedb, 8, 90000, true, &SystemCalls{traces: []*SystemCallTrace{trace(SystemCallFlareDaemon)}})
+	// A transaction of another execution of the block, which is rejected, uses as much gas
+	other, err := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addSystemCallRecords(other, 8, 42000, false, &SystemCalls{traces: []*SystemCallTrace{trace(SystemCallHook)}})
+	FinaliseBlockRecords(other, common.Hash{8})
+	FinaliseBlockRecords(statedb, block.Hash())
+
+	traces, err := AcceptSystemCallTraces(db, block)
+	want := []string{SystemCallAttestorReward, SystemCallAttestorLookup, SystemCallFinaliseRound, SystemCallFlareDaemon}
+	if err != nil || len(traces) != len(want) {
+		t.Fatalf("got (%v, %v)", traces, err)
+	}
+	stored, err := ReadSystemCallTraces(db, 8)
+	if err != nil || len(stored) != len(want) {
+		t.Fatalf("stored: got (%v, %v)", stored, err)
+	}
+	for i, kind := range want {
+		if stored[i].Kind != kind || stored[i].BlockNumber != 8 {
+			t.Errorf("trace %d: got %+v want kind %s", i, stored[i], kind)
+		}
+	}
+	if !stored[3].BlockEnd || stored[3].BlockGasUsed != 90000 || stored[1].BlockEnd {
+		t.Errorf("sources: got %+v and %+v", stored[1], stored[3])
+	}
+	if traces, err := ReadSystemCallTraces(db, 9); traces != nil || err != nil {
+		t.Errorf("unaccepted block: got (%v, %v)", traces, err)
+	}
+	if traces, err := ReadSystemCallTraces(FailingDBMock{}, 8); traces != nil || err != errRoundsTestDB {
+		t.Errorf("failing database: got (%v, %v)", traces, err)
+	}
+}
@@ -0,0 +1,154 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ava-labs/coreth/core/vm"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// Triggers of a SystemHook
+const (
+	SystemHookPerBlock       = "block"       // once at the end of every block
+	SystemHookPerTransaction = "transaction" // after every successful transaction
+	SystemHookOnSelector     = "selector"    // after every successful transaction that calls onSelector on onContract
+)
+
+// SystemHook is a protocol contract that the chain calls on its own behalf. The hooks in effect
+// are listed by the systemHooks parameter of the fork schedule, so a hook is activated, changed
+// or removed by a fork. A hook is called from its own address with [Selector] and no arguments,
+// as a system call given at most [Gas].
+type SystemHook struct {
+	Name       string          `json:"name"`
+	Contract   common.Address  `json:"contract"`
+	Selector   hexutil.Bytes   `json:"selector"`
+	Trigger    string          `json:"trigger"`
+	OnContract *common.Address `json:"onContract,omitempty"`
+	OnSelector hexutil.Bytes   `json:"onSelector,omitempty"`
+	Gas        uint64          `json:"gas"`
+}
+
+func (h *SystemHook) validate() error {
+	switch {
+	case h.Name == "":
+		return errors.New("name must be set")
+	case h.Contract == (common.Address{}):
+		return errors.New("contract must be set")
+	case len(h.Selector) != 4:
+		return errors.New("selector must be 4 bytes")
+	case h.Gas == 0:
+		return errors.New("gas must be positive")
+	}
+	switch h.Trigger {
+	case SystemHookPerBlock, SystemHookPerTransaction:
+		if h.OnContract != nil || h.OnSelector != nil {
+			return fmt.Errorf("onContract and onSelector only apply to the %q trigger", SystemHookOnSelector)
+		}
+	case SystemHookOnSelector:
+		if h.OnContract == nil || *h.OnContract == (common.Address{}) || len(h.OnSelector) != 4 {
+			return errors.New("onContract and a 4 byte onSelector must be set")
+		}
+	default:
+		return fmt.Errorf("unknown trigger %q", h.Trigger)
+	}
+	return nil
+}
+
+// copy returns a copy of [h] that shares none of its slices or pointers
+func (h SystemHook) copy() SystemHook {
+	h.Selector = common.CopyBytes(h.Selector)
+	h.OnSelector = common.CopyBytes(h.OnSelector)
+	if h.OnContract != nil {
+		onContract := *h.OnContract
+		h.OnContract = &onContract
+	}
+	return h
+}
+
+// validateSystemHooks checks every hook of a fork and that their names are unique
+func validateSystemHooks(hooks []SystemHook) error {
+	names := make(map[string]bool)
+	for i := range hooks {
+		if err := hooks[i].validate(); err != nil {
+			return fmt.Errorf("system hook %d: %w", i, err)
+		}
+		if names[hooks[i].Name] {
+			return fmt.Errorf("system hook %q defined twice", hooks[i].Name)
+		}
+		names[hooks[i].Name] = true
+	}
+	return nil
+}
+
+// GetSystemHooks returns the system hooks in effect, in the order they are called
+func GetSystemHooks(chainID *big.Int, blockNumber *big.Int, blockTime *big.Int) []SystemHook {
+	return GetFlareParams(chainID, blockNumber, blockTime).SystemHooks
+}
+
+// callSystemHook calls [hook] as a system call of [calls]. A failing hook is logged and does
+// not affect the transaction or the block.
+func (s *SystemCalls) callSystemHook(evm systemCallEVM, hook *SystemHook) {
+	trace := &SystemCallTrace{Kind: SystemCallHook, Hook: hook.Name}
+	if _, _, err := s.callWithGas(evm, trace, vm.AccountRef(hook.Contract), hook.Contract, hook.Selector, hook.Gas); err != nil {
+		log.Warn("System hook failed", "hook", hook.Name, "error", err)
+	}
+}
+
+// systemHookTx is the part of a successful transaction that system hooks are triggered by
+type systemHookTx struct {
+	to   *common.Address
+	data []byte
+}
+
+// triggeredBy returns whether [h] is triggered by the successful transaction [tx], or by the end
+// of the block if [tx] is nil. Only the transaction itself is matched: a call of onSelector made
+// by a contract during the transaction does not trigger the hook.
+func (h *SystemHook) triggeredBy(tx *systemHookTx) bool {
+	switch h.Trigger {
+	case SystemHookPerBlock:
+		return tx == nil
+	case SystemHookPerTransaction:
+		return tx != nil
+	case SystemHookOnSelector:
+		return tx != nil && tx.to != nil && *tx.to == *h.OnContract && bytes.HasPrefix(tx.data, h.OnSelector)
+	default:
+		return false
+	}
+}
+
+// runSystemHooks makes the system calls triggered by the successful transaction [tx], or by the
+// end of the block if [tx] is nil, in [evm]: the flareDaemon, when the fork schedule triggers it
+// there, and then the system hooks that [tx] triggers, in order. It returns the flareDaemon
+// execution if the trigger ran.
+func runSystemHooks(evm EVMCaller, tx *systemHookTx) *FlareDaemonExecution {
+	chainID, blockNumber, blockTime := evm.GetChainID(), evm.GetBlockNumber(), evm.GetBlockTime()
+	var execution *FlareDaemonExecution
+	if GetFlareDaemonPerBlock(chainID, blockNumber, blockTime) == (tx == nil) {
+		execution = triggerFlareDaemonAndMint(evm, log.Root())
+	}
+	hooks := GetSystemHooks(chainID, blockNumber, blockTime)
+	for i := range hooks {
+		if hooks[i].triggeredBy(tx) {
+			evm.systemCalls().callSystemHook(evm, &hooks[i])
+		}
+	}
+	return execution
+}
+
+// hasBlockSystemHooks returns whether any hook is triggered at the end of every block
+func hasBlockSystemHooks(chainID *big.Int, blockNumber *big.Int, blockTime *big.Int) bool {
+	for _, hook := range GetSystemHooks(chainID, blockNumber, blockTime) {
+		if hook.Trigger == SystemHookPerBlock {
+			return true
+		}
+	}
+	return false
+}
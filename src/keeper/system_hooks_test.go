@@ -0,0 +1,215 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package core
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ava-labs/coreth/core/rawdb"
+	"github.com/ava-labs/coreth/core/state"
+	"github.com/ava-labs/coreth/core/types"
+	"github.com/ava-labs/coreth/core/vm"
+	"github.com/ava-labs/coreth/params"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Code that increments storage slot 0 of the contract, and code that always reverts
+var (
+	counterCode = common.FromHex("0x60005460010160005500")
+	revertCode  = common.FromHex("0x60006000fd")
+)
+
+func TestSystemHookValidate(t *testing.T) {
+	on := common.Address{0xaa}
+	zero := common.Address{}
+	hook := func(trigger string) SystemHook {
+		return SystemHook{Name: "h", Contract: common.Address{1}, Selector: []byte{1, 2, 3, 4}, Trigger: trigger, Gas: 100000}
+	}
+	tests := []struct {
+		name    string
+		hooks   func() []SystemHook
+		wantErr string
+	}{
+		{"block", func() []SystemHook { return []SystemHook{hook(SystemHookPerBlock)} }, ""},
+		{"selector", func() []SystemHook {
+			h := hook(SystemHookOnSelector)
+			h.OnContract, h.OnSelector = &on, []byte{5, 6, 7, 8}
+			return []SystemHook{h}
+		}, ""},
+		{"no name", func() []SystemHook { h := hook(SystemHookPerBlock); h.Name = ""; return []SystemHook{h} }, "name"},
+		{"zero contract", func() []SystemHook { h := hook(SystemHookPerBlock); h.Contract = zero; return []SystemHook{h} }, "contract"},
+		{"short selector", func() []SystemHook { h := hook(SystemHookPerBlock); h.Selector = []byte{1}; return []SystemHook{h} }, "selector"},
+		{"no gas", func() []SystemHook { h := hook(SystemHookPerBlock); h.Gas = 0; return []SystemHook{h} }, "gas"},
+		{"unknown trigger", func() []SystemHook { return []SystemHook{hook("epoch")} }, "unknown trigger"},
+		{"selector without contract", func() []SystemHook {
+			h := hook(SystemHookOnSelector)
+			h.OnContract, h.OnSelector = &zero, []byte{5, 6, 7, 8}
+			return []SystemHook{h}
+		}, "onContract"},
+		{"onSelector on a block hook", func() []SystemHook {
+			h := hook(SystemHookPerBlock)
+			h.OnSelector = []byte{5, 6, 7, 8}
+			return []SystemHook{h}
+		}, "only apply"},
+		{"duplicate name", func() []SystemHook {
+			return []SystemHook{hook(SystemHookPerBlock), hook(SystemHookPerTransaction)}
+		}, "defined twice"},
+	}
+	for _, test := range tests {
+		err := validateSystemHooks(test.hooks())
+		switch {
+		case test.wantErr == "" && err != nil:
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		case test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)):
+			t.Errorf("%s: got %v want error containing %q", test.name, err, test.wantErr)
+		}
+	}
+}
+
+func TestSystemHooksForks(t *testing.T) {
+	block := func(n uint64) *uint64 { return &n }
+	hook := SystemHook{Name: "h", Contract: common.Address{1}, Selector: []byte{1, 2, 3, 4}, Trigger: SystemHookPerBlock, Gas: 100000}
+	genesis := genesisFlareFork()
+	genesis.SystemHooks = []SystemHook{hook}
+	schedule := FlareForkSchedule{
+		genesis,
+		{Name: "unrelated", Block: block(5), FlareForkParams: FlareForkParams{FlareDaemonPerBlock: new(bool)}},
+		{Name: "removeHooks", Block: block(10), FlareForkParams: FlareForkParams{SystemHooks: []SystemHook{}}},
+	}
+	if err := schedule.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		blockNumber int64
+		wantHooks   int
+	}{{0, 1}, {5, 1}, {10, 0}} {
+		if got := schedule.ParamsAt(big.NewInt(test.blockNumber), big.NewInt(0)).SystemHooks; len(got) != test.wantHooks {
+			t.Errorf("block %d: got hooks %v want %d", test.blockNumber, got, test.wantHooks)
+		}
+	}
+}
+
+func TestSystemHookTriggeredBy(t *testing.T) {
+	on := common.Address{0xaa}
+	other := common.Address{0xab}
+	selector := []byte{5, 6, 7, 8}
+	hooks := map[string]SystemHook{
+		SystemHookPerBlock:       {Trigger: SystemHookPerBlock},
+		SystemHookPerTransaction: {Trigger: SystemHookPerTransaction},
+		SystemHookOnSelector:     {Trigger: SystemHookOnSelector, OnContract: &on, OnSelector: selector},
+	}
+	tests := []struct {
+		name string
+		tx   *systemHookTx
+		want []string
+	}{
+		{"end of block", nil, []string{SystemHookPerBlock}},
+		{"selector", &systemHookTx{to: &on, data: append(common.CopyBytes(selector), 1)}, []string{SystemHookPerTransaction, SystemHookOnSelector}},
+		{"other selector", &systemHookTx{to: &on, data: []byte{1, 2, 3, 4}}, []string{SystemHookPerTransaction}},
+		{"other contract", &systemHookTx{to: &other, data: selector}, []string{SystemHookPerTransaction}},
+		{"contract creation", &systemHookTx{data: selector}, []string{SystemHookPerTransaction}},
+	}
+	for _, test := range tests {
+		var got []string
+		for _, trigger := range []string{SystemHookPerBlock, SystemHookPerTransaction, SystemHookOnSelector} {
+			if hook := hooks[trigger]; hook.triggeredBy(test.tx) {
+				got = append(got, trigger)
+			}
+		}
+		if strings.Join(got, ",") != strings.Join(test.want, ",") {
+			t.Errorf("%s: got %v want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestSystemHooks(t *testing.T) {
+	config := *params.TestChainConfig
+	config.ChainID = big.NewInt(987654324)
+	onContract := common.Address{0xaa}
+	onSelector := []byte{1, 2, 3, 4}
+	blockHook := common.Address{0xc1}
+	txHook := common.Address{0xc2}
+	selectorHook := common.Address{0xc3}
+	failingHook := common.Address{0xc4}
+
+	genesis := genesisFlareFork()
+	genesis.SystemHooks = []SystemHook{
+		{Name: "perBlock", Contract: blockHook, Selector: []byte{0, 0, 0, 1}, Trigger: SystemHookPerBlock, Gas: 100000},
+		{Name: "perTransaction", Contract: txHook, Selector: []byte{0, 0, 0, 2}, Trigger: SystemHookPerTransaction, Gas: 100000},
+		{Name: "onSelector", Contract: selectorHook, Selector: []byte{0, 0, 0, 3}, Trigger: SystemHookOnSelector, OnContract: &onContract, OnSelector: onSelector, Gas: 100000},
+		{Name: "failing", Contract: failingHook, Selector: []byte{0, 0, 0, 4}, Trigger: SystemHookPerTransaction, Gas: 100000},
+	}
+	if err := SetFlareChainConfig(config.ChainID, &FlareChainConfig{Forks: FlareForkSchedule{genesis}}); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		flareChainConfigsLock.Lock()
+		delete(flareChainConfigs, config.ChainID.Uint64())
+		flareChainConfigsLock.Unlock()
+	}()
+
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, addr := range []common.Address{blockHook, txHook, selectorHook} {
+		statedb.SetCode(addr, counterCode)
+	}
+	statedb.SetCode(failingHook, revertCode)
+	header := &types.Header{
+		ParentHash: common.Hash{1},
+		Coinbase:   common.HexToAddress("0x0100000000000000000000000000000000000000"),
+		Number:     big.NewInt(1),
+		Time:       100,
+		GasLimit:   8000000,
+		Difficulty: common.Big1,
+		BaseFee:    big.NewInt(0),
+	}
+	gp := new(GasPool).AddGas(header.GasLimit)
+	txs := []struct {
+		to   common.Address
+		data []byte
+	}{
+		{onContract, append(common.CopyBytes(onSelector), 9, 9)},
+		{onContract, []byte{5, 6, 7, 8}},
+		{common.Address{0xab}, onSelector},
+		{onContract, onSelector},
+	}
+	for i, tx := range txs {
+		to := tx.to
+		evm := vm.NewEVM(NewEVMBlockContext(header, nil, &header.Coinbase), vm.TxContext{GasPrice: big.NewInt(0)}, statedb, &config, vm.Config{})
+		msg := types.NewMessage(common.Address{0xbb}, &to, 0, big.NewInt(0), 100000, big.NewInt(0), big.NewInt(0), big.NewInt(0), tx.data, nil, true)
+		result, err := ApplyMessage(evm, msg, gp)
+		if err != nil || result.Err != nil {
+			t.Fatalf("tx %d: got (%+v, %v), a failing hook must not fail the transaction", i, result, err)
+		}
+	}
+	header.GasUsed = header.GasLimit - gp.Gas()
+	if execution := FinalizeSystemCalls(&config, nil, header, statedb); execution != nil {
+		t.Errorf("flareDaemon triggered at finalisation: %+v", execution)
+	}
+
+	for _, counter := range []struct {
+		hook common.Address
+		want int64
+	}{
+		{blockHook, 1},
+		{txHook, int64(len(txs))},
+		{selectorHook, 2},
+	} {
+		if got := statedb.GetState(counter.hook, common.Hash{}).Big().Int64(); got != counter.want {
+			t.Errorf("hook %x: got %d calls want %d", counter.hook, got, counter.want)
+		}
+	}
+
+	// The transactions are fake messages, which are not recorded
+	block := types.NewBlockWithHeader(header)
+	FinaliseBlockRecords(statedb, block.Hash())
+	traces, err := AcceptSystemCallTraces(rawdb.NewMemoryDatabase(), block)
+	if err != nil || len(traces) != 1 || !traces[0].BlockEnd || traces[0].BlockGasUsed != header.GasUsed || traces[0].Kind != SystemCallHook || traces[0].Hook != "perBlock" || traces[0].Caller != blockHook || traces[0].GasUsed == 0 {
+		t.Errorf("block end traces: got %+v", traces)
+	}
+}
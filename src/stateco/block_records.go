@@ -0,0 +1,110 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package core
+
+import (
+	"sync"
+
+	"github.com/ava-labs/coreth/core/types"
+	"github.com/ava-labs/coreth/core/vm"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+)
+
+// Kinds of the records that a block keeps until it is accepted
+type blockRecordKind int
+
+const (
+	blockRecordAttestationRound blockRecordKind = iota
+	blockRecordForkCheckpoint
+	blockRecordFlareDaemonExecution
+	blockRecordBlockEndReceipt
+	blockRecordSystemCall
+)
+
+// blockRecords are the records kept by one execution of a block at height [number]
+type blockRecords struct {
+	number  uint64
+	records map[blockRecordKind][]interface{}
+}
+
+// A block is executed when it is built, when it is verified and when it is reprocessed, and
+// blocks that are verified may still be rejected. Records are therefore kept per execution,
+// identified by the StateDB that the block is executed on, until the execution is finalised
+// and the hash of the block is known. They reach the database only once that block is
+// accepted. Records of blocks that are never accepted are dropped once a block at their
+// height is.
+var (
+	blockRecordsLock      sync.Mutex
+	executingBlockRecords = make(map[vm.StateDB]*blockRecords)
+	executedBlockRecords  = make(map[common.Hash]*blockRecords)
+)
+
+// addBlockRecord keeps [record] of the block at height [number] that is executed on [statedb],
+// after the records of [kind] added before it
+func addBlockRecord(statedb vm.StateDB, number uint64, kind blockRecordKind, record interface{}) {
+	blockRecordsLock.Lock()
+	defer blockRecordsLock.Unlock()
+	r := executingBlockRecords[statedb]
+	if r == nil {
+		r = &blockRecords{number: number, records: make(map[blockRecordKind][]interface{})}
+		executingBlockRecords[statedb] = r
+	}
+	r.records[kind] = append(r.records[kind], record)
+}
+
+// FinaliseBlockRecords binds the records kept while executing a block on [statedb] to the hash
+// of that block, once its transactions and the end of the block have been executed. Executing
+// the same block again replaces the records of the earlier execution.
+func FinaliseBlockRecords(statedb vm.StateDB, hash common.Hash) {
+	blockRecordsLock.Lock()
+	defer blockRecordsLock.Unlock()
+	r := executingBlockRecords[statedb]
+	delete(executingBlockRecords, statedb)
+	if r == nil {
+		delete(executedBlockRecords, hash)
+		return
+	}
+	executedBlockRecords[hash] = r
+}
+
+// takeBlockRecords returns the records of [kind] of the accepted [block], in the order they
+// were added, and drops the records of [kind] of every other block at its height or below
+func takeBlockRecords(block *types.Block, kind blockRecordKind) []interface{} {
+	blockRecordsLock.Lock()
+	defer blockRecordsLock.Unlock()
+	var taken []interface{}
+	if r := executedBlockRecords[block.Hash()]; r != nil {
+		taken = r.records[kind]
+	}
+	number := block.NumberU64()
+	for hash, r := range executedBlockRecords {
+		if r.number > number {
+			continue
+		}
+		delete(r.records, kind)
+		if len(r.records) == 0 {
+			delete(executedBlockRecords, hash)
+		}
+	}
+	for statedb, r := range executingBlockRecords {
+		if r.number > number {
+			continue
+		}
+		delete(r.records, kind)
+		if len(r.records) == 0 {
+			delete(executingBlockRecords, statedb)
+		}
+	}
+	return taken
+}
+
+// readRecord returns the value stored under [key], or nil if there is none. Unlike a bare Get
+// it tells a missing record apart from a failing database.
+func readRecord(db ethdb.KeyValueReader, key []byte) ([]byte, error) {
+	if has, err := db.Has(key); err != nil || !has {
+		return nil, err
+	}
+	return db.Get(key)
+}
@@ -5,12 +5,12 @@ package core
 
 import (
 	"encoding/hex"
+	"errors"
 	"math/big"
-	"os"
-	"strings"
 
 	"github.com/ava-labs/coreth/core/vm"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
 )
 
 var (
@@ -21,6 +21,8 @@ var (
 	songbirdStateConnectorActivationTime = new(big.Int).SetUint64(1000000000000)
 )
 
+var errEmptyMajorityDecision = errors.New("refusing to finalise a round without a majority decision")
+
 type AttestationVotes struct {
 	reachedMajority    bool
 	majorityDecision   string
@@ -29,12 +31,43 @@ type AttestationVotes struct {
 	abstainedAttestors []common.Address
 }
 
+// AttestationMajorityRule is the share of attestor weight, Numerator/Denominator, that the
+// plurality has to exceed. With OfParticipating set the share is measured over the attestors
+// that submitted a valid attestation, otherwise over every attestor including abstainers.
+type AttestationMajorityRule struct {
+	Numerator       uint64 `json:"numerator"`
+	Denominator     uint64 `json:"denominator"`
+	OfParticipating bool   `json:"ofParticipating"`
+}
+
+// validate checks that the plurality can exceed the share of [r] without having to hold all of
+// the weight
+func (r *AttestationMajorityRule) validate() error {
+	if r.Numerator == 0 || r.Numerator >= r.Denominator {
+		return errors.New("numerator must be positive and less than the denominator")
+	}
+	return nil
+}
+
+func (r AttestationMajorityRule) exceededBy(weight *big.Int, totalWeight *big.Int) bool {
+	supporting := new(big.Int).Mul(weight, new(big.Int).SetUint64(r.Denominator))
+	required := new(big.Int).Mul(totalWeight, new(big.Int).SetUint64(r.Numerator))
+	return weight.Sign() > 0 && supporting.Cmp(required) > 0
+}
+
+// A chain with a genesis flare config is a testing chain if its config says so, otherwise every
+// chain but Flare and Songbird is
 func GetTestingChain(chainID *big.Int) bool {
+	if config := GetFlareChainConfig(chainID); config != nil {
+		return config.TestingChain
+	}
 	return chainID.Cmp(flareChainID) != 0 && chainID.Cmp(songbirdChainID) != 0
 }
 
 func GetStateConnectorActivated(chainID *big.Int, blockTime *big.Int) bool {
-	if GetTestingChain(chainID) {
+	if config := GetFlareChainConfig(chainID); config != nil && config.StateConnectorActivationTime != nil {
+		return blockTime.Cmp(new(big.Int).SetUint64(*config.StateConnectorActivationTime)) >= 0
+	} else if GetTestingChain(chainID) {
 		return true
 	} else if chainID.Cmp(flareChainID) == 0 {
 		return blockTime.Cmp(flareStateConnectorActivationTime) >= 0
@@ -44,13 +77,37 @@ func GetStateConnectorActivated(chainID *big.Int, blockTime *big.Int) bool {
 	return false
 }
 
-func GetStateConnectorContract(chainID *big.Int, blockTime *big.Int) common.Address {
-	switch {
-	default:
-		return common.HexToAddress("0x1000000000000000000000000000000000000001")
+// GetStateConnectorProofVerifierActivated returns whether the precompile that verifies
+// attestation request proofs against finalised rounds is activated, which takes both a fork
+// that sets stateConnectorProofVerifier and an activated state connector
+func GetStateConnectorProofVerifierActivated(chainID *big.Int, blockNumber *big.Int, blockTime *big.Int) bool {
+	return GetFlareParams(chainID, blockNumber, blockTime).StateConnectorProofVerifier && GetStateConnectorActivated(chainID, blockTime)
+}
+
+// RegisterStateConnectorProofVerifier adds the proof verifier to the precompiles of chain
+// [chainID] if a fork of its schedule activates it. It is called when the VM starts, once the
+// flare config of the chain is set.
+func RegisterStateConnectorProofVerifier(chainID *big.Int) {
+	for _, fork := range GetFlareForkSchedule(chainID) {
+		if fork.StateConnectorProofVerifier != nil && *fork.StateConnectorProofVerifier {
+			vm.RegisterStateConnectorProofVerifier(chainID, func(blockNumber *big.Int, blockTime *big.Int) (common.Address, bool) {
+				return GetStateConnectorContract(chainID, blockNumber, blockTime), GetStateConnectorProofVerifierActivated(chainID, blockNumber, blockTime)
+			})
+			return
+		}
 	}
 }
 
+// GetAttestationMajorityRule returns the share of the attestors that the plurality has to
+// exceed for a round counted per address to reach a majority
+func GetAttestationMajorityRule(chainID *big.Int, blockNumber *big.Int, blockTime *big.Int) AttestationMajorityRule {
+	return GetFlareParams(chainID, blockNumber, blockTime).AttestationMajority
+}
+
+func GetStateConnectorContract(chainID *big.Int, blockNumber *big.Int, blockTime *big.Int) common.Address {
+	return GetFlareParams(chainID, blockNumber, blockTime).StateConnectorContract
+}
+
 func GetStateConnectorCoinbaseSignalAddr(chainID *big.Int, blockTime *big.Int) common.Address {
 	switch {
 	default:
@@ -58,11 +115,8 @@ func GetStateConnectorCoinbaseSignalAddr(chainID *big.Int, blockTime *big.Int) c
 	}
 }
 
-func SubmitAttestationSelector(chainID *big.Int, blockTime *big.Int) []byte {
-	switch {
-	default:
-		return []byte{0xcf, 0xd1, 0xfd, 0xad}
-	}
+func SubmitAttestationSelector(chainID *big.Int, blockNumber *big.Int, blockTime *big.Int) []byte {
+	return GetFlareParams(chainID, blockNumber, blockTime).SubmitAttestationSelector
 }
 
 func GetAttestationSelector(chainID *big.Int, blockTime *big.Int) []byte {
@@ -93,29 +147,45 @@ func GetFtsoWhitelistedPriceProvidersSelector(chainID *big.Int, blockTime *big.I
 	}
 }
 
-// The default attestors are the FTSO price providers
+// The default attestors are the FTSO price providers, less those excluded by the penalty ledger
 func (st *StateTransition) GetDefaultAttestors(chainID *big.Int, timestamp *big.Int) ([]common.Address, error) {
-	if os.Getenv("TESTING_ATTESTATION_PROVIDERS") != "" && GetTestingChain(chainID) {
-		return GetEnvAttestationProviders("TESTING"), nil
+	candidates, err := st.GetAttestorCandidates(chainID, timestamp)
+	if err != nil {
+		return []common.Address{}, err
+	}
+	defaultAttestors, _ := st.filterExcludedAttestors(chainID, timestamp, candidates)
+	return defaultAttestors, nil
+}
+
+// GetAttestorCandidates returns the FTSO price providers, before penalties are applied
+func (st *StateTransition) GetAttestorCandidates(chainID *big.Int, timestamp *big.Int) ([]common.Address, error) {
+	if attestors, ok := GetGenesisAttestors(chainID); ok {
+		return attestors, nil
+	} else if testingAttestors := GetTestingAttestors(); len(testingAttestors) > 0 && GetTestingChain(chainID) {
+		return testingAttestors, nil
 	} else {
+		calls := st.systemCalls()
+		legacyGas := GetFlareDaemonGasMultiplier(chainID, st.evm.Context.BlockNumber, timestamp) * st.evm.Context.GasLimit
 		// Get VoterWhitelister contract
-		voterWhitelisterContractBytes, _, err := st.evm.Call(
-			vm.AccountRef(st.msg.From()),
-			GetPrioritisedFTSOContract(timestamp),
+		voterWhitelisterContractBytes, _, err := calls.call(
+			st.evm,
+			SystemCallAttestorLookup,
+			calls.caller(vm.AccountRef(st.msg.From())),
+			GetPrioritisedFTSOContract(chainID, st.evm.Context.BlockNumber, timestamp),
 			GetVoterWhitelisterSelector(chainID, timestamp),
-			GetFlareDaemonGasMultiplier(st.evm.Context.BlockNumber)*st.evm.Context.GasLimit,
-			big.NewInt(0))
+			legacyGas)
 		if err != nil {
 			return []common.Address{}, err
 		}
 		// Get FTSO prive providers
 		voterWhitelisterContract := common.BytesToAddress(voterWhitelisterContractBytes)
-		priceProvidersBytes, _, err := st.evm.Call(
-			vm.AccountRef(st.msg.From()),
+		priceProvidersBytes, _, err := calls.call(
+			st.evm,
+			SystemCallAttestorLookup,
+			calls.caller(vm.AccountRef(st.msg.From())),
 			voterWhitelisterContract,
 			GetFtsoWhitelistedPriceProvidersSelector(chainID, timestamp),
-			GetFlareDaemonGasMultiplier(st.evm.Context.BlockNumber)*st.evm.Context.GasLimit,
-			big.NewInt(0))
+			legacyGas)
 		if err != nil {
 			return []common.Address{}, err
 		}
@@ -128,102 +198,145 @@ func (st *StateTransition) GetDefaultAttestors(chainID *big.Int, timestamp *big.
 	}
 }
 
-func GetEnvAttestationProviders(attestorType string) []common.Address {
-	envAttestationProvidersString := os.Getenv(attestorType + "_ATTESTATION_PROVIDERS")
-	if envAttestationProvidersString == "" {
-		return []common.Address{}
-	}
-	envAttestationProviders := strings.Split(envAttestationProvidersString, ",")
-	NUM_ATTESTORS := len(envAttestationProviders)
-	var attestors []common.Address
-	for i := 0; i < NUM_ATTESTORS; i++ {
-		attestors = append(attestors, common.HexToAddress(envAttestationProviders[i]))
-	}
-	return attestors
-}
-
+// GetAttestation executes getAttestation on the StateConnector contract on behalf of [attestor].
+// CountAttestations reads the same result from storage; this remains as the reference path.
 func (st *StateTransition) GetAttestation(attestor common.Address, instructions []byte) (string, error) {
 	merkleRootHash, _, err := st.evm.Call(vm.AccountRef(attestor), st.to(), instructions, 20000, big.NewInt(0))
 	return hex.EncodeToString(merkleRootHash), err
 }
 
-func (st *StateTransition) CountAttestations(attestors []common.Address, instructions []byte) (AttestationVotes, error) {
+// CountAttestations tallies the votes of [attestors] for the round preceding [bufferNumber].
+// Votes are read straight from StateConnector storage, see ReadAttestations.
+func (st *StateTransition) CountAttestations(chainID *big.Int, timestamp *big.Int, attestors []common.Address, bufferNumber []byte) (AttestationVotes, error) {
+	hashes, errs := st.ReadAttestations(attestors, new(big.Int).SetBytes(bufferNumber))
+	rule := GetAttestationMajorityRule(chainID, st.evm.Context.BlockNumber, timestamp)
+	return tallyAttestations(attestors, hashes, errs, rule), nil
+}
+
+// tallyAttestations decides a round by head count
+func tallyAttestations(attestors []common.Address, hashes []string, errs []error, rule AttestationMajorityRule) AttestationVotes {
+	return tallyWeightedAttestations(attestors, hashes, errs, nil, rule)
+}
+
+// tallyWeightedAttestations finds the attestation with the most weight behind it and checks it
+// against [rule]. A nil [weights] gives every attestor a weight of one.
+//
+// An attestor whose attestation could not be read abstains: it is listed in abstainedAttestors
+// only, and never votes for any decision. Abstainers still count towards the total weight when
+// the rule measures the majority over all attestors, so abstaining weighs against the plurality.
+func tallyWeightedAttestations(attestors []common.Address, hashes []string, errs []error, weights []*big.Int, rule AttestationMajorityRule) AttestationVotes {
 	var attestationVotes AttestationVotes
 	hashFrequencies := make(map[string][]common.Address)
+	hashWeights := make(map[string]*big.Int)
+	totalWeight := new(big.Int)
+	// Keep the order in which hashes were first seen so the outcome does not depend on map iteration
+	var hashOrder []string
 	for i, a := range attestors {
-		h, err := st.GetAttestation(a, instructions)
-		if err != nil {
+		weight := big.NewInt(1)
+		if weights != nil {
+			weight = weights[i]
+		}
+		h := hashes[i]
+		if errs[i] != nil || h == "" {
 			attestationVotes.abstainedAttestors = append(attestationVotes.abstainedAttestors, a)
+			if !rule.OfParticipating {
+				totalWeight.Add(totalWeight, weight)
+			}
+			continue
+		}
+		if _, ok := hashFrequencies[h]; !ok {
+			hashOrder = append(hashOrder, h)
+			hashWeights[h] = new(big.Int)
 		}
-		hashFrequencies[h] = append(hashFrequencies[h], attestors[i])
+		hashFrequencies[h] = append(hashFrequencies[h], a)
+		hashWeights[h].Add(hashWeights[h], weight)
+		totalWeight.Add(totalWeight, weight)
 	}
-	// Find the plurality
-	var pluralityNum int
+	// Find the plurality, ties go to the hash seen first
+	pluralityWeight := new(big.Int)
 	var pluralityKey string
-	for key, val := range hashFrequencies {
-		if len(val) > pluralityNum {
-			pluralityNum = len(val)
+	for _, key := range hashOrder {
+		if hashWeights[key].Cmp(pluralityWeight) > 0 {
+			pluralityWeight = hashWeights[key]
 			pluralityKey = key
 		}
 	}
-	if pluralityNum > len(attestors)/2 {
+	if pluralityKey != "" && rule.exceededBy(pluralityWeight, totalWeight) {
 		attestationVotes.reachedMajority = true
 		attestationVotes.majorityDecision = pluralityKey
 		attestationVotes.majorityAttestors = hashFrequencies[pluralityKey]
 	}
-	for key, val := range hashFrequencies {
+	for _, key := range hashOrder {
 		if key != pluralityKey {
-			attestationVotes.divergentAttestors = append(attestationVotes.divergentAttestors, val...)
+			attestationVotes.divergentAttestors = append(attestationVotes.divergentAttestors, hashFrequencies[key]...)
 		}
 	}
-	return attestationVotes, nil
+	return attestationVotes
 }
 
 func (st *StateTransition) FinalisePreviousRound(chainID *big.Int, timestamp *big.Int, currentRoundNumber []byte) error {
-	getAttestationSelector := GetAttestationSelector(chainID, timestamp)
-	instructions := append(getAttestationSelector[:], currentRoundNumber[:]...)
-	defaultAttestors, err := st.GetDefaultAttestors(chainID, timestamp)
+	candidateAttestors, err := st.GetAttestorCandidates(chainID, timestamp)
 	if err != nil {
 		return err
 	}
-	defaultAttestationVotes, err := st.CountAttestations(defaultAttestors, instructions)
+	defaultAttestors, excludedAttestors := st.filterExcludedAttestors(chainID, timestamp, candidateAttestors)
+	var defaultAttestationVotes AttestationVotes
+	if GetWeightedAttestationActivated(chainID, st.evm.Context.BlockNumber, timestamp) {
+		defaultAttestationVotes, err = st.CountWeightedAttestations(chainID, timestamp, defaultAttestors, currentRoundNumber)
+	} else {
+		defaultAttestationVotes, err = st.CountAttestations(chainID, timestamp, defaultAttestors, currentRoundNumber)
+	}
 	if err != nil {
 		return err
 	}
-	localAttestors := GetEnvAttestationProviders("LOCAL")
+	st.updateAttestorPenalties(chainID, timestamp, currentRoundNumber, defaultAttestationVotes, excludedAttestors)
+	localAttestors := GetLocalAttestors()
 	var finalityReached bool
 	if len(localAttestors) > 0 {
-		localAttestationVotes, err := st.CountAttestations(localAttestors, instructions)
+		localAttestationVotes, err := st.CountAttestations(chainID, timestamp, localAttestors, currentRoundNumber)
 		if defaultAttestationVotes.reachedMajority && localAttestationVotes.reachedMajority && defaultAttestationVotes.majorityDecision == localAttestationVotes.majorityDecision {
 			finalityReached = true
 		} else if err != nil || (defaultAttestationVotes.reachedMajority && defaultAttestationVotes.majorityDecision != localAttestationVotes.majorityDecision) {
 			// Make a back-up of the current state database, because this node is about to fork from the default set
+			st.createForkCheckpoint(currentRoundNumber, defaultAttestationVotes, localAttestationVotes)
 		}
 	} else if defaultAttestationVotes.reachedMajority {
 		finalityReached = true
 	}
 	if finalityReached {
-		// Finalise defaultAttestationVotes.majorityDecision
-		finaliseRoundSelector := FinaliseRoundSelector(chainID, timestamp)
-		finalisedData := append(finaliseRoundSelector[:], currentRoundNumber[:]...)
-		merkleRootHashBytes, err := hex.DecodeString(defaultAttestationVotes.majorityDecision)
-		if err != nil {
-			return err
-		}
-		finalisedData = append(finalisedData[:], merkleRootHashBytes[:]...)
-		coinbaseSignal := GetStateConnectorCoinbaseSignalAddr(chainID, timestamp)
-		originalCoinbase := st.evm.Context.Coinbase
-		defer func() {
-			st.evm.Context.Coinbase = originalCoinbase
-		}()
-		st.evm.Context.Coinbase = coinbaseSignal
-
-		_, _, err = st.evm.Call(vm.AccountRef(coinbaseSignal), st.to(), finalisedData, st.evm.Context.GasLimit, new(big.Int).SetUint64(0))
-		if err != nil {
+		if err := st.finaliseRound(chainID, timestamp, currentRoundNumber, defaultAttestationVotes.majorityDecision); err != nil {
+			st.recordAttestationRound(currentRoundNumber, defaultAttestationVotes, false)
 			return err
 		}
 
-		// Issue rewards to defaultAttestationVotes.majorityAttestors here:
+		// Issue rewards to defaultAttestationVotes.majorityAttestors
+		if err := distributeAttestorRewards(st, defaultAttestationVotes.majorityAttestors); err != nil {
+			log.Warn("Error issuing state connector attestor rewards", "error", err)
+		}
 	}
+	st.recordAttestationRound(currentRoundNumber, defaultAttestationVotes, finalityReached)
 	return nil
 }
+
+// finaliseRound calls finaliseRound on the StateConnector contract with the signalling coinbase set
+func (st *StateTransition) finaliseRound(chainID *big.Int, timestamp *big.Int, currentRoundNumber []byte, majorityDecision string) error {
+	if majorityDecision == "" {
+		return errEmptyMajorityDecision
+	}
+	finaliseRoundSelector := FinaliseRoundSelector(chainID, timestamp)
+	finalisedData := append(finaliseRoundSelector[:], currentRoundNumber[:]...)
+	merkleRootHashBytes, err := hex.DecodeString(majorityDecision)
+	if err != nil {
+		return err
+	}
+	finalisedData = append(finalisedData[:], merkleRootHashBytes[:]...)
+	coinbaseSignal := GetStateConnectorCoinbaseSignalAddr(chainID, timestamp)
+	originalCoinbase := st.evm.Context.Coinbase
+	defer func() {
+		st.evm.Context.Coinbase = originalCoinbase
+	}()
+	st.evm.Context.Coinbase = coinbaseSignal
+
+	_, _, err = st.systemCalls().call(st.evm, SystemCallFinaliseRound, vm.AccountRef(coinbaseSignal), st.to(), finalisedData, st.evm.Context.GasLimit)
+	return err
+}
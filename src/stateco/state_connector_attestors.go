@@ -0,0 +1,117 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// AttestorConfig holds the attestor overrides of the C-chain node config:
+//
+//	{
+//		"testing-attestors": ["0xff57CaF5B871db64F2a7F4C5bc2d17A5E666F7E8"],
+//		"local-attestors": ["0x..."]
+//	}
+//
+// Testing attestors replace the FTSO price providers as the default attestors of a testing
+// chain. Local attestors are the set this node checks the default attestors against before it
+// considers a round final.
+type AttestorConfig struct {
+	TestingAttestors []string `json:"testing-attestors,omitempty"`
+	LocalAttestors   []string `json:"local-attestors,omitempty"`
+}
+
+// AttestorSets are the attestor overrides in effect
+type AttestorSets struct {
+	Testing []common.Address `json:"testing"`
+	Local   []common.Address `json:"local"`
+}
+
+var (
+	attestorSetsLock sync.RWMutex
+	attestorSets     = AttestorSets{Testing: []common.Address{}, Local: []common.Address{}}
+)
+
+// ParseAttestorConfig reads the attestor overrides from the node config [configBytes]
+func ParseAttestorConfig(configBytes []byte) (*AttestorConfig, error) {
+	config := &AttestorConfig{}
+	if len(configBytes) == 0 {
+		return config, nil
+	}
+	if err := json.Unmarshal(configBytes, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// Resolve validates the configured addresses and returns them as attestor sets
+func (c *AttestorConfig) Resolve() (AttestorSets, error) {
+	testing, err := parseAttestorAddresses("testing-attestors", c.TestingAttestors)
+	if err != nil {
+		return AttestorSets{}, err
+	}
+	local, err := parseAttestorAddresses("local-attestors", c.LocalAttestors)
+	if err != nil {
+		return AttestorSets{}, err
+	}
+	return AttestorSets{Testing: testing, Local: local}, nil
+}
+
+// parseAttestorAddresses accepts 0x-prefixed hex addresses only. Mixed-case addresses must
+// carry a valid EIP-55 checksum, and the zero address and duplicates are rejected.
+func parseAttestorAddresses(name string, hexAddresses []string) ([]common.Address, error) {
+	addresses := make([]common.Address, 0, len(hexAddresses))
+	seen := make(map[common.Address]bool)
+	for i, s := range hexAddresses {
+		if !strings.HasPrefix(s, "0x") || !common.IsHexAddress(s) {
+			return nil, fmt.Errorf("%s[%d]: %q is not a 0x-prefixed hex address", name, i, s)
+		}
+		addr := common.HexToAddress(s)
+		if s != strings.ToLower(s) && s[2:] != strings.ToUpper(s[2:]) && addr.Hex() != s {
+			return nil, fmt.Errorf("%s[%d]: %q has an invalid checksum", name, i, s)
+		}
+		if addr == (common.Address{}) {
+			return nil, fmt.Errorf("%s[%d]: zero address", name, i)
+		}
+		if seen[addr] {
+			return nil, fmt.Errorf("%s[%d]: %s is listed twice", name, i, addr.Hex())
+		}
+		seen[addr] = true
+		addresses = append(addresses, addr)
+	}
+	return addresses, nil
+}
+
+// SetAttestorConfig validates [config] and makes its sets the attestor overrides of this node.
+// Nothing changes if [config] is invalid.
+func SetAttestorConfig(config *AttestorConfig) (AttestorSets, error) {
+	sets, err := config.Resolve()
+	if err != nil {
+		return AttestorSets{}, err
+	}
+	attestorSetsLock.Lock()
+	attestorSets = sets
+	attestorSetsLock.Unlock()
+	return sets, nil
+}
+
+// GetAttestorSets returns the attestor overrides in effect
+func GetAttestorSets() AttestorSets {
+	attestorSetsLock.RLock()
+	defer attestorSetsLock.RUnlock()
+	return attestorSets
+}
+
+func GetTestingAttestors() []common.Address {
+	return GetAttestorSets().Testing
+}
+
+func GetLocalAttestors() []common.Address {
+	return GetAttestorSets().Local
+}
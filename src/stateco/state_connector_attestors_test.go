@@ -0,0 +1,70 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package core
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestParseAttestorConfig(t *testing.T) {
+	config, err := ParseAttestorConfig([]byte(`{
+		"snowman-api-enabled": true,
+		"testing-attestors": ["0xff57CaF5B871db64F2a7F4C5bc2d17A5E666F7E8"],
+		"local-attestors": ["0x0000000000000000000000000000000000010000", "0x0000000000000000000000000000000000010001"]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sets, err := config.Resolve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sets.Testing) != 1 || sets.Testing[0] != common.HexToAddress("0xff57CaF5B871db64F2a7F4C5bc2d17A5E666F7E8") {
+		t.Errorf("got testing attestors %v", sets.Testing)
+	}
+	if len(sets.Local) != 2 || sets.Local[1] != testAttestor(1) {
+		t.Errorf("got local attestors %v", sets.Local)
+	}
+	if config, err := ParseAttestorConfig(nil); err != nil || len(config.TestingAttestors) != 0 {
+		t.Errorf("empty node config: got (%v, %v)", config, err)
+	}
+}
+
+func TestAttestorConfigValidation(t *testing.T) {
+	tests := []struct {
+		name      string
+		attestors []string
+		wantErr   string
+	}{
+		{"missing prefix", []string{"ff57CaF5B871db64F2a7F4C5bc2d17A5E666F7E8"}, "not a 0x-prefixed"},
+		{"too short", []string{"0xff57CaF5B871db64F2a7F4C5bc2d17A5E666F7E"}, "not a 0x-prefixed"},
+		{"not hex", []string{"0xzz57caf5b871db64f2a7f4c5bc2d17a5e666f7e8"}, "not a 0x-prefixed"},
+		{"empty entry", []string{""}, "not a 0x-prefixed"},
+		{"bad checksum", []string{"0xFf57CaF5B871db64F2a7F4C5bc2d17A5E666F7E8"}, "checksum"},
+		{"zero address", []string{"0x0000000000000000000000000000000000000000"}, "zero address"},
+		{"duplicate", []string{"0xff57caf5b871db64f2a7f4c5bc2d17a5e666f7e8", "0xFF57CAF5B871DB64F2A7F4C5BC2D17A5E666F7E8"}, "listed twice"},
+	}
+	for _, test := range tests {
+		_, err := (&AttestorConfig{LocalAttestors: test.attestors}).Resolve()
+		if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("%s: got %v want error containing %q", test.name, err, test.wantErr)
+		}
+	}
+}
+
+func TestSetAttestorConfigKeepsSetsOnError(t *testing.T) {
+	t.Cleanup(func() { SetAttestorConfig(&AttestorConfig{}) })
+	if _, err := SetAttestorConfig(&AttestorConfig{LocalAttestors: []string{testAttestor(0).Hex()}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SetAttestorConfig(&AttestorConfig{LocalAttestors: []string{"0x1234"}}); err == nil {
+		t.Fatal("invalid config was accepted")
+	}
+	if local := GetLocalAttestors(); len(local) != 1 || local[0] != testAttestor(0) {
+		t.Errorf("got local attestors %v", local)
+	}
+}
@@ -0,0 +1,147 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package core
+
+import (
+	"math/big"
+
+	"github.com/ava-labs/coreth/core/types"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+var (
+	forkCheckpointPrefix = []byte("stateco-checkpoint-") // forkCheckpointPrefix + bufferNumber (uint64 big endian) -> ForkCheckpoint
+
+	forkCheckpointCounter = metrics.NewRegisteredCounter("stateconnector/fork/checkpoints", nil)
+	forkCheckpointFailed  = metrics.NewRegisteredCounter("stateconnector/fork/checkpoints/failed", nil)
+)
+
+// ForkCheckpointCommitter persists the state of block [blockHash] so that it survives pruning,
+// and returns its state root
+type ForkCheckpointCommitter func(blockHash common.Hash) (common.Hash, error)
+
+// ForkCheckpoint records the last block before this node's local attestors disagreed with the
+// default attestor set. Rolling back to ParentHash undoes the disputed round.
+type ForkCheckpoint struct {
+	BufferNumber     uint64      `json:"bufferNumber"`
+	BlockNumber      uint64      `json:"blockNumber"`
+	Timestamp        uint64      `json:"timestamp"`
+	ParentHash       common.Hash `json:"parentHash"`
+	ParentRoot       common.Hash `json:"parentRoot"`
+	StateCommitted   bool        `json:"stateCommitted"`
+	DefaultDecision  common.Hash `json:"defaultDecision"`
+	LocalDecision    common.Hash `json:"localDecision"`
+	LocalHasMajority bool        `json:"localHasMajority"`
+}
+
+func forkCheckpointKey(bufferNumber uint64) []byte {
+	return append(append([]byte{}, forkCheckpointPrefix...), encodeBufferNumber(bufferNumber)...)
+}
+
+// createForkCheckpoint records that this node is about to fork from the default attestor set
+// in the block of [st]. The checkpoint is kept with the block and its parent state is only
+// backed up once the block is accepted, see AcceptForkCheckpoints.
+func (st *StateTransition) createForkCheckpoint(currentRoundNumber []byte, defaultVotes AttestationVotes, localVotes AttestationVotes) {
+	if st.msg.IsFake() {
+		return
+	}
+	bufferNumber := new(big.Int).SetBytes(currentRoundNumber)
+	blockNumber := st.evm.Context.BlockNumber.Uint64()
+	if !bufferNumber.IsUint64() || blockNumber == 0 {
+		return
+	}
+	checkpoint := &ForkCheckpoint{
+		BufferNumber:     bufferNumber.Uint64(),
+		BlockNumber:      blockNumber,
+		Timestamp:        st.evm.Context.Time.Uint64(),
+		ParentHash:       st.evm.Context.GetHash(blockNumber - 1),
+		DefaultDecision:  common.HexToHash(defaultVotes.majorityDecision),
+		LocalHasMajority: localVotes.reachedMajority,
+	}
+	if localVotes.reachedMajority {
+		checkpoint.LocalDecision = common.HexToHash(localVotes.majorityDecision)
+	}
+	addBlockRecord(st.state, blockNumber, blockRecordForkCheckpoint, checkpoint)
+}
+
+// AcceptForkCheckpoints backs up the parent state of each fork checkpoint created in the
+// accepted [block] with [commit], and stores the checkpoints in [db]. A checkpoint whose state
+// could not be backed up is stored with StateCommitted unset.
+func AcceptForkCheckpoints(db ethdb.KeyValueWriter, block *types.Block, commit ForkCheckpointCommitter) error {
+	for _, record := range takeBlockRecords(block, blockRecordForkCheckpoint) {
+		checkpoint := record.(*ForkCheckpoint)
+		root, err := commit(checkpoint.ParentHash)
+		if err != nil {
+			log.Error("Error backing up state for fork checkpoint", "bufferNumber", checkpoint.BufferNumber, "parentHash", checkpoint.ParentHash, "error", err)
+			forkCheckpointFailed.Inc(1)
+		} else {
+			checkpoint.ParentRoot = root
+			checkpoint.StateCommitted = true
+		}
+		forkCheckpointCounter.Inc(1)
+		log.Warn("Local attestation providers disagree with the default set, created fork checkpoint",
+			"bufferNumber", checkpoint.BufferNumber,
+			"blockNumber", checkpoint.BlockNumber,
+			"parentHash", checkpoint.ParentHash,
+			"parentRoot", checkpoint.ParentRoot,
+			"stateCommitted", checkpoint.StateCommitted,
+			"defaultDecision", checkpoint.DefaultDecision,
+			"localDecision", checkpoint.LocalDecision,
+			"localHasMajority", checkpoint.LocalHasMajority,
+		)
+		if err := writeForkCheckpoint(db, checkpoint); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeForkCheckpoint(db ethdb.KeyValueWriter, checkpoint *ForkCheckpoint) error {
+	enc, err := rlp.EncodeToBytes(checkpoint)
+	if err != nil {
+		return err
+	}
+	return db.Put(forkCheckpointKey(checkpoint.BufferNumber), enc)
+}
+
+// ReadForkCheckpoint returns the fork checkpoint created for round [bufferNumber], or nil if there is none
+func ReadForkCheckpoint(db ethdb.KeyValueReader, bufferNumber uint64) (*ForkCheckpoint, error) {
+	enc, err := readRecord(db, forkCheckpointKey(bufferNumber))
+	if err != nil || enc == nil {
+		return nil, err
+	}
+	checkpoint := new(ForkCheckpoint)
+	if err := rlp.DecodeBytes(enc, checkpoint); err != nil {
+		return nil, err
+	}
+	return checkpoint, nil
+}
+
+// ReadForkCheckpoints returns all stored fork checkpoints, oldest round first
+func ReadForkCheckpoints(db ethdb.Iteratee) ([]*ForkCheckpoint, error) {
+	it := db.NewIterator(forkCheckpointPrefix, nil)
+	defer it.Release()
+
+	checkpoints := []*ForkCheckpoint{}
+	for it.Next() {
+		if len(it.Key()) != len(forkCheckpointPrefix)+8 {
+			continue
+		}
+		checkpoint := new(ForkCheckpoint)
+		if err := rlp.DecodeBytes(it.Value(), checkpoint); err != nil {
+			return nil, err
+		}
+		checkpoints = append(checkpoints, checkpoint)
+	}
+	return checkpoints, it.Error()
+}
+
+// DeleteForkCheckpoint removes the fork checkpoint for round [bufferNumber]
+func DeleteForkCheckpoint(db ethdb.KeyValueWriter, bufferNumber uint64) error {
+	return db.Delete(forkCheckpointKey(bufferNumber))
+}
@@ -0,0 +1,87 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package core
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ava-labs/coreth/core/rawdb"
+	"github.com/ava-labs/coreth/core/types"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Create a fork checkpoint for round [bufferNumber] in a block executed on the state of [st]
+// and finalise the block as [block]
+func createTestForkCheckpoint(st *StateTransition, bufferNumber int64, block *types.Block) {
+	to := stateConnectorTestAddr
+	st.msg = types.NewMessage(common.Address{}, &to, 0, big.NewInt(0), 0, big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, false)
+	st.evm.Context.GetHash = func(uint64) common.Hash { return block.ParentHash() }
+	defaultVotes := AttestationVotes{reachedMajority: true, majorityDecision: penaltyTestAgreed.Hex()[2:]}
+	localVotes := AttestationVotes{reachedMajority: true, majorityDecision: penaltyTestDivergent.Hex()[2:]}
+	st.createForkCheckpoint(common.BigToHash(big.NewInt(bufferNumber)).Bytes(), defaultVotes, localVotes)
+	FinaliseBlockRecords(st.state, block.Hash())
+}
+
+func TestAcceptForkCheckpoints(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	parent := common.Hash{7}
+	root := common.Hash{8}
+	accepted := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1), ParentHash: parent, Extra: []byte("accepted")})
+	rejected := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1), ParentHash: parent, Extra: []byte("rejected")})
+
+	createTestForkCheckpoint(newStateConnectorTestTransition(t), 10, rejected)
+	createTestForkCheckpoint(newStateConnectorTestTransition(t), 12, accepted)
+	var committed []common.Hash
+	commit := func(blockHash common.Hash) (common.Hash, error) {
+		committed = append(committed, blockHash)
+		return root, nil
+	}
+	if err := AcceptForkCheckpoints(db, accepted, commit); err != nil {
+		t.Fatal(err)
+	}
+	if len(committed) != 1 || committed[0] != parent {
+		t.Errorf("committed %v want the parent %s only", committed, parent.Hex())
+	}
+	checkpoints, err := ReadForkCheckpoints(db)
+	if err != nil || len(checkpoints) != 1 {
+		t.Fatalf("got (%v, %v)", checkpoints, err)
+	}
+	want := ForkCheckpoint{
+		BufferNumber:     12,
+		BlockNumber:      1,
+		Timestamp:        stateConnectorTestBufferTimestampOffset,
+		ParentHash:       parent,
+		ParentRoot:       root,
+		StateCommitted:   true,
+		DefaultDecision:  penaltyTestAgreed,
+		LocalDecision:    penaltyTestDivergent,
+		LocalHasMajority: true,
+	}
+	if *checkpoints[0] != want {
+		t.Errorf("got %+v want %+v", checkpoints[0], want)
+	}
+
+	// A checkpoint whose state could not be backed up is still stored
+	next := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(2), ParentHash: accepted.Hash()})
+	createTestForkCheckpoint(newStateConnectorTestTransition(t), 14, next)
+	failing := func(common.Hash) (common.Hash, error) { return common.Hash{}, errors.New("trie missing") }
+	if err := AcceptForkCheckpoints(db, next, failing); err != nil {
+		t.Fatal(err)
+	}
+	checkpoint, err := ReadForkCheckpoint(db, 14)
+	if err != nil || checkpoint == nil || checkpoint.StateCommitted || checkpoint.ParentRoot != (common.Hash{}) {
+		t.Errorf("got (%+v, %v)", checkpoint, err)
+	}
+}
+
+func TestReadForkCheckpointReturnsDatabaseErrors(t *testing.T) {
+	if checkpoint, err := ReadForkCheckpoint(rawdb.NewMemoryDatabase(), 10); checkpoint != nil || err != nil {
+		t.Errorf("missing checkpoint: got (%+v, %v)", checkpoint, err)
+	}
+	if _, err := ReadForkCheckpoint(FailingDBMock{}, 10); err != errRoundsTestDB {
+		t.Errorf("got %v want %v", err, errRoundsTestDB)
+	}
+}
@@ -0,0 +1,235 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package core
+
+import (
+	"math/big"
+
+	"github.com/ava-labs/coreth/core/vm"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// Storage layout of the penalty ledger account. Each attestor has a record at
+// keccak(attestor . attestorPenaltiesSlot) holding its consecutive divergences, its consecutive
+// abstentions and the buffer number at which it was excluded (zero while it is not excluded).
+// The excluded attestors are also kept in an array at attestorPenaltyExcludedSlot so that they
+// can be listed without iterating storage.
+const (
+	attestorPenaltiesSlot       = 0
+	attestorPenaltyExcludedSlot = 1
+
+	attestorPenaltyDivergencesOffset = 0
+	attestorPenaltyAbstentionsOffset = 1
+	attestorPenaltyExcludedAtOffset  = 2
+)
+
+var (
+	attestorPenaltiesSlotBytes       = common.BigToHash(big.NewInt(attestorPenaltiesSlot)).Bytes()
+	attestorPenaltyExcludedSlotHash  = common.BigToHash(big.NewInt(attestorPenaltyExcludedSlot))
+	attestorPenaltyExcludedArrayBase = new(big.Int).SetBytes(crypto.Keccak256(attestorPenaltyExcludedSlotHash.Bytes()))
+)
+
+// AttestorPenalty is the penalty ledger record of an attestor
+type AttestorPenalty struct {
+	Attestor               common.Address `json:"attestor"`
+	ConsecutiveDivergences uint64         `json:"consecutiveDivergences"`
+	ConsecutiveAbstentions uint64         `json:"consecutiveAbstentions"`
+	Excluded               bool           `json:"excluded"`
+	ExcludedAt             uint64         `json:"excludedAt"`
+}
+
+// GetAttestorPenaltyLedgerAddr returns the account whose storage holds the penalty ledger.
+// It has no code, the ledger is only ever written by the state transition.
+func GetAttestorPenaltyLedgerAddr(chainID *big.Int, blockTime *big.Int) common.Address {
+	switch {
+	default:
+		return common.HexToAddress("0x1000000000000000000000000000000000000100")
+	}
+}
+
+// GetMaxConsecutiveDivergences returns the number of consecutive rounds in which an attestor may
+// disagree with the majority before it is excluded from the default attestor set. Zero disables
+// exclusion for divergence.
+func GetMaxConsecutiveDivergences(chainID *big.Int, blockNumber *big.Int, blockTime *big.Int) uint64 {
+	return GetFlareParams(chainID, blockNumber, blockTime).MaxConsecutiveDivergences
+}
+
+// GetMaxConsecutiveAbstentions returns the number of consecutive rounds in which an attestor may
+// fail to provide a valid attestation before it is excluded from the default attestor set. Zero
+// disables exclusion for abstention.
+func GetMaxConsecutiveAbstentions(chainID *big.Int, blockNumber *big.Int, blockTime *big.Int) uint64 {
+	return GetFlareParams(chainID, blockNumber, blockTime).MaxConsecutiveAbstentions
+}
+
+// GetAttestorPenaltiesActivated returns whether the penalty ledger is kept and applied to the
+// default attestor set. It is off until a fork sets one of the thresholds.
+func GetAttestorPenaltiesActivated(chainID *big.Int, blockNumber *big.Int, blockTime *big.Int) bool {
+	params := GetFlareParams(chainID, blockNumber, blockTime)
+	return params.MaxConsecutiveDivergences > 0 || params.MaxConsecutiveAbstentions > 0
+}
+
+func attestorPenaltyLocation(attestor common.Address) *big.Int {
+	key := crypto.Keccak256(common.LeftPadBytes(attestor.Bytes(), 32), attestorPenaltiesSlotBytes)
+	return new(big.Int).SetBytes(key)
+}
+
+func attestorPenaltySlot(attestor common.Address, offset int64) common.Hash {
+	return storageSlot(attestorPenaltyLocation(attestor), big.NewInt(offset))
+}
+
+// ReadAttestorPenalty returns the penalty ledger record of [attestor] from [statedb]
+func ReadAttestorPenalty(statedb vm.StateDB, ledger common.Address, attestor common.Address) AttestorPenalty {
+	excludedAt := statedb.GetState(ledger, attestorPenaltySlot(attestor, attestorPenaltyExcludedAtOffset)).Big().Uint64()
+	return AttestorPenalty{
+		Attestor:               attestor,
+		ConsecutiveDivergences: statedb.GetState(ledger, attestorPenaltySlot(attestor, attestorPenaltyDivergencesOffset)).Big().Uint64(),
+		ConsecutiveAbstentions: statedb.GetState(ledger, attestorPenaltySlot(attestor, attestorPenaltyAbstentionsOffset)).Big().Uint64(),
+		Excluded:               excludedAt != 0,
+		ExcludedAt:             excludedAt,
+	}
+}
+
+// ReadExcludedAttestors returns the attestors that the penalty ledger currently excludes from
+// the default attestor set, in the order they were excluded
+func ReadExcludedAttestors(statedb vm.StateDB, ledger common.Address) []common.Address {
+	n := statedb.GetState(ledger, attestorPenaltyExcludedSlotHash).Big().Uint64()
+	excluded := make([]common.Address, 0, n)
+	for i := uint64(0); i < n; i++ {
+		slot := storageSlot(attestorPenaltyExcludedArrayBase, new(big.Int).SetUint64(i))
+		excluded = append(excluded, common.BytesToAddress(statedb.GetState(ledger, slot).Bytes()))
+	}
+	return excluded
+}
+
+func writeAttestorPenalty(statedb vm.StateDB, ledger common.Address, penalty AttestorPenalty) {
+	statedb.SetState(ledger, attestorPenaltySlot(penalty.Attestor, attestorPenaltyDivergencesOffset), common.BigToHash(new(big.Int).SetUint64(penalty.ConsecutiveDivergences)))
+	statedb.SetState(ledger, attestorPenaltySlot(penalty.Attestor, attestorPenaltyAbstentionsOffset), common.BigToHash(new(big.Int).SetUint64(penalty.ConsecutiveAbstentions)))
+	statedb.SetState(ledger, attestorPenaltySlot(penalty.Attestor, attestorPenaltyExcludedAtOffset), common.BigToHash(new(big.Int).SetUint64(penalty.ExcludedAt)))
+}
+
+func writeExcludedAttestors(statedb vm.StateDB, ledger common.Address, previous int, excluded []common.Address) {
+	for i, a := range excluded {
+		slot := storageSlot(attestorPenaltyExcludedArrayBase, big.NewInt(int64(i)))
+		statedb.SetState(ledger, slot, common.BytesToHash(a.Bytes()))
+	}
+	for i := len(excluded); i < previous; i++ {
+		slot := storageSlot(attestorPenaltyExcludedArrayBase, big.NewInt(int64(i)))
+		statedb.SetState(ledger, slot, common.Hash{})
+	}
+	statedb.SetState(ledger, attestorPenaltyExcludedSlotHash, common.BigToHash(big.NewInt(int64(len(excluded)))))
+}
+
+// filterExcludedAttestors splits [attestors] into those that count towards the default attestor
+// set and those that the penalty ledger excludes. Nobody is excluded while penalties are off.
+func (st *StateTransition) filterExcludedAttestors(chainID *big.Int, timestamp *big.Int, attestors []common.Address) ([]common.Address, []common.Address) {
+	if !GetAttestorPenaltiesActivated(chainID, st.evm.Context.BlockNumber, timestamp) {
+		return attestors, nil
+	}
+	ledger := GetAttestorPenaltyLedgerAddr(chainID, timestamp)
+	var active, excluded []common.Address
+	for _, a := range attestors {
+		if st.state.GetState(ledger, attestorPenaltySlot(a, attestorPenaltyExcludedAtOffset)) != (common.Hash{}) {
+			excluded = append(excluded, a)
+		} else {
+			active = append(active, a)
+		}
+	}
+	return active, excluded
+}
+
+// updateAttestorPenalties applies the outcome of a round to the penalty ledger. Only rounds in
+// which the default attestors reached a majority are counted, so an outage of the whole set
+// cannot exclude every attestor. Agreeing with the majority clears both counters, diverging
+// clears the abstention counter and abstaining clears the divergence counter, so that each
+// only counts rounds in a row; an attestor is excluded once either counter reaches its
+// threshold. Excluded attestors are still checked against the majority decision and are
+// readmitted as soon as they agree with it again.
+func (st *StateTransition) updateAttestorPenalties(chainID *big.Int, timestamp *big.Int, currentRoundNumber []byte, votes AttestationVotes, excludedAttestors []common.Address) {
+	if !votes.reachedMajority || !GetAttestorPenaltiesActivated(chainID, st.evm.Context.BlockNumber, timestamp) {
+		return
+	}
+	bufferNumber := new(big.Int).SetBytes(currentRoundNumber)
+	if !bufferNumber.IsUint64() {
+		return
+	}
+	ledger := GetAttestorPenaltyLedgerAddr(chainID, timestamp)
+	maxDivergences := GetMaxConsecutiveDivergences(chainID, st.evm.Context.BlockNumber, timestamp)
+	maxAbstentions := GetMaxConsecutiveAbstentions(chainID, st.evm.Context.BlockNumber, timestamp)
+	excluded := ReadExcludedAttestors(st.state, ledger)
+	previousExcluded := len(excluded)
+	changed := false
+
+	penalise := func(a common.Address, abstention bool) {
+		penalty := ReadAttestorPenalty(st.state, ledger, a)
+		if abstention {
+			penalty.ConsecutiveAbstentions++
+			penalty.ConsecutiveDivergences = 0
+		} else {
+			penalty.ConsecutiveDivergences++
+			penalty.ConsecutiveAbstentions = 0
+		}
+		if !penalty.Excluded &&
+			((maxDivergences > 0 && penalty.ConsecutiveDivergences >= maxDivergences) ||
+				(maxAbstentions > 0 && penalty.ConsecutiveAbstentions >= maxAbstentions)) {
+			penalty.Excluded = true
+			penalty.ExcludedAt = bufferNumber.Uint64()
+			excluded = append(excluded, a)
+			log.Info("Excluding attestor from the default attestor set",
+				"attestor", a,
+				"bufferNumber", penalty.ExcludedAt,
+				"consecutiveDivergences", penalty.ConsecutiveDivergences,
+				"consecutiveAbstentions", penalty.ConsecutiveAbstentions,
+			)
+		}
+		writeAttestorPenalty(st.state, ledger, penalty)
+		changed = true
+	}
+
+	for _, a := range votes.majorityAttestors {
+		if penalty := ReadAttestorPenalty(st.state, ledger, a); penalty.ConsecutiveDivergences != 0 || penalty.ConsecutiveAbstentions != 0 {
+			writeAttestorPenalty(st.state, ledger, AttestorPenalty{Attestor: a})
+			changed = true
+		}
+	}
+	for _, a := range votes.abstainedAttestors {
+		penalise(a, true)
+	}
+	for _, a := range votes.divergentAttestors {
+		penalise(a, false)
+	}
+
+	if len(excludedAttestors) > 0 {
+		readmitted := make(map[common.Address]bool)
+		hashes, errs := st.ReadAttestations(excludedAttestors, bufferNumber)
+		for i, a := range excludedAttestors {
+			if errs[i] == nil && hashes[i] == votes.majorityDecision {
+				writeAttestorPenalty(st.state, ledger, AttestorPenalty{Attestor: a})
+				readmitted[a] = true
+				log.Info("Readmitting attestor to the default attestor set", "attestor", a, "bufferNumber", bufferNumber.Uint64())
+			} else {
+				penalise(a, errs[i] != nil)
+			}
+		}
+		if len(readmitted) > 0 {
+			remaining := excluded[:0]
+			for _, a := range excluded {
+				if !readmitted[a] {
+					remaining = append(remaining, a)
+				}
+			}
+			excluded = remaining
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+	writeExcludedAttestors(st.state, ledger, previousExcluded, excluded)
+	// An account without nonce, balance or code is removed at the end of the transaction
+	if st.state.GetNonce(ledger) == 0 {
+		st.state.SetNonce(ledger, 1)
+	}
+}
@@ -0,0 +1,253 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package core
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ava-labs/coreth/params"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+var (
+	penaltyTestAgreed    = common.HexToHash("0xaa")
+	penaltyTestDivergent = common.HexToHash("0xbb")
+)
+
+func setTestingAttestationProviders(t *testing.T, attestors []common.Address) {
+	providers := make([]string, len(attestors))
+	for i, a := range attestors {
+		providers[i] = a.Hex()
+	}
+	if _, err := SetAttestorConfig(&AttestorConfig{TestingAttestors: providers}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { SetAttestorConfig(&AttestorConfig{}) })
+}
+
+// Set up a state transition on a testing chain whose genesis fork enables the penalty ledger
+// with the given thresholds
+func newPenaltyTestTransition(t *testing.T, chainID int64, maxDivergences uint64, maxAbstentions uint64) *StateTransition {
+	config := *params.TestChainConfig
+	config.ChainID = big.NewInt(chainID)
+	genesis := genesisFlareFork()
+	genesis.MaxConsecutiveDivergences = &maxDivergences
+	genesis.MaxConsecutiveAbstentions = &maxAbstentions
+	if err := SetFlareChainConfig(config.ChainID, &FlareChainConfig{TestingChain: true, Forks: FlareForkSchedule{genesis}}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		flareChainConfigsLock.Lock()
+		delete(flareChainConfigs, config.ChainID.Uint64())
+		flareChainConfigsLock.Unlock()
+	})
+	return newStateConnectorTestTransitionWithConfig(t, &config)
+}
+
+// Cast [votes] for round [bufferNumber] and apply the round to the penalty ledger, the way
+// FinalisePreviousRound does. Attestors without an entry in [votes] abstain.
+func runPenaltyTestRound(t *testing.T, st *StateTransition, bufferNumber int64, votes map[common.Address]common.Hash) AttestationVotes {
+	chainID := st.evm.ChainConfig().ChainID
+	for a, h := range votes {
+		voteTestAttestation(t, st, a, bufferNumber, h, common.BigToHash(big.NewInt(bufferNumber)))
+	}
+	round := common.BigToHash(big.NewInt(bufferNumber)).Bytes()
+	candidates, err := st.GetAttestorCandidates(chainID, st.evm.Context.Time)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaultAttestors, excludedAttestors := st.filterExcludedAttestors(chainID, st.evm.Context.Time, candidates)
+	attestationVotes, err := st.CountAttestations(chainID, st.evm.Context.Time, defaultAttestors, round)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.updateAttestorPenalties(chainID, st.evm.Context.Time, round, attestationVotes, excludedAttestors)
+	return attestationVotes
+}
+
+func penaltyTestVotes(honest []common.Address, extra map[common.Address]common.Hash) map[common.Address]common.Hash {
+	votes := make(map[common.Address]common.Hash)
+	for _, a := range honest {
+		votes[a] = penaltyTestAgreed
+	}
+	for a, h := range extra {
+		votes[a] = h
+	}
+	return votes
+}
+
+func testPenaltyAttestors(n int) []common.Address {
+	attestors := make([]common.Address, n)
+	for i := range attestors {
+		attestors[i] = testAttestor(i)
+	}
+	return attestors
+}
+
+func TestAttestorPenaltiesExcludeAndReadmitDivergentAttestor(t *testing.T) {
+	st := newPenaltyTestTransition(t, 987654330, 5, 0)
+	attestors := testPenaltyAttestors(6)
+	honest, divergent := attestors[:5], attestors[5]
+	setTestingAttestationProviders(t, attestors)
+	chainID := st.evm.ChainConfig().ChainID
+	ledger := GetAttestorPenaltyLedgerAddr(chainID, nil)
+	maxDivergences := GetMaxConsecutiveDivergences(chainID, st.evm.Context.BlockNumber, st.evm.Context.Time)
+
+	// Rounds are two buffers apart so that every submission is in a later buffer than the last
+	bufferNumber := int64(10)
+	for i := uint64(1); i < maxDivergences; i++ {
+		runPenaltyTestRound(t, st, bufferNumber, penaltyTestVotes(honest, map[common.Address]common.Hash{divergent: penaltyTestDivergent}))
+		bufferNumber += 2
+	}
+	penalty := ReadAttestorPenalty(st.state, ledger, divergent)
+	if penalty.Excluded || penalty.ConsecutiveDivergences != maxDivergences-1 {
+		t.Fatalf("unexpected penalty before threshold %+v", penalty)
+	}
+
+	runPenaltyTestRound(t, st, bufferNumber, penaltyTestVotes(honest, map[common.Address]common.Hash{divergent: penaltyTestDivergent}))
+	penalty = ReadAttestorPenalty(st.state, ledger, divergent)
+	if !penalty.Excluded || penalty.ExcludedAt != uint64(bufferNumber) || penalty.ConsecutiveDivergences != maxDivergences {
+		t.Fatalf("expected attestor to be excluded at buffer %d, got %+v", bufferNumber, penalty)
+	}
+	if excluded := ReadExcludedAttestors(st.state, ledger); len(excluded) != 1 || excluded[0] != divergent {
+		t.Errorf("unexpected excluded attestors %v", excluded)
+	}
+	defaultAttestors, err := st.GetDefaultAttestors(chainID, st.evm.Context.Time)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(defaultAttestors) != len(honest) {
+		t.Errorf("excluded attestor still in default set %v", defaultAttestors)
+	}
+	for _, a := range honest {
+		if p := ReadAttestorPenalty(st.state, ledger, a); p != (AttestorPenalty{Attestor: a}) {
+			t.Errorf("honest attestor penalised %+v", p)
+		}
+	}
+
+	// Agreeing with the majority readmits the attestor
+	bufferNumber += 2
+	runPenaltyTestRound(t, st, bufferNumber, penaltyTestVotes(attestors, nil))
+	if penalty = ReadAttestorPenalty(st.state, ledger, divergent); penalty != (AttestorPenalty{Attestor: divergent}) {
+		t.Errorf("expected attestor to be readmitted, got %+v", penalty)
+	}
+	if excluded := ReadExcludedAttestors(st.state, ledger); len(excluded) != 0 {
+		t.Errorf("unexpected excluded attestors %v", excluded)
+	}
+	if defaultAttestors, _ = st.GetDefaultAttestors(chainID, st.evm.Context.Time); len(defaultAttestors) != len(attestors) {
+		t.Errorf("readmitted attestor missing from default set %v", defaultAttestors)
+	}
+}
+
+func TestAttestorPenaltiesExcludeAbstainingAttestor(t *testing.T) {
+	st := newPenaltyTestTransition(t, 987654331, 0, 8)
+	attestors := testPenaltyAttestors(4)
+	honest, absent := attestors[:3], attestors[3]
+	setTestingAttestationProviders(t, attestors)
+	ledger := GetAttestorPenaltyLedgerAddr(st.evm.ChainConfig().ChainID, nil)
+	maxAbstentions := GetMaxConsecutiveAbstentions(st.evm.ChainConfig().ChainID, st.evm.Context.BlockNumber, st.evm.Context.Time)
+
+	bufferNumber := int64(10)
+	for i := uint64(0); i < maxAbstentions; i++ {
+		votes := runPenaltyTestRound(t, st, bufferNumber, penaltyTestVotes(honest, nil))
+		if i == 0 && (len(votes.abstainedAttestors) != 1 || votes.abstainedAttestors[0] != absent) {
+			t.Fatalf("expected %s to abstain, got %+v", absent.Hex(), votes)
+		}
+		bufferNumber += 2
+	}
+	penalty := ReadAttestorPenalty(st.state, ledger, absent)
+	if !penalty.Excluded || penalty.ConsecutiveAbstentions != maxAbstentions || penalty.ConsecutiveDivergences != 0 {
+		t.Fatalf("expected abstaining attestor to be excluded, got %+v", penalty)
+	}
+
+	// Excluded attestors keep accumulating abstentions while they stay away
+	runPenaltyTestRound(t, st, bufferNumber, penaltyTestVotes(honest, nil))
+	if penalty = ReadAttestorPenalty(st.state, ledger, absent); penalty.ConsecutiveAbstentions != maxAbstentions+1 {
+		t.Errorf("unexpected penalty %+v", penalty)
+	}
+	if excluded := ReadExcludedAttestors(st.state, ledger); len(excluded) != 1 {
+		t.Errorf("attestor excluded more than once %v", excluded)
+	}
+}
+
+func TestAttestorPenaltiesResetOnAgreement(t *testing.T) {
+	st := newPenaltyTestTransition(t, 987654332, 3, 3)
+	attestors := testPenaltyAttestors(4)
+	honest, flaky := attestors[:3], attestors[3]
+	setTestingAttestationProviders(t, attestors)
+	ledger := GetAttestorPenaltyLedgerAddr(st.evm.ChainConfig().ChainID, nil)
+
+	runPenaltyTestRound(t, st, 10, penaltyTestVotes(honest, map[common.Address]common.Hash{flaky: penaltyTestDivergent}))
+	runPenaltyTestRound(t, st, 12, penaltyTestVotes(attestors, nil))
+	if penalty := ReadAttestorPenalty(st.state, ledger, flaky); penalty != (AttestorPenalty{Attestor: flaky}) {
+		t.Errorf("expected penalty to be cleared, got %+v", penalty)
+	}
+}
+
+func TestAttestorPenaltiesCountOnlyConsecutiveRounds(t *testing.T) {
+	st := newPenaltyTestTransition(t, 987654333, 3, 3)
+	attestors := testPenaltyAttestors(4)
+	honest, flaky := attestors[:3], attestors[3]
+	setTestingAttestationProviders(t, attestors)
+	ledger := GetAttestorPenaltyLedgerAddr(st.evm.ChainConfig().ChainID, nil)
+
+	// Alternating between diverging and abstaining never reaches either threshold
+	bufferNumber := int64(10)
+	for i := 0; i < 6; i++ {
+		extra := map[common.Address]common.Hash{flaky: penaltyTestDivergent}
+		want := AttestorPenalty{Attestor: flaky, ConsecutiveDivergences: 1}
+		if i%2 == 1 {
+			extra = nil
+			want = AttestorPenalty{Attestor: flaky, ConsecutiveAbstentions: 1}
+		}
+		runPenaltyTestRound(t, st, bufferNumber, penaltyTestVotes(honest, extra))
+		if penalty := ReadAttestorPenalty(st.state, ledger, flaky); penalty != want {
+			t.Fatalf("round %d: got %+v want %+v", i, penalty, want)
+		}
+		bufferNumber += 2
+	}
+}
+
+func TestAttestorPenaltiesOffByDefault(t *testing.T) {
+	st := newStateConnectorTestTransition(t)
+	attestors := testPenaltyAttestors(4)
+	honest, divergent := attestors[:3], attestors[3]
+	setTestingAttestationProviders(t, attestors)
+	chainID := st.evm.ChainConfig().ChainID
+	ledger := GetAttestorPenaltyLedgerAddr(chainID, nil)
+	if GetAttestorPenaltiesActivated(chainID, st.evm.Context.BlockNumber, st.evm.Context.Time) {
+		t.Fatal("penalties activated at genesis")
+	}
+
+	bufferNumber := int64(10)
+	for i := 0; i < 50; i++ {
+		runPenaltyTestRound(t, st, bufferNumber, penaltyTestVotes(honest, map[common.Address]common.Hash{divergent: penaltyTestDivergent}))
+		bufferNumber += 2
+	}
+	if penalty := ReadAttestorPenalty(st.state, ledger, divergent); penalty != (AttestorPenalty{Attestor: divergent}) {
+		t.Errorf("penalty recorded while penalties are off %+v", penalty)
+	}
+	if defaultAttestors, err := st.GetDefaultAttestors(chainID, st.evm.Context.Time); err != nil || len(defaultAttestors) != len(attestors) {
+		t.Errorf("got default attestors (%v, %v) want all %d", defaultAttestors, err, len(attestors))
+	}
+}
+
+func TestAttestorPenaltiesIgnoreRoundsWithoutMajority(t *testing.T) {
+	st := newPenaltyTestTransition(t, 987654334, 3, 3)
+	attestors := testPenaltyAttestors(4)
+	setTestingAttestationProviders(t, attestors)
+	ledger := GetAttestorPenaltyLedgerAddr(st.evm.ChainConfig().ChainID, nil)
+
+	// Nobody votes
+	votes := runPenaltyTestRound(t, st, 10, nil)
+	if votes.reachedMajority {
+		t.Fatalf("unexpected majority %+v", votes)
+	}
+	for _, a := range attestors {
+		if penalty := ReadAttestorPenalty(st.state, ledger, a); penalty != (AttestorPenalty{Attestor: a}) {
+			t.Errorf("attestor penalised in a round without majority %+v", penalty)
+		}
+	}
+}
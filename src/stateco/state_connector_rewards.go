@@ -0,0 +1,134 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package core
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ava-labs/coreth/core/vm"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Define errors
+type ErrMaxAttestorRewardExceeded struct {
+	rewardMax    *big.Int
+	rewardBudget *big.Int
+}
+
+func (e *ErrMaxAttestorRewardExceeded) Error() string {
+	return fmt.Sprintf("attestor reward budget of %s exceeded max of %s", e.rewardBudget.Text(10), e.rewardMax.Text(10))
+}
+
+type ErrAttestorRewardNegative struct{}
+
+func (e *ErrAttestorRewardNegative) Error() string {
+	return "attestor reward budget cannot be negative"
+}
+
+// Define reward parameters that can change by block height
+
+// GetAttestorRewardPerRound returns the total amount minted to the majority attestors of a
+// finalised round. A budget of zero disables rewards.
+func GetAttestorRewardPerRound(blockNumber *big.Int) *big.Int {
+	switch {
+	default:
+		return big.NewInt(0)
+	}
+}
+
+func GetMaximumAttestorRewardPerRound(blockNumber *big.Int) *big.Int {
+	switch {
+	default:
+		maxReward, _ := new(big.Int).SetString("1000000000000000000000000", 10)
+		return maxReward
+	}
+}
+
+// GetAttestorRewardContract returns the contract that distributes attestor rewards. While it
+// is the zero address, rewards are credited directly to the attestors.
+func GetAttestorRewardContract(blockNumber *big.Int) common.Address {
+	switch {
+	default:
+		return common.Address{}
+	}
+}
+
+// GetAttestorRewardSelector returns the selector of distributeRewards(address[]) on the reward contract
+func GetAttestorRewardSelector(blockNumber *big.Int) []byte {
+	switch {
+	default:
+		return []byte{0xc5, 0xa5, 0x51, 0x52}
+	}
+}
+
+// encodeAddressArrayCall ABI encodes a call of [selector] with a single address[] argument
+func encodeAddressArrayCall(selector []byte, attestors []common.Address) []byte {
+	input := append([]byte{}, selector...)
+	input = append(input, common.BigToHash(big.NewInt(32)).Bytes()...)
+	input = append(input, common.BigToHash(big.NewInt(int64(len(attestors)))).Bytes()...)
+	for _, a := range attestors {
+		input = append(input, common.LeftPadBytes(a.Bytes(), 32)...)
+	}
+	return input
+}
+
+// rewardAttestors splits [budget] equally between [attestors]; any remainder is not minted.
+// If [rewardContract] is set, the total is minted to the contract before the attestor list is
+// passed to it, so that the contract can pay the attestors out during the call, and it is
+// taken back if that call fails. Otherwise each share is minted directly to the attestor.
+// Rewards are minted like any other issuance, so they count against the mint caps and are
+// recorded in the mint ledger.
+func rewardAttestors(evm EVMCaller, attestors []common.Address, budget *big.Int, rewardContract common.Address) error {
+	max := GetMaximumAttestorRewardPerRound(evm.GetBlockNumber())
+	if budget.Sign() < 0 {
+		return &ErrAttestorRewardNegative{}
+	} else if budget.Cmp(max) > 0 {
+		return &ErrMaxAttestorRewardExceeded{
+			rewardBudget: budget,
+			rewardMax:    max,
+		}
+	}
+	if budget.Sign() == 0 || len(attestors) == 0 {
+		return nil
+	}
+	share := new(big.Int).Div(budget, big.NewInt(int64(len(attestors))))
+	if share.Sign() == 0 {
+		return nil
+	}
+	total := new(big.Int).Mul(share, big.NewInt(int64(len(attestors))))
+	if err := checkMintCaps(evm, total); err != nil {
+		return err
+	}
+	if rewardContract == (common.Address{}) {
+		for _, a := range attestors {
+			evm.AddBalance(a, share)
+		}
+		recordMint(evm, total)
+		return nil
+	}
+	evm.AddBalance(rewardContract, total)
+	_, _, err := evm.systemCalls().call(
+		evm,
+		SystemCallAttestorReward,
+		vm.AccountRef(rewardContract),
+		rewardContract,
+		encodeAddressArrayCall(GetAttestorRewardSelector(evm.GetBlockNumber()), attestors),
+		GetFlareDaemonGasMultiplier(evm.GetChainID(), evm.GetBlockNumber(), evm.GetBlockTime())*evm.GetGasLimit())
+	if err != nil {
+		// The failed call reverted whatever it did with the reward, so the contract still holds it
+		evm.SubBalance(rewardContract, total)
+		return err
+	}
+	recordMint(evm, total)
+	return nil
+}
+
+func distributeAttestorRewards(evm EVMCaller, attestors []common.Address) error {
+	return rewardAttestors(
+		evm,
+		attestors,
+		GetAttestorRewardPerRound(evm.GetBlockNumber()),
+		GetAttestorRewardContract(evm.GetBlockNumber()))
+}
@@ -0,0 +1,286 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package core
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ava-labs/coreth/core/vm"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Define a mock that keeps balances and records the last call input and the balance of the
+// called contract at the time of the call, on top of the MockEVMCallerData spy
+type RewardRecordingEVMMock struct {
+	mockEVMCallerData MockEVMCallerData
+	balances          map[common.Address]*big.Int
+	lastCallAddr      common.Address
+	lastCallInput     []byte
+	lastCallBalance   *big.Int
+	callErr           error
+}
+
+func (e *RewardRecordingEVMMock) Call(caller vm.ContractRef, addr common.Address, input []byte, gas uint64, value *big.Int) (ret []byte, leftOverGas uint64, err error) {
+	e.lastCallAddr = addr
+	e.lastCallInput = input
+	e.lastCallBalance = new(big.Int).Set(e.balance(addr))
+	if e.callErr != nil {
+		e.mockEVMCallerData.callCalls++
+		return nil, 0, e.callErr
+	}
+	return defautCall(&e.mockEVMCallerData, caller, addr, input, gas, value)
+}
+
+func (e *RewardRecordingEVMMock) GetChainID() *big.Int {
+	return defaultGetChainID(&e.mockEVMCallerData)
+}
+
+func (e *RewardRecordingEVMMock) GetBlockNumber() *big.Int {
+	return defaultGetBlockNumber(&e.mockEVMCallerData)
+}
+
+func (e *RewardRecordingEVMMock) GetBlockTime() *big.Int {
+	return defaultGetBlockTime(&e.mockEVMCallerData)
+}
+
+func (e *RewardRecordingEVMMock) GetGasLimit() uint64 {
+	return defaultGetGasLimit(&e.mockEVMCallerData)
+}
+
+func (e *RewardRecordingEVMMock) AddBalance(addr common.Address, amount *big.Int) {
+	defaultAddBalance(&e.mockEVMCallerData, addr, amount)
+	if e.balances[addr] == nil {
+		e.balances[addr] = new(big.Int)
+	}
+	e.balances[addr].Add(e.balances[addr], amount)
+}
+
+func (e *RewardRecordingEVMMock) SubBalance(addr common.Address, amount *big.Int) {
+	defaultSubBalance(&e.mockEVMCallerData, addr, amount)
+	if e.balances[addr] == nil {
+		e.balances[addr] = new(big.Int)
+	}
+	e.balances[addr].Sub(e.balances[addr], amount)
+}
+
+func (e *RewardRecordingEVMMock) balance(addr common.Address) *big.Int {
+	if e.balances[addr] == nil {
+		return new(big.Int)
+	}
+	return e.balances[addr]
+}
+
+func (e *RewardRecordingEVMMock) GetState(addr common.Address, key common.Hash) common.Hash {
+	return defaultGetState(&e.mockEVMCallerData, addr, key)
+}
+
+func (e *RewardRecordingEVMMock) SetState(addr common.Address, key common.Hash, value common.Hash) {
+	defaultSetState(&e.mockEVMCallerData, addr, key, value)
+}
+
+func (e *RewardRecordingEVMMock) GetNonce(addr common.Address) uint64 {
+	return defaultGetNonce(&e.mockEVMCallerData, addr)
+}
+
+func (e *RewardRecordingEVMMock) SetNonce(addr common.Address, nonce uint64) {
+	defaultSetNonce(&e.mockEVMCallerData, addr, nonce)
+}
+
+func (e *RewardRecordingEVMMock) systemCalls() *SystemCalls {
+	return defaultSystemCalls(&e.mockEVMCallerData)
+}
+
+func newRewardRecordingEVMMock() *RewardRecordingEVMMock {
+	return &RewardRecordingEVMMock{
+		mockEVMCallerData: MockEVMCallerData{
+			blockNumber:       *big.NewInt(0),
+			gasLimit:          0,
+			mintRequestReturn: *big.NewInt(0),
+		},
+		balances: make(map[common.Address]*big.Int),
+	}
+}
+
+func TestAttestorRewardsShouldCreditMajorityAttestors(t *testing.T) {
+	// Assemble
+	evmMock := newRewardRecordingEVMMock()
+	attestors := testPenaltyAttestors(3)
+	budget := big.NewInt(3000)
+
+	// Act
+	err := rewardAttestors(evmMock, attestors, budget, common.Address{})
+
+	// Assert
+	if err != nil {
+		t.Fatalf("unexpected error returned; was = %s", err.Error())
+	}
+	if evmMock.mockEVMCallerData.addBalanceCalls != 3 {
+		t.Errorf("AddBalance call count not as expected. got %d want 3", evmMock.mockEVMCallerData.addBalanceCalls)
+	}
+	if evmMock.mockEVMCallerData.callCalls != 0 {
+		t.Errorf("EVM Call called unexpectedly")
+	}
+	for _, a := range attestors {
+		if evmMock.balances[a] == nil || evmMock.balances[a].Cmp(big.NewInt(1000)) != 0 {
+			t.Errorf("wanted reward 1000 for %s; got %v", a.Hex(), evmMock.balances[a])
+		}
+	}
+}
+
+func TestAttestorRewardsShouldNotMintRemainder(t *testing.T) {
+	evmMock := newRewardRecordingEVMMock()
+	attestors := testPenaltyAttestors(3)
+
+	err := rewardAttestors(evmMock, attestors, big.NewInt(3002), common.Address{})
+
+	if err != nil {
+		t.Fatalf("unexpected error returned; was = %s", err.Error())
+	}
+	total := new(big.Int)
+	for _, b := range evmMock.balances {
+		total.Add(total, b)
+	}
+	if total.Cmp(big.NewInt(3000)) != 0 {
+		t.Errorf("wanted total minted 3000; got %s", total.Text(10))
+	}
+}
+
+func TestAttestorRewardsShouldNotRewardMoreThanMax(t *testing.T) {
+	evmMock := newRewardRecordingEVMMock()
+	budget := new(big.Int).Add(GetMaximumAttestorRewardPerRound(big.NewInt(0)), big.NewInt(1))
+
+	err := rewardAttestors(evmMock, testPenaltyAttestors(2), budget, common.Address{})
+
+	if err != nil {
+		if err, ok := err.(*ErrMaxAttestorRewardExceeded); !ok {
+			want := &ErrMaxAttestorRewardExceeded{
+				rewardBudget: budget,
+				rewardMax:    GetMaximumAttestorRewardPerRound(big.NewInt(0)),
+			}
+			t.Errorf("got '%s' want '%s'", err.Error(), want.Error())
+		}
+	} else {
+		t.Errorf("no error returned as expected")
+	}
+	if evmMock.mockEVMCallerData.addBalanceCalls != 0 {
+		t.Errorf("AddBalance called unexpectedly")
+	}
+}
+
+func TestAttestorRewardsShouldNotRewardNegative(t *testing.T) {
+	evmMock := newRewardRecordingEVMMock()
+
+	err := rewardAttestors(evmMock, testPenaltyAttestors(2), big.NewInt(-1), common.Address{})
+
+	if err != nil {
+		if err, ok := err.(*ErrAttestorRewardNegative); !ok {
+			want := &ErrAttestorRewardNegative{}
+			t.Errorf("got '%s' want '%s'", err.Error(), want.Error())
+		}
+	} else {
+		t.Errorf("no error returned as expected")
+	}
+	if evmMock.mockEVMCallerData.addBalanceCalls != 0 {
+		t.Errorf("AddBalance called unexpectedly")
+	}
+}
+
+func TestAttestorRewardsShouldNotErrorWithoutAttestors(t *testing.T) {
+	evmMock := newRewardRecordingEVMMock()
+
+	err := rewardAttestors(evmMock, nil, big.NewInt(1000), common.Address{})
+
+	if err != nil {
+		t.Errorf("unexpected error returned; was %s", err.Error())
+	}
+	if evmMock.mockEVMCallerData.addBalanceCalls != 0 {
+		t.Errorf("AddBalance called unexpectedly")
+	}
+}
+
+func TestAttestorRewardsShouldBeDisabledByDefault(t *testing.T) {
+	evmMock := newRewardRecordingEVMMock()
+
+	err := distributeAttestorRewards(evmMock, testPenaltyAttestors(2))
+
+	if err != nil {
+		t.Errorf("unexpected error returned; was %s", err.Error())
+	}
+	if evmMock.mockEVMCallerData.addBalanceCalls != 0 || evmMock.mockEVMCallerData.callCalls != 0 {
+		t.Errorf("rewards issued with a zero budget")
+	}
+}
+
+func TestAttestorRewardsShouldFundRewardContract(t *testing.T) {
+	// Assemble
+	evmMock := newRewardRecordingEVMMock()
+	attestors := testPenaltyAttestors(2)
+	rewardContract := common.HexToAddress("0x1000000000000000000000000000000000000004")
+
+	// Act
+	err := rewardAttestors(evmMock, attestors, big.NewInt(2001), rewardContract)
+
+	// Assert
+	if err != nil {
+		t.Fatalf("unexpected error returned; was = %s", err.Error())
+	}
+	if evmMock.mockEVMCallerData.callCalls != 1 || evmMock.lastCallAddr != rewardContract {
+		t.Errorf("reward contract not called as expected")
+	}
+	wantInput := encodeAddressArrayCall(GetAttestorRewardSelector(big.NewInt(0)), attestors)
+	if string(evmMock.lastCallInput) != string(wantInput) || len(wantInput) != 4+32*4 {
+		t.Errorf("unexpected reward contract input %x", evmMock.lastCallInput)
+	}
+	if evmMock.lastCallBalance.Cmp(big.NewInt(2000)) != 0 {
+		t.Errorf("reward contract must be funded before it is called; held %s during the call", evmMock.lastCallBalance.Text(10))
+	}
+	if evmMock.balances[rewardContract].Cmp(big.NewInt(2000)) != 0 {
+		t.Errorf("wanted amount 2000; got amount %s", evmMock.balances[rewardContract].Text(10))
+	}
+}
+
+func TestAttestorRewardsShouldNotFundRewardContractOnCallError(t *testing.T) {
+	// Set up mock EVM call to return an error
+	evmMock := newRewardRecordingEVMMock()
+	evmMock.callErr = errors.New("call failed")
+	rewardContract := common.HexToAddress("0x1000000000000000000000000000000000000004")
+
+	err := rewardAttestors(evmMock, testPenaltyAttestors(2), big.NewInt(2000), rewardContract)
+
+	if err != evmMock.callErr {
+		t.Errorf("got %v want %v", err, evmMock.callErr)
+	}
+	if evmMock.lastCallBalance.Cmp(big.NewInt(2000)) != 0 {
+		t.Errorf("reward contract not funded during the call; held %s", evmMock.lastCallBalance.Text(10))
+	}
+	if evmMock.balance(rewardContract).Sign() != 0 {
+		t.Errorf("reward kept after a failed call; got %s", evmMock.balance(rewardContract).Text(10))
+	}
+}
+
+func TestAttestorRewardsShouldCountAgainstMintCaps(t *testing.T) {
+	chainID := big.NewInt(987654325)
+	setMintLedgerChainConfig(t, chainID, 3600, big.NewInt(3000), nil)
+	evmMock := newRewardRecordingEVMMock()
+	evmMock.mockEVMCallerData.chainID = *chainID
+	evmMock.mockEVMCallerData.blockTime = *big.NewInt(7200)
+	attestors := testPenaltyAttestors(2)
+
+	if err := rewardAttestors(evmMock, attestors, big.NewInt(2000), common.Address{}); err != nil {
+		t.Fatalf("unexpected error returned; was = %s", err.Error())
+	}
+	ledger := ReadMintLedger(evmMock, GetMintLedgerAddr(chainID, big.NewInt(7200)), 3600, 7200)
+	if ledger.TotalMinted.Cmp(big.NewInt(2000)) != 0 || ledger.EpochMinted.Cmp(big.NewInt(2000)) != 0 {
+		t.Errorf("rewards not recorded in the mint ledger; got %+v", ledger)
+	}
+	err := rewardAttestors(evmMock, attestors, big.NewInt(2000), common.Address{})
+	if _, ok := err.(*ErrMintCapExceeded); !ok {
+		t.Errorf("got %v want ErrMintCapExceeded", err)
+	}
+	if evmMock.balance(attestors[0]).Cmp(big.NewInt(1000)) != 0 {
+		t.Errorf("reward minted over the cap; got %s", evmMock.balance(attestors[0]).Text(10))
+	}
+}
@@ -0,0 +1,194 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package core
+
+import (
+	"encoding/binary"
+	"math/big"
+
+	"github.com/ava-labs/coreth/core/types"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+var (
+	attestationRoundPrefix    = []byte("stateco-round-")     // attestationRoundPrefix + bufferNumber (uint64 big endian) -> AttestationRound
+	attestorHistoryPrefix     = []byte("stateco-attestor-")  // attestorHistoryPrefix + attestor + bufferNumber -> AttestorVote.Vote
+	divergentRoundIndexPrefix = []byte("stateco-divergent-") // divergentRoundIndexPrefix + bufferNumber -> nil
+)
+
+// Classification of an attestor's vote in a round
+const (
+	AttestorVoteMajority  = "majority"
+	AttestorVoteDivergent = "divergent"
+	AttestorVoteAbstained = "abstained"
+)
+
+// AttestationRound is the vote breakdown of a state connector round, as seen by this node.
+// BufferNumber is the buffer number passed to getAttestation and finaliseRound.
+type AttestationRound struct {
+	BufferNumber       uint64           `json:"bufferNumber"`
+	BlockNumber        uint64           `json:"blockNumber"`
+	Timestamp          uint64           `json:"timestamp"`
+	ReachedMajority    bool             `json:"reachedMajority"`
+	Finalised          bool             `json:"finalised"`
+	MajorityDecision   common.Hash      `json:"majorityDecision"`
+	MajorityAttestors  []common.Address `json:"majorityAttestors"`
+	DivergentAttestors []common.Address `json:"divergentAttestors"`
+	AbstainedAttestors []common.Address `json:"abstainedAttestors"`
+}
+
+// AttestorVote is one entry in the history of an attestor
+type AttestorVote struct {
+	BufferNumber uint64 `json:"bufferNumber"`
+	Vote         string `json:"vote"`
+}
+
+func attestationRoundKey(bufferNumber uint64) []byte {
+	return append(append([]byte{}, attestationRoundPrefix...), encodeBufferNumber(bufferNumber)...)
+}
+
+func attestorHistoryKey(attestor common.Address, bufferNumber uint64) []byte {
+	key := append(append([]byte{}, attestorHistoryPrefix...), attestor.Bytes()...)
+	return append(key, encodeBufferNumber(bufferNumber)...)
+}
+
+func divergentRoundKey(bufferNumber uint64) []byte {
+	return append(append([]byte{}, divergentRoundIndexPrefix...), encodeBufferNumber(bufferNumber)...)
+}
+
+func encodeBufferNumber(bufferNumber uint64) []byte {
+	enc := make([]byte, 8)
+	binary.BigEndian.PutUint64(enc, bufferNumber)
+	return enc
+}
+
+// recordAttestationRound keeps the breakdown of a round that has just been finalised or failed
+// until its block is accepted. Simulated calls (eth_call, gas estimation) are never recorded. A
+// round that is finalised again in a later accepted block overwrites the earlier record.
+func (st *StateTransition) recordAttestationRound(currentRoundNumber []byte, votes AttestationVotes, finalised bool) {
+	if st.msg.IsFake() {
+		return
+	}
+	bufferNumber := new(big.Int).SetBytes(currentRoundNumber)
+	if !bufferNumber.IsUint64() {
+		return
+	}
+	round := &AttestationRound{
+		BufferNumber:       bufferNumber.Uint64(),
+		BlockNumber:        st.evm.Context.BlockNumber.Uint64(),
+		Timestamp:          st.evm.Context.Time.Uint64(),
+		ReachedMajority:    votes.reachedMajority,
+		Finalised:          finalised,
+		MajorityAttestors:  votes.majorityAttestors,
+		DivergentAttestors: votes.divergentAttestors,
+		AbstainedAttestors: votes.abstainedAttestors,
+	}
+	if votes.reachedMajority {
+		round.MajorityDecision = common.HexToHash(votes.majorityDecision)
+	}
+	addBlockRecord(st.state, round.BlockNumber, blockRecordAttestationRound, round)
+}
+
+// AcceptAttestationRounds stores the breakdowns of the rounds finalised in the accepted [block]
+func AcceptAttestationRounds(db ethdb.KeyValueStore, block *types.Block) error {
+	for _, round := range takeBlockRecords(block, blockRecordAttestationRound) {
+		if err := writeAttestationRound(db, round.(*AttestationRound)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeAttestationRound(db ethdb.KeyValueStore, round *AttestationRound) error {
+	enc, err := rlp.EncodeToBytes(round)
+	if err != nil {
+		return err
+	}
+	batch := db.NewBatch()
+	if err := batch.Put(attestationRoundKey(round.BufferNumber), enc); err != nil {
+		return err
+	}
+	// An attestor is listed in one classification only, later classifications take precedence
+	// defensively, should an attestor ever be listed twice
+	votes := make(map[common.Address]string)
+	for _, a := range round.MajorityAttestors {
+		votes[a] = AttestorVoteMajority
+	}
+	for _, a := range round.DivergentAttestors {
+		votes[a] = AttestorVoteDivergent
+	}
+	for _, a := range round.AbstainedAttestors {
+		votes[a] = AttestorVoteAbstained
+	}
+	for a, vote := range votes {
+		if err := batch.Put(attestorHistoryKey(a, round.BufferNumber), []byte(vote)); err != nil {
+			return err
+		}
+	}
+	if len(round.DivergentAttestors) > 0 {
+		err = batch.Put(divergentRoundKey(round.BufferNumber), nil)
+	} else {
+		err = batch.Delete(divergentRoundKey(round.BufferNumber))
+	}
+	if err != nil {
+		return err
+	}
+	return batch.Write()
+}
+
+// ReadAttestationRound returns the stored breakdown of round [bufferNumber], or nil if there is none
+func ReadAttestationRound(db ethdb.KeyValueReader, bufferNumber uint64) (*AttestationRound, error) {
+	enc, err := readRecord(db, attestationRoundKey(bufferNumber))
+	if err != nil || enc == nil {
+		return nil, err
+	}
+	round := new(AttestationRound)
+	if err := rlp.DecodeBytes(enc, round); err != nil {
+		return nil, err
+	}
+	return round, nil
+}
+
+// ReadAttestorHistory returns how [attestor] voted in every stored round, oldest first
+func ReadAttestorHistory(db ethdb.Iteratee, attestor common.Address) []AttestorVote {
+	prefix := append(append([]byte{}, attestorHistoryPrefix...), attestor.Bytes()...)
+	it := db.NewIterator(prefix, nil)
+	defer it.Release()
+
+	var history []AttestorVote
+	for it.Next() {
+		key := it.Key()
+		if len(key) != len(prefix)+8 {
+			continue
+		}
+		history = append(history, AttestorVote{
+			BufferNumber: binary.BigEndian.Uint64(key[len(prefix):]),
+			Vote:         string(it.Value()),
+		})
+	}
+	return history
+}
+
+// ReadDivergentRounds returns the buffer numbers in [from, to] of stored rounds with at least one
+// divergent attestor, in ascending order
+func ReadDivergentRounds(db ethdb.Iteratee, from uint64, to uint64) []uint64 {
+	it := db.NewIterator(divergentRoundIndexPrefix, encodeBufferNumber(from))
+	defer it.Release()
+
+	var rounds []uint64
+	for it.Next() {
+		key := it.Key()
+		if len(key) != len(divergentRoundIndexPrefix)+8 {
+			continue
+		}
+		bufferNumber := binary.BigEndian.Uint64(key[len(divergentRoundIndexPrefix):])
+		if bufferNumber > to {
+			break
+		}
+		rounds = append(rounds, bufferNumber)
+	}
+	return rounds
+}
@@ -0,0 +1,102 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package core
+
+import (
+	"errors"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/ava-labs/coreth/core/rawdb"
+	"github.com/ava-labs/coreth/core/types"
+	"github.com/ava-labs/coreth/core/vm"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+var errRoundsTestDB = errors.New("database failure")
+
+// Define a database whose reads always fail
+type FailingDBMock struct{}
+
+func (FailingDBMock) Has(key []byte) (bool, error) { return false, errRoundsTestDB }
+
+func (FailingDBMock) Get(key []byte) ([]byte, error) { return nil, errRoundsTestDB }
+
+// Record round [bufferNumber] from a transaction of a block executed on the state of [st]
+func recordTestRound(st *StateTransition, bufferNumber int64) {
+	to := stateConnectorTestAddr
+	st.msg = types.NewMessage(common.Address{}, &to, 0, big.NewInt(0), 0, big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, false)
+	votes := AttestationVotes{
+		reachedMajority:    true,
+		majorityDecision:   penaltyTestAgreed.Hex()[2:],
+		majorityAttestors:  []common.Address{testAttestor(0), testAttestor(1)},
+		divergentAttestors: []common.Address{testAttestor(2)},
+		abstainedAttestors: []common.Address{testAttestor(3)},
+	}
+	st.recordAttestationRound(common.BigToHash(big.NewInt(bufferNumber)).Bytes(), votes, true)
+}
+
+func TestAttestationRoundsAreStoredOnAccept(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	st := newStateConnectorTestTransition(t)
+	accepted := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1), Extra: []byte("accepted")})
+	rejected := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1), Extra: []byte("rejected")})
+
+	// A block that is executed and then rejected leaves nothing behind
+	recordTestRound(st, 10)
+	FinaliseBlockRecords(st.state, rejected.Hash())
+	// The accepted block is executed twice, to build and to verify it
+	other := newStateConnectorTestTransition(t)
+	recordTestRound(other, 12)
+	FinaliseBlockRecords(other.state, accepted.Hash())
+	recordTestRound(other, 12)
+	FinaliseBlockRecords(other.state, accepted.Hash())
+	if round, err := ReadAttestationRound(db, 12); round != nil || err != nil {
+		t.Fatalf("round stored before its block was accepted: got (%+v, %v)", round, err)
+	}
+
+	if err := AcceptAttestationRounds(db, accepted); err != nil {
+		t.Fatal(err)
+	}
+	if round, err := ReadAttestationRound(db, 10); round != nil || err != nil {
+		t.Errorf("round of rejected block stored: got (%+v, %v)", round, err)
+	}
+	round, err := ReadAttestationRound(db, 12)
+	if err != nil || round == nil {
+		t.Fatalf("got (%+v, %v)", round, err)
+	}
+	if !round.Finalised || round.BlockNumber != 1 || round.MajorityDecision != penaltyTestAgreed || len(round.MajorityAttestors) != 2 {
+		t.Errorf("unexpected round %+v", round)
+	}
+	if got := ReadAttestorHistory(db, testAttestor(3)); !reflect.DeepEqual(got, []AttestorVote{{BufferNumber: 12, Vote: AttestorVoteAbstained}}) {
+		t.Errorf("unexpected attestor history %+v", got)
+	}
+	if got := ReadDivergentRounds(db, 0, 100); !reflect.DeepEqual(got, []uint64{12}) {
+		t.Errorf("unexpected divergent rounds %v", got)
+	}
+
+	// The rounds of both executions are dropped
+	blockRecordsLock.Lock()
+	defer blockRecordsLock.Unlock()
+	for _, statedb := range []vm.StateDB{st.state, other.state} {
+		if r := executingBlockRecords[statedb]; r != nil && r.records[blockRecordAttestationRound] != nil {
+			t.Errorf("rounds kept after accept while executing: %v", r.records[blockRecordAttestationRound])
+		}
+	}
+	for _, hash := range []common.Hash{accepted.Hash(), rejected.Hash()} {
+		if r := executedBlockRecords[hash]; r != nil && r.records[blockRecordAttestationRound] != nil {
+			t.Errorf("rounds of block %x kept after accept: %v", hash, r.records[blockRecordAttestationRound])
+		}
+	}
+}
+
+func TestReadAttestationRoundReturnsDatabaseErrors(t *testing.T) {
+	if round, err := ReadAttestationRound(rawdb.NewMemoryDatabase(), 10); round != nil || err != nil {
+		t.Errorf("missing round: got (%+v, %v)", round, err)
+	}
+	if _, err := ReadAttestationRound(FailingDBMock{}, 10); err != errRoundsTestDB {
+		t.Errorf("got %v want %v", err, errRoundsTestDB)
+	}
+}
@@ -0,0 +1,147 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package core
+
+import (
+	"encoding/hex"
+	"math/big"
+	"runtime"
+	"sync"
+
+	"github.com/ava-labs/coreth/core/stateconnector"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Storage layout of StateConnector.sol as compiled by solc 0.7.6. Constants do not occupy
+// storage, so the `buffers` mapping is the first slot.
+const (
+	stateConnectorBuffersSlot      = stateconnector.BuffersSlot
+	stateConnectorTotalBuffersSlot = stateconnector.TotalBuffersSlot
+	stateConnectorMerkleRootsSlot  = stateconnector.MerkleRootsSlot
+
+	stateConnectorTotalStoredBuffers = stateconnector.TotalStoredBuffers
+	stateConnectorVoteSlots          = 3                                                          // {maskedMerkleHash, committedRandom, revealedRandom}
+	stateConnectorLatestVoteOffset   = stateConnectorTotalStoredBuffers * stateConnectorVoteSlots // Buffers.latestVote
+)
+
+// Attestation sets smaller than this are verified on the calling goroutine, spinning up
+// workers costs more than the hashing it would save.
+const minParallelAttestations = 64
+
+var (
+	storageSlotModulus             = new(big.Int).Lsh(big.NewInt(1), 256)
+	stateConnectorBuffersSlotBytes = common.BigToHash(big.NewInt(stateConnectorBuffersSlot)).Bytes()
+	errAttestationReverted         = &ErrAttestationReverted{}
+)
+
+type ErrAttestationReverted struct{}
+
+func (e *ErrAttestationReverted) Error() string { return "getAttestation would revert for attestor" }
+
+// storedVote holds the raw storage words that getAttestation(bufferNumber) reads for one attestor
+type storedVote struct {
+	latestVote       common.Hash
+	maskedMerkleHash common.Hash
+	committedRandom  common.Hash
+	revealedRandom   common.Hash
+}
+
+// buffersLocation returns the storage location of buffers[attestor]
+func buffersLocation(attestor common.Address) *big.Int {
+	key := crypto.Keccak256(common.LeftPadBytes(attestor.Bytes(), 32), stateConnectorBuffersSlotBytes)
+	return new(big.Int).SetBytes(key)
+}
+
+// storageSlot returns the slot at [offset] words past [base], wrapping like the EVM does
+func storageSlot(base *big.Int, offset *big.Int) common.Hash {
+	slot := new(big.Int).Add(base, offset)
+	return common.BigToHash(slot.Mod(slot, storageSlotModulus))
+}
+
+// voteOffset returns the offset of field [field] of buffers[attestor].votes[index] within Buffers
+func voteOffset(index *big.Int, field int64) *big.Int {
+	offset := new(big.Int).Mul(index, big.NewInt(stateConnectorVoteSlots))
+	return offset.Add(offset, big.NewInt(field))
+}
+
+// readStoredVotes reads the storage words needed to evaluate getAttestation(bufferNumber)
+// for every attestor. The StateDB is not safe for concurrent use, so all reads happen here.
+func (st *StateTransition) readStoredVotes(attestors []common.Address, bufferNumber *big.Int) []storedVote {
+	contract := st.to()
+	one := big.NewInt(1)
+	totalStoredBuffers := big.NewInt(stateConnectorTotalStoredBuffers)
+	prevBufferNumber := new(big.Int).Sub(bufferNumber, one)
+	revealIndex := new(big.Int).Mod(prevBufferNumber, totalStoredBuffers)
+	commitIndex := new(big.Int).Mod(new(big.Int).Sub(prevBufferNumber, one), totalStoredBuffers)
+	latestVoteOffset := big.NewInt(stateConnectorLatestVoteOffset)
+	maskedOffset := voteOffset(commitIndex, 0)
+	committedOffset := voteOffset(commitIndex, 1)
+	revealedOffset := voteOffset(revealIndex, 2)
+
+	votes := make([]storedVote, len(attestors))
+	for i, a := range attestors {
+		base := buffersLocation(a)
+		votes[i].latestVote = st.state.GetState(contract, storageSlot(base, latestVoteOffset))
+		votes[i].maskedMerkleHash = st.state.GetState(contract, storageSlot(base, maskedOffset))
+		votes[i].committedRandom = st.state.GetState(contract, storageSlot(base, committedOffset))
+		votes[i].revealedRandom = st.state.GetState(contract, storageSlot(base, revealedOffset))
+	}
+	return votes
+}
+
+// unmaskAttestation mirrors StateConnector.getAttestation on a vote read from storage.
+// The returned string is identical to the hex encoding of the EVM return data.
+func unmaskAttestation(vote storedVote, prevBufferNumber *big.Int) (string, error) {
+	if vote.latestVote.Big().Cmp(prevBufferNumber) < 0 {
+		return "", errAttestationReverted
+	}
+	if stateconnector.Commit(vote.revealedRandom) != vote.committedRandom {
+		return "", errAttestationReverted
+	}
+	unmasked := stateconnector.Mask(vote.maskedMerkleHash, vote.revealedRandom)
+	return hex.EncodeToString(unmasked.Bytes()), nil
+}
+
+// ReadAttestations returns what getAttestation(bufferNumber) would return for each attestor,
+// without executing the contract. The storage words are read sequentially and the
+// commit/reveal checks are then spread over a bounded number of workers. Results are
+// returned in the order of [attestors].
+func (st *StateTransition) ReadAttestations(attestors []common.Address, bufferNumber *big.Int) ([]string, []error) {
+	hashes := make([]string, len(attestors))
+	errs := make([]error, len(attestors))
+	if bufferNumber.Cmp(big.NewInt(1)) <= 0 {
+		for i := range attestors {
+			errs[i] = errAttestationReverted
+		}
+		return hashes, errs
+	}
+	votes := st.readStoredVotes(attestors, bufferNumber)
+	prevBufferNumber := new(big.Int).Sub(bufferNumber, big.NewInt(1))
+
+	workers := runtime.NumCPU()
+	if len(votes) < minParallelAttestations || workers < 2 {
+		for i, v := range votes {
+			hashes[i], errs[i] = unmaskAttestation(v, prevBufferNumber)
+		}
+		return hashes, errs
+	}
+	batchSize := (len(votes) + workers - 1) / workers
+	var wg sync.WaitGroup
+	for start := 0; start < len(votes); start += batchSize {
+		end := start + batchSize
+		if end > len(votes) {
+			end = len(votes)
+		}
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			for i := start; i < end; i++ {
+				hashes[i], errs[i] = unmaskAttestation(votes[i], prevBufferNumber)
+			}
+		}(start, end)
+	}
+	wg.Wait()
+	return hashes, errs
+}
@@ -0,0 +1,270 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package core
+
+import (
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/ava-labs/coreth/core/rawdb"
+	"github.com/ava-labs/coreth/core/state"
+	"github.com/ava-labs/coreth/core/stateconnector"
+	"github.com/ava-labs/coreth/core/types"
+	"github.com/ava-labs/coreth/core/vm"
+	"github.com/ava-labs/coreth/params"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Runtime bytecode of StateConnector.sol, as deployed in the genesis files
+const stateConnectorTestCode = "0x608060405234801561001057600080fd5b50600436106100b45760003560e01c8063cda8e74111610071578063cda8e7411461015f578063cfd1fdad1461018a578063eaebf6d3146101cd578063ec7424a0146101f0578063f417c9d8146101f8578063f5f59a4a14610200576100b4565b806329be4db2146100b95780634b8a125f146100e85780635f8c940d146100f057806371c5ecb1146100f857806371e24574146101155780637ff6faa61461013b575b600080fd5b6100d6600480360360208110156100cf57600080fd5b5035610208565b60408051918252519081900360200190f35b6100d66102f8565b6100d6610300565b6100d66004803603602081101561010e57600080fd5b5035610305565b6100d66004803603602081101561012b57600080fd5b50356001600160a01b031661031d565b610143610332565b604080516001600160a01b039092168252519081900360200190f35b6101886004803603606081101561017557600080fd5b5080359060208101359060400135610338565b005b6101b9600480360360808110156101a057600080fd5b50803590602081013590604081013590606001356103a5565b604080519115158252519081900360200190f35b610188600480360360408110156101e357600080fd5b5080359060200135610443565b6100d66104ac565b6100d66104b2565b6100d66104b8565b60006001821161021757600080fd5b3360009081526020819052604090206009015460001983019081111561023c57600080fd5b336000908152602081905260408120600383066003811061025957fe5b60039081029190910160020154336000908152602081905260408120919350916000198501066003811061028957fe5b6003020160010154905081604051602001808281526020019150506040516020818303038152906040528051906020012081146102c557600080fd5b3360009081526020819052604081206003600019860106600381106102e657fe5b60030201549290921895945050505050565b636184740081565b600381565b600281611a40811061031657600080fd5b0154905081565b60006020819052908152604090206009015481565b61dead81565b6000831161034557600080fd5b8161034f57600080fd5b8061035957600080fd5b60408051428152602081018590528082018490526060810183905290517f8749596bd7e565d6062796c02ca60f1968dc22a20b5350cce723e8216a9b2dba9181900360800190a1505050565b6000605a63618473ff1942010485146103bd57600080fd5b33600081815260208181526040808320600981018a9055815160608101835289815280840189905291820187905293835291905290600387066003811061040057fe5b600302016000820151816000015560208201518160010155604082015181600201559050506001548511156104375750600161043b565b5060005b949350505050565b6001821161045057600080fd5b605a63618473ff19420104821461046657600080fd5b600154821161047457600080fd5b334114801561048457504161dead145b156104a8576001829055806002611a40600019850106611a4081106104a557fe5b01555b5050565b60015481565b611a4081565b605a8156fea26469706673582212207377101f4664fc04622642390e537acfa5c64527a2125776104176c208bdcd5364736f6c63430007060033"
+
+const (
+	stateConnectorTestBufferTimestampOffset = 1636070400
+	stateConnectorTestBufferWindow          = 90
+)
+
+var stateConnectorTestAddr = common.HexToAddress("0x1000000000000000000000000000000000000001")
+
+// Set up a state transition whose message targets a freshly deployed StateConnector
+func newStateConnectorTestTransition(tb testing.TB) *StateTransition {
+	return newStateConnectorTestTransitionWithConfig(tb, params.TestChainConfig)
+}
+
+func newStateConnectorTestTransitionWithConfig(tb testing.TB, config *params.ChainConfig) *StateTransition {
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	statedb.SetCode(stateConnectorTestAddr, common.FromHex(stateConnectorTestCode))
+	blockContext := vm.BlockContext{
+		CanTransfer:       CanTransfer,
+		CanTransferMC:     CanTransferMC,
+		Transfer:          Transfer,
+		TransferMultiCoin: TransferMultiCoin,
+		Coinbase:          common.HexToAddress("0x0100000000000000000000000000000000000000"),
+		GasLimit:          8000000,
+		BlockNumber:       big.NewInt(1),
+		Time:              big.NewInt(stateConnectorTestBufferTimestampOffset),
+		Difficulty:        big.NewInt(1),
+		BaseFee:           big.NewInt(0),
+	}
+	evm := vm.NewEVM(blockContext, vm.TxContext{GasPrice: big.NewInt(0)}, statedb, config, vm.Config{})
+	to := stateConnectorTestAddr
+	msg := types.NewMessage(common.Address{}, &to, 0, big.NewInt(0), 0, big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, true)
+	return NewStateTransition(evm, msg, new(GasPool).AddGas(8000000))
+}
+
+func submitTestAttestation(tb testing.TB, st *StateTransition, attestor common.Address, bufferNumber int64, maskedMerkleHash, committedRandom, revealedRandom common.Hash) {
+	st.evm.Context.Time = big.NewInt(stateConnectorTestBufferTimestampOffset + bufferNumber*stateConnectorTestBufferWindow)
+	data := append([]byte{}, SubmitAttestationSelector(nil, nil, nil)...)
+	data = append(data, common.BigToHash(big.NewInt(bufferNumber)).Bytes()...)
+	data = append(data, maskedMerkleHash.Bytes()...)
+	data = append(data, committedRandom.Bytes()...)
+	data = append(data, revealedRandom.Bytes()...)
+	if _, _, err := st.evm.Call(vm.AccountRef(attestor), stateConnectorTestAddr, data, 1000000, big.NewInt(0)); err != nil {
+		tb.Fatalf("submitAttestation for %s in buffer %d failed: %s", attestor.Hex(), bufferNumber, err)
+	}
+}
+
+// Commit to [merkleHash] in buffer [bufferNumber]-2 and reveal in [bufferNumber]-1
+func voteTestAttestation(tb testing.TB, st *StateTransition, attestor common.Address, bufferNumber int64, merkleHash common.Hash, random common.Hash) {
+	var masked common.Hash
+	for i := range masked {
+		masked[i] = merkleHash[i] ^ random[i]
+	}
+	submitTestAttestation(tb, st, attestor, bufferNumber-2, masked, crypto.Keccak256Hash(random.Bytes()), common.Hash{})
+	submitTestAttestation(tb, st, attestor, bufferNumber-1, common.Hash{}, common.Hash{}, random)
+}
+
+func testAttestor(i int) common.Address {
+	return common.BigToAddress(big.NewInt(int64(0x10000 + i)))
+}
+
+// countAttestationsEVM is the original tally loop, which executes getAttestation once per attestor
+func countAttestationsEVM(st *StateTransition, attestors []common.Address, bufferNumber []byte) AttestationVotes {
+	instructions := append(GetAttestationSelector(nil, nil), bufferNumber...)
+	hashes := make([]string, len(attestors))
+	errs := make([]error, len(attestors))
+	for i, a := range attestors {
+		hashes[i], errs[i] = st.GetAttestation(a, instructions)
+	}
+	return tallyAttestations(attestors, hashes, errs, GetAttestationMajorityRule(st.evm.ChainConfig().ChainID, st.evm.Context.BlockNumber, st.evm.Context.Time))
+}
+
+func TestReadAttestationsMatchesEVM(t *testing.T) {
+	st := newStateConnectorTestTransition(t)
+	bufferNumber := int64(10)
+	agreed := common.HexToHash("0xaa")
+	var attestors []common.Address
+	// Honest attestors
+	for i := 0; i < 5; i++ {
+		a := testAttestor(i)
+		voteTestAttestation(t, st, a, bufferNumber, agreed, common.BigToHash(big.NewInt(int64(i+1))))
+		attestors = append(attestors, a)
+	}
+	// Divergent attestor
+	divergent := testAttestor(5)
+	voteTestAttestation(t, st, divergent, bufferNumber, common.HexToHash("0xbb"), common.HexToHash("0x99"))
+	// Attestor whose reveal does not match its commitment
+	badReveal := testAttestor(6)
+	submitTestAttestation(t, st, badReveal, bufferNumber-2, agreed, crypto.Keccak256Hash([]byte{1}), common.Hash{})
+	submitTestAttestation(t, st, badReveal, bufferNumber-1, common.Hash{}, common.Hash{}, common.HexToHash("0x02"))
+	// Attestor that never revealed
+	noReveal := testAttestor(7)
+	submitTestAttestation(t, st, noReveal, bufferNumber-2, agreed, crypto.Keccak256Hash([]byte{1}), common.Hash{})
+	// Attestor that never voted
+	absent := testAttestor(8)
+	attestors = append(attestors, divergent, badReveal, noReveal, absent)
+
+	for _, b := range []int64{0, 1, 2, bufferNumber - 1, bufferNumber, bufferNumber + 1, bufferNumber + 3} {
+		round := common.BigToHash(big.NewInt(b)).Bytes()
+		instructions := append(GetAttestationSelector(nil, nil), round...)
+		hashes, errs := st.ReadAttestations(attestors, big.NewInt(b))
+		for i, a := range attestors {
+			want, wantErr := st.GetAttestation(a, instructions)
+			if hashes[i] != want || (errs[i] == nil) != (wantErr == nil) {
+				t.Errorf("buffer %d attestor %s: got (%q, %v) want (%q, %v)", b, a.Hex(), hashes[i], errs[i], want, wantErr)
+			}
+		}
+		got, _ := st.CountAttestations(st.evm.ChainConfig().ChainID, st.evm.Context.Time, attestors, round)
+		want := countAttestationsEVM(st, attestors, round)
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("buffer %d: got votes %+v want %+v", b, got, want)
+		}
+	}
+
+	votes, _ := st.CountAttestations(st.evm.ChainConfig().ChainID, st.evm.Context.Time, attestors, common.BigToHash(big.NewInt(bufferNumber)).Bytes())
+	if !votes.reachedMajority || votes.majorityDecision != common.Bytes2Hex(agreed.Bytes()) {
+		t.Errorf("expected majority for %s, got %+v", agreed.Hex(), votes)
+	}
+	if len(votes.majorityAttestors) != 5 || len(votes.abstainedAttestors) != 3 || len(votes.divergentAttestors) != 1 {
+		t.Errorf("unexpected vote breakdown %+v", votes)
+	}
+}
+
+func TestReadAttestationsParallelPreservesOrder(t *testing.T) {
+	st := newStateConnectorTestTransition(t)
+	bufferNumber := int64(5)
+	attestors := make([]common.Address, 4*minParallelAttestations+3)
+	for i := range attestors {
+		attestors[i] = testAttestor(i)
+		// Every attestor votes for a distinct hash so any reordering is visible
+		voteTestAttestation(t, st, attestors[i], bufferNumber, common.BigToHash(big.NewInt(int64(i+1))), common.HexToHash("0x1234"))
+	}
+	hashes, errs := st.ReadAttestations(attestors, big.NewInt(bufferNumber))
+	for i := range attestors {
+		if errs[i] != nil {
+			t.Fatalf("attestor %d: unexpected error %s", i, errs[i])
+		}
+		if want := common.Bytes2Hex(common.BigToHash(big.NewInt(int64(i + 1))).Bytes()); hashes[i] != want {
+			t.Fatalf("attestor %d: got %s want %s", i, hashes[i], want)
+		}
+	}
+}
+
+// Run the same submissions through the contract and through the stateconnector package, and
+// check that both agree on every return value, attestation and finalised merkle root
+func TestStateConnectorModelMatchesEVM(t *testing.T) {
+	st := newStateConnectorTestTransition(t)
+	model := stateconnector.New()
+	chainID := st.evm.ChainConfig().ChainID
+	attestors := []common.Address{testAttestor(0), testAttestor(1), testAttestor(2)}
+	randoms := make(map[common.Address]common.Hash)
+
+	for bufferNumber := int64(2); bufferNumber < 12; bufferNumber++ {
+		timestamp := new(big.Int).SetUint64(stateconnector.BufferStartTime(uint64(bufferNumber)))
+		st.evm.Context.Time = timestamp
+		for i, a := range attestors {
+			// Attestor 1 skips every third buffer, attestor 2 reveals a wrong random in buffer 6
+			if i == 1 && bufferNumber%3 == 0 {
+				continue
+			}
+			random := crypto.Keccak256Hash(a.Bytes(), big.NewInt(bufferNumber).Bytes())
+			reveal := randoms[a]
+			if i == 2 && bufferNumber == 6 {
+				reveal = common.HexToHash("0xbad")
+			}
+			vote := stateconnector.NewVote(common.BigToHash(big.NewInt(bufferNumber*10+int64(i%2))), random, reveal)
+			randoms[a] = random
+
+			data := append([]byte{}, SubmitAttestationSelector(nil, nil, nil)...)
+			data = append(data, common.BigToHash(big.NewInt(bufferNumber)).Bytes()...)
+			data = append(data, vote.MaskedMerkleHash.Bytes()...)
+			data = append(data, vote.CommittedRandom.Bytes()...)
+			data = append(data, vote.RevealedRandom.Bytes()...)
+			ret, _, err := st.evm.Call(vm.AccountRef(a), stateConnectorTestAddr, data, 1000000, big.NewInt(0))
+			if err != nil {
+				t.Fatalf("submitAttestation in buffer %d: %s", bufferNumber, err)
+			}
+			initial, err := model.SubmitAttestation(a, timestamp, big.NewInt(bufferNumber), vote)
+			if err != nil {
+				t.Fatalf("model submitAttestation in buffer %d: %s", bufferNumber, err)
+			}
+			if (common.BytesToHash(ret).Big().Sign() != 0) != initial {
+				t.Errorf("buffer %d attestor %d: isInitialBufferSlot differs, EVM %x model %v", bufferNumber, i, ret, initial)
+			}
+		}
+
+		instructions := append(GetAttestationSelector(nil, nil), common.BigToHash(big.NewInt(bufferNumber)).Bytes()...)
+		for i, a := range attestors {
+			want, wantErr := st.GetAttestation(a, instructions)
+			got, err := model.GetAttestation(a, big.NewInt(bufferNumber))
+			if (err == nil) != (wantErr == nil) || (err == nil && common.Bytes2Hex(got.Bytes()) != want) {
+				t.Errorf("buffer %d attestor %d: model (%s, %v) EVM (%s, %v)", bufferNumber, i, got.Hex(), err, want, wantErr)
+			}
+		}
+
+		if bufferNumber%2 == 0 {
+			merkleHash := common.BigToHash(big.NewInt(bufferNumber))
+			wantErr := model.FinaliseRound(stateconnector.SignalCoinbase, stateconnector.SignalCoinbase, timestamp, big.NewInt(bufferNumber), merkleHash)
+			err := st.finaliseRound(chainID, timestamp, common.BigToHash(big.NewInt(bufferNumber)).Bytes(), common.Bytes2Hex(merkleHash.Bytes()))
+			if (err == nil) != (wantErr == nil) {
+				t.Errorf("finaliseRound in buffer %d: EVM %v model %v", bufferNumber, err, wantErr)
+			}
+		}
+		totalBuffers := st.state.GetState(stateConnectorTestAddr, common.BigToHash(big.NewInt(stateConnectorTotalBuffersSlot)))
+		if totalBuffers.Big().Cmp(model.TotalBuffers) != 0 {
+			t.Errorf("buffer %d: totalBuffers EVM %s model %s", bufferNumber, totalBuffers.Big(), model.TotalBuffers)
+		}
+		proofIndex := stateconnector.ProofIndex(big.NewInt(bufferNumber))
+		merkleRoot := st.state.GetState(stateConnectorTestAddr, common.BigToHash(big.NewInt(int64(stateConnectorMerkleRootsSlot+proofIndex))))
+		if merkleRoot != model.MerkleRoot(big.NewInt(bufferNumber)) {
+			t.Errorf("buffer %d: merkle root EVM %s model %s", bufferNumber, merkleRoot.Hex(), model.MerkleRoot(big.NewInt(bufferNumber)).Hex())
+		}
+	}
+}
+
+func benchmarkCountAttestations(b *testing.B, numAttestors int, count func(*StateTransition, []common.Address, []byte) AttestationVotes) {
+	st := newStateConnectorTestTransition(b)
+	bufferNumber := int64(10)
+	attestors := make([]common.Address, numAttestors)
+	for i := range attestors {
+		attestors[i] = testAttestor(i)
+		voteTestAttestation(b, st, attestors[i], bufferNumber, common.HexToHash("0xaa"), common.BigToHash(big.NewInt(int64(i+1))))
+	}
+	round := common.BigToHash(big.NewInt(bufferNumber)).Bytes()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if votes := count(st, attestors, round); !votes.reachedMajority {
+			b.Fatal("expected majority")
+		}
+	}
+}
+
+func BenchmarkCountAttestations(b *testing.B) {
+	storage := func(st *StateTransition, attestors []common.Address, round []byte) AttestationVotes {
+		votes, _ := st.CountAttestations(st.evm.ChainConfig().ChainID, st.evm.Context.Time, attestors, round)
+		return votes
+	}
+	for _, n := range []int{10, 100, 1000, 5000} {
+		b.Run(fmt.Sprintf("EVM/%d", n), func(b *testing.B) {
+			benchmarkCountAttestations(b, n, countAttestationsEVM)
+		})
+		b.Run(fmt.Sprintf("Storage/%d", n), func(b *testing.B) {
+			benchmarkCountAttestations(b, n, storage)
+		})
+	}
+}
@@ -0,0 +1,319 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package core
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ava-labs/coreth/core/rawdb"
+	"github.com/ava-labs/coreth/core/state"
+	"github.com/ava-labs/coreth/core/stateconnector"
+	"github.com/ava-labs/coreth/core/vm"
+	"github.com/ava-labs/coreth/params"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Attestation outcomes used by the tally tests; abstain stands for a getAttestation call that reverted
+const (
+	tallyTestAbstain = "-"
+	tallyTestA       = "aa"
+	tallyTestB       = "bb"
+	tallyTestC       = "cc"
+)
+
+var (
+	halfOfTotal         = AttestationMajorityRule{Numerator: 1, Denominator: 2}
+	halfOfParticipating = AttestationMajorityRule{Numerator: 1, Denominator: 2, OfParticipating: true}
+	twoThirdsOfTotal    = AttestationMajorityRule{Numerator: 2, Denominator: 3}
+)
+
+func TestTallyAttestations(t *testing.T) {
+	tests := []struct {
+		name          string
+		votes         []string
+		rule          AttestationMajorityRule
+		wantMajority  bool
+		wantDecision  string
+		wantMajors    []int
+		wantDivergent []int
+		wantAbstained []int
+	}{
+		{
+			name:         "no attestors",
+			rule:         halfOfTotal,
+			wantMajority: false,
+		},
+		{
+			name:         "unanimous",
+			votes:        []string{tallyTestA, tallyTestA, tallyTestA},
+			rule:         halfOfTotal,
+			wantMajority: true,
+			wantDecision: tallyTestA,
+			wantMajors:   []int{0, 1, 2},
+		},
+		{
+			name:          "everyone abstains",
+			votes:         []string{tallyTestAbstain, tallyTestAbstain, tallyTestAbstain},
+			rule:          halfOfTotal,
+			wantMajority:  false,
+			wantAbstained: []int{0, 1, 2},
+		},
+		{
+			name:          "everyone abstains over participating attestors",
+			votes:         []string{tallyTestAbstain, tallyTestAbstain, tallyTestAbstain},
+			rule:          halfOfParticipating,
+			wantMajority:  false,
+			wantAbstained: []int{0, 1, 2},
+		},
+		{
+			name:          "abstainers do not form a majority of nothing",
+			votes:         []string{tallyTestAbstain, tallyTestAbstain, tallyTestAbstain, tallyTestA, tallyTestB},
+			rule:          halfOfTotal,
+			wantMajority:  false,
+			wantDivergent: []int{4},
+			wantAbstained: []int{0, 1, 2},
+		},
+		{
+			name:          "abstainers count against a majority of the total",
+			votes:         []string{tallyTestA, tallyTestA, tallyTestAbstain, tallyTestAbstain, tallyTestAbstain},
+			rule:          halfOfTotal,
+			wantMajority:  false,
+			wantAbstained: []int{2, 3, 4},
+		},
+		{
+			name:          "abstainers are ignored in a majority of participants",
+			votes:         []string{tallyTestA, tallyTestA, tallyTestAbstain, tallyTestAbstain, tallyTestAbstain},
+			rule:          halfOfParticipating,
+			wantMajority:  true,
+			wantDecision:  tallyTestA,
+			wantMajors:    []int{0, 1},
+			wantAbstained: []int{2, 3, 4},
+		},
+		{
+			name:          "majority of participants with divergence",
+			votes:         []string{tallyTestA, tallyTestA, tallyTestB, tallyTestAbstain},
+			rule:          halfOfParticipating,
+			wantMajority:  true,
+			wantDecision:  tallyTestA,
+			wantMajors:    []int{0, 1},
+			wantDivergent: []int{2},
+			wantAbstained: []int{3},
+		},
+		{
+			name:          "exactly half is not a majority",
+			votes:         []string{tallyTestA, tallyTestA, tallyTestB, tallyTestC},
+			rule:          halfOfTotal,
+			wantMajority:  false,
+			wantDivergent: []int{2, 3},
+		},
+		{
+			name:          "tie between two hashes",
+			votes:         []string{tallyTestA, tallyTestB, tallyTestB, tallyTestA},
+			rule:          halfOfParticipating,
+			wantMajority:  false,
+			wantDivergent: []int{1, 2},
+		},
+		{
+			name:          "plurality below threshold",
+			votes:         []string{tallyTestA, tallyTestA, tallyTestB, tallyTestC, tallyTestAbstain},
+			rule:          halfOfTotal,
+			wantMajority:  false,
+			wantDivergent: []int{2, 3},
+			wantAbstained: []int{4},
+		},
+		{
+			name:          "supermajority reached",
+			votes:         []string{tallyTestA, tallyTestA, tallyTestA, tallyTestB},
+			rule:          twoThirdsOfTotal,
+			wantMajority:  true,
+			wantDecision:  tallyTestA,
+			wantMajors:    []int{0, 1, 2},
+			wantDivergent: []int{3},
+		},
+		{
+			name:          "exactly two thirds is not a supermajority",
+			votes:         []string{tallyTestA, tallyTestA, tallyTestB},
+			rule:          twoThirdsOfTotal,
+			wantMajority:  false,
+			wantDivergent: []int{2},
+		},
+		{
+			name:          "majority keeps attestor order",
+			votes:         []string{tallyTestB, tallyTestA, tallyTestA, tallyTestC, tallyTestA},
+			rule:          halfOfTotal,
+			wantMajority:  true,
+			wantDecision:  tallyTestA,
+			wantMajors:    []int{1, 2, 4},
+			wantDivergent: []int{0, 3},
+		},
+		{
+			name:          "empty attestation without error abstains",
+			votes:         []string{"", "", tallyTestA},
+			rule:          halfOfParticipating,
+			wantMajority:  true,
+			wantDecision:  tallyTestA,
+			wantMajors:    []int{2},
+			wantAbstained: []int{0, 1},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			attestors := testPenaltyAttestors(len(test.votes))
+			hashes := make([]string, len(test.votes))
+			errs := make([]error, len(test.votes))
+			for i, v := range test.votes {
+				if v == tallyTestAbstain {
+					errs[i] = errAttestationReverted
+				} else {
+					hashes[i] = v
+				}
+			}
+			votes := tallyAttestations(attestors, hashes, errs, test.rule)
+			if votes.reachedMajority != test.wantMajority {
+				t.Errorf("reachedMajority: got %v want %v", votes.reachedMajority, test.wantMajority)
+			}
+			if votes.majorityDecision != test.wantDecision {
+				t.Errorf("majorityDecision: got %q want %q", votes.majorityDecision, test.wantDecision)
+			}
+			checkTallyAttestors(t, "majorityAttestors", votes.majorityAttestors, attestors, test.wantMajors)
+			checkTallyAttestors(t, "divergentAttestors", votes.divergentAttestors, attestors, test.wantDivergent)
+			checkTallyAttestors(t, "abstainedAttestors", votes.abstainedAttestors, attestors, test.wantAbstained)
+		})
+	}
+}
+
+func checkTallyAttestors(t *testing.T, field string, got []common.Address, attestors []common.Address, want []int) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("%s: got %v want indices %v", field, got, want)
+		return
+	}
+	for i, index := range want {
+		if got[i] != attestors[index] {
+			t.Errorf("%s: got %v want indices %v", field, got, want)
+			return
+		}
+	}
+}
+
+func TestFinaliseRoundRejectsEmptyDecision(t *testing.T) {
+	st := newStateConnectorTestTransition(t)
+	round := common.BigToHash(big.NewInt(10)).Bytes()
+	if err := st.finaliseRound(st.evm.ChainConfig().ChainID, st.evm.Context.Time, round, ""); err != errEmptyMajorityDecision {
+		t.Errorf("got %v want %v", err, errEmptyMajorityDecision)
+	}
+}
+
+func TestStateConnectorProofVerifierRegistration(t *testing.T) {
+	verifierFork := uint64(5)
+	enabled := true
+	genesis := genesisFlareFork()
+	withVerifier, withoutVerifier := big.NewInt(987654340), big.NewInt(987654341)
+	for chainID, forks := range map[*big.Int]FlareForkSchedule{
+		withVerifier:    {genesis, {Name: "proofVerifier", Block: &verifierFork, FlareForkParams: FlareForkParams{StateConnectorProofVerifier: &enabled}}},
+		withoutVerifier: {genesis},
+	} {
+		if err := SetFlareChainConfig(chainID, &FlareChainConfig{TestingChain: true, Forks: forks}); err != nil {
+			t.Fatal(err)
+		}
+		RegisterStateConnectorProofVerifier(chainID)
+	}
+	defer func() {
+		flareChainConfigsLock.Lock()
+		delete(flareChainConfigs, withVerifier.Uint64())
+		delete(flareChainConfigs, withoutVerifier.Uint64())
+		flareChainConfigsLock.Unlock()
+	}()
+	for _, chainID := range []*big.Int{flareChainID, songbirdChainID} {
+		if GetStateConnectorProofVerifierActivated(chainID, big.NewInt(0), big.NewInt(2000000000000)) {
+			t.Errorf("chain %s: proof verifier activated without a fork", chainID)
+		}
+	}
+
+	verifier, ok := vm.PrecompiledContractsApricotPhase2[vm.StateConnectorProofVerifierAddr]
+	if !ok {
+		t.Fatal("proof verifier not registered for a chain whose schedule activates it")
+	}
+	tests := []struct {
+		chainID     *big.Int
+		blockNumber int64
+		wantActive  bool
+	}{
+		{withVerifier, 4, false},
+		{withVerifier, 5, true},
+		{withoutVerifier, 5, false},
+	}
+	for _, test := range tests {
+		config := *params.TestChainConfig
+		config.ChainID = test.chainID
+		evm := vm.NewEVM(vm.BlockContext{BlockNumber: big.NewInt(test.blockNumber), Time: big.NewInt(0)}, vm.TxContext{}, nil, &config, vm.Config{})
+		// Malformed input reverts once the verifier is active, and is ignored before
+		_, gas, err := verifier.Run(evm, vm.AccountRef(common.Address{}), vm.StateConnectorProofVerifierAddr, new(big.Int), []byte{1}, 50000, true)
+		if active := err == vm.ErrExecutionReverted; active != test.wantActive || (!active && (err != nil || gas != 50000)) {
+			t.Errorf("chain %s at block %d: got (%d, %v), want active %v", test.chainID, test.blockNumber, gas, err, test.wantActive)
+		}
+	}
+}
+
+func TestStateConnectorProofVerifierFollowsContractForks(t *testing.T) {
+	chainID := big.NewInt(987654345)
+	enabled := true
+	movedAt := uint64(7)
+	moved := common.HexToAddress("0x1000000000000000000000000000000000000011")
+	genesis := genesisFlareFork()
+	genesis.StateConnectorProofVerifier = &enabled
+	forks := FlareForkSchedule{genesis, {Name: "moveStateConnector", Block: &movedAt, FlareForkParams: FlareForkParams{StateConnectorContract: &moved}}}
+	if err := SetFlareChainConfig(chainID, &FlareChainConfig{TestingChain: true, Forks: forks}); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		flareChainConfigsLock.Lock()
+		delete(flareChainConfigs, chainID.Uint64())
+		flareChainConfigsLock.Unlock()
+	}()
+	RegisterStateConnectorProofVerifier(chainID)
+
+	// Finalise a round that includes [requests] on the contract of the second fork only
+	round := big.NewInt(100)
+	requestTime := new(big.Int).SetUint64(stateconnector.BufferStartTime(stateconnector.RequestBufferNumber(round).Uint64()))
+	requests := []*stateconnector.Request{
+		{Timestamp: requestTime, Instructions: big.NewInt(1), ID: common.HexToHash("0x1d"), DataAvailabilityProof: common.HexToHash("0xda")},
+		{Timestamp: requestTime, Instructions: big.NewInt(2), ID: common.HexToHash("0x1d"), DataAvailabilityProof: common.HexToHash("0xda")},
+	}
+	tree := stateconnector.NewMerkleTree(requests)
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statedb.SetState(moved, common.BigToHash(big.NewInt(stateconnector.TotalBuffersSlot)), common.BigToHash(round))
+	statedb.SetState(moved, stateconnector.MerkleRootSlot(round), tree.Root())
+
+	proof, _ := tree.Proof(requests[0].Hash())
+	input := common.BigToHash(round).Bytes()
+	input = append(input, common.BigToHash(requests[0].Timestamp).Bytes()...)
+	input = append(input, common.BigToHash(requests[0].Instructions).Bytes()...)
+	input = append(input, requests[0].ID.Bytes()...)
+	input = append(input, requests[0].DataAvailabilityProof.Bytes()...)
+	for _, sibling := range proof {
+		input = append(input, sibling.Bytes()...)
+	}
+
+	config := *params.TestChainConfig
+	config.ChainID = chainID
+	verifier := vm.PrecompiledContractsApricotPhase2[vm.StateConnectorProofVerifierAddr]
+	for _, test := range []struct {
+		blockNumber int64
+		want        common.Hash
+	}{
+		{6, common.Hash{}},
+		{7, common.BigToHash(common.Big1)},
+	} {
+		evm := vm.NewEVM(vm.BlockContext{BlockNumber: big.NewInt(test.blockNumber), Time: requestTime}, vm.TxContext{}, statedb, &config, vm.Config{})
+		ret, _, err := verifier.Run(evm, vm.AccountRef(common.Address{}), vm.StateConnectorProofVerifierAddr, new(big.Int), input, 50000, true)
+		if err != nil || common.BytesToHash(ret) != test.want {
+			t.Errorf("block %d: got (%x, %v) want %x", test.blockNumber, ret, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package core
+
+import (
+	"math/big"
+
+	"github.com/ava-labs/coreth/core/vm"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// GetWeightedAttestationActivated returns whether the default attestors vote with their FTSO
+// vote power instead of one vote per address, once a fork sets weightedAttestations
+func GetWeightedAttestationActivated(chainID *big.Int, blockNumber *big.Int, blockTime *big.Int) bool {
+	return GetFlareParams(chainID, blockNumber, blockTime).WeightedAttestations
+}
+
+// GetWeightedAttestationMajorityRule returns the share of the weight that the plurality has to
+// exceed for a weighted round to reach a majority
+func GetWeightedAttestationMajorityRule(chainID *big.Int, blockNumber *big.Int, blockTime *big.Int) AttestationMajorityRule {
+	return GetFlareParams(chainID, blockNumber, blockTime).WeightedAttestationMajority
+}
+
+// GetAttestorWeightSelector returns the selector of batchVotePowerOf(address[]) on the
+// prioritised FTSO contract
+func GetAttestorWeightSelector(chainID *big.Int, blockTime *big.Int) []byte {
+	switch {
+	default:
+		return []byte{0xc4, 0x07, 0x2e, 0x61}
+	}
+}
+
+// decodeAttestorWeights decodes the uint256[] returned for [n] attestors, or returns nil if
+// [ret] is not exactly one ABI encoded array of [n] values
+func decodeAttestorWeights(ret []byte, n int) []*big.Int {
+	if len(ret) != 64+32*n ||
+		new(big.Int).SetBytes(ret[:32]).Cmp(big.NewInt(32)) != 0 ||
+		new(big.Int).SetBytes(ret[32:64]).Cmp(big.NewInt(int64(n))) != 0 {
+		return nil
+	}
+	weights := make([]*big.Int, n)
+	for i := range weights {
+		weights[i] = new(big.Int).SetBytes(ret[64+32*i : 96+32*i])
+	}
+	return weights
+}
+
+// GetAttestorWeights returns the vote power of each of [attestors], read with a single call so
+// that the cost of a round does not grow with one full system call per attestor. If the vote
+// powers cannot be read every attestor is given a weight of zero rather than failing the round.
+func (st *StateTransition) GetAttestorWeights(chainID *big.Int, timestamp *big.Int, attestors []common.Address) []*big.Int {
+	weightContract := GetPrioritisedFTSOContract(chainID, st.evm.Context.BlockNumber, timestamp)
+	input := encodeAddressArrayCall(GetAttestorWeightSelector(chainID, timestamp), attestors)
+	gas := GetFlareDaemonGasMultiplier(chainID, st.evm.Context.BlockNumber, timestamp) * st.evm.Context.GasLimit
+	calls := st.systemCalls()
+	ret, _, err := calls.call(st.evm, SystemCallAttestorWeight, calls.caller(vm.AccountRef(st.msg.From())), weightContract, input, gas)
+	var weights []*big.Int
+	if err == nil {
+		weights = decodeAttestorWeights(ret, len(attestors))
+	}
+	if weights == nil {
+		weights = make([]*big.Int, len(attestors))
+		for i := range weights {
+			weights[i] = new(big.Int)
+		}
+	}
+	return weights
+}
+
+// CountWeightedAttestations tallies the votes of [attestors] for the round preceding
+// [bufferNumber], weighting each vote by the attestor's vote power
+func (st *StateTransition) CountWeightedAttestations(chainID *big.Int, timestamp *big.Int, attestors []common.Address, bufferNumber []byte) (AttestationVotes, error) {
+	hashes, errs := st.ReadAttestations(attestors, new(big.Int).SetBytes(bufferNumber))
+	weights := st.GetAttestorWeights(chainID, timestamp, attestors)
+	rule := GetWeightedAttestationMajorityRule(chainID, st.evm.Context.BlockNumber, timestamp)
+	return tallyWeightedAttestations(attestors, hashes, errs, weights, rule), nil
+}
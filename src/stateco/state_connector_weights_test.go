@@ -0,0 +1,232 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package core
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestTallyWeightedAttestations(t *testing.T) {
+	attestors := testPenaltyAttestors(4)
+	a, b := "aa", "bb"
+	maxUint256 := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+	weights := func(w ...int64) []*big.Int {
+		out := make([]*big.Int, len(w))
+		for i := range w {
+			out[i] = big.NewInt(w[i])
+		}
+		return out
+	}
+
+	tests := []struct {
+		name          string
+		hashes        []string
+		abstained     []bool
+		weights       []*big.Int
+		rule          *AttestationMajorityRule
+		wantMajority  bool
+		wantDecision  string
+		wantAttestors []common.Address
+	}{
+		{
+			name:          "weight outvotes head count",
+			hashes:        []string{a, b, b, b},
+			weights:       weights(100, 1, 1, 1),
+			wantMajority:  true,
+			wantDecision:  a,
+			wantAttestors: attestors[:1],
+		},
+		{
+			name:         "exactly two thirds is not enough",
+			hashes:       []string{a, a, b, b},
+			weights:      weights(1, 1, 1, 0),
+			wantMajority: false,
+		},
+		{
+			name:          "just over two thirds",
+			hashes:        []string{a, a, b, b},
+			weights:       weights(100, 101, 100, 0),
+			wantMajority:  true,
+			wantDecision:  a,
+			wantAttestors: attestors[:2],
+		},
+		{
+			name:         "tie between two roots is no majority",
+			hashes:       []string{b, a, a, b},
+			weights:      weights(1, 3, 2, 4),
+			wantMajority: false,
+		},
+		{
+			name:          "tie between two roots goes to the root seen first",
+			hashes:        []string{b, a, a, b},
+			weights:       weights(1, 3, 2, 4),
+			rule:          &AttestationMajorityRule{Numerator: 1, Denominator: 3},
+			wantMajority:  true,
+			wantDecision:  b,
+			wantAttestors: []common.Address{attestors[0], attestors[3]},
+		},
+		{
+			name:          "zero weight attestors do not count",
+			hashes:        []string{a, b, b, b},
+			weights:       weights(1, 0, 0, 0),
+			wantMajority:  true,
+			wantDecision:  a,
+			wantAttestors: attestors[:1],
+		},
+		{
+			name:         "all weights zero",
+			hashes:       []string{a, a, a, a},
+			weights:      weights(0, 0, 0, 0),
+			wantMajority: false,
+		},
+		{
+			name:         "abstainers weigh against the plurality",
+			hashes:       []string{a, a, "", ""},
+			abstained:    []bool{false, false, true, true},
+			weights:      weights(3, 3, 2, 2),
+			wantMajority: false,
+		},
+		{
+			name:          "weights beyond uint256 do not overflow",
+			hashes:        []string{a, a, a, b},
+			weights:       []*big.Int{maxUint256, maxUint256, maxUint256, maxUint256},
+			wantMajority:  true,
+			wantDecision:  a,
+			wantAttestors: attestors[:3],
+		},
+		{
+			name:          "large minority does not wrap into a majority",
+			hashes:        []string{a, b, b, b},
+			weights:       []*big.Int{maxUint256, maxUint256, maxUint256, big.NewInt(1)},
+			wantMajority:  true,
+			wantDecision:  b,
+			wantAttestors: attestors[1:],
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			errs := make([]error, len(attestors))
+			for i := range test.abstained {
+				if test.abstained[i] {
+					errs[i] = errAttestationReverted
+				}
+			}
+			rule := AttestationMajorityRule{Numerator: 2, Denominator: 3}
+			if test.rule != nil {
+				rule = *test.rule
+			}
+			votes := tallyWeightedAttestations(attestors, test.hashes, errs, test.weights, rule)
+			if votes.reachedMajority != test.wantMajority {
+				t.Fatalf("reachedMajority: got %v want %v (%+v)", votes.reachedMajority, test.wantMajority, votes)
+			}
+			if !test.wantMajority {
+				return
+			}
+			if votes.majorityDecision != test.wantDecision {
+				t.Errorf("majorityDecision: got %q want %q", votes.majorityDecision, test.wantDecision)
+			}
+			if len(votes.majorityAttestors) != len(test.wantAttestors) {
+				t.Fatalf("majorityAttestors: got %v want %v", votes.majorityAttestors, test.wantAttestors)
+			}
+			for i := range test.wantAttestors {
+				if votes.majorityAttestors[i] != test.wantAttestors[i] {
+					t.Errorf("majorityAttestors: got %v want %v", votes.majorityAttestors, test.wantAttestors)
+				}
+			}
+			if len(votes.majorityAttestors)+len(votes.divergentAttestors)+len(votes.abstainedAttestors) != len(attestors) {
+				t.Errorf("attestors lost from the breakdown %+v", votes)
+			}
+		})
+	}
+}
+
+func TestTallyAttestationsHeadCountUnchanged(t *testing.T) {
+	attestors := testPenaltyAttestors(5)
+	errs := make([]error, len(attestors))
+	for _, hashes := range [][]string{
+		{"aa", "aa", "aa", "bb", "bb"},
+		{"aa", "aa", "bb", "bb", "cc"},
+		{"aa", "bb", "aa", "bb", "aa"},
+	} {
+		want := 0
+		for _, h := range hashes {
+			if h == "aa" {
+				want++
+			}
+		}
+		votes := tallyAttestations(attestors, hashes, errs, GetAttestationMajorityRule(nil, nil, nil))
+		if votes.reachedMajority != (want > len(attestors)/2) {
+			t.Errorf("%v: got reachedMajority %v", hashes, votes.reachedMajority)
+		}
+	}
+}
+
+func TestGetAttestorWeights(t *testing.T) {
+	st := newStateConnectorTestTransition(t)
+	chainID := st.evm.ChainConfig().ChainID
+	weightContract := GetPrioritisedFTSOContract(chainID, st.evm.Context.BlockNumber, st.evm.Context.Time)
+	attestors := testPenaltyAttestors(3)
+
+	// Return the address[] argument as the uint256[] of vote powers
+	st.state.SetCode(weightContract, common.FromHex("0x600436038060046000376000f3"))
+	weights := st.GetAttestorWeights(chainID, st.evm.Context.Time, attestors)
+	for i, a := range attestors {
+		if weights[i].Cmp(a.Hash().Big()) != 0 {
+			t.Errorf("attestor %s: got weight %s", a.Hex(), weights[i])
+		}
+	}
+	if traces := st.systemCalls().traces; len(traces) != 1 || traces[0].Kind != SystemCallAttestorWeight {
+		t.Errorf("vote powers not read in a single system call: %+v", traces)
+	}
+
+	for _, code := range []string{
+		// A reverting contract
+		"0x60006000fd",
+		// A contract that returns a single uint256
+		"0x60043560005260206000f3",
+	} {
+		st.state.SetCode(weightContract, common.FromHex(code))
+		for i, w := range st.GetAttestorWeights(chainID, st.evm.Context.Time, attestors) {
+			if w.Sign() != 0 {
+				t.Errorf("code %s, attestor %d: got weight %s want 0", code, i, w)
+			}
+		}
+	}
+}
+
+func TestDecodeAttestorWeights(t *testing.T) {
+	encode := func(words ...int64) []byte {
+		var ret []byte
+		for _, w := range words {
+			ret = append(ret, common.BigToHash(big.NewInt(w)).Bytes()...)
+		}
+		return ret
+	}
+	tests := []struct {
+		name string
+		ret  []byte
+		want []int64
+	}{
+		{"two weights", encode(32, 2, 7, 9), []int64{7, 9}},
+		{"wrong length", encode(32, 3, 7, 9), nil},
+		{"wrong offset", encode(64, 2, 7, 9), nil},
+		{"trailing data", encode(32, 2, 7, 9, 1), nil},
+		{"empty", nil, nil},
+	}
+	for _, test := range tests {
+		got := decodeAttestorWeights(test.ret, 2)
+		if (got == nil) != (test.want == nil) {
+			t.Errorf("%s: got %v want %v", test.name, got, test.want)
+			continue
+		}
+		for i := range test.want {
+			if got[i].Int64() != test.want[i] {
+				t.Errorf("%s: got %v want %v", test.name, got, test.want)
+			}
+		}
+	}
+}
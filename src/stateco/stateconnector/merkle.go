@@ -0,0 +1,265 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package stateconnector
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/big"
+	"sort"
+
+	"github.com/ava-labs/coreth/core/types"
+	"github.com/ava-labs/coreth/interfaces"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// The merkle root of a round commits to the attestation requests emitted in the buffer two
+// buffers earlier: votes on those requests are committed to in the next buffer and revealed
+// in the one after, which is the round.
+//
+// The tree layout that every attestor and dApp has to agree on is:
+//   - a leaf is keccak256(abi.encode(timestamp, instructions, id, dataAvailabilityProof)),
+//     the hash of the AttestationRequest event data
+//   - leaves are sorted ascending and duplicates are dropped, so the root does not depend
+//     on the order in which requests were mined or fetched
+//   - a parent is keccak256(abi.encodePacked(min(a, b), max(a, b))) of its two children
+//   - a node without a sibling is carried up to the next level unchanged
+//   - a buffer without requests has the zero root
+//
+// Hashing pairs in sorted order means a proof is only the list of siblings from the leaf up,
+// which is what OpenZeppelin's MerkleProof.verify expects.
+const requestRoundDelay = 2
+
+// Storage layout of StateConnector.sol as compiled by solc 0.7.6
+const (
+	BuffersSlot      = 0
+	TotalBuffersSlot = 1
+	MerkleRootsSlot  = 2
+)
+
+var (
+	// AttestationRequestTopic is the topic of AttestationRequest(uint256,uint256,bytes32,bytes32)
+	AttestationRequestTopic = crypto.Keccak256Hash([]byte("AttestationRequest(uint256,uint256,bytes32,bytes32)"))
+
+	ErrInvalidRequestLog = errors.New("log is not a valid AttestationRequest event")
+	ErrLeafNotFound      = errors.New("request is not in the merkle tree")
+	ErrRoundNotFinalised = errors.New("round has not been finalised")
+	ErrRootExpired       = errors.New("merkle root of round is no longer stored")
+	ErrInvalidProof      = errors.New("proof does not lead to the merkle root of round")
+)
+
+// Request is an AttestationRequest event emitted by requestAttestations
+type Request struct {
+	Timestamp             *big.Int
+	Instructions          *big.Int
+	ID                    common.Hash
+	DataAvailabilityProof common.Hash
+}
+
+// Hash returns the merkle tree leaf of [r]
+func (r *Request) Hash() common.Hash {
+	return crypto.Keccak256Hash(r.encode())
+}
+
+// encode returns the ABI encoding of [r], which is also the data of its event
+func (r *Request) encode() []byte {
+	data := make([]byte, 0, 4*common.HashLength)
+	data = append(data, common.BigToHash(r.Timestamp).Bytes()...)
+	data = append(data, common.BigToHash(r.Instructions).Bytes()...)
+	data = append(data, r.ID.Bytes()...)
+	return append(data, r.DataAvailabilityProof.Bytes()...)
+}
+
+// ParseRequest decodes an AttestationRequest event. The contract rejects empty inputs, so a log
+// carrying one was not emitted by it.
+func ParseRequest(log *types.Log) (*Request, error) {
+	if len(log.Topics) != 1 || log.Topics[0] != AttestationRequestTopic || len(log.Data) != 4*common.HashLength {
+		return nil, ErrInvalidRequestLog
+	}
+	r := &Request{
+		Timestamp:             new(big.Int).SetBytes(log.Data[0:32]),
+		Instructions:          new(big.Int).SetBytes(log.Data[32:64]),
+		ID:                    common.BytesToHash(log.Data[64:96]),
+		DataAvailabilityProof: common.BytesToHash(log.Data[96:128]),
+	}
+	if r.Instructions.Sign() == 0 || r.ID == (common.Hash{}) || r.DataAvailabilityProof == (common.Hash{}) {
+		return nil, ErrInvalidRequestLog
+	}
+	return r, nil
+}
+
+// RequestBufferNumber returns the buffer whose requests are attested to in round [round]
+func RequestBufferNumber(round *big.Int) *big.Int {
+	bufferNumber := new(big.Int).Sub(round, big.NewInt(requestRoundDelay))
+	return bufferNumber.Mod(bufferNumber, uint256Modulus)
+}
+
+// CollectRequests returns the requests emitted by [contract] in buffer [bufferNumber]. Logs
+// from other contracts, other buffers and reorged blocks are skipped.
+func CollectRequests(logs []types.Log, contract common.Address, bufferNumber *big.Int) []*Request {
+	var requests []*Request
+	for i := range logs {
+		if logs[i].Removed || logs[i].Address != contract {
+			continue
+		}
+		r, err := ParseRequest(&logs[i])
+		if err != nil || BufferNumber(r.Timestamp).Cmp(bufferNumber) != 0 {
+			continue
+		}
+		requests = append(requests, r)
+	}
+	return requests
+}
+
+// LogFilterer is the subset of the C-chain RPC client used to fetch request logs
+type LogFilterer interface {
+	FilterLogs(ctx context.Context, q interfaces.FilterQuery) ([]types.Log, error)
+}
+
+// FetchRequests fetches the requests emitted by [contract] in buffer [bufferNumber] from
+// blocks [fromBlock, toBlock], which must cover the buffer
+func FetchRequests(ctx context.Context, filterer LogFilterer, contract common.Address, fromBlock *big.Int, toBlock *big.Int, bufferNumber *big.Int) ([]*Request, error) {
+	logs, err := filterer.FilterLogs(ctx, interfaces.FilterQuery{
+		FromBlock: fromBlock,
+		ToBlock:   toBlock,
+		Addresses: []common.Address{contract},
+		Topics:    [][]common.Hash{{AttestationRequestTopic}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return CollectRequests(logs, contract, bufferNumber), nil
+}
+
+// MerkleTree is the canonical tree of a batch of requests
+type MerkleTree struct {
+	levels [][]common.Hash // levels[0] holds the sorted leaves, the last level the root
+}
+
+// NewMerkleTree builds the tree of [requests]
+func NewMerkleTree(requests []*Request) *MerkleTree {
+	leaves := make([]common.Hash, len(requests))
+	for i, r := range requests {
+		leaves[i] = r.Hash()
+	}
+	return NewMerkleTreeFromLeaves(leaves)
+}
+
+// NewMerkleTreeFromLeaves builds the tree of the already hashed [leaves]
+func NewMerkleTreeFromLeaves(leaves []common.Hash) *MerkleTree {
+	sorted := make([]common.Hash, len(leaves))
+	copy(sorted, leaves)
+	sort.Slice(sorted, func(i, j int) bool { return bytes.Compare(sorted[i][:], sorted[j][:]) < 0 })
+	level := sorted[:0]
+	for i, leaf := range sorted {
+		if i == 0 || leaf != sorted[i-1] {
+			level = append(level, leaf)
+		}
+	}
+
+	t := &MerkleTree{levels: [][]common.Hash{level}}
+	for len(level) > 1 {
+		next := make([]common.Hash, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+			} else {
+				next = append(next, hashPair(level[i], level[i+1]))
+			}
+		}
+		t.levels = append(t.levels, next)
+		level = next
+	}
+	return t
+}
+
+// Root returns the merkle root, the zero hash for an empty tree
+func (t *MerkleTree) Root() common.Hash {
+	top := t.levels[len(t.levels)-1]
+	if len(top) == 0 {
+		return common.Hash{}
+	}
+	return top[0]
+}
+
+// Proof returns the siblings on the path from [leaf] to the root
+func (t *MerkleTree) Proof(leaf common.Hash) ([]common.Hash, error) {
+	leaves := t.levels[0]
+	index := sort.Search(len(leaves), func(i int) bool { return bytes.Compare(leaves[i][:], leaf[:]) >= 0 })
+	if index == len(leaves) || leaves[index] != leaf {
+		return nil, ErrLeafNotFound
+	}
+	var proof []common.Hash
+	for _, level := range t.levels[:len(t.levels)-1] {
+		if sibling := index ^ 1; sibling < len(level) {
+			proof = append(proof, level[sibling])
+		}
+		index /= 2
+	}
+	return proof, nil
+}
+
+// VerifyProof reports whether [proof] leads from [leaf] to [root]
+func VerifyProof(root common.Hash, leaf common.Hash, proof []common.Hash) bool {
+	if root == (common.Hash{}) {
+		return false
+	}
+	hash := leaf
+	for _, sibling := range proof {
+		hash = hashPair(hash, sibling)
+	}
+	return hash == root
+}
+
+func hashPair(a common.Hash, b common.Hash) common.Hash {
+	if bytes.Compare(a[:], b[:]) > 0 {
+		a, b = b, a
+	}
+	return crypto.Keccak256Hash(a[:], b[:])
+}
+
+// StateReader reads contract storage, such as a vm.StateDB
+type StateReader interface {
+	GetState(addr common.Address, hash common.Hash) common.Hash
+}
+
+// MerkleRootSlot returns the storage slot of merkleRoots[ProofIndex(round)]
+func MerkleRootSlot(round *big.Int) common.Hash {
+	return common.BigToHash(big.NewInt(int64(MerkleRootsSlot + ProofIndex(round))))
+}
+
+// ReadMerkleRoot returns the proven merkle root of [round] from the storage of [contract]. A
+// root is only returned while it has not been overwritten by a later round.
+func ReadMerkleRoot(state StateReader, contract common.Address, round *big.Int) (common.Hash, error) {
+	if round.Cmp(one) <= 0 {
+		return common.Hash{}, ErrBufferNumberTooLow
+	}
+	totalBuffers := state.GetState(contract, common.BigToHash(big.NewInt(TotalBuffersSlot))).Big()
+	if round.Cmp(totalBuffers) > 0 {
+		return common.Hash{}, ErrRoundNotFinalised
+	}
+	if new(big.Int).Sub(totalBuffers, round).Cmp(big.NewInt(TotalStoredProofs)) >= 0 {
+		return common.Hash{}, ErrRootExpired
+	}
+	return state.GetState(contract, MerkleRootSlot(round)), nil
+}
+
+// VerifyRequest checks that [request] is proven by [proof] against the merkle root of [round]
+// stored in [contract]. The request must have been emitted in the buffer that the round attests
+// to, so a root left over from an unfinalised round a week earlier proves nothing.
+func VerifyRequest(state StateReader, contract common.Address, round *big.Int, request *Request, proof []common.Hash) error {
+	if BufferNumber(request.Timestamp).Cmp(RequestBufferNumber(round)) != 0 {
+		return ErrInvalidProof
+	}
+	root, err := ReadMerkleRoot(state, contract, round)
+	if err != nil {
+		return err
+	}
+	if !VerifyProof(root, request.Hash(), proof) {
+		return ErrInvalidProof
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package stateconnector
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ava-labs/coreth/core/types"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+type testState map[common.Hash]common.Hash
+
+func (s testState) GetState(_ common.Address, slot common.Hash) common.Hash { return s[slot] }
+
+func testRequests(n int, bufferNumber uint64) []*Request {
+	requests := make([]*Request, n)
+	for i := range requests {
+		requests[i] = &Request{
+			Timestamp:             new(big.Int).SetUint64(BufferStartTime(bufferNumber) + uint64(i)%BufferWindow),
+			Instructions:          big.NewInt(int64(i + 1)),
+			ID:                    crypto.Keccak256Hash([]byte{byte(i)}),
+			DataAvailabilityProof: common.HexToHash("0xda"),
+		}
+	}
+	return requests
+}
+
+func TestMerkleTreeProofs(t *testing.T) {
+	for n := 0; n <= 9; n++ {
+		requests := testRequests(n, 10)
+		tree := NewMerkleTree(requests)
+		if (n == 0) != (tree.Root() == common.Hash{}) {
+			t.Errorf("%d leaves: unexpected root %s", n, tree.Root().Hex())
+		}
+		for i, r := range requests {
+			proof, err := tree.Proof(r.Hash())
+			if err != nil {
+				t.Fatalf("%d leaves: proof of leaf %d: %v", n, i, err)
+			}
+			if !VerifyProof(tree.Root(), r.Hash(), proof) {
+				t.Errorf("%d leaves: proof of leaf %d does not verify", n, i)
+			}
+			if len(proof) > 0 && VerifyProof(tree.Root(), r.Hash(), proof[1:]) {
+				t.Errorf("%d leaves: truncated proof of leaf %d verifies", n, i)
+			}
+		}
+		if _, err := tree.Proof(common.HexToHash("0x01")); err != ErrLeafNotFound {
+			t.Errorf("%d leaves: proof of a missing leaf: got %v want %v", n, err, ErrLeafNotFound)
+		}
+	}
+}
+
+func TestMerkleTreeLayout(t *testing.T) {
+	a, b, c := common.HexToHash("0x01"), common.HexToHash("0x02"), common.HexToHash("0x03")
+	pair := func(x, y common.Hash) common.Hash { return crypto.Keccak256Hash(x[:], y[:]) }
+
+	if root := NewMerkleTreeFromLeaves([]common.Hash{a}).Root(); root != a {
+		t.Errorf("single leaf: got root %s", root.Hex())
+	}
+	// The odd leaf is carried up, pairs are hashed smaller first
+	want := pair(c, pair(a, b))
+	if pair(a, b).Big().Cmp(c.Big()) < 0 {
+		want = pair(pair(a, b), c)
+	}
+	for _, leaves := range [][]common.Hash{{a, b, c}, {c, b, a}, {b, c, a, b, c}} {
+		if root := NewMerkleTreeFromLeaves(leaves).Root(); root != want {
+			t.Errorf("leaves %v: got root %s want %s", leaves, root.Hex(), want.Hex())
+		}
+	}
+	if proof, _ := NewMerkleTreeFromLeaves([]common.Hash{a, b, c}).Proof(c); len(proof) != 1 || proof[0] != pair(a, b) {
+		t.Errorf("unexpected proof of the carried leaf %v", proof)
+	}
+}
+
+func TestCollectRequests(t *testing.T) {
+	contract := common.HexToAddress("0x1000000000000000000000000000000000000001")
+	requests := append(testRequests(2, 10), testRequests(1, 11)...)
+	var logs []types.Log
+	for _, r := range requests {
+		logs = append(logs, types.Log{Address: contract, Topics: []common.Hash{AttestationRequestTopic}, Data: r.encode()})
+	}
+	logs = append(logs,
+		types.Log{Address: common.HexToAddress("0x02"), Topics: []common.Hash{AttestationRequestTopic}, Data: requests[0].encode()},
+		types.Log{Address: contract, Topics: []common.Hash{AttestationRequestTopic}, Data: requests[1].encode(), Removed: true},
+		types.Log{Address: contract, Topics: []common.Hash{AttestationRequestTopic}, Data: (&Request{Timestamp: requests[0].Timestamp, Instructions: new(big.Int)}).encode()},
+	)
+	got := CollectRequests(logs, contract, big.NewInt(10))
+	if len(got) != 2 || got[0].Hash() != requests[0].Hash() || got[1].Hash() != requests[1].Hash() {
+		t.Errorf("unexpected requests %v", got)
+	}
+	if NewMerkleTree(got).Root() != NewMerkleTree(requests[:2]).Root() {
+		t.Errorf("collected requests give a different root")
+	}
+	if crypto.Keccak256Hash(logs[0].Data) != requests[0].Hash() {
+		t.Errorf("leaf is not the hash of the event data")
+	}
+}
+
+func TestVerifyRequest(t *testing.T) {
+	contract := common.HexToAddress("0x1000000000000000000000000000000000000001")
+	round := big.NewInt(12)
+	requests := testRequests(5, RequestBufferNumber(round).Uint64())
+	tree := NewMerkleTree(requests)
+	proof, _ := tree.Proof(requests[3].Hash())
+
+	state := testState{MerkleRootSlot(round): tree.Root()}
+	if err := VerifyRequest(state, contract, round, requests[3], proof); err != ErrRoundNotFinalised {
+		t.Errorf("unfinalised round: got %v want %v", err, ErrRoundNotFinalised)
+	}
+	state[common.BigToHash(big.NewInt(TotalBuffersSlot))] = common.BigToHash(round)
+	if err := VerifyRequest(state, contract, round, requests[3], proof); err != nil {
+		t.Errorf("valid proof: %v", err)
+	}
+	if err := VerifyRequest(state, contract, round, requests[2], proof); err != ErrInvalidProof {
+		t.Errorf("proof of another request: got %v want %v", err, ErrInvalidProof)
+	}
+	// A request from another buffer is rejected even if the stored root proves it
+	if err := VerifyRequest(state, contract, new(big.Int).Add(round, big.NewInt(TotalStoredProofs)), requests[3], proof); err != ErrInvalidProof {
+		t.Errorf("request from another buffer: got %v want %v", err, ErrInvalidProof)
+	}
+	state[common.BigToHash(big.NewInt(TotalBuffersSlot))] = common.BigToHash(new(big.Int).Add(round, big.NewInt(TotalStoredProofs)))
+	if err := VerifyRequest(state, contract, round, requests[3], proof); err != ErrRootExpired {
+		t.Errorf("expired root: got %v want %v", err, ErrRootExpired)
+	}
+}
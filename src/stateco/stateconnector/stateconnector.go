@@ -0,0 +1,183 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+// Package stateconnector mirrors the commit/reveal logic of StateConnector.sol so that
+// attestation clients and tests can compute buffer numbers, masks and unmasked merkle hashes
+// without a node. Every function follows the contract, including its uint256 arithmetic. It
+// also defines the merkle tree of attestation requests whose root a round finalises.
+package stateconnector
+
+import (
+	"errors"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Constants of StateConnector.sol
+const (
+	BufferTimestampOffset = 1636070400                      // BUFFER_TIMESTAMP_OFFSET, November 5th, 2021
+	BufferWindow          = 90                              // BUFFER_WINDOW, seconds a buffer is active
+	TotalStoredBuffers    = 3                               // TOTAL_STORED_BUFFERS, {Requests, Votes, Reveals}
+	TotalStoredProofs     = 7 * 24 * 60 * 60 / BufferWindow // TOTAL_STORED_PROOFS, one week of merkle roots
+)
+
+var (
+	// SignalCoinbase is the block.coinbase value with which the node signals finaliseRound
+	SignalCoinbase = common.HexToAddress("0x000000000000000000000000000000000000dEaD")
+
+	uint256Modulus = new(big.Int).Lsh(big.NewInt(1), 256)
+	one            = big.NewInt(1)
+)
+
+// Reasons for which the contract reverts
+var (
+	ErrBufferNumberTooLow    = errors.New("buffer number must be greater than 1")
+	ErrWrongBufferNumber     = errors.New("buffer number does not match the block timestamp")
+	ErrNoVoteInPrevBuffer    = errors.New("attestor has not voted since the previous buffer")
+	ErrRevealMismatch        = errors.New("revealed random does not match the committed random")
+	ErrRoundAlreadyFinalised = errors.New("round is already finalised")
+)
+
+// Vote is the Vote struct that an attestor submits in a buffer
+type Vote struct {
+	MaskedMerkleHash common.Hash // Masked hash of the merkle tree of valid requests from the previous buffer
+	CommittedRandom  common.Hash // Hash of the random value that masks MaskedMerkleHash
+	RevealedRandom   common.Hash // Reveal of the random committed to in the previous buffer
+}
+
+// Buffers is the Buffers struct that the contract stores per attestor
+type Buffers struct {
+	Votes      [TotalStoredBuffers]Vote
+	LatestVote *big.Int
+}
+
+// BufferNumber returns (timestamp - BUFFER_TIMESTAMP_OFFSET) / BUFFER_WINDOW. As in the
+// contract, a timestamp before the offset wraps around rather than going negative.
+func BufferNumber(timestamp *big.Int) *big.Int {
+	elapsed := new(big.Int).Sub(timestamp, big.NewInt(BufferTimestampOffset))
+	elapsed.Mod(elapsed, uint256Modulus)
+	return elapsed.Div(elapsed, big.NewInt(BufferWindow))
+}
+
+// BufferStartTime returns the first timestamp that falls in buffer [bufferNumber]
+func BufferStartTime(bufferNumber uint64) uint64 {
+	return BufferTimestampOffset + bufferNumber*BufferWindow
+}
+
+// VoteIndex returns the index into Buffers.Votes used by buffer [bufferNumber]
+func VoteIndex(bufferNumber *big.Int) int {
+	return int(new(big.Int).Mod(bufferNumber, big.NewInt(TotalStoredBuffers)).Int64())
+}
+
+// ProofIndex returns the index into merkleRoots at which the root of round [bufferNumber] is stored
+func ProofIndex(bufferNumber *big.Int) int {
+	index := new(big.Int).Sub(bufferNumber, one)
+	index.Mod(index, uint256Modulus)
+	return int(index.Mod(index, big.NewInt(TotalStoredProofs)).Int64())
+}
+
+// Commit returns keccak256(abi.encodePacked(random)), the commitment to [random]
+func Commit(random common.Hash) common.Hash {
+	return crypto.Keccak256Hash(random.Bytes())
+}
+
+// Mask returns [merkleHash] XOR [random]. Masking is its own inverse, so Mask also unmasks.
+func Mask(merkleHash common.Hash, random common.Hash) common.Hash {
+	var masked common.Hash
+	for i := range masked {
+		masked[i] = merkleHash[i] ^ random[i]
+	}
+	return masked
+}
+
+// NewVote builds the vote an attestor submits in a buffer: [merkleHash] masked and committed
+// to with [random], together with the reveal of the random used in the previous buffer
+func NewVote(merkleHash common.Hash, random common.Hash, prevRandom common.Hash) Vote {
+	return Vote{
+		MaskedMerkleHash: Mask(merkleHash, random),
+		CommittedRandom:  Commit(random),
+		RevealedRandom:   prevRandom,
+	}
+}
+
+// Unmask mirrors getAttestation(bufferNumber) on the buffers of one attestor and returns the
+// unmasked merkle hash that the attestor voted for in round [bufferNumber]
+func Unmask(buffers *Buffers, bufferNumber *big.Int) (common.Hash, error) {
+	if bufferNumber.Cmp(one) <= 0 {
+		return common.Hash{}, ErrBufferNumberTooLow
+	}
+	prevBufferNumber := new(big.Int).Sub(bufferNumber, one)
+	if buffers == nil || buffers.LatestVote == nil || buffers.LatestVote.Cmp(prevBufferNumber) < 0 {
+		return common.Hash{}, ErrNoVoteInPrevBuffer
+	}
+	reveal := buffers.Votes[VoteIndex(prevBufferNumber)]
+	commit := buffers.Votes[VoteIndex(new(big.Int).Sub(prevBufferNumber, one))]
+	if Commit(reveal.RevealedRandom) != commit.CommittedRandom {
+		return common.Hash{}, ErrRevealMismatch
+	}
+	return Mask(commit.MaskedMerkleHash, reveal.RevealedRandom), nil
+}
+
+// StateConnector is an in-memory model of the contract's storage
+type StateConnector struct {
+	Buffers      map[common.Address]*Buffers
+	TotalBuffers *big.Int
+	MerkleRoots  map[int]common.Hash
+}
+
+// New returns the state of a freshly deployed StateConnector
+func New() *StateConnector {
+	return &StateConnector{
+		Buffers:      make(map[common.Address]*Buffers),
+		TotalBuffers: new(big.Int),
+		MerkleRoots:  make(map[int]common.Hash),
+	}
+}
+
+// SubmitAttestation mirrors submitAttestation sent by [sender] in a block at [timestamp]. It
+// returns whether this is the first attestation of a new buffer, in which case the node
+// finalises the previous round.
+func (sc *StateConnector) SubmitAttestation(sender common.Address, timestamp *big.Int, bufferNumber *big.Int, vote Vote) (bool, error) {
+	if bufferNumber.Cmp(BufferNumber(timestamp)) != 0 {
+		return false, ErrWrongBufferNumber
+	}
+	buffers, ok := sc.Buffers[sender]
+	if !ok {
+		buffers = &Buffers{}
+		sc.Buffers[sender] = buffers
+	}
+	buffers.LatestVote = new(big.Int).Set(bufferNumber)
+	buffers.Votes[VoteIndex(bufferNumber)] = vote
+	return bufferNumber.Cmp(sc.TotalBuffers) > 0, nil
+}
+
+// GetAttestation mirrors getAttestation called by [sender]
+func (sc *StateConnector) GetAttestation(sender common.Address, bufferNumber *big.Int) (common.Hash, error) {
+	return Unmask(sc.Buffers[sender], bufferNumber)
+}
+
+// FinaliseRound mirrors finaliseRound sent by [sender] in a block at [timestamp] with
+// block.coinbase set to [coinbase]. The round is only recorded when the node signals the call.
+func (sc *StateConnector) FinaliseRound(sender common.Address, coinbase common.Address, timestamp *big.Int, bufferNumber *big.Int, merkleHash common.Hash) error {
+	if bufferNumber.Cmp(one) <= 0 {
+		return ErrBufferNumberTooLow
+	}
+	if bufferNumber.Cmp(BufferNumber(timestamp)) != 0 {
+		return ErrWrongBufferNumber
+	}
+	if bufferNumber.Cmp(sc.TotalBuffers) <= 0 {
+		return ErrRoundAlreadyFinalised
+	}
+	if sender == coinbase && coinbase == SignalCoinbase {
+		sc.TotalBuffers = new(big.Int).Set(bufferNumber)
+		sc.MerkleRoots[ProofIndex(bufferNumber)] = merkleHash
+	}
+	return nil
+}
+
+// MerkleRoot returns the proven merkle root of round [bufferNumber], if it is still stored
+func (sc *StateConnector) MerkleRoot(bufferNumber *big.Int) common.Hash {
+	return sc.MerkleRoots[ProofIndex(bufferNumber)]
+}
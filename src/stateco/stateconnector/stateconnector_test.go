@@ -0,0 +1,136 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package stateconnector
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestBufferNumber(t *testing.T) {
+	tests := []struct {
+		timestamp *big.Int
+		want      *big.Int
+	}{
+		{big.NewInt(BufferTimestampOffset), big.NewInt(0)},
+		{big.NewInt(BufferTimestampOffset + BufferWindow - 1), big.NewInt(0)},
+		{big.NewInt(BufferTimestampOffset + BufferWindow), big.NewInt(1)},
+		{big.NewInt(BufferTimestampOffset + 10*BufferWindow + 45), big.NewInt(10)},
+		// Timestamps before the offset underflow like uint256 subtraction in solidity 0.7
+		{big.NewInt(BufferTimestampOffset - 1), new(big.Int).Div(new(big.Int).Sub(uint256Modulus, big.NewInt(1)), big.NewInt(BufferWindow))},
+	}
+	for _, test := range tests {
+		if got := BufferNumber(test.timestamp); got.Cmp(test.want) != 0 {
+			t.Errorf("BufferNumber(%s): got %s want %s", test.timestamp, got, test.want)
+		}
+	}
+	if got := BufferStartTime(10); BufferNumber(new(big.Int).SetUint64(got)).Uint64() != 10 || BufferNumber(new(big.Int).SetUint64(got-1)).Uint64() != 9 {
+		t.Errorf("BufferStartTime(10) = %d is not the start of buffer 10", got)
+	}
+}
+
+func TestIndices(t *testing.T) {
+	if TotalStoredProofs != 6720 {
+		t.Errorf("TotalStoredProofs: got %d want 6720", TotalStoredProofs)
+	}
+	for bufferNumber, want := range map[int64]int{0: 0, 1: 1, 2: 2, 3: 0, 7: 1} {
+		if got := VoteIndex(big.NewInt(bufferNumber)); got != want {
+			t.Errorf("VoteIndex(%d): got %d want %d", bufferNumber, got, want)
+		}
+	}
+	for bufferNumber, want := range map[int64]int{1: 0, 2: 1, TotalStoredProofs: TotalStoredProofs - 1, TotalStoredProofs + 1: 0} {
+		if got := ProofIndex(big.NewInt(bufferNumber)); got != want {
+			t.Errorf("ProofIndex(%d): got %d want %d", bufferNumber, got, want)
+		}
+	}
+}
+
+// The mock client votes for soliditySha3(bufferNumber) masked with a fixed random value
+func TestMaskMatchesMockClient(t *testing.T) {
+	random := common.HexToHash("0x219458272a869b877db9fa6dd9522ed858e21fd23cce7089322e8af1d9f1c9ad")
+	merkleHash := crypto.Keccak256Hash(common.BigToHash(big.NewInt(12345)).Bytes())
+	vote := NewVote(merkleHash, random, random)
+	if vote.CommittedRandom != crypto.Keccak256Hash(random.Bytes()) {
+		t.Errorf("unexpected commitment %s", vote.CommittedRandom.Hex())
+	}
+	if Mask(vote.MaskedMerkleHash, random) != merkleHash {
+		t.Errorf("masking is not its own inverse")
+	}
+	if new(big.Int).Xor(merkleHash.Big(), random.Big()).Cmp(vote.MaskedMerkleHash.Big()) != 0 {
+		t.Errorf("mask differs from the mock client's xor")
+	}
+}
+
+func TestCommitReveal(t *testing.T) {
+	sc := New()
+	attestor := common.HexToAddress("0x01")
+	merkleHash := common.HexToHash("0xaa")
+	random := common.HexToHash("0x1234")
+	at := func(bufferNumber uint64) *big.Int { return new(big.Int).SetUint64(BufferStartTime(bufferNumber)) }
+
+	// Commit in buffer 8, reveal in buffer 9, the hash is the attestation for round 10
+	initial, err := sc.SubmitAttestation(attestor, at(8), big.NewInt(8), NewVote(merkleHash, random, common.Hash{}))
+	if err != nil || !initial {
+		t.Fatalf("submit in buffer 8: got (%v, %v)", initial, err)
+	}
+	if _, err := sc.GetAttestation(attestor, big.NewInt(10)); err != ErrNoVoteInPrevBuffer {
+		t.Errorf("attestation before reveal: got %v want %v", err, ErrNoVoteInPrevBuffer)
+	}
+	if _, err := sc.SubmitAttestation(attestor, at(9), big.NewInt(9), NewVote(common.Hash{}, common.HexToHash("0x99"), random)); err != nil {
+		t.Fatal(err)
+	}
+	got, err := sc.GetAttestation(attestor, big.NewInt(10))
+	if err != nil || got != merkleHash {
+		t.Errorf("attestation for round 10: got (%s, %v) want %s", got.Hex(), err, merkleHash.Hex())
+	}
+	if _, err := sc.GetAttestation(attestor, big.NewInt(1)); err != ErrBufferNumberTooLow {
+		t.Errorf("round 1: got %v want %v", err, ErrBufferNumberTooLow)
+	}
+	if _, err := sc.GetAttestation(common.HexToAddress("0x02"), big.NewInt(10)); err != ErrNoVoteInPrevBuffer {
+		t.Errorf("unknown attestor: got %v want %v", err, ErrNoVoteInPrevBuffer)
+	}
+	if _, err := sc.SubmitAttestation(attestor, at(9), big.NewInt(10), Vote{}); err != ErrWrongBufferNumber {
+		t.Errorf("submit in the wrong buffer: got %v want %v", err, ErrWrongBufferNumber)
+	}
+
+	// A reveal that does not match the commitment
+	cheat := common.HexToAddress("0x03")
+	sc.SubmitAttestation(cheat, at(8), big.NewInt(8), NewVote(merkleHash, random, common.Hash{}))
+	sc.SubmitAttestation(cheat, at(9), big.NewInt(9), NewVote(common.Hash{}, random, common.HexToHash("0x4321")))
+	if _, err := sc.GetAttestation(cheat, big.NewInt(10)); err != ErrRevealMismatch {
+		t.Errorf("mismatched reveal: got %v want %v", err, ErrRevealMismatch)
+	}
+}
+
+func TestFinaliseRound(t *testing.T) {
+	sc := New()
+	merkleHash := common.HexToHash("0xaa")
+	timestamp := new(big.Int).SetUint64(BufferStartTime(10))
+
+	// Only a call signalled by the node records the round
+	if err := sc.FinaliseRound(common.HexToAddress("0x01"), common.HexToAddress("0x01"), timestamp, big.NewInt(10), merkleHash); err != nil {
+		t.Fatal(err)
+	}
+	if sc.TotalBuffers.Sign() != 0 || sc.MerkleRoot(big.NewInt(10)) != (common.Hash{}) {
+		t.Fatalf("unsignalled finaliseRound changed state")
+	}
+	if err := sc.FinaliseRound(SignalCoinbase, SignalCoinbase, timestamp, big.NewInt(10), merkleHash); err != nil {
+		t.Fatal(err)
+	}
+	if sc.TotalBuffers.Uint64() != 10 || sc.MerkleRoot(big.NewInt(10)) != merkleHash {
+		t.Errorf("round 10 not finalised")
+	}
+	if err := sc.FinaliseRound(SignalCoinbase, SignalCoinbase, timestamp, big.NewInt(10), merkleHash); err != ErrRoundAlreadyFinalised {
+		t.Errorf("finalising twice: got %v want %v", err, ErrRoundAlreadyFinalised)
+	}
+	if err := sc.FinaliseRound(SignalCoinbase, SignalCoinbase, timestamp, big.NewInt(11), merkleHash); err != ErrWrongBufferNumber {
+		t.Errorf("finalising the wrong buffer: got %v want %v", err, ErrWrongBufferNumber)
+	}
+	if initial, _ := sc.SubmitAttestation(common.HexToAddress("0x01"), timestamp, big.NewInt(10), Vote{}); initial {
+		t.Errorf("submission in a finalised buffer reported as the initial one")
+	}
+}
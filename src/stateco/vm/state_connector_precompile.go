@@ -0,0 +1,117 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package vm
+
+import (
+	"errors"
+	"math/big"
+	"sync"
+
+	"github.com/ava-labs/coreth/core/stateconnector"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// StateConnectorProofVerifierAddr verifies that an attestation request is included in the merkle
+// root of a finalised StateConnector round. Its input is
+//
+//	abi.encodePacked(uint256 round, uint256 timestamp, uint256 instructions, bytes32 id,
+//	                 bytes32 dataAvailabilityProof, bytes32[] proof)
+//
+// where the four request fields are those of the AttestationRequest event and proof holds the
+// siblings from the leaf up, see stateconnector.MerkleTree. It returns abi.encode(true) if the
+// request was proven in [round], abi.encode(false) otherwise, and reverts on malformed input
+// or when called with value. Every call costs StateConnectorProofVerifierGas.
+var StateConnectorProofVerifierAddr = common.HexToAddress("0x0200000000000000000000000000000000000001")
+
+const (
+	StateConnectorProofVerifierGas = 12000 // two cold SLOADs and a full-depth proof
+	stateConnectorMaxProofLength   = 32
+	stateConnectorRequestInputLen  = 5 * common.HashLength
+)
+
+var (
+	errMalformedProofInput = errors.New("malformed state connector proof input")
+
+	proofVerifierRulesLock sync.RWMutex
+	proofVerifierRules     = make(map[uint64]ProofVerifierRules)
+)
+
+// ProofVerifierRules returns whether the proof verifier is activated at [blockNumber] and
+// [blockTime], and the StateConnector contract whose rounds it reads there
+type ProofVerifierRules func(blockNumber *big.Int, blockTime *big.Int) (contract common.Address, activated bool)
+
+// RegisterStateConnectorProofVerifier makes the proof verifier a precompile of chain [chainID]
+// that follows [rules]. It is called by core for a chain whose fork schedule activates the
+// verifier, the precompiles of every other chain are those of coreth. The address is left out
+// of the precompile address list, so it is not warm in access lists and a call of it costs the
+// same before and after registration.
+func RegisterStateConnectorProofVerifier(chainID *big.Int, rules ProofVerifierRules) {
+	proofVerifierRulesLock.Lock()
+	defer proofVerifierRulesLock.Unlock()
+	proofVerifierRules[chainID.Uint64()] = rules
+	PrecompiledContractsApricotPhase2[StateConnectorProofVerifierAddr] = &stateConnectorProofVerifier{}
+}
+
+func getProofVerifierRules(chainID *big.Int) (ProofVerifierRules, bool) {
+	if !chainID.IsUint64() {
+		return nil, false
+	}
+	proofVerifierRulesLock.RLock()
+	defer proofVerifierRulesLock.RUnlock()
+	rules, ok := proofVerifierRules[chainID.Uint64()]
+	return rules, ok
+}
+
+type stateConnectorProofVerifier struct{}
+
+// Run behaves like a call to an empty account on chains without the verifier and until it is
+// activated
+func (*stateConnectorProofVerifier) Run(evm *EVM, caller ContractRef, addr common.Address, value *big.Int, input []byte, suppliedGas uint64, readOnly bool) (ret []byte, remainingGas uint64, err error) {
+	rules, ok := getProofVerifierRules(evm.ChainConfig().ChainID)
+	if !ok {
+		return nil, suppliedGas, nil
+	}
+	contract, activated := rules(evm.Context.BlockNumber, evm.Context.Time)
+	if !activated {
+		return nil, suppliedGas, nil
+	}
+	if suppliedGas < StateConnectorProofVerifierGas {
+		return nil, 0, ErrOutOfGas
+	}
+	remainingGas = suppliedGas - StateConnectorProofVerifierGas
+	if value != nil && value.Sign() != 0 {
+		return nil, remainingGas, ErrExecutionReverted
+	}
+	round, request, proof, err := unpackStateConnectorProof(input)
+	if err != nil {
+		return nil, remainingGas, ErrExecutionReverted
+	}
+	if stateconnector.VerifyRequest(evm.StateDB, contract, round, request, proof) != nil {
+		return common.Hash{}.Bytes(), remainingGas, nil
+	}
+	return common.BigToHash(common.Big1).Bytes(), remainingGas, nil
+}
+
+func unpackStateConnectorProof(input []byte) (*big.Int, *stateconnector.Request, []common.Hash, error) {
+	if len(input) < stateConnectorRequestInputLen || len(input)%common.HashLength != 0 {
+		return nil, nil, nil, errMalformedProofInput
+	}
+	proofLen := (len(input) - stateConnectorRequestInputLen) / common.HashLength
+	if proofLen > stateConnectorMaxProofLength {
+		return nil, nil, nil, errMalformedProofInput
+	}
+	round := new(big.Int).SetBytes(input[0:32])
+	request := &stateconnector.Request{
+		Timestamp:             new(big.Int).SetBytes(input[32:64]),
+		Instructions:          new(big.Int).SetBytes(input[64:96]),
+		ID:                    common.BytesToHash(input[96:128]),
+		DataAvailabilityProof: common.BytesToHash(input[128:160]),
+	}
+	proof := make([]common.Hash, proofLen)
+	for i := range proof {
+		offset := stateConnectorRequestInputLen + i*common.HashLength
+		proof[i] = common.BytesToHash(input[offset : offset+common.HashLength])
+	}
+	return round, request, proof, nil
+}
@@ -0,0 +1,125 @@
+// (c) 2021, Flare Networks Limited. All rights reserved.
+// Please see the file LICENSE for licensing terms.
+
+package vm
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ava-labs/coreth/core/rawdb"
+	"github.com/ava-labs/coreth/core/state"
+	"github.com/ava-labs/coreth/core/stateconnector"
+	"github.com/ava-labs/coreth/params"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func packStateConnectorProof(round *big.Int, request *stateconnector.Request, proof []common.Hash) []byte {
+	input := common.BigToHash(round).Bytes()
+	input = append(input, common.BigToHash(request.Timestamp).Bytes()...)
+	input = append(input, common.BigToHash(request.Instructions).Bytes()...)
+	input = append(input, request.ID.Bytes()...)
+	input = append(input, request.DataAvailabilityProof.Bytes()...)
+	for _, sibling := range proof {
+		input = append(input, sibling.Bytes()...)
+	}
+	return input
+}
+
+func TestStateConnectorProofVerifier(t *testing.T) {
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	round := big.NewInt(100)
+	requestTime := new(big.Int).SetUint64(stateconnector.BufferStartTime(stateconnector.RequestBufferNumber(round).Uint64()))
+	requests := make([]*stateconnector.Request, 3)
+	for i := range requests {
+		requests[i] = &stateconnector.Request{
+			Timestamp:             requestTime,
+			Instructions:          big.NewInt(int64(i + 1)),
+			ID:                    common.HexToHash("0x1d"),
+			DataAvailabilityProof: common.HexToHash("0xda"),
+		}
+	}
+	tree := stateconnector.NewMerkleTree(requests)
+	contract := common.HexToAddress("0x1000000000000000000000000000000000000011")
+	config := *params.TestChainConfig
+	config.ChainID = big.NewInt(987654342)
+	RegisterStateConnectorProofVerifier(config.ChainID, func(blockNumber *big.Int, blockTime *big.Int) (common.Address, bool) {
+		return contract, true
+	})
+	statedb.SetState(contract, common.BigToHash(big.NewInt(stateconnector.TotalBuffersSlot)), common.BigToHash(round))
+	statedb.SetState(contract, stateconnector.MerkleRootSlot(round), tree.Root())
+
+	evm := NewEVM(BlockContext{Time: requestTime, BlockNumber: big.NewInt(1)}, TxContext{}, statedb, &config, Config{})
+	verifier, ok := PrecompiledContractsApricotPhase2[StateConnectorProofVerifierAddr]
+	if !ok {
+		t.Fatal("proof verifier is not registered")
+	}
+	proof, _ := tree.Proof(requests[1].Hash())
+	valid := packStateConnectorProof(round, requests[1], proof)
+	tooLong := packStateConnectorProof(round, requests[1], make([]common.Hash, stateConnectorMaxProofLength+1))
+
+	tests := []struct {
+		name    string
+		input   []byte
+		value   *big.Int
+		gas     uint64
+		want    []byte
+		wantErr error
+	}{
+		{"valid proof", valid, new(big.Int), 50000, common.BigToHash(common.Big1).Bytes(), nil},
+		{"other request", packStateConnectorProof(round, requests[0], proof), new(big.Int), 50000, common.Hash{}.Bytes(), nil},
+		{"wrong round", packStateConnectorProof(big.NewInt(101), requests[1], proof), new(big.Int), 50000, common.Hash{}.Bytes(), nil},
+		{"truncated input", valid[:len(valid)-1], new(big.Int), 50000, nil, ErrExecutionReverted},
+		{"proof too long", tooLong, new(big.Int), 50000, nil, ErrExecutionReverted},
+		{"with value", valid, big.NewInt(1), 50000, nil, ErrExecutionReverted},
+		{"out of gas", valid, new(big.Int), StateConnectorProofVerifierGas - 1, nil, ErrOutOfGas},
+	}
+	for _, test := range tests {
+		ret, remainingGas, err := verifier.Run(evm, AccountRef(common.Address{}), StateConnectorProofVerifierAddr, test.value, test.input, test.gas, true)
+		if err != test.wantErr || string(ret) != string(test.want) {
+			t.Errorf("%s: got (%x, %v) want (%x, %v)", test.name, ret, err, test.want, test.wantErr)
+		}
+		if err == nil && remainingGas != test.gas-StateConnectorProofVerifierGas {
+			t.Errorf("%s: charged %d gas", test.name, test.gas-remainingGas)
+		}
+	}
+}
+
+func TestStateConnectorProofVerifierActivation(t *testing.T) {
+	activationBlock := big.NewInt(10)
+	config := *params.TestChainConfig
+	config.ChainID = big.NewInt(987654343)
+	RegisterStateConnectorProofVerifier(config.ChainID, func(blockNumber *big.Int, blockTime *big.Int) (common.Address, bool) {
+		return common.Address{1}, blockNumber.Cmp(activationBlock) >= 0
+	})
+	unregistered := *params.TestChainConfig
+	unregistered.ChainID = big.NewInt(987654344)
+
+	tests := []struct {
+		name        string
+		config      *params.ChainConfig
+		blockNumber int64
+		wantErr     error
+	}{
+		{"before activation", &config, 9, nil},
+		{"activated", &config, 10, ErrExecutionReverted},
+		{"chain without the verifier", &unregistered, 10, nil},
+	}
+	verifier := &stateConnectorProofVerifier{}
+	for _, test := range tests {
+		evm := NewEVM(BlockContext{Time: big.NewInt(0), BlockNumber: big.NewInt(test.blockNumber)}, TxContext{}, nil, test.config, Config{})
+		// Malformed input reverts once the verifier is activated
+		ret, remainingGas, err := verifier.Run(evm, AccountRef(common.Address{}), StateConnectorProofVerifierAddr, new(big.Int), []byte{1}, 50000, true)
+		if err != test.wantErr {
+			t.Errorf("%s: got error %v want %v", test.name, err, test.wantErr)
+		}
+		if test.wantErr == nil && (ret != nil || remainingGas != 50000) {
+			t.Errorf("%s: got (%x, %d), want the call of an empty account", test.name, ret, remainingGas)
+		}
+	}
+	for _, addr := range PrecompiledAddressesApricotPhase2 {
+		if addr == StateConnectorProofVerifierAddr {
+			t.Errorf("proof verifier address listed with the precompiles that are warm in access lists")
+		}
+	}
+}